@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+
+	"kept/internal/backup"
+	"kept/internal/database"
+)
+
+const usage = `usage: kept [command]
+
+With no command, kept starts the server.
+
+Commands:
+  migrate status      show applied and pending database migrations
+  migrate up          apply all pending migrations
+  migrate down [n]    revert the last n migrations (default 1)
+  backup create       take a database snapshot now
+  backup list         list database snapshots
+  backup restore NAME replace the database with a snapshot
+  db status           show whether the SQLite database is encrypted
+  db encrypt          encrypt a plaintext database with NEW_DB_ENCRYPTION_KEY
+  db rekey            change the key from DB_ENCRYPTION_KEY to NEW_DB_ENCRYPTION_KEY
+  encryption status   show field encryption keys and rows left to re-encrypt
+  encryption rotate   give every user a new data key
+  encryption run      re-encrypt all content with the current keys now
+  search rebuild      rebuild the full-text search index
+
+Stop the server before running db encrypt or db rekey.`
+
+// runCommand runs a command-line subcommand against the database.
+func runCommand(args []string) error {
+	switch args[0] {
+	case "migrate":
+		return migrateCommand(args[1:])
+	case "backup":
+		return backupCommand(args[1:])
+	case "db":
+		return dbCommand(args[1:])
+	case "encryption":
+		return encryptionCommand(args[1:])
+	case "search":
+		return searchCommand(args[1:])
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return nil
+	default:
+		return fmt.Errorf("unknown command %q\n%s", args[0], usage)
+	}
+}
+
+func migrateCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing migrate subcommand\n%s", usage)
+	}
+
+	db, err := database.Open(databaseURL())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch args[0] {
+	case "status":
+		statuses, err := database.MigrationStatuses(db)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATUS")
+		for _, s := range statuses {
+			status := "pending"
+			if s.Applied {
+				status = "applied " + s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.ChecksumMismatch {
+				status += " (checksum mismatch)"
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, status)
+		}
+		return w.Flush()
+
+	case "up":
+		pending, err := database.PendingMigrations(db)
+		if err != nil {
+			return err
+		}
+		if err := database.Migrate(db); err != nil {
+			return err
+		}
+		fmt.Printf("Applied %d migration(s)\n", len(pending))
+		return nil
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of steps %q", args[1])
+			}
+		}
+		if err := database.MigrateDown(db, steps); err != nil {
+			return err
+		}
+		fmt.Printf("Reverted up to %d migration(s)\n", steps)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown migrate subcommand %q\n%s", args[0], usage)
+	}
+}
+
+// newBackupManager returns the snapshot manager for db, or nil if the
+// database doesn't support snapshots.
+func newBackupManager(db *database.DB) (*backup.Manager, error) {
+	if db.Dialect != database.SQLite {
+		return nil, nil
+	}
+	cfg, err := backup.ConfigFromEnv(databaseURL())
+	if err != nil {
+		return nil, err
+	}
+	return backup.NewManager(db, cfg)
+}
+
+func backupCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing backup subcommand\n%s", usage)
+	}
+
+	db, err := database.Open(databaseURL())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	backups, err := newBackupManager(db)
+	if err != nil {
+		return err
+	}
+	if backups == nil {
+		return backup.ErrUnsupported
+	}
+
+	switch args[0] {
+	case "create":
+		snap, err := backups.Create()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %s (%d bytes)\n", snap.Name, snap.Size)
+		return nil
+
+	case "list":
+		snapshots, err := backups.List()
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tCREATED\tSIZE")
+		for _, s := range snapshots {
+			fmt.Fprintf(w, "%s\t%s\t%d\n", s.Name, s.CreatedAt.Local().Format("2006-01-02 15:04:05"), s.Size)
+		}
+		return w.Flush()
+
+	case "restore":
+		if len(args) < 2 {
+			return fmt.Errorf("missing snapshot name\n%s", usage)
+		}
+		safety, err := backups.Restore(args[1])
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Restored %s; the previous database was saved as %s\n", args[1], safety.Name)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown backup subcommand %q\n%s", args[0], usage)
+	}
+}
+
+// dbCommand inspects and changes SQLite encryption. Keys are read from the
+// environment rather than the command line so they don't end up in shell
+// history or the process list.
+func dbCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing db subcommand\n%s", usage)
+	}
+
+	path := databaseURL()
+	if database.DialectFor(path) != database.SQLite {
+		return fmt.Errorf("db commands only apply to SQLite databases")
+	}
+
+	switch args[0] {
+	case "status":
+		kind, err := database.DetectSQLiteFile(path)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Database:  %s (%s)\n", path, kind)
+		fmt.Printf("SQLCipher: %t\n", database.SQLCipherAvailable())
+		return nil
+
+	case "encrypt":
+		key := os.Getenv("NEW_DB_ENCRYPTION_KEY")
+		if key == "" {
+			return fmt.Errorf("set NEW_DB_ENCRYPTION_KEY to the key to encrypt with")
+		}
+		if err := database.EncryptFile(path, key); err != nil {
+			return err
+		}
+		fmt.Println("Encrypted the database; set DB_ENCRYPTION_KEY to the new key before starting the server")
+		return nil
+
+	case "rekey":
+		oldKey, newKey := os.Getenv("DB_ENCRYPTION_KEY"), os.Getenv("NEW_DB_ENCRYPTION_KEY")
+		if oldKey == "" || newKey == "" {
+			return fmt.Errorf("set DB_ENCRYPTION_KEY to the current key and NEW_DB_ENCRYPTION_KEY to the new one")
+		}
+		if err := database.RekeyFile(path, oldKey, newKey); err != nil {
+			return err
+		}
+		fmt.Println("Changed the database key; set DB_ENCRYPTION_KEY to the new key before starting the server." +
+			" Existing backups still use the old key.")
+		return nil
+
+	default:
+		return fmt.Errorf("unknown db subcommand %q\n%s", args[0], usage)
+	}
+}
+
+func encryptionCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing encryption subcommand\n%s", usage)
+	}
+
+	db, err := database.Open(databaseURL())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	st, err := newStore(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "status":
+		status, err := st.EncryptionStatus()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Field encryption: %t\n", st.FieldsEncrypted())
+		versions := make([]int, 0, len(status.KeysByMasterVersion))
+		for version := range status.KeysByMasterVersion {
+			versions = append(versions, version)
+		}
+		sort.Ints(versions)
+		for _, version := range versions {
+			fmt.Printf("Data keys wrapped by master key %d: %d\n", version, status.KeysByMasterVersion[version])
+		}
+		fmt.Printf("Promises to re-encrypt:  %d\n", status.StalePromises)
+		fmt.Printf("Events to re-encrypt:    %d\n", status.StaleEvents)
+		fmt.Printf("Revisions to re-encrypt: %d\n", status.StaleRevisions)
+		fmt.Printf("Items to re-encrypt:     %d\n", status.StaleItems)
+		fmt.Printf("Contacts to re-encrypt:  %d\n", status.StaleContacts)
+		fmt.Printf("Templates to re-encrypt: %d\n", status.StaleTemplates)
+		return nil
+
+	case "rotate":
+		n, err := st.RotateDataKeys()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Created %d data key(s); run `kept encryption run` or let the server re-encrypt in the background\n", n)
+		return nil
+
+	case "run":
+		if !st.FieldsEncrypted() {
+			return fmt.Errorf("set FIELD_ENCRYPTION_KEYS to enable field encryption")
+		}
+		total := 0
+		for {
+			n, err := st.ReencryptFields(500)
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+			total += n
+		}
+		fmt.Printf("Rewrote %d key(s) and row(s)\n", total)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown encryption subcommand %q\n%s", args[0], usage)
+	}
+}
+
+func searchCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("missing search subcommand\n%s", usage)
+	}
+
+	db, err := database.Open(databaseURL())
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	st, err := newStore(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "rebuild":
+		n, err := st.RebuildSearchIndex()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Indexed %d promise(s) and reflection note(s)\n", n)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown search subcommand %q\n%s", args[0], usage)
+	}
+}
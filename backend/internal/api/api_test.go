@@ -2,37 +2,35 @@ package api_test
 
 import (
 	"bytes"
-	"database/sql"
 	"encoding/json"
 	"io"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"kept/internal/api"
-	"kept/internal/database"
 	"kept/internal/models"
+	"kept/internal/store"
+	"kept/internal/store/storetest"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func setupTestDB(t *testing.T) *sql.DB {
-	db, err := database.Initialize(":memory:")
-	if err != nil {
-		t.Fatal(err)
-	}
-	return db
+// setupTestDB returns a migrated store, backed by PostgreSQL when
+// KEPT_TEST_POSTGRES_DSN is set. It is closed when the test ends.
+func setupTestDB(t *testing.T) store.Store {
+	return storetest.Open(t)
 }
 
-func setupTestApp(db *sql.DB) *fiber.App {
-	app := fiber.New()
-	api.SetupRoutes(app, db)
+func setupTestApp(st store.Store) *fiber.App {
+	app := fiber.New(fiber.Config{RequestMethods: api.RequestMethods})
+	api.SetupRoutes(app, st, nil)
 	return app
 }
 
 func TestRegisterAndLogin(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-	app := setupTestApp(db)
+	st := setupTestDB(t)
+	app := setupTestApp(st)
 
 	// Test registration
 	registerReq := models.RegisterRequest{
@@ -89,9 +87,8 @@ func TestRegisterAndLogin(t *testing.T) {
 }
 
 func TestCreatePromise(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-	app := setupTestApp(db)
+	st := setupTestDB(t)
+	app := setupTestApp(st)
 
 	// Register user first
 	registerReq := models.RegisterRequest{
@@ -140,9 +137,8 @@ func TestCreatePromise(t *testing.T) {
 }
 
 func TestListPromises(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-	app := setupTestApp(db)
+	st := setupTestDB(t)
+	app := setupTestApp(st)
 
 	// Register and get token
 	registerReq := models.RegisterRequest{
@@ -194,9 +190,8 @@ func TestListPromises(t *testing.T) {
 }
 
 func TestUpdatePromiseState(t *testing.T) {
-	db := setupTestDB(t)
-	defer db.Close()
-	app := setupTestApp(db)
+	st := setupTestDB(t)
+	app := setupTestApp(st)
 
 	// Register and get token
 	registerReq := models.RegisterRequest{
@@ -237,6 +232,7 @@ func TestUpdatePromiseState(t *testing.T) {
 	req = httptest.NewRequest("PUT", "/api/promises/"+string(rune(promise.ID+'0'))+"/state", bytes.NewReader(body))
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("If-Match", "*")
 
 	resp, err := app.Test(req)
 	if err != nil {
@@ -248,98 +244,44 @@ func TestUpdatePromiseState(t *testing.T) {
 	}
 }
 
-func TestMigratePostponedToKept(t *testing.T) {
-    db := setupTestDB(t)
-    defer db.Close()
-
-    // Create a user to own the promise
-    _, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, ?)", "migrator", "x")
-    if err != nil {
-        t.Fatal(err)
-    }
-    var userID int
-    if err := db.QueryRow("SELECT id FROM users WHERE username = ?", "migrator").Scan(&userID); err != nil {
-        t.Fatal(err)
-    }
-
-    // Insert a promise with state 'postponed' and an event recording 'postponed'
-    res, err := db.Exec("INSERT INTO promises (user_id, recipient, description, due_date, current_state) VALUES (?, ?, ?, ?, 'postponed')", userID, "Alice", "desc", nil)
-    if err != nil {
-        t.Fatal(err)
-    }
-    pid64, _ := res.LastInsertId()
-    pid := int(pid64)
-
-    if _, err := db.Exec("INSERT INTO promise_events (promise_id, state, reflection_note) VALUES (?, 'postponed', ?)", pid, "postponed before migration"); err != nil {
-        t.Fatal(err)
-    }
-
-    // Run migration
-    if err := api.MigratePostponedToKept(db); err != nil {
-        t.Fatal(err)
-    }
-
-    // Verify promise state has been updated
-    var state string
-    if err := db.QueryRow("SELECT current_state FROM promises WHERE id = ?", pid).Scan(&state); err != nil {
-        t.Fatal(err)
-    }
-    if state != "kept" {
-        t.Fatalf("Expected promise state 'kept', got '%s'", state)
-    }
-
-    // Verify events were normalized to 'kept'
-    var evState string
-    if err := db.QueryRow("SELECT state FROM promise_events WHERE promise_id = ? LIMIT 1", pid).Scan(&evState); err != nil {
-        t.Fatal(err)
-    }
-    if evState != "kept" {
-        t.Fatalf("Expected event state 'kept', got '%s'", evState)
-    }
-}
-
 func TestAutoKeepOverduePromises(t *testing.T) {
-    db := setupTestDB(t)
-    defer db.Close()
+    st := setupTestDB(t)
 
     // Create a user to own the promise
-    _, err := db.Exec("INSERT INTO users (username, password_hash) VALUES (?, ?)", "autoer", "x")
+    userID, err := st.Users().Create("autoer", "x")
     if err != nil {
         t.Fatal(err)
     }
-    var userID int
-    if err := db.QueryRow("SELECT id FROM users WHERE username = ?", "autoer").Scan(&userID); err != nil {
-        t.Fatal(err)
-    }
 
     // Insert an active promise with a due_date in the past
-    res, err := db.Exec("INSERT INTO promises (user_id, recipient, description, due_date, current_state) VALUES (?, ?, ?, datetime('now', '-1 day'), 'active')", userID, "Bob", "do something")
-    if err != nil {
+    due := time.Now().Add(-24 * time.Hour)
+    promise := &models.Promise{UserID: userID, Recipient: "Bob", Description: "do something", DueDate: &due}
+    if err := st.Promises().Create(promise); err != nil {
         t.Fatal(err)
     }
-    pid64, _ := res.LastInsertId()
-    pid := int(pid64)
+    pid := promise.ID
 
     // Run the auto-keep function
-    if err := api.AutoKeepOverduePromises(db); err != nil {
+    if err := api.AutoKeepOverduePromises(st); err != nil {
         t.Fatal(err)
     }
 
     // Verify promise state has been updated
-    var state string
-    if err := db.QueryRow("SELECT current_state FROM promises WHERE id = ?", pid).Scan(&state); err != nil {
+    got, err := st.Promises().Get(pid)
+    if err != nil {
         t.Fatal(err)
     }
-    if state != "kept" {
-        t.Fatalf("Expected promise state 'kept', got '%s'", state)
+    if got.CurrentState != "kept" {
+        t.Fatalf("Expected promise state 'kept', got '%s'", got.CurrentState)
     }
 
     // Verify an auto-kept event was inserted
-    var note string
-    if err := db.QueryRow("SELECT reflection_note FROM promise_events WHERE promise_id = ? AND state = 'kept' ORDER BY created_at DESC LIMIT 1", pid).Scan(&note); err != nil {
+    events, err := st.Events().ListByPromise(pid)
+    if err != nil {
         t.Fatal(err)
     }
-    if note != "Auto-kept: due date passed" {
-        t.Fatalf("Expected auto-kept reflection note, got '%s'", note)
+    last := events[len(events)-1]
+    if last.State != "kept" || last.ReflectionNote != "Auto-kept: due date passed" {
+        t.Fatalf("Expected auto-kept reflection note, got '%s'", last.ReflectionNote)
     }
 }
@@ -0,0 +1,103 @@
+package api
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxAppPasswords        = 20
+	maxAppPasswordNameSize = 100
+)
+
+// newAppPassword returns a random app password.
+func newAppPassword() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// ListAppPasswordsHandler returns the user's app passwords, without the
+// passwords themselves.
+func ListAppPasswordsHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		passwords, err := st.AppPasswords().ListByUser(userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(passwords)
+	}
+}
+
+// CreateAppPasswordHandler creates a password for a CalDAV client. It is
+// only returned now; the server keeps just its hash. Once a user has an app
+// password, CalDAV stops accepting their account password.
+func CreateAppPasswordHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.CreateAppPasswordRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		name := strings.TrimSpace(req.Name)
+		if name == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Name is required")
+		}
+		if utf8.RuneCountInString(name) > maxAppPasswordNameSize {
+			return fiber.NewError(fiber.StatusBadRequest, "Names must be at most 100 characters")
+		}
+		existing, err := st.AppPasswords().ListByUser(userID)
+		if err != nil {
+			return err
+		}
+		if len(existing) >= maxAppPasswords {
+			return fiber.NewError(fiber.StatusConflict, "You can have at most 20 app passwords")
+		}
+
+		password, err := newAppPassword()
+		if err != nil {
+			return err
+		}
+		appPassword := &models.AppPassword{UserID: userID, Name: name}
+		if err := st.AppPasswords().Create(appPassword, hashToken(password)); err != nil {
+			return err
+		}
+		appPassword.Password = password
+		return c.Status(fiber.StatusCreated).JSON(appPassword)
+	}
+}
+
+// DeleteAppPasswordHandler revokes an app password. Clients using it are
+// signed out right away.
+func DeleteAppPasswordHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		id, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid app password ID")
+		}
+
+		err = st.AppPasswords().Delete(id, userID)
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "App password not found")
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
@@ -1,16 +1,17 @@
 package api
 
 import (
-	"database/sql"
+	"errors"
 	"kept/internal/auth"
 	"kept/internal/models"
+	"kept/internal/store"
 	"log"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func RegisterHandler(db *sql.DB) fiber.Handler {
+func RegisterHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var req models.RegisterRequest
 		if err := c.BodyParser(&req); err != nil {
@@ -28,36 +29,34 @@ func RegisterHandler(db *sql.DB) fiber.Handler {
 		}
 
 		// Insert user
-		result, err := db.Exec(
-			"INSERT INTO users (username, password_hash) VALUES (?, ?)",
-			req.Username, hashedPassword,
-		)
-		if err != nil {
+		userID, err := st.Users().Create(req.Username, hashedPassword)
+		if errors.Is(err, store.ErrConflict) {
 			return fiber.NewError(fiber.StatusConflict, "Username already exists")
 		}
-
-		userID, _ := result.LastInsertId()
+		if err != nil {
+			return fiber.NewError(fiber.StatusInternalServerError, "Database error")
+		}
 
 		// Generate access and refresh tokens
-		accessToken, err := auth.GenerateToken(int(userID), req.Username)
+		accessToken, err := auth.GenerateToken(userID, req.Username)
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate token")
 		}
 
 		days := auth.RefreshDays(req.Remember)
-		refreshToken, err := auth.GenerateRefreshToken(int(userID), req.Username, days)
+		refreshToken, err := auth.GenerateRefreshToken(userID, req.Username, days)
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate refresh token")
 		}
 
 		user := models.User{
-			ID:       int(userID),
+			ID:       userID,
 			Username: req.Username,
 		}
 
 		// Persist refresh token in DB and set cookie
 		expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour)
-		if err := StoreRefreshToken(db, int(userID), refreshToken, expiresAt, days); err != nil {
+		if err := StoreRefreshToken(st, userID, refreshToken, expiresAt, days); err != nil {
 			log.Printf("Failed to store refresh token (register): %v", err)
 			return fiber.NewError(fiber.StatusInternalServerError, "Failed to store refresh token")
 		}
@@ -78,7 +77,7 @@ func RegisterHandler(db *sql.DB) fiber.Handler {
 	}
 }
 
-func LoginHandler(db *sql.DB) fiber.Handler {
+func LoginHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		var req models.LoginRequest
 		if err := c.BodyParser(&req); err != nil {
@@ -86,15 +85,8 @@ func LoginHandler(db *sql.DB) fiber.Handler {
 		}
 
 		// Get user
-		var user models.User
-
-		// Try to select with email column first, fall back if it doesn't exist
-		err := db.QueryRow(
-			"SELECT id, username, password_hash, COALESCE(email, '') FROM users WHERE username = ?",
-			req.Username,
-		).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.Email)
-
-		if err == sql.ErrNoRows {
+		user, err := st.Users().GetByUsername(req.Username)
+		if errors.Is(err, store.ErrNotFound) {
 			return fiber.NewError(fiber.StatusUnauthorized, "Invalid username or password")
 		}
 		if err != nil {
@@ -120,7 +112,7 @@ func LoginHandler(db *sql.DB) fiber.Handler {
 		}
 		// Persist refresh token and set cookie
 		expiresAt := time.Now().Add(time.Duration(days) * 24 * time.Hour)
-		if err := StoreRefreshToken(db, user.ID, refreshToken, expiresAt, days); err != nil {
+		if err := StoreRefreshToken(st, user.ID, refreshToken, expiresAt, days); err != nil {
 			log.Printf("Failed to store refresh token (login): %v", err)
 			return fiber.NewError(fiber.StatusInternalServerError, "Failed to store refresh token")
 		}
@@ -136,13 +128,13 @@ func LoginHandler(db *sql.DB) fiber.Handler {
 
 		return c.JSON(models.AuthResponse{
 			Token: accessToken,
-			User:  user,
+			User:  *user,
 		})
 	}
 }
 
 // RefreshTokenHandler generates a new access token from a valid refresh token cookie
-func RefreshTokenHandler(db *sql.DB) fiber.Handler {
+func RefreshTokenHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Get refresh token from cookie
 		refreshToken := c.Cookies("refresh_token")
@@ -157,7 +149,7 @@ func RefreshTokenHandler(db *sql.DB) fiber.Handler {
 		}
 
 		// Check token presence in DB and get its TTL
-		dbUserID, ttlDays, err := ValidateRefreshTokenInDB(db, refreshToken)
+		dbUserID, ttlDays, err := ValidateRefreshTokenInDB(st, refreshToken)
 		if err != nil {
 			log.Printf("Refresh token DB validation failed: %v", err)
 			return fiber.NewError(fiber.StatusUnauthorized, "Refresh token not valid")
@@ -178,12 +170,12 @@ func RefreshTokenHandler(db *sql.DB) fiber.Handler {
 			return fiber.NewError(fiber.StatusInternalServerError, "Failed to generate new refresh token")
 		}
 		expiresAt := time.Now().Add(time.Duration(ttlDays) * 24 * time.Hour)
-		if err := StoreRefreshToken(db, claims.UserID, newRefreshToken, expiresAt, ttlDays); err != nil {
+		if err := StoreRefreshToken(st, claims.UserID, newRefreshToken, expiresAt, ttlDays); err != nil {
 			log.Printf("Failed to store new refresh token (refresh): %v", err)
 			return fiber.NewError(fiber.StatusInternalServerError, "Failed to store new refresh token")
 		}
 		// Revoke old token
-		if err := RevokeRefreshToken(db, refreshToken); err != nil {
+		if err := RevokeRefreshToken(st, refreshToken); err != nil {
 			// non-fatal: log but continue
 		}
 
@@ -205,12 +197,12 @@ func RefreshTokenHandler(db *sql.DB) fiber.Handler {
 }
 
 // LogoutHandler clears the refresh token cookie
-func LogoutHandler(db *sql.DB) fiber.Handler {
+func LogoutHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		// Revoke refresh token in DB if present
 		old := c.Cookies("refresh_token")
 		if old != "" {
-			_ = RevokeRefreshToken(db, old) // best-effort; ignore errors
+			_ = RevokeRefreshToken(st, old) // best-effort; ignore errors
 		}
 
 		c.Cookie(&fiber.Cookie{
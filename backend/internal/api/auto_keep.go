@@ -1,10 +1,11 @@
 package api
 
 import (
-	"database/sql"
+	"kept/internal/store"
 	"log"
 	"strconv"
 	"strings"
+	"time"
 )
 
 func idsToCSV(ids []int) string {
@@ -17,65 +18,34 @@ func idsToCSV(ids []int) string {
 
 // AutoKeepOverduePromises finds promises in state 'active' whose due_date has
 // passed and converts them to 'kept', appending an event noting the automatic
-// transition.
-func AutoKeepOverduePromises(db *sql.DB) error {
-	tx, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	defer tx.Rollback()
-
-	rows, err := tx.Query("SELECT id FROM promises WHERE current_state = 'active' AND due_date IS NOT NULL AND due_date <= CURRENT_TIMESTAMP")
-	if err != nil {
-		return err
-	}
-	defer rows.Close()
-
-	ids := []int{}
-	for rows.Next() {
-		var id int
-		if err := rows.Scan(&id); err != nil {
-			return err
-		}
-		ids = append(ids, id)
-	}
-
-	if len(ids) == 0 {
-		return tx.Commit()
-	}
-
-	// Update promises to 'kept' using parameterized queries
-	updateStmt, err := tx.Prepare("UPDATE promises SET current_state = 'kept', updated_at = CURRENT_TIMESTAMP WHERE id = ?")
-	if err != nil {
-		return err
-	}
-	defer updateStmt.Close()
-	
+// transition. Instances of a series are left for the user to resolve, so that
+// the series' hit rate and streak only count what they actually kept.
+func AutoKeepOverduePromises(st store.Store) error {
 	updatedCount := 0
-	for _, id := range ids {
-		if _, err := updateStmt.Exec(id); err != nil {
+	err := st.InTx(func(tx store.Store) error {
+		ids, err := tx.Promises().ListOverdueIDs(time.Now())
+		if err != nil {
 			return err
 		}
-		updatedCount++
-	}
 
-	// Insert events for each auto-kept promise
-	eventStmt, err := tx.Prepare("INSERT INTO promise_events (promise_id, state, reflection_note) VALUES (?, 'kept', ?)")
+		for _, id := range ids {
+			promise, err := tx.Promises().Get(id)
+			if err != nil {
+				return err
+			}
+			if _, err := applyPromiseState(tx, promise, "kept", "Auto-kept: due date passed", nil); err != nil {
+				return err
+			}
+			updatedCount++
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	defer eventStmt.Close()
 
-	for _, id := range ids {
-		if _, err := eventStmt.Exec(id, "Auto-kept: due date passed"); err != nil {
-			return err
-		}
-	}
-
-	if err := tx.Commit(); err != nil {
-		return err
+	if updatedCount > 0 {
+		log.Printf("Auto-kept %d overdue promises", updatedCount)
 	}
-
-	log.Printf("Auto-kept %d overdue promises", updatedCount)
 	return nil
 }
@@ -0,0 +1,68 @@
+package api
+
+import (
+	"errors"
+	"log"
+
+	"kept/internal/backup"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func backupsUnavailable() error {
+	return fiber.NewError(fiber.StatusNotImplemented, "Backups are only available for SQLite databases")
+}
+
+// ListBackupsHandler lists the database snapshots, newest first.
+func ListBackupsHandler(backups *backup.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if backups == nil {
+			return backupsUnavailable()
+		}
+		snapshots, err := backups.List()
+		if err != nil {
+			return err
+		}
+		return c.JSON(snapshots)
+	}
+}
+
+// CreateBackupHandler takes a snapshot immediately.
+func CreateBackupHandler(backups *backup.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if backups == nil {
+			return backupsUnavailable()
+		}
+		snap, err := backups.Create()
+		if err != nil {
+			log.Printf("Manual backup failed: %v", err)
+			return fiber.NewError(fiber.StatusInternalServerError, "Failed to create backup")
+		}
+		log.Printf("Created backup %s for %s", snap.Name, c.Locals("username"))
+		return c.Status(fiber.StatusCreated).JSON(snap)
+	}
+}
+
+// RestoreBackupHandler replaces the live database with a snapshot. The
+// response names the snapshot of the previous state.
+func RestoreBackupHandler(backups *backup.Manager) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if backups == nil {
+			return backupsUnavailable()
+		}
+		safety, err := backups.Restore(c.Params("name"))
+		if errors.Is(err, backup.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Backup not found")
+		}
+		if err != nil {
+			log.Printf("Restore failed: %v", err)
+			return fiber.NewError(fiber.StatusInternalServerError, "Failed to restore backup")
+		}
+		log.Printf("Restored backup %s for %s", c.Params("name"), c.Locals("username"))
+		return c.JSON(fiber.Map{
+			"success":         true,
+			"restored":        c.Params("name"),
+			"previous_backup": safety,
+		})
+	}
+}
@@ -0,0 +1,1004 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"kept/internal/auth"
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	davNS    = "DAV:"
+	caldavNS = "urn:ietf:params:xml:ns:caldav"
+	csNS     = "http://calendarserver.org/ns/"
+
+	caldavPrefix         = "/caldav"
+	caldavCollectionName = "promises"
+)
+
+// davExplicitProps are only returned when a client asks for them by name,
+// never for allprop.
+var davExplicitProps = map[xml.Name]bool{
+	{Space: caldavNS, Local: "calendar-data"}: true,
+}
+
+// Limits on failed CalDAV sign-ins with an account password. Each client IP
+// and each username may fail davMaxFailures times per davFailureWindow
+// before further attempts are refused without checking the password.
+const (
+	davMaxFailures   = 10
+	davFailureWindow = 15 * time.Minute
+	// davVerifiedTTL is how long a checked account password is trusted, so
+	// clients polling every few minutes don't run bcrypt each time.
+	davVerifiedTTL = 10 * time.Minute
+	// davAuthSweepSize is how many entries the limiter holds before it drops
+	// expired ones.
+	davAuthSweepSize = 10000
+)
+
+type davFailures struct {
+	count int
+	since time.Time
+}
+
+// davAuthLimiter counts failed CalDAV sign-ins and remembers account
+// passwords that were checked recently. It lives in memory, so limits are
+// per server process.
+type davAuthLimiter struct {
+	mu       sync.Mutex
+	failures map[string]*davFailures
+	verified map[string]time.Time
+}
+
+func newDAVAuthLimiter() *davAuthLimiter {
+	return &davAuthLimiter{failures: map[string]*davFailures{}, verified: map[string]time.Time{}}
+}
+
+// retryAfter returns how long until any of keys may try again, or 0 if none
+// of them is locked out.
+func (l *davAuthLimiter) retryAfter(now time.Time, keys ...string) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var wait time.Duration
+	for _, key := range keys {
+		if f := l.failures[key]; f != nil && f.count >= davMaxFailures {
+			wait = max(wait, f.since.Add(davFailureWindow).Sub(now))
+		}
+	}
+	return wait
+}
+
+// fail records a failed sign-in for each of keys.
+func (l *davAuthLimiter) fail(now time.Time, keys ...string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.failures) >= davAuthSweepSize {
+		for key, f := range l.failures {
+			if now.Sub(f.since) >= davFailureWindow {
+				delete(l.failures, key)
+			}
+		}
+	}
+	for _, key := range keys {
+		f := l.failures[key]
+		if f == nil || now.Sub(f.since) >= davFailureWindow {
+			f = &davFailures{since: now}
+			l.failures[key] = f
+		}
+		f.count++
+	}
+}
+
+// verify reports whether the password behind key was checked recently.
+func (l *davAuthLimiter) verify(now time.Time, key string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return now.Before(l.verified[key])
+}
+
+// succeed clears the failures of userKey and remembers the password behind
+// key as checked.
+func (l *davAuthLimiter) succeed(now time.Time, userKey, key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.failures, userKey)
+	if len(l.verified) >= davAuthSweepSize {
+		for k, expires := range l.verified {
+			if !now.Before(expires) {
+				delete(l.verified, k)
+			}
+		}
+	}
+	l.verified[key] = now.Add(davVerifiedTTL)
+}
+
+// CalDAVAuthMiddleware authenticates CalDAV clients with HTTP Basic auth.
+// Task apps can't go through the JWT login and refresh flow, so they send a
+// password on every request instead: an app password, or the account
+// password for users who haven't created any. Account passwords are
+// throttled per client IP and username, and trusted for a few minutes once
+// checked.
+func CalDAVAuthMiddleware(st store.Store) fiber.Handler {
+	limiter := newDAVAuthLimiter()
+	return func(c *fiber.Ctx) error {
+		// Clients probe capabilities before sending credentials
+		if c.Method() == fiber.MethodOptions {
+			return c.Next()
+		}
+
+		username, password, ok := parseBasicAuth(c.Get(fiber.HeaderAuthorization))
+		if !ok {
+			return davUnauthorized(c)
+		}
+		now := time.Now()
+		userKey := "user:" + strings.ToLower(username)
+		keys := []string{"ip:" + c.IP(), userKey}
+
+		user, err := st.Users().GetByUsername(username)
+		if errors.Is(err, store.ErrNotFound) {
+			limiter.fail(now, keys...)
+			return davUnauthorized(c)
+		}
+		if err != nil {
+			return err
+		}
+
+		// App passwords are too long to guess, so they work even while the
+		// account is locked out
+		appPasswords, err := st.AppPasswords().ListByUser(user.ID)
+		if err != nil {
+			return err
+		}
+		if len(appPasswords) > 0 {
+			appPassword, err := st.AppPasswords().GetByTokenHash(hashToken(password))
+			if errors.Is(err, store.ErrNotFound) || (err == nil && appPassword.UserID != user.ID) {
+				limiter.fail(now, keys...)
+				return davUnauthorized(c)
+			}
+			if err != nil {
+				return err
+			}
+		} else {
+			if wait := limiter.retryAfter(now, keys...); wait > 0 {
+				c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(wait.Seconds())+1))
+				return fiber.NewError(fiber.StatusTooManyRequests, "Too many failed sign-ins, try again later")
+			}
+			// The hash changes with the password, so a changed password
+			// isn't trusted from before
+			verifiedKey := hashToken(user.PasswordHash + "\x00" + password)
+			if !limiter.verify(now, verifiedKey) {
+				if err := auth.CheckPassword(user.PasswordHash, password); err != nil {
+					limiter.fail(now, keys...)
+					return davUnauthorized(c)
+				}
+				limiter.succeed(now, userKey, verifiedKey)
+			}
+		}
+
+		c.Locals("userID", user.ID)
+		c.Locals("username", user.Username)
+		return c.Next()
+	}
+}
+
+func parseBasicAuth(header string) (string, string, bool) {
+	scheme, encoded, ok := strings.Cut(header, " ")
+	if !ok || !strings.EqualFold(scheme, "Basic") {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil {
+		return "", "", false
+	}
+	username, password, ok := strings.Cut(string(decoded), ":")
+	if !ok || username == "" {
+		return "", "", false
+	}
+	return username, password, true
+}
+
+func davUnauthorized(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="Kept", charset="UTF-8"`)
+	return fiber.NewError(fiber.StatusUnauthorized, "Authentication required")
+}
+
+// CalDAVWellKnownHandler redirects service discovery to the CalDAV root.
+func CalDAVWellKnownHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.Redirect(caldavPrefix+"/", fiber.StatusMovedPermanently)
+	}
+}
+
+// CalDAVOptionsHandler advertises CalDAV support.
+func CalDAVOptionsHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		c.Set("DAV", "1, 3, calendar-access")
+		c.Set(fiber.HeaderAllow, "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		return c.SendStatus(fiber.StatusOK)
+	}
+}
+
+// davPath is a parsed request path below /caldav.
+//
+//	/caldav/                          root
+//	/caldav/<user>/                   principal and calendar home
+//	/caldav/<user>/promises/          the VTODO calendar collection
+//	/caldav/<user>/promises/<name>    a single promise
+type davPath struct {
+	user       string
+	collection bool
+	object     string
+}
+
+func parseDAVPath(raw string) (davPath, bool) {
+	rest, ok := strings.CutPrefix(raw, caldavPrefix)
+	if !ok {
+		return davPath{}, false
+	}
+	var segments []string
+	for _, s := range strings.Split(rest, "/") {
+		if s == "" {
+			continue
+		}
+		unescaped, err := url.PathUnescape(s)
+		if err != nil {
+			return davPath{}, false
+		}
+		segments = append(segments, unescaped)
+	}
+
+	switch {
+	case len(segments) == 0:
+		return davPath{}, true
+	case len(segments) == 1:
+		return davPath{user: segments[0]}, true
+	case segments[1] != caldavCollectionName || len(segments) > 3:
+		return davPath{}, false
+	case len(segments) == 2:
+		return davPath{user: segments[0], collection: true}, true
+	default:
+		return davPath{user: segments[0], collection: true, object: segments[2]}, true
+	}
+}
+
+// resolveDAVPath parses the request path and makes sure it belongs to the
+// authenticated user.
+func resolveDAVPath(c *fiber.Ctx, raw string) (davPath, error) {
+	p, ok := parseDAVPath(raw)
+	if !ok {
+		return p, fiber.NewError(fiber.StatusNotFound, "Not found")
+	}
+	if p.user != "" && p.user != c.Locals("username").(string) {
+		return p, fiber.NewError(fiber.StatusForbidden, "Not authorized")
+	}
+	return p, nil
+}
+
+func principalHref(username string) string {
+	return caldavPrefix + "/" + url.PathEscape(username) + "/"
+}
+
+func collectionHref(username string) string {
+	return principalHref(username) + caldavCollectionName + "/"
+}
+
+// davObject is a promise as seen through CalDAV.
+type davObject struct {
+	promise     models.Promise
+	uid         string
+	name        string
+	completedAt *time.Time
+}
+
+func (o davObject) todo() vtodo {
+	return newVTODO(o.promise, o.uid, o.completedAt)
+}
+
+func (o davObject) etag() string {
+	sum := sha256.Sum256([]byte(o.todo().render()))
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// loadDAVObjects returns the user's promises, or a single promise when
+// promiseID is non-zero. Promises that were not created over CalDAV get a
+// synthetic name and UID derived from their ID.
+func loadDAVObjects(st store.Store, userID, promiseID int) ([]davObject, error) {
+	var promises []models.Promise
+	var events []models.Event
+	if promiseID == 0 {
+		var err error
+		if promises, err = st.Promises().ListByUser(userID, store.PromiseFilter{}); err != nil {
+			return nil, err
+		}
+		if events, err = st.Events().ListByUser(userID); err != nil {
+			return nil, err
+		}
+		sort.Slice(promises, func(i, j int) bool { return promises[i].ID < promises[j].ID })
+	} else {
+		p, err := st.Promises().Get(promiseID)
+		if errors.Is(err, store.ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		// Workspace promises aren't in the user's calendar
+		if p.UserID != userID || p.WorkspaceID != nil {
+			return nil, nil
+		}
+		if events, err = st.Events().ListByPromise(promiseID); err != nil {
+			return nil, err
+		}
+		promises = []models.Promise{*p}
+	}
+
+	stored, err := st.CalDAVObjects().ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	names := map[int]store.CalDAVObject{}
+	for _, o := range stored {
+		names[o.PromiseID] = o
+	}
+
+	// The promise was resolved by the latest event for its current state
+	resolvedAt := map[int]time.Time{}
+	states := map[int]string{}
+	for _, p := range promises {
+		states[p.ID] = p.CurrentState
+	}
+	for _, e := range events {
+		if e.State == states[e.PromiseID] && e.CreatedAt.After(resolvedAt[e.PromiseID]) {
+			resolvedAt[e.PromiseID] = e.CreatedAt
+		}
+	}
+
+	objects := []davObject{}
+	for _, p := range promises {
+		o := davObject{
+			promise: p,
+			uid:     fmt.Sprintf("kept-%d@kept", p.ID),
+			name:    fmt.Sprintf("kept-%d.ics", p.ID),
+		}
+		if stored, ok := names[p.ID]; ok {
+			o.uid, o.name = stored.UID, stored.Name
+		}
+		if t, ok := resolvedAt[p.ID]; ok && p.CurrentState != "active" {
+			o.completedAt = &t
+		}
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+// findDAVObject looks up a promise by its resource name. It returns nil if
+// there is no such resource.
+func findDAVObject(st store.Store, userID int, name string) (*davObject, error) {
+	var promiseID int
+	stored, err := st.CalDAVObjects().GetByName(userID, name)
+	if errors.Is(err, store.ErrNotFound) {
+		// Fall back to the synthetic name of promises created in the app
+		id, ok := strings.CutPrefix(strings.TrimSuffix(name, ".ics"), "kept-")
+		if !ok || !strings.HasSuffix(name, ".ics") {
+			return nil, nil
+		}
+		if promiseID, err = strconv.Atoi(id); err != nil {
+			return nil, nil
+		}
+	} else if err != nil {
+		return nil, err
+	} else {
+		promiseID = stored.PromiseID
+	}
+
+	objects, err := loadDAVObjects(st, userID, promiseID)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range objects {
+		if o.name == name {
+			return &o, nil
+		}
+	}
+	return nil, nil
+}
+
+// davProp is a property and its already-encoded inner XML.
+type davProp struct {
+	name  xml.Name
+	inner string
+}
+
+type davResource struct {
+	href   string
+	props  []davProp
+	status int // set for resources that could not be found
+}
+
+// davPropList collects the element names inside a DAV:prop element.
+type davPropList []xml.Name
+
+func (l *davPropList) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			*l = append(*l, t.Name)
+			if err := d.Skip(); err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
+}
+
+type davPropfind struct {
+	XMLName  xml.Name    `xml:"DAV: propfind"`
+	AllProp  *struct{}   `xml:"DAV: allprop"`
+	PropName *struct{}   `xml:"DAV: propname"`
+	Prop     davPropList `xml:"DAV: prop"`
+}
+
+type calendarMultiget struct {
+	Prop  davPropList `xml:"DAV: prop"`
+	Hrefs []string    `xml:"DAV: href"`
+}
+
+type calendarQuery struct {
+	Prop   davPropList `xml:"DAV: prop"`
+	Filter struct {
+		Comp calCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	} `xml:"urn:ietf:params:xml:ns:caldav filter"`
+}
+
+type calCompFilter struct {
+	Name         string          `xml:"name,attr"`
+	IsNotDefined *struct{}       `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	Comps        []calCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+	Props        []calPropFilter `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+}
+
+type calPropFilter struct {
+	Name         string    `xml:"name,attr"`
+	IsNotDefined *struct{} `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TextMatch    *struct {
+		Value  string `xml:",chardata"`
+		Negate string `xml:"negate-condition,attr"`
+	} `xml:"urn:ietf:params:xml:ns:caldav text-match"`
+}
+
+// matches reports whether a VTODO passes a calendar-query filter. Only
+// component and property filters are evaluated; time ranges are ignored and
+// match everything, which clients tolerate.
+func (f calCompFilter) matches(t vtodo) bool {
+	if !strings.EqualFold(f.Name, "VCALENDAR") {
+		return false
+	}
+	for _, comp := range f.Comps {
+		isTodo := strings.EqualFold(comp.Name, "VTODO")
+		if comp.IsNotDefined != nil {
+			if isTodo {
+				return false
+			}
+			continue
+		}
+		if !isTodo {
+			return false
+		}
+		for _, pf := range comp.Props {
+			if !pf.matches(t) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (f calPropFilter) matches(t vtodo) bool {
+	value, defined := "", false
+	for _, p := range t.properties() {
+		if strings.EqualFold(p.Name, f.Name) {
+			value, defined = p.Value, true
+			break
+		}
+	}
+	if f.IsNotDefined != nil {
+		return !defined
+	}
+	if f.TextMatch != nil {
+		if !defined {
+			return false
+		}
+		match := strings.Contains(strings.ToLower(value), strings.ToLower(f.TextMatch.Value))
+		if f.TextMatch.Negate == "yes" {
+			return !match
+		}
+		return match
+	}
+	return defined
+}
+
+func xmlEscape(s string) string {
+	var b strings.Builder
+	_ = xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func hrefXML(href string) string {
+	return `<href xmlns="DAV:">` + xmlEscape(href) + `</href>`
+}
+
+func rootResource(username string) davResource {
+	return davResource{
+		href: caldavPrefix + "/",
+		props: []davProp{
+			{xml.Name{Space: davNS, Local: "resourcetype"}, `<collection xmlns="DAV:"/>`},
+			{xml.Name{Space: davNS, Local: "displayname"}, "Kept"},
+			{xml.Name{Space: davNS, Local: "current-user-principal"}, hrefXML(principalHref(username))},
+		},
+	}
+}
+
+func principalResource(username string) davResource {
+	return davResource{
+		href: principalHref(username),
+		props: []davProp{
+			{xml.Name{Space: davNS, Local: "resourcetype"}, `<collection xmlns="DAV:"/><principal xmlns="DAV:"/>`},
+			{xml.Name{Space: davNS, Local: "displayname"}, xmlEscape(username)},
+			{xml.Name{Space: davNS, Local: "current-user-principal"}, hrefXML(principalHref(username))},
+			{xml.Name{Space: davNS, Local: "principal-URL"}, hrefXML(principalHref(username))},
+			{xml.Name{Space: caldavNS, Local: "calendar-home-set"}, hrefXML(principalHref(username))},
+		},
+	}
+}
+
+func collectionResource(username string, objects []davObject) davResource {
+	h := sha256.New()
+	for _, o := range objects {
+		h.Write([]byte(o.name + o.etag()))
+	}
+	ctag := hex.EncodeToString(h.Sum(nil)[:12])
+
+	return davResource{
+		href: collectionHref(username),
+		props: []davProp{
+			{xml.Name{Space: davNS, Local: "resourcetype"}, `<collection xmlns="DAV:"/><calendar xmlns="urn:ietf:params:xml:ns:caldav"/>`},
+			{xml.Name{Space: davNS, Local: "displayname"}, "Promises"},
+			{xml.Name{Space: davNS, Local: "owner"}, hrefXML(principalHref(username))},
+			{xml.Name{Space: davNS, Local: "current-user-principal"}, hrefXML(principalHref(username))},
+			{xml.Name{Space: davNS, Local: "current-user-privilege-set"},
+				`<privilege xmlns="DAV:"><read/></privilege><privilege xmlns="DAV:"><write/></privilege>` +
+					`<privilege xmlns="DAV:"><write-content/></privilege><privilege xmlns="DAV:"><bind/></privilege>` +
+					`<privilege xmlns="DAV:"><unbind/></privilege>`},
+			{xml.Name{Space: davNS, Local: "supported-report-set"},
+				`<supported-report xmlns="DAV:"><report><calendar-query xmlns="urn:ietf:params:xml:ns:caldav"/></report></supported-report>` +
+					`<supported-report xmlns="DAV:"><report><calendar-multiget xmlns="urn:ietf:params:xml:ns:caldav"/></report></supported-report>`},
+			{xml.Name{Space: caldavNS, Local: "calendar-description"}, "Promises tracked in Kept"},
+			{xml.Name{Space: caldavNS, Local: "supported-calendar-component-set"}, `<comp xmlns="urn:ietf:params:xml:ns:caldav" name="VTODO"/>`},
+			{xml.Name{Space: csNS, Local: "getctag"}, ctag},
+		},
+	}
+}
+
+func objectResource(username string, o davObject) davResource {
+	ics := o.todo().render()
+	return davResource{
+		href: collectionHref(username) + url.PathEscape(o.name),
+		props: []davProp{
+			{xml.Name{Space: davNS, Local: "resourcetype"}, ""},
+			{xml.Name{Space: davNS, Local: "getetag"}, xmlEscape(o.etag())},
+			{xml.Name{Space: davNS, Local: "getcontenttype"}, "text/calendar; charset=utf-8; component=vtodo"},
+			{xml.Name{Space: davNS, Local: "getcontentlength"}, strconv.Itoa(len(ics))},
+			{xml.Name{Space: davNS, Local: "getlastmodified"}, o.promise.UpdatedAt.UTC().Format(http.TimeFormat)},
+			{xml.Name{Space: caldavNS, Local: "calendar-data"}, xmlEscape(ics)},
+		},
+	}
+}
+
+// writeMultistatus renders a 207 response. With no requested names every
+// property is returned (allprop); with names only, values are left empty
+// (propname).
+func writeMultistatus(c *fiber.Ctx, resources []davResource, want []xml.Name, namesOnly bool) error {
+	var b strings.Builder
+	b.WriteString(xml.Header)
+	b.WriteString(`<multistatus xmlns="DAV:">`)
+	for _, r := range resources {
+		b.WriteString("<response><href>" + xmlEscape(r.href) + "</href>")
+		if r.status != 0 {
+			fmt.Fprintf(&b, "<status>HTTP/1.1 %d %s</status></response>", r.status, http.StatusText(r.status))
+			continue
+		}
+
+		var found []davProp
+		var missing []xml.Name
+		if len(want) == 0 {
+			for _, p := range r.props {
+				if !davExplicitProps[p.name] {
+					found = append(found, p)
+				}
+			}
+		} else {
+			for _, name := range want {
+				ok := false
+				for _, p := range r.props {
+					if p.name == name {
+						found = append(found, p)
+						ok = true
+						break
+					}
+				}
+				if !ok {
+					missing = append(missing, name)
+				}
+			}
+		}
+
+		if len(found) > 0 {
+			b.WriteString("<propstat><prop>")
+			for _, p := range found {
+				inner := p.inner
+				if namesOnly {
+					inner = ""
+				}
+				fmt.Fprintf(&b, `<%s xmlns="%s">%s</%s>`, p.name.Local, xmlEscape(p.name.Space), inner, p.name.Local)
+			}
+			b.WriteString("</prop><status>HTTP/1.1 200 OK</status></propstat>")
+		}
+		if len(missing) > 0 {
+			b.WriteString("<propstat><prop>")
+			for _, name := range missing {
+				fmt.Fprintf(&b, `<%s xmlns="%s"/>`, name.Local, xmlEscape(name.Space))
+			}
+			b.WriteString("</prop><status>HTTP/1.1 404 Not Found</status></propstat>")
+		}
+		b.WriteString("</response>")
+	}
+	b.WriteString("</multistatus>")
+
+	c.Set(fiber.HeaderContentType, "application/xml; charset=utf-8")
+	return c.Status(fiber.StatusMultiStatus).SendString(b.String())
+}
+
+// CalDAVPropfindHandler answers PROPFIND for the root, principal, calendar
+// collection and individual promises.
+func CalDAVPropfindHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		username := c.Locals("username").(string)
+
+		p, err := resolveDAVPath(c, c.Path())
+		if err != nil {
+			return err
+		}
+
+		var req davPropfind
+		if len(c.Body()) > 0 {
+			if err := xml.Unmarshal(c.Body(), &req); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid PROPFIND body")
+			}
+		}
+		want := []xml.Name(req.Prop)
+		if req.AllProp != nil {
+			want = nil
+		}
+		// Depth: infinity is treated as 1; we never recurse further anyway
+		shallow := c.Get("Depth", "1") == "0"
+
+		var resources []davResource
+		switch {
+		case p.user == "":
+			resources = append(resources, rootResource(username))
+			if !shallow {
+				resources = append(resources, principalResource(username))
+			}
+		case !p.collection:
+			resources = append(resources, principalResource(username))
+			if !shallow {
+				objects, err := loadDAVObjects(st, userID, 0)
+				if err != nil {
+					return err
+				}
+				resources = append(resources, collectionResource(username, objects))
+			}
+		case p.object == "":
+			objects, err := loadDAVObjects(st, userID, 0)
+			if err != nil {
+				return err
+			}
+			resources = append(resources, collectionResource(username, objects))
+			if !shallow {
+				for _, o := range objects {
+					resources = append(resources, objectResource(username, o))
+				}
+			}
+		default:
+			o, err := findDAVObject(st, userID, p.object)
+			if err != nil {
+				return err
+			}
+			if o == nil {
+				return fiber.NewError(fiber.StatusNotFound, "Not found")
+			}
+			resources = append(resources, objectResource(username, *o))
+		}
+
+		return writeMultistatus(c, resources, want, req.PropName != nil)
+	}
+}
+
+// CalDAVReportHandler implements the calendar-query and calendar-multiget
+// reports on the promises collection.
+func CalDAVReportHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		username := c.Locals("username").(string)
+
+		p, err := resolveDAVPath(c, c.Path())
+		if err != nil {
+			return err
+		}
+		if !p.collection {
+			return fiber.NewError(fiber.StatusForbidden, "Reports are only supported on the promises collection")
+		}
+
+		var root struct{ XMLName xml.Name }
+		if err := xml.Unmarshal(c.Body(), &root); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid REPORT body")
+		}
+
+		var resources []davResource
+		var want []xml.Name
+		switch root.XMLName {
+		case xml.Name{Space: caldavNS, Local: "calendar-multiget"}:
+			var req calendarMultiget
+			if err := xml.Unmarshal(c.Body(), &req); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid calendar-multiget body")
+			}
+			want = req.Prop
+			for _, href := range req.Hrefs {
+				href = strings.TrimSpace(href)
+				if u, err := url.Parse(href); err == nil {
+					href = u.Path
+				}
+				hp, ok := parseDAVPath(href)
+				if !ok || hp.user != username || hp.object == "" {
+					resources = append(resources, davResource{href: href, status: fiber.StatusNotFound})
+					continue
+				}
+				o, err := findDAVObject(st, userID, hp.object)
+				if err != nil {
+					return err
+				}
+				if o == nil {
+					resources = append(resources, davResource{href: href, status: fiber.StatusNotFound})
+					continue
+				}
+				resources = append(resources, objectResource(username, *o))
+			}
+
+		case xml.Name{Space: caldavNS, Local: "calendar-query"}:
+			var req calendarQuery
+			if err := xml.Unmarshal(c.Body(), &req); err != nil {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid calendar-query body")
+			}
+			want = req.Prop
+			objects, err := loadDAVObjects(st, userID, 0)
+			if err != nil {
+				return err
+			}
+			for _, o := range objects {
+				if p.object != "" && o.name != p.object {
+					continue
+				}
+				if req.Filter.Comp.Name != "" && !req.Filter.Comp.matches(o.todo()) {
+					continue
+				}
+				resources = append(resources, objectResource(username, o))
+			}
+
+		default:
+			return fiber.NewError(fiber.StatusForbidden, "Unsupported report")
+		}
+
+		return writeMultistatus(c, resources, want, false)
+	}
+}
+
+// CalDAVGetHandler returns a single promise as an iCalendar VTODO.
+func CalDAVGetHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		p, err := resolveDAVPath(c, c.Path())
+		if err != nil {
+			return err
+		}
+		if p.object == "" {
+			return fiber.NewError(fiber.StatusMethodNotAllowed, "Only calendar objects can be fetched")
+		}
+
+		o, err := findDAVObject(st, userID, p.object)
+		if err != nil {
+			return err
+		}
+		if o == nil {
+			return fiber.NewError(fiber.StatusNotFound, "Not found")
+		}
+
+		c.Set(fiber.HeaderETag, o.etag())
+		c.Set(fiber.HeaderContentType, "text/calendar; charset=utf-8")
+		return c.SendString(o.todo().render())
+	}
+}
+
+// checkDAVPreconditions applies If-Match and If-None-Match to a resource that
+// may not exist yet.
+func checkDAVPreconditions(c *fiber.Ctx, existing *davObject) error {
+	if ifMatch := c.Get(fiber.HeaderIfMatch); ifMatch != "" {
+		if existing == nil || (ifMatch != "*" && ifMatch != existing.etag()) {
+			return fiber.NewError(fiber.StatusPreconditionFailed, "Resource has changed")
+		}
+	}
+	if c.Get(fiber.HeaderIfNoneMatch) == "*" && existing != nil {
+		return fiber.NewError(fiber.StatusPreconditionFailed, "Resource already exists")
+	}
+	return nil
+}
+
+// CalDAVPutHandler creates or updates a promise from a VTODO. Status changes
+// go through the same transition and event logic as the REST API.
+func CalDAVPutHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		p, err := resolveDAVPath(c, c.Path())
+		if err != nil {
+			return err
+		}
+		if p.object == "" {
+			return fiber.NewError(fiber.StatusMethodNotAllowed, "Only calendar objects can be written")
+		}
+
+		todo, err := parseVTODO(c.Body())
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, err.Error())
+		}
+
+		existing, err := findDAVObject(st, userID, p.object)
+		if err != nil {
+			return err
+		}
+		if err := checkDAVPreconditions(c, existing); err != nil {
+			return err
+		}
+
+		state := icalStatusToPromise(todo.Status)
+		recipient := recipientFromVTODO(todo)
+
+		if existing == nil {
+			if recipient == "" {
+				recipient = "yourself"
+			}
+			err := st.InTx(func(tx store.Store) error {
+				promise, err := insertPromise(tx, userID, models.CreatePromiseRequest{
+					Recipient:   recipient,
+					Description: todo.Summary,
+					DueDate:     todo.Due,
+				})
+				if err != nil {
+					return err
+				}
+				if state != "active" {
+					if _, err := applyPromiseState(tx, promise, state, "", nil); err != nil {
+						return err
+					}
+				}
+				return tx.CalDAVObjects().Create(store.CalDAVObject{
+					PromiseID: promise.ID,
+					UserID:    userID,
+					UID:       todo.UID,
+					Name:      p.object,
+				})
+			})
+			if errors.Is(err, store.ErrConflict) {
+				return fiber.NewError(fiber.StatusConflict, "UID already in use")
+			}
+			if err != nil {
+				return err
+			}
+			return c.SendStatus(fiber.StatusCreated)
+		}
+
+		current := existing.promise
+		if recipient == "" {
+			recipient = current.Recipient
+		}
+		dueChanged := !sameTime(todo.Due, current.DueDate)
+		if current.Encrypted {
+			// Task apps only see placeholder text for encrypted promises
+			recipient, todo.Summary = current.Recipient, current.Description
+		}
+		updated := current
+		err = st.InTx(func(tx store.Store) error {
+			if recipient != current.Recipient || todo.Summary != current.Description || dueChanged {
+				updated.Recipient = recipient
+				updated.Description = todo.Summary
+				updated.DueDate = todo.Due
+				if err := updatePromise(tx, &current, &updated, actorCalDAV, userID); err != nil {
+					return err
+				}
+				if dueChanged {
+					if err := rescheduleDueReminders(tx, &updated, false); err != nil {
+						return err
+					}
+				}
+			}
+			if state != current.CurrentState {
+				if _, err := applyPromiseState(tx, &updated, state, "", nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, store.ErrStale) {
+			return fiber.NewError(fiber.StatusPreconditionFailed, "Resource has changed")
+		}
+		if err != nil {
+			return err
+		}
+		if state == "kept" && current.CurrentState != "kept" {
+			notifyRecipient(st, updated, recipientEmailKept)
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
+
+// CalDAVDeleteHandler deletes the promise behind a calendar object.
+func CalDAVDeleteHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		p, err := resolveDAVPath(c, c.Path())
+		if err != nil {
+			return err
+		}
+		if p.object == "" {
+			return fiber.NewError(fiber.StatusMethodNotAllowed, "Only calendar objects can be deleted")
+		}
+
+		existing, err := findDAVObject(st, userID, p.object)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return fiber.NewError(fiber.StatusNotFound, "Not found")
+		}
+		if err := checkDAVPreconditions(c, existing); err != nil {
+			return err
+		}
+
+		if err := trashPromise(st, existing.promise.ID, userID); err != nil {
+			return err
+		}
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+}
@@ -0,0 +1,278 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"kept/internal/models"
+	"kept/internal/store"
+)
+
+func basicAuth(username, password string) string {
+	return "Basic " + base64.StdEncoding.EncodeToString([]byte(username+":"+password))
+}
+
+func findPromise(t *testing.T, st store.Store, userID int, description string) models.Promise {
+	t.Helper()
+	promises, err := st.Promises().ListByUser(userID, store.PromiseFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range promises {
+		if p.Description == description {
+			return p
+		}
+	}
+	t.Fatalf("No promise %q", description)
+	return models.Promise{}
+}
+
+func TestCalDAVSync(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+
+	// Register user and create a promise through the REST API
+	body, _ := json.Marshal(models.RegisterRequest{Username: "davuser", Password: "password123"})
+	req := httptest.NewRequest("POST", "/api/auth/register", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req)
+	var authResp models.AuthResponse
+	bodyBytes, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(bodyBytes, &authResp)
+
+	body, _ = json.Marshal(models.CreatePromiseRequest{Recipient: "Mom", Description: "Call on Sunday"})
+	req = httptest.NewRequest("POST", "/api/promises/", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+authResp.Token)
+	resp, _ = app.Test(req)
+	var promise models.Promise
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	json.Unmarshal(bodyBytes, &promise)
+
+	// Wrong password is rejected
+	req = httptest.NewRequest("PROPFIND", "/caldav/davuser/promises/", nil)
+	req.Header.Set("Authorization", basicAuth("davuser", "wrong"))
+	resp, _ = app.Test(req)
+	if resp.StatusCode != 401 {
+		t.Fatalf("Expected status 401, got %d", resp.StatusCode)
+	}
+
+	// The collection lists the promise
+	req = httptest.NewRequest("PROPFIND", "/caldav/davuser/promises/", strings.NewReader(
+		`<?xml version="1.0"?><d:propfind xmlns:d="DAV:"><d:prop><d:getetag/><d:resourcetype/></d:prop></d:propfind>`))
+	req.Header.Set("Authorization", basicAuth("davuser", "password123"))
+	req.Header.Set("Depth", "1")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != 207 {
+		t.Fatalf("Expected status 207, got %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	if !strings.Contains(string(bodyBytes), "/caldav/davuser/promises/kept-1.ics") {
+		t.Fatalf("Expected promise in collection listing: %s", string(bodyBytes))
+	}
+
+	// The VTODO is readable
+	req = httptest.NewRequest("GET", "/caldav/davuser/promises/kept-1.ics", nil)
+	req.Header.Set("Authorization", basicAuth("davuser", "password123"))
+	resp, _ = app.Test(req)
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || !strings.Contains(string(bodyBytes), "SUMMARY:Call on Sunday") {
+		t.Fatalf("Unexpected VTODO (%d): %s", resp.StatusCode, string(bodyBytes))
+	}
+	etag := resp.Header.Get("ETag")
+
+	// Completing it in the task app marks the promise kept
+	completed := strings.Replace(string(bodyBytes), "STATUS:NEEDS-ACTION", "STATUS:COMPLETED", 1)
+	req = httptest.NewRequest("PUT", "/caldav/davuser/promises/kept-1.ics", strings.NewReader(completed))
+	req.Header.Set("Authorization", basicAuth("davuser", "password123"))
+	req.Header.Set("If-Match", etag)
+	resp, _ = app.Test(req)
+	if resp.StatusCode != 204 {
+		bodyBytes, _ = io.ReadAll(resp.Body)
+		t.Fatalf("Expected status 204, got %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	stored, err := st.Promises().Get(promise.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored.CurrentState != "kept" {
+		t.Fatalf("Expected promise state 'kept', got '%s'", stored.CurrentState)
+	}
+	events, _ := st.Events().ListByPromise(promise.ID)
+	if len(events) != 2 || events[1].State != "kept" {
+		t.Fatalf("Expected one kept event, got %+v", events)
+	}
+
+	// A stale ETag is refused
+	req = httptest.NewRequest("PUT", "/caldav/davuser/promises/kept-1.ics", strings.NewReader(completed))
+	req.Header.Set("Authorization", basicAuth("davuser", "password123"))
+	req.Header.Set("If-Match", etag)
+	resp, _ = app.Test(req)
+	if resp.StatusCode != 412 {
+		t.Fatalf("Expected status 412, got %d", resp.StatusCode)
+	}
+
+	// A task created in the app becomes a promise, cancelling it breaks it
+	todo := "BEGIN:VCALENDAR\r\nVERSION:2.0\r\nBEGIN:VTODO\r\nUID:abc-123\r\nSUMMARY:Return the drill\r\n" +
+		"DESCRIPTION:Promised to: Sam\r\nDUE:20300101T120000Z\r\nEND:VTODO\r\nEND:VCALENDAR\r\n"
+	req = httptest.NewRequest("PUT", "/caldav/davuser/promises/abc-123.ics", strings.NewReader(todo))
+	req.Header.Set("Authorization", basicAuth("davuser", "password123"))
+	req.Header.Set("If-None-Match", "*")
+	resp, _ = app.Test(req)
+	if resp.StatusCode != 201 {
+		bodyBytes, _ = io.ReadAll(resp.Body)
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+
+	drill := findPromise(t, st, authResp.User.ID, "Return the drill")
+	if drill.Recipient != "Sam" {
+		t.Fatalf("Expected recipient 'Sam', got '%s'", drill.Recipient)
+	}
+
+	cancelled := strings.Replace(todo, "END:VTODO", "STATUS:CANCELLED\r\nEND:VTODO", 1)
+	req = httptest.NewRequest("PUT", "/caldav/davuser/promises/abc-123.ics", strings.NewReader(cancelled))
+	req.Header.Set("Authorization", basicAuth("davuser", "password123"))
+	resp, _ = app.Test(req)
+	if resp.StatusCode != 204 {
+		t.Fatalf("Expected status 204, got %d", resp.StatusCode)
+	}
+	if drill = findPromise(t, st, authResp.User.ID, "Return the drill"); drill.CurrentState != "broken" {
+		t.Fatalf("Expected promise state 'broken', got '%s'", drill.CurrentState)
+	}
+
+	// calendar-query can leave out completed tasks
+	req = httptest.NewRequest("REPORT", "/caldav/davuser/promises/", strings.NewReader(
+		`<?xml version="1.0"?><c:calendar-query xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav">`+
+			`<d:prop><d:getetag/></d:prop><c:filter><c:comp-filter name="VCALENDAR"><c:comp-filter name="VTODO">`+
+			`<c:prop-filter name="COMPLETED"><c:is-not-defined/></c:prop-filter></c:comp-filter></c:comp-filter></c:filter></c:calendar-query>`))
+	req.Header.Set("Authorization", basicAuth("davuser", "password123"))
+	resp, _ = app.Test(req)
+	bodyBytes, _ = io.ReadAll(resp.Body)
+	if resp.StatusCode != 207 {
+		t.Fatalf("Expected status 207, got %d: %s", resp.StatusCode, string(bodyBytes))
+	}
+	if strings.Contains(string(bodyBytes), "kept-1.ics") || !strings.Contains(string(bodyBytes), "abc-123.ics") {
+		t.Fatalf("Unexpected calendar-query result: %s", string(bodyBytes))
+	}
+
+	// Deleting the object deletes the promise
+	req = httptest.NewRequest("DELETE", "/caldav/davuser/promises/abc-123.ics", nil)
+	req.Header.Set("Authorization", basicAuth("davuser", "password123"))
+	resp, _ = app.Test(req)
+	if resp.StatusCode != 204 {
+		t.Fatalf("Expected status 204, got %d", resp.StatusCode)
+	}
+	remaining, _ := st.Promises().ListByUser(authResp.User.ID, store.PromiseFilter{})
+	if len(remaining) != 1 {
+		t.Fatalf("Expected 1 promise left, got %d", len(remaining))
+	}
+}
+
+func TestCalDAVAppPasswords(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "davapp")
+	bob := registerUser(t, app, "davappbob")
+
+	propfind := func(username, password string) int {
+		t.Helper()
+		req := httptest.NewRequest("PROPFIND", "/caldav/"+username+"/promises/", nil)
+		req.Header.Set("Authorization", basicAuth(username, password))
+		req.Header.Set("Depth", "0")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode
+	}
+	if status := propfind("davapp", "password123"); status != 207 {
+		t.Fatalf("Expected the account password to work without app passwords, got %d", status)
+	}
+
+	if resp, _ := doJSON(t, app, "POST", "/api/user/app-passwords", alice.Token, models.CreateAppPasswordRequest{Name: " "}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 without a name, got %d", resp.StatusCode)
+	}
+	resp, body := doJSON(t, app, "POST", "/api/user/app-passwords", alice.Token, models.CreateAppPasswordRequest{Name: "Phone"})
+	var created models.AppPassword
+	json.Unmarshal(body, &created)
+	if resp.StatusCode != 201 || created.Password == "" || created.Name != "Phone" {
+		t.Fatalf("Expected an app password, got %d: %s", resp.StatusCode, body)
+	}
+	_, body = doJSON(t, app, "GET", "/api/user/app-passwords", alice.Token, nil)
+	if strings.Contains(string(body), created.Password) || !strings.Contains(string(body), "Phone") {
+		t.Fatalf("Expected the list without the password, got %s", body)
+	}
+
+	// Once there is an app password, only app passwords work
+	if status := propfind("davapp", created.Password); status != 207 {
+		t.Fatalf("Expected the app password to work, got %d", status)
+	}
+	if status := propfind("davapp", "password123"); status != 401 {
+		t.Fatalf("Expected the account password to stop working, got %d", status)
+	}
+	if status := propfind("davappbob", created.Password); status != 401 {
+		t.Fatalf("Expected another user's app password to be rejected, got %d", status)
+	}
+
+	path := "/api/user/app-passwords/" + strconv.Itoa(created.ID)
+	if resp, _ := doJSON(t, app, "DELETE", path, bob.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 deleting another user's app password, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "DELETE", path, alice.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if status := propfind("davapp", created.Password); status != 401 {
+		t.Fatalf("Expected a revoked app password to be rejected, got %d", status)
+	}
+}
+
+func TestCalDAVThrottlesFailedSignIns(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "davthrottle")
+	registerUser(t, app, "davthrottlebob")
+
+	propfind := func(username, password string) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest("PROPFIND", "/caldav/"+username+"/promises/", nil)
+		req.Header.Set("Authorization", basicAuth(username, password))
+		req.Header.Set("Depth", "0")
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	for i := range 10 {
+		if resp := propfind("davthrottle", "guess"+strconv.Itoa(i)); resp.StatusCode != 401 {
+			t.Fatalf("Expected status 401 for guess %d, got %d", i, resp.StatusCode)
+		}
+	}
+	// Further attempts aren't checked, even with the right password, and
+	// other users from the same address wait too
+	for _, username := range []string{"davthrottle", "davthrottlebob"} {
+		resp := propfind(username, "password123")
+		if resp.StatusCode != 429 || resp.Header.Get("Retry-After") == "" {
+			t.Fatalf("Expected status 429 with Retry-After for %s, got %d", username, resp.StatusCode)
+		}
+	}
+
+	// App passwords can't be guessed, so they still work
+	_, body := doJSON(t, app, "POST", "/api/user/app-passwords", alice.Token, models.CreateAppPasswordRequest{Name: "Phone"})
+	var created models.AppPassword
+	json.Unmarshal(body, &created)
+	if resp := propfind("davthrottle", created.Password); resp.StatusCode != 207 {
+		t.Fatalf("Expected the app password to work while locked out, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,371 @@
+package api
+
+import (
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxContactNameLength = 100
+
+// contactForRecipient returns the user's contact whose name or one of whose
+// aliases matches recipient, ignoring case and surrounding space, creating
+// one if there is none. It returns nil for an empty recipient.
+func contactForRecipient(tx store.Store, userID int, recipient string) (*models.Contact, error) {
+	recipient = strings.TrimSpace(recipient)
+	if recipient == "" {
+		return nil, nil
+	}
+	contacts, err := tx.Contacts().ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	for i, contact := range contacts {
+		if strings.EqualFold(contact.Name, recipient) {
+			return &contacts[i], nil
+		}
+		for _, alias := range contact.Aliases {
+			if strings.EqualFold(alias, recipient) {
+				return &contacts[i], nil
+			}
+		}
+	}
+	contact := &models.Contact{UserID: userID, Name: recipient}
+	if err := tx.Contacts().Create(contact); err != nil {
+		return nil, err
+	}
+	return contact, nil
+}
+
+// linkContact links a promise to the contact its recipient names. End-to-end
+// encrypted promises have no readable recipient and are left alone.
+func linkContact(tx store.Store, p *models.Promise) error {
+	if p.Encrypted {
+		return nil
+	}
+	contact, err := contactForRecipient(tx, p.UserID, p.Recipient)
+	if err != nil {
+		return err
+	}
+	p.ContactID = nil
+	if contact != nil {
+		p.ContactID = &contact.ID
+	}
+	return nil
+}
+
+// resolveContact looks up a contact a promise request links to. Contacts
+// that don't exist or belong to someone else are a bad request.
+func resolveContact(st store.Store, userID, id int) (*models.Contact, error) {
+	contact, err := st.Contacts().Get(id, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Unknown contact "+strconv.Itoa(id))
+	}
+	return contact, err
+}
+
+// getOwnedContact loads a contact from the :id route parameter.
+func getOwnedContact(c *fiber.Ctx, st store.Store, userID int) (*models.Contact, error) {
+	contactID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid contact ID")
+	}
+	contact, err := st.Contacts().Get(contactID, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Contact not found")
+	}
+	return contact, err
+}
+
+// LinkRecipientsToContacts links promises to contacts by recipient where
+// the contacts migration couldn't, such as recipients encrypted at rest. It
+// returns the number of promises linked.
+func LinkRecipientsToContacts(st store.Store) (int, error) {
+	unlinked, err := st.Contacts().ListUnlinked()
+	if err != nil {
+		return 0, err
+	}
+	linked := 0
+	for _, p := range unlinked {
+		err := st.InTx(func(tx store.Store) error {
+			contact, err := contactForRecipient(tx, p.UserID, p.Recipient)
+			if err != nil || contact == nil {
+				return err
+			}
+			return tx.Contacts().Link(p.ID, contact.ID)
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			continue // linked in the meantime
+		}
+		if err != nil {
+			return linked, err
+		}
+		linked++
+	}
+	return linked, nil
+}
+
+// cleanAliases trims aliases and drops empty ones, duplicates and ones
+// matching the name.
+func cleanAliases(name string, aliases []string) []string {
+	cleaned := []string{}
+	seen := map[string]bool{strings.ToLower(name): true}
+	for _, alias := range aliases {
+		alias = strings.TrimSpace(alias)
+		if alias == "" || seen[strings.ToLower(alias)] {
+			continue
+		}
+		seen[strings.ToLower(alias)] = true
+		cleaned = append(cleaned, alias)
+	}
+	return cleaned
+}
+
+// applyContactRequest validates req and applies it to contact.
+func applyContactRequest(contact *models.Contact, req models.ContactRequest) error {
+	optional := func(o models.Optional[string]) string {
+		if o.Value == nil {
+			return ""
+		}
+		return strings.TrimSpace(*o.Value)
+	}
+	if req.Name.Set {
+		name := optional(req.Name)
+		if name == "" || utf8.RuneCountInString(name) > maxContactNameLength {
+			return fiber.NewError(fiber.StatusBadRequest, "Contact names must be 1 to 100 characters")
+		}
+		contact.Name = name
+	}
+	if req.Aliases.Set {
+		contact.Aliases = nil
+		if req.Aliases.Value != nil {
+			contact.Aliases = *req.Aliases.Value
+		}
+	}
+	for _, alias := range contact.Aliases {
+		if utf8.RuneCountInString(strings.TrimSpace(alias)) > maxContactNameLength {
+			return fiber.NewError(fiber.StatusBadRequest, "Aliases must be at most 100 characters")
+		}
+	}
+	contact.Aliases = cleanAliases(contact.Name, contact.Aliases)
+	if req.Email.Set {
+		contact.Email = optional(req.Email)
+		if contact.Email != "" && !strings.Contains(contact.Email, "@") {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid email")
+		}
+	}
+	if req.Phone.Set {
+		contact.Phone = optional(req.Phone)
+	}
+	if req.Notes.Set {
+		contact.Notes = optional(req.Notes)
+	}
+	return nil
+}
+
+func ListContactsHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		contacts, err := st.Contacts().ListByUser(userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(contacts)
+	}
+}
+
+func CreateContactHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.ContactRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		req.Name.Set = true
+
+		contact := &models.Contact{UserID: userID}
+		if err := applyContactRequest(contact, req); err != nil {
+			return err
+		}
+		if err := st.Contacts().Create(contact); err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusCreated).JSON(contact)
+	}
+}
+
+func GetContactHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		contact, err := getOwnedContact(c, st, userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(contact)
+	}
+}
+
+// UpdateContactHandler edits a contact. Renaming a contact doesn't change
+// the recipient of its promises.
+func UpdateContactHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.ContactRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		contact, err := getOwnedContact(c, st, userID)
+		if err != nil {
+			return err
+		}
+		if err := applyContactRequest(contact, req); err != nil {
+			return err
+		}
+		if err := st.Contacts().Update(contact); err != nil {
+			return err
+		}
+		return c.JSON(contact)
+	}
+}
+
+// DeleteContactHandler deletes a contact. Its promises are kept and
+// unlinked.
+func DeleteContactHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		contactID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid contact ID")
+		}
+
+		err = st.InTx(func(tx store.Store) error {
+			return tx.Contacts().Delete(contactID, userID)
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Contact not found")
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
+
+// MergeContactHandler merges the contact in the request into the one in the
+// route: its promises move over, its name and aliases become aliases, and
+// details the remaining contact lacks are copied. The merged contact is
+// deleted.
+func MergeContactHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.MergeContactRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		contact, err := getOwnedContact(c, st, userID)
+		if err != nil {
+			return err
+		}
+		if req.ContactID == contact.ID {
+			return fiber.NewError(fiber.StatusBadRequest, "Can't merge a contact into itself")
+		}
+		other, err := resolveContact(st, userID, req.ContactID)
+		if err != nil {
+			return err
+		}
+
+		contact.Aliases = cleanAliases(contact.Name, append(append(contact.Aliases, other.Name), other.Aliases...))
+		if contact.Email == "" {
+			contact.Email = other.Email
+		}
+		if contact.Phone == "" {
+			contact.Phone = other.Phone
+		}
+		if contact.Notes == "" {
+			contact.Notes = other.Notes
+		} else if other.Notes != "" {
+			contact.Notes += "\n\n" + other.Notes
+		}
+
+		err = st.InTx(func(tx store.Store) error {
+			if err := tx.Contacts().MovePromises(other.ID, contact.ID); err != nil {
+				return err
+			}
+			if err := tx.Contacts().Update(contact); err != nil {
+				return err
+			}
+			return tx.Contacts().Delete(other.ID, userID)
+		})
+		if err != nil {
+			return err
+		}
+		return c.JSON(contact)
+	}
+}
+
+// ContactHistoryHandler returns the contact's promises outside the trash,
+// newest first, with their events and tags.
+func ContactHistoryHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		contact, err := getOwnedContact(c, st, userID)
+		if err != nil {
+			return err
+		}
+		promises, err := st.Promises().ListByUser(userID, store.PromiseFilter{ContactID: contact.ID})
+		if err != nil {
+			return err
+		}
+		sort.SliceStable(promises, func(i, j int) bool { return promises[i].CreatedAt.After(promises[j].CreatedAt) })
+		for i := range promises {
+			if promises[i].Events, err = st.Events().ListByPromise(promises[i].ID); err != nil {
+				return err
+			}
+		}
+		if err := attachTagsToList(st, userID, promises); err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"contact": contact, "promises": promises})
+	}
+}
+
+// ContactStatsHandler counts the contact's promises outside the trash by
+// state and reports how many of the resolved ones were kept.
+func ContactStatsHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		contact, err := getOwnedContact(c, st, userID)
+		if err != nil {
+			return err
+		}
+		promises, err := st.Promises().ListByUser(userID, store.PromiseFilter{ContactID: contact.ID})
+		if err != nil {
+			return err
+		}
+
+		stats := models.ContactStats{Counts: map[string]int{"active": 0, "kept": 0, "broken": 0}}
+		for _, p := range promises {
+			stats.Counts[p.CurrentState]++
+			stats.Total++
+		}
+		if resolved := stats.Counts["kept"] + stats.Counts["broken"]; resolved > 0 {
+			rate := float64(stats.Counts["kept"]) / float64(resolved)
+			stats.KeepRate = &rate
+		}
+		return c.JSON(stats)
+	}
+}
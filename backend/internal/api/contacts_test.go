@@ -0,0 +1,153 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"kept/internal/api"
+	"kept/internal/models"
+)
+
+func TestContacts(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	auth := registerUser(t, app, "contactuser")
+	other := registerUser(t, app, "othercontacts")
+
+	create := func(req models.CreatePromiseRequest) models.Promise {
+		t.Helper()
+		resp, body := doJSON(t, app, "POST", "/api/promises/", auth.Token, req)
+		if resp.StatusCode != 201 {
+			t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+		}
+		var p models.Promise
+		json.Unmarshal(body, &p)
+		return p
+	}
+
+	// Recipients are matched to contacts ignoring case and spaces
+	first := create(models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the drill"})
+	second := create(models.CreatePromiseRequest{Recipient: " sam ", Description: "Lunch"})
+	if first.ContactID == nil || second.ContactID == nil || *first.ContactID != *second.ContactID {
+		t.Fatalf("Expected both promises linked to one contact, got %v and %v", first.ContactID, second.ContactID)
+	}
+	samID := *first.ContactID
+	samPath := "/api/contacts/" + strconv.Itoa(samID)
+
+	resp, body := doJSON(t, app, "PATCH", samPath, auth.Token, map[string]any{"aliases": []string{"Samuel", "sam", " "}, "email": "sam@example.com"})
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	var sam models.Contact
+	json.Unmarshal(body, &sam)
+	if sam.Name != "Sam" || len(sam.Aliases) != 1 || sam.Aliases[0] != "Samuel" || sam.Email != "sam@example.com" {
+		t.Fatalf("Unexpected contact: %s", body)
+	}
+	third := create(models.CreatePromiseRequest{Recipient: "samuel", Description: "Help move"})
+	if third.ContactID == nil || *third.ContactID != samID {
+		t.Fatalf("Expected the alias to match contact %d, got %v", samID, third.ContactID)
+	}
+
+	// A contact can be picked instead of typing the recipient
+	resp, body = doJSON(t, app, "POST", "/api/contacts/", auth.Token, map[string]any{"name": "Alex", "phone": "555-0100"})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var alex models.Contact
+	json.Unmarshal(body, &alex)
+	picked := create(models.CreatePromiseRequest{Description: "Water plants", ContactID: &alex.ID})
+	if picked.Recipient != "Alex" || picked.ContactID == nil || *picked.ContactID != alex.ID {
+		t.Fatalf("Expected the contact's name as recipient, got %+v", picked)
+	}
+	_, body = doJSON(t, app, "POST", "/api/contacts/", other.Token, map[string]any{"name": "Theirs"})
+	var theirs models.Contact
+	json.Unmarshal(body, &theirs)
+	if resp, _ := doJSON(t, app, "POST", "/api/promises/", auth.Token, models.CreatePromiseRequest{Description: "x", ContactID: &theirs.ID}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for another user's contact, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "GET", "/api/contacts/"+strconv.Itoa(theirs.ID), auth.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 for another user's contact, got %d", resp.StatusCode)
+	}
+
+	// Changing the recipient relinks the promise
+	resp, body = doJSONIfMatch(t, app, "PATCH", "/api/promises/"+strconv.Itoa(second.ID), auth.Token, "*", map[string]any{"recipient": "Alex"})
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	var relinked models.Promise
+	json.Unmarshal(body, &relinked)
+	if relinked.ContactID == nil || *relinked.ContactID != alex.ID {
+		t.Fatalf("Expected the promise relinked to contact %d, got %v", alex.ID, relinked.ContactID)
+	}
+
+	doJSONIfMatch(t, app, "PUT", "/api/promises/"+strconv.Itoa(first.ID)+"/state", auth.Token, "*", models.UpdatePromiseStateRequest{State: "kept"})
+	doJSONIfMatch(t, app, "PUT", "/api/promises/"+strconv.Itoa(third.ID)+"/state", auth.Token, "*", models.UpdatePromiseStateRequest{State: "broken"})
+
+	_, body = doJSON(t, app, "GET", samPath+"/stats", auth.Token, nil)
+	var stats models.ContactStats
+	json.Unmarshal(body, &stats)
+	if stats.Total != 2 || stats.Counts["kept"] != 1 || stats.Counts["broken"] != 1 || stats.KeepRate == nil || *stats.KeepRate != 0.5 {
+		t.Fatalf("Unexpected stats: %s", body)
+	}
+	_, body = doJSON(t, app, "GET", "/api/contacts/"+strconv.Itoa(alex.ID)+"/stats", auth.Token, nil)
+	json.Unmarshal(body, &stats)
+	if stats.Total != 2 || stats.KeepRate != nil {
+		t.Fatalf("Expected no keep rate without resolved promises, got %s", body)
+	}
+
+	var history struct {
+		Contact  models.Contact   `json:"contact"`
+		Promises []models.Promise `json:"promises"`
+	}
+	_, body = doJSON(t, app, "GET", samPath+"/history", auth.Token, nil)
+	json.Unmarshal(body, &history)
+	if history.Contact.ID != samID || len(history.Promises) != 2 || history.Promises[0].ID != third.ID || len(history.Promises[1].Events) != 2 {
+		t.Fatalf("Unexpected history: %s", body)
+	}
+
+	// Merging moves the promises and keeps the merged name as an alias
+	resp, body = doJSON(t, app, "POST", samPath+"/merge", auth.Token, models.MergeContactRequest{ContactID: alex.ID})
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	json.Unmarshal(body, &sam)
+	if len(sam.Aliases) != 2 || sam.Aliases[1] != "Alex" || sam.Phone != "555-0100" {
+		t.Fatalf("Unexpected merged contact: %s", body)
+	}
+	var promises []models.Promise
+	_, body = doJSON(t, app, "GET", "/api/promises/?contact="+strconv.Itoa(samID), auth.Token, nil)
+	json.Unmarshal(body, &promises)
+	if len(promises) != 4 {
+		t.Fatalf("Expected all 4 promises linked after merging, got %d", len(promises))
+	}
+	if resp, _ := doJSON(t, app, "GET", "/api/contacts/"+strconv.Itoa(alex.ID), auth.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected the merged contact to be gone, got %d", resp.StatusCode)
+	}
+
+	// Deleting a contact keeps its promises
+	if resp, _ := doJSON(t, app, "DELETE", samPath, auth.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	_, body = doJSON(t, app, "GET", "/api/promises/"+strconv.Itoa(first.ID), auth.Token, nil)
+	var unlinked models.Promise
+	json.Unmarshal(body, &unlinked)
+	if unlinked.ID != first.ID || unlinked.ContactID != nil {
+		t.Fatalf("Expected the promise to survive unlinked, got %s", body)
+	}
+
+	// The startup backfill links the now unlinked promises again
+	n, err := api.LinkRecipientsToContacts(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Fatalf("Expected 4 promises linked, got %d", n)
+	}
+	var contacts []models.Contact
+	_, body = doJSON(t, app, "GET", "/api/contacts/", auth.Token, nil)
+	json.Unmarshal(body, &contacts)
+	if len(contacts) != 3 || contacts[0].Name != "Alex" {
+		t.Fatalf("Expected contacts for Alex, Sam and samuel, got %s", body)
+	}
+}
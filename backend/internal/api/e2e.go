@@ -0,0 +1,136 @@
+package api
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxEnvelopeSize caps the ciphertext of a single envelope.
+const maxEnvelopeSize = 64 * 1024
+
+// Wording used in reminders and CalDAV for end-to-end encrypted promises,
+// whose content the server can't read.
+const (
+	encryptedReminderTitle  = "Reminder about one of your promises"
+	encryptedReminderBody   = "Open Kept to see the details."
+	encryptedPromiseSummary = "Encrypted promise"
+)
+
+// reminderText returns the push title and body for a reminder about a
+// promise.
+func reminderText(recipient, description string, encrypted bool) (title, body string) {
+	if encrypted {
+		return encryptedReminderTitle, encryptedReminderBody
+	}
+	return fmt.Sprintf("Reminder about your promise to: %s", recipient), description
+}
+
+// validEnvelope checks that an envelope is well-formed. Its contents can't
+// be checked.
+func validEnvelope(e *models.Envelope) bool {
+	if e == nil || e.Alg == "" || len(e.Alg) > 32 || e.Nonce == "" || e.Ciphertext == "" {
+		return false
+	}
+	if len(e.Ciphertext) > base64.StdEncoding.EncodedLen(maxEnvelopeSize) {
+		return false
+	}
+	_, nonceErr := base64.StdEncoding.DecodeString(e.Nonce)
+	_, ctErr := base64.StdEncoding.DecodeString(e.Ciphertext)
+	return nonceErr == nil && ctErr == nil
+}
+
+// hasEncryptedPromises reports whether any of the user's promises are end-to-end
+// encrypted.
+func hasEncryptedPromises(st store.Store, userID int) (bool, error) {
+	promises, err := st.Promises().ListByUser(userID, store.PromiseFilter{})
+	if err != nil {
+		return false, err
+	}
+	for _, p := range promises {
+		if p.Encrypted {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// GetE2EKeyCheckHandler returns the user's end-to-end encryption key check so
+// a client can verify the passphrase before decrypting promises.
+func GetE2EKeyCheckHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		check, err := st.Users().GetE2EKeyCheck(userID)
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "End-to-end encryption is not set up")
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(check)
+	}
+}
+
+// SetE2EKeyCheckHandler turns on end-to-end encryption by storing the key
+// check. Promises encrypted under an old passphrase would become unreadable,
+// so replacing it is refused while any exist.
+func SetE2EKeyCheckHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var check models.E2EKeyCheck
+		if err := c.BodyParser(&check); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		if check.KDF == "" || check.Iterations <= 0 || check.Salt == "" || !validEnvelope(&check.Check) {
+			return fiber.NewError(fiber.StatusBadRequest, "kdf, iterations, salt and check are required")
+		}
+
+		if err := requireNoEncryptedPromises(st, userID); err != nil {
+			return err
+		}
+		if err := st.Users().SetE2EKeyCheck(userID, &check); err != nil {
+			return err
+		}
+		return c.JSON(check)
+	}
+}
+
+// DeleteE2EKeyCheckHandler turns end-to-end encryption off for a user with no
+// encrypted promises.
+func DeleteE2EKeyCheckHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		if err := requireNoEncryptedPromises(st, userID); err != nil {
+			return err
+		}
+		if err := st.Users().SetE2EKeyCheck(userID, nil); err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
+
+func requireNoEncryptedPromises(st store.Store, userID int) error {
+	_, err := st.Users().GetE2EKeyCheck(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	encrypted, err := hasEncryptedPromises(st, userID)
+	if err != nil {
+		return err
+	}
+	if encrypted {
+		return fiber.NewError(fiber.StatusConflict, "Delete your encrypted promises before changing the passphrase")
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"kept/internal/api"
+	"kept/internal/models"
+)
+
+func TestEndToEndEncryptedPromises(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	auth := registerUser(t, app, "e2euser")
+
+	envelope := &models.Envelope{Alg: "AES-GCM-256", Nonce: "bm9uY2Vub25jZQ==", Ciphertext: "c2VjcmV0IHN0dWZm"}
+	create := models.CreatePromiseRequest{Encrypted: true, Envelope: envelope}
+
+	// Encrypted promises need a key check first
+	resp, _ := doJSON(t, app, "POST", "/api/promises/", auth.Token, create)
+	if resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 without a key check, got %d", resp.StatusCode)
+	}
+	check := models.E2EKeyCheck{KDF: "PBKDF2-SHA256", Iterations: 600000, Salt: "c2FsdA==", Check: *envelope}
+	resp, body := doJSON(t, app, "PUT", "/api/user/e2e", auth.Token, check)
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+
+	// Plaintext alongside the envelope is refused
+	leaky := create
+	leaky.Description = "Secret plan"
+	if resp, _ := doJSON(t, app, "POST", "/api/promises/", auth.Token, leaky); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for plaintext content, got %d", resp.StatusCode)
+	}
+
+	resp, body = doJSON(t, app, "POST", "/api/promises/", auth.Token, create)
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	if !promise.Encrypted || promise.Envelope == nil || *promise.Envelope != *envelope || promise.Description != "" {
+		t.Fatalf("Unexpected encrypted promise: %+v", promise)
+	}
+
+	// Reflection notes must be encrypted as well
+	path := "/api/promises/" + strconv.Itoa(promise.ID) + "/state"
+	resp, _ = doJSONIfMatch(t, app, "PUT", path, auth.Token, "*", models.UpdatePromiseStateRequest{State: "kept", ReflectionNote: "plaintext"})
+	if resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for a plaintext note, got %d", resp.StatusCode)
+	}
+	resp, _ = doJSONIfMatch(t, app, "PUT", path, auth.Token, "*", models.UpdatePromiseStateRequest{State: "kept", NoteEnvelope: envelope})
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	resp, body = doJSON(t, app, "GET", "/api/promises/"+strconv.Itoa(promise.ID), auth.Token, nil)
+	json.Unmarshal(body, &promise)
+	if len(promise.Events) != 2 || promise.Events[1].Envelope == nil {
+		t.Fatalf("Expected the kept event to carry the envelope, got %+v", promise.Events)
+	}
+
+	// The passphrase can't change while encrypted promises exist
+	if resp, _ := doJSON(t, app, "DELETE", "/api/user/e2e", auth.Token, nil); resp.StatusCode != 409 {
+		t.Fatalf("Expected status 409, got %d", resp.StatusCode)
+	}
+	resp, body = doJSON(t, app, "GET", "/api/user/e2e", auth.Token, nil)
+	if resp.StatusCode != 200 || !strings.Contains(string(body), "PBKDF2-SHA256") {
+		t.Fatalf("Expected the key check back, got %d: %s", resp.StatusCode, body)
+	}
+}
+
+func TestEncryptedPromiseRemindersUseGenericText(t *testing.T) {
+	st := setupTestDB(t)
+	userID, err := st.Users().Create("e2euser", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	envelope := &models.Envelope{Alg: "AES-GCM-256", Nonce: "bm9uY2U=", Ciphertext: "c2VjcmV0"}
+	p := &models.Promise{UserID: userID, Encrypted: true, Envelope: envelope}
+	if err := st.Promises().Create(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Reminders().Create(&models.Reminder{PromiseID: p.ID, UserID: userID, RemindAt: time.Now().Add(-time.Minute)}); err != nil {
+		t.Fatal(err)
+	}
+
+	due, err := st.Reminders().ListDue(time.Now())
+	if err != nil || len(due) != 1 || !due[0].Encrypted {
+		t.Fatalf("Expected one due reminder for an encrypted promise, got %+v (%v)", due, err)
+	}
+
+	t.Chdir("../..") // the email template is loaded from the working directory
+	html, err := api.GenerateReminderEmail(*p, "https://kept.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(html, "c2VjcmV0") || !strings.Contains(html, "Open Kept to see the details.") {
+		t.Fatalf("Expected generic email wording, got %s", html)
+	}
+}
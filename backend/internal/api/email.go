@@ -2,10 +2,10 @@ package api
 
 import (
 	"bytes"
-	"database/sql"
 	"fmt"
 	"html/template"
 	"kept/internal/models"
+	"kept/internal/store"
 	"log"
 	"os"
 	"path/filepath"
@@ -57,13 +57,19 @@ func GenerateReminderEmail(promise models.Promise, appURL string) (string, error
 		dueDateStr = formatEmailDate(*promise.DueDate)
 	}
 
+	title, description, promiseTo := promise.Description, promise.Description, promise.Recipient
+	if promise.Encrypted {
+		// The server can't read end-to-end encrypted promises
+		title, description, promiseTo = "One of your promises", encryptedReminderBody, "someone"
+	}
+
 	data := EmailReminderData{
 		RecipientName: "there", // Could be extracted from user data if available
-		Title:         promise.Description,
-		Description:   promise.Description,
+		Title:         title,
+		Description:   description,
 		DueDate:       dueDateStr,
 		DueDateRaw:    promise.DueDate,
-		PromiseTo:     promise.Recipient,
+		PromiseTo:     promiseTo,
 		AppURL:        appURL,
 		PromiseID:     promise.ID,
 		Year:          time.Now().Year(),
@@ -156,7 +162,7 @@ func GetSMTPConfig() (*SMTPConfig, error) {
 }
 
 // SendReminderEmail sends an email reminder using configured SMTP server
-func SendReminderEmail(db *sql.DB, promise models.Promise, userEmail string) error {
+func SendReminderEmail(st store.Store, promise models.Promise, userEmail string) error {
 	config, err := GetSMTPConfig()
 	if err != nil {
 		log.Printf("SMTP not configured, skipping email: %v", err)
@@ -206,28 +212,28 @@ func getAppURL() string {
 }
 
 // Example: Extend reminder sending to include email option
-func SendReminderWithEmail(db *sql.DB, promise models.Promise, userID int, sendEmail bool) error {
+func SendReminderWithEmail(st store.Store, promise models.Promise, userID int, sendEmail bool) error {
 	// Send push notification (existing behavior)
+	title, body := reminderText(promise.Recipient, promise.Description, promise.Encrypted)
 	payload := PushPayload{
-		Title: fmt.Sprintf("Reminder about your promise to: %s", promise.Recipient),
-		Body:  promise.Description,
+		Title: title,
+		Body:  body,
 		Icon:  "/Static/logos/Kept Mascot Colored.svg",
 		Badge: "/Static/logos/Kept Mascot Colored.svg",
 		Tag:   fmt.Sprintf("kept-reminder-%d", promise.ID),
 		Data:  map[string]interface{}{"promise_id": promise.ID},
 	}
 
-	if err := SendPushToUser(db, userID, payload); err != nil {
+	if err := SendPushToUser(st, userID, payload); err != nil {
 		log.Printf("Failed to send push notification: %v", err)
 	}
 
 	// Optionally send email if enabled
 	if sendEmail {
 		// Get user email from database
-		var email sql.NullString
-		err := db.QueryRow("SELECT email FROM users WHERE id = ?", userID).Scan(&email)
-		if err == nil && email.Valid && email.String != "" {
-			if err := SendReminderEmail(db, promise, email.String); err != nil {
+		user, err := st.Users().GetByID(userID)
+		if err == nil && user.Email != "" {
+			if err := SendReminderEmail(st, promise, user.Email); err != nil {
 				log.Printf("Failed to send email reminder: %v", err)
 			}
 		}
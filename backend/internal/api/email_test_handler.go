@@ -1,9 +1,9 @@
 package api
 
 import (
-	"database/sql"
 	"fmt"
 	"kept/internal/models"
+	"kept/internal/store"
 	"regexp"
 	"sync"
 	"time"
@@ -18,7 +18,7 @@ var (
 
 // TestEmailHandler sends a test email to verify SMTP configuration
 // Rate limited to once per 10 minutes per server
-func TestEmailHandler(db *sql.DB) fiber.Handler {
+func TestEmailHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 
@@ -48,14 +48,12 @@ func TestEmailHandler(db *sql.DB) fiber.Handler {
 		}
 
 		// Get user email from database
-		var userEmail sql.NullString
-		var username string
-		err = db.QueryRow("SELECT email, username FROM users WHERE id = ?", userID).Scan(&userEmail, &username)
+		user, err := st.Users().GetByID(userID)
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Failed to get user data")
 		}
 
-		if !userEmail.Valid || userEmail.String == "" {
+		if user.Email == "" {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error":   "No email address",
 				"message": "Your account does not have an email address set. Please update your profile.",
@@ -63,7 +61,7 @@ func TestEmailHandler(db *sql.DB) fiber.Handler {
 		}
 
 		// Validate email format
-		if !isValidEmail(userEmail.String) {
+		if !isValidEmail(user.Email) {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 				"error":   "Invalid email address",
 				"message": "The email address on your account is not valid. Please update your profile with a valid email address.",
@@ -82,7 +80,7 @@ func TestEmailHandler(db *sql.DB) fiber.Handler {
 		}
 
 		// Send test email
-		err = SendReminderEmail(db, testPromise, userEmail.String)
+		err = SendReminderEmail(st, testPromise, user.Email)
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error":   "Failed to send test email",
@@ -92,7 +90,7 @@ func TestEmailHandler(db *sql.DB) fiber.Handler {
 
 		return c.JSON(fiber.Map{
 			"success": true,
-			"message": fmt.Sprintf("Test email sent successfully to %s", userEmail.String),
+			"message": fmt.Sprintf("Test email sent successfully to %s", user.Email),
 			"smtp_config": fiber.Map{
 				"host": config.Host,
 				"port": config.Port,
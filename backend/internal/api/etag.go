@@ -0,0 +1,97 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// promiseETag is the entity tag of a promise: its version, which every write
+// bumps.
+func promiseETag(p *models.Promise) string {
+	return `"` + strconv.Itoa(p.Version) + `"`
+}
+
+// etagListMatches reports whether an If-Match or If-None-Match header value
+// lists etag. Weak comparison ignores W/ prefixes, as If-None-Match requires;
+// If-Match uses strong comparison.
+func etagListMatches(header, etag string, weak bool) bool {
+	if weak {
+		etag = strings.TrimPrefix(etag, "W/")
+	}
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if weak {
+			candidate = strings.TrimPrefix(candidate, "W/")
+		}
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// sendList sends a list of promises with a weak ETag derived from its
+// contents, or 304 Not Modified if the client's If-None-Match already has
+// it. Lists are tagged by content rather than by version because they also
+// change when tags are renamed or their counts move.
+func sendList(c *fiber.Ctx, promises []models.Promise) error {
+	body, err := json.Marshal(promises)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(body)
+	etag := `W/"` + hex.EncodeToString(sum[:12]) + `"`
+	c.Set(fiber.HeaderETag, etag)
+	if ifNoneMatch := c.Get(fiber.HeaderIfNoneMatch); ifNoneMatch != "" && etagListMatches(ifNoneMatch, etag, true) {
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Send(body)
+}
+
+// checkIfMatch requires the request's If-Match header to name the current
+// version of p. It returns store.ErrStale if it doesn't. Every write that
+// bumps the version checks it: edits, state changes and deletes of the
+// promise and changes to its checklist. Reminders, share links, tags and
+// templates are resources of their own that leave the version alone, so
+// writes to them don't.
+func checkIfMatch(c *fiber.Ctx, p *models.Promise) error {
+	ifMatch := c.Get(fiber.HeaderIfMatch)
+	if ifMatch == "" {
+		return fiber.NewError(fiber.StatusPreconditionRequired, "If-Match header is required")
+	}
+	if !etagListMatches(ifMatch, promiseETag(p), false) {
+		return store.ErrStale
+	}
+	return nil
+}
+
+// staleResponse turns store.ErrStale into 412 Precondition Failed with the
+// current promise, so the client can merge its change and retry. Other
+// errors are returned unchanged.
+func staleResponse(c *fiber.Ctx, st store.Store, promiseID int, err error) error {
+	if !errors.Is(err, store.ErrStale) {
+		return err
+	}
+	promise, err := st.Promises().Get(promiseID)
+	if err != nil {
+		return err
+	}
+	if promise.Events, err = st.Events().ListByPromise(promiseID); err != nil {
+		return err
+	}
+	if err := attachTags(st, promise.UserID, promise); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderETag, promiseETag(promise))
+	return c.Status(fiber.StatusPreconditionFailed).JSON(promise)
+}
@@ -0,0 +1,76 @@
+package api_test
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"kept/internal/models"
+)
+
+func TestPromiseETags(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	auth := registerUser(t, app, "etaguser")
+
+	_, body := doJSON(t, app, "POST", "/api/promises/", auth.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the ladder"})
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	path := "/api/promises/" + strconv.Itoa(promise.ID)
+
+	resp, _ := doJSON(t, app, "GET", path, auth.Token, nil)
+	etag := resp.Header.Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf("Expected ETag \"1\", got %q", etag)
+	}
+	resp, _ = doJSON(t, app, "GET", "/api/promises/", auth.Token, nil)
+	listTag := resp.Header.Get("ETag")
+	if listTag == "" {
+		t.Fatal("Expected an ETag on the list")
+	}
+	req := httptest.NewRequest("GET", "/api/promises/", nil)
+	req.Header.Set("Authorization", "Bearer "+auth.Token)
+	req.Header.Set("If-None-Match", listTag)
+	if resp, _ := app.Test(req); resp.StatusCode != 304 {
+		t.Fatalf("Expected status 304 for a matching If-None-Match, got %d", resp.StatusCode)
+	}
+
+	// Mutations need If-Match
+	edit := map[string]any{"description": "Return the ladder today"}
+	if resp, _ := doJSON(t, app, "PATCH", path, auth.Token, edit); resp.StatusCode != 428 {
+		t.Fatalf("Expected status 428 without If-Match, got %d", resp.StatusCode)
+	}
+	resp, body = doJSONIfMatch(t, app, "PATCH", path, auth.Token, etag, edit)
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	newTag := resp.Header.Get("ETag")
+	if newTag != `"2"` {
+		t.Fatalf("Expected ETag \"2\" after the edit, got %q", newTag)
+	}
+
+	// A second device still holding the old ETag gets the current promise back
+	resp, body = doJSONIfMatch(t, app, "PUT", path+"/state", auth.Token, etag, models.UpdatePromiseStateRequest{State: "kept"})
+	if resp.StatusCode != 412 {
+		t.Fatalf("Expected status 412 for a stale ETag, got %d: %s", resp.StatusCode, body)
+	}
+	var current models.Promise
+	json.Unmarshal(body, &current)
+	if current.Description != "Return the ladder today" || current.CurrentState != "active" || len(current.Events) == 0 ||
+		resp.Header.Get("ETag") != newTag {
+		t.Fatalf("Expected the current promise with its ETag, got %s", body)
+	}
+	if resp, _ := doJSONIfMatch(t, app, "DELETE", path, auth.Token, etag, nil); resp.StatusCode != 412 {
+		t.Fatalf("Expected status 412 deleting with a stale ETag, got %d", resp.StatusCode)
+	}
+
+	resp, _ = doJSONIfMatch(t, app, "PUT", path+"/state", auth.Token, newTag, models.UpdatePromiseStateRequest{State: "kept"})
+	if resp.StatusCode != 200 || resp.Header.Get("ETag") != `"3"` {
+		t.Fatalf("Expected status 200 and ETag \"3\", got %d and %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+	resp, _ = doJSON(t, app, "GET", "/api/promises/", auth.Token, nil)
+	if resp.Header.Get("ETag") == listTag {
+		t.Fatal("Expected the list ETag to change")
+	}
+}
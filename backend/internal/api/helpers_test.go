@@ -0,0 +1,62 @@
+package api_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"kept/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// registerUser registers a user through the API and returns the response.
+func registerUser(t *testing.T, app *fiber.App, username string) models.AuthResponse {
+	t.Helper()
+	resp, body := doJSON(t, app, "POST", "/api/auth/register", "", models.RegisterRequest{Username: username, Password: "password123"})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Registering %s: expected status 201, got %d: %s", username, resp.StatusCode, body)
+	}
+	var auth models.AuthResponse
+	json.Unmarshal(body, &auth)
+	return auth
+}
+
+// doJSON sends a request with an optional JSON body and bearer token and
+// returns the response with its body read.
+func doJSON(t *testing.T, app *fiber.App, method, path, token string, payload any) (*http.Response, []byte) {
+	t.Helper()
+	return doJSONIfMatch(t, app, method, path, token, "", payload)
+}
+
+// doJSONIfMatch is doJSON with an If-Match header, unless etag is empty.
+func doJSONIfMatch(t *testing.T, app *fiber.App, method, path, token, etag string, payload any) (*http.Response, []byte) {
+	t.Helper()
+	var reader io.Reader
+	if payload != nil {
+		body, err := json.Marshal(payload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewReader(body)
+	}
+	req := httptest.NewRequest(method, path, reader)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	return resp, body
+}
@@ -0,0 +1,332 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+	"time"
+
+	"kept/internal/models"
+)
+
+const icalTimeUTC = "20060102T150405Z"
+
+// vtodo is the subset of an iCalendar VTODO that maps onto a promise.
+type vtodo struct {
+	UID          string
+	Summary      string
+	Description  string
+	Recipient    string
+	Status       string
+	Due          *time.Time
+	Completed    *time.Time
+	Created      time.Time
+	LastModified time.Time
+}
+
+// icalProperty is a single "NAME:value" line of a component.
+type icalProperty struct {
+	Name  string
+	Value string
+}
+
+// promiseStatusToICal maps a stored promise state onto a VTODO STATUS.
+func promiseStatusToICal(state string) string {
+	switch state {
+	case "kept":
+		return "COMPLETED"
+	case "broken":
+		return "CANCELLED"
+	default:
+		return "NEEDS-ACTION"
+	}
+}
+
+// icalStatusToPromise maps a VTODO STATUS onto a promise state. Anything that
+// isn't explicitly finished is treated as still active.
+func icalStatusToPromise(status string) string {
+	switch strings.ToUpper(status) {
+	case "COMPLETED":
+		return "kept"
+	case "CANCELLED":
+		return "broken"
+	default:
+		return "active"
+	}
+}
+
+// newVTODO builds the VTODO view of a promise. completedAt is the time of the
+// event that resolved the promise, if any.
+func newVTODO(p models.Promise, uid string, completedAt *time.Time) vtodo {
+	summary, description := p.Description, "Promised to: "+p.Recipient
+	if p.Encrypted {
+		summary, description = encryptedPromiseSummary, "This promise is end-to-end encrypted. "+encryptedReminderBody
+	}
+	return vtodo{
+		UID:          uid,
+		Summary:      summary,
+		Description:  description,
+		Recipient:    p.Recipient,
+		Status:       promiseStatusToICal(p.CurrentState),
+		Due:          p.DueDate,
+		Completed:    completedAt,
+		Created:      p.CreatedAt,
+		LastModified: p.UpdatedAt,
+	}
+}
+
+// properties returns the VTODO properties in the order they are rendered.
+func (t vtodo) properties() []icalProperty {
+	props := []icalProperty{
+		{"UID", t.UID},
+		{"DTSTAMP", t.LastModified.UTC().Format(icalTimeUTC)},
+		{"CREATED", t.Created.UTC().Format(icalTimeUTC)},
+		{"LAST-MODIFIED", t.LastModified.UTC().Format(icalTimeUTC)},
+		{"SUMMARY", t.Summary},
+		{"DESCRIPTION", t.Description},
+		{"X-KEPT-RECIPIENT", t.Recipient},
+		{"STATUS", t.Status},
+	}
+	if t.Due != nil {
+		props = append(props, icalProperty{"DUE", t.Due.UTC().Format(icalTimeUTC)})
+	}
+	if t.Completed != nil && t.Status == "COMPLETED" {
+		props = append(props,
+			icalProperty{"COMPLETED", t.Completed.UTC().Format(icalTimeUTC)},
+			icalProperty{"PERCENT-COMPLETE", "100"},
+		)
+	}
+	return props
+}
+
+// render serializes the VTODO wrapped in a VCALENDAR.
+func (t vtodo) render() string {
+	var b strings.Builder
+	writeICalLine(&b, "BEGIN", "VCALENDAR")
+	writeICalLine(&b, "VERSION", "2.0")
+	writeICalLine(&b, "PRODID", "-//Kept//Promises//EN")
+	writeICalLine(&b, "BEGIN", "VTODO")
+	for _, p := range t.properties() {
+		value := p.Value
+		switch p.Name {
+		case "SUMMARY", "DESCRIPTION", "X-KEPT-RECIPIENT", "UID":
+			value = escapeICalText(value)
+		}
+		writeICalLine(&b, p.Name, value)
+	}
+	writeICalLine(&b, "END", "VTODO")
+	writeICalLine(&b, "END", "VCALENDAR")
+	return b.String()
+}
+
+// writeICalLine writes a content line, folding it at 75 octets as required by
+// RFC 5545 without splitting multi-byte characters.
+func writeICalLine(b *strings.Builder, name, value string) {
+	line := name + ":" + value
+	width := 0
+	for _, r := range line {
+		size := len(string(r))
+		if width+size > 75 {
+			b.WriteString("\r\n ")
+			width = 1
+		}
+		b.WriteRune(r)
+		width += size
+	}
+	b.WriteString("\r\n")
+}
+
+func escapeICalText(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	return r.Replace(s)
+}
+
+func unescapeICalText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// parseVTODO extracts the first VTODO from an iCalendar body. Unknown
+// properties are ignored.
+func parseVTODO(data []byte) (*vtodo, error) {
+	lines, err := unfoldICalLines(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var todo *vtodo
+	depth := 0
+	for _, line := range lines {
+		name, params, value, ok := splitICalLine(line)
+		if !ok {
+			continue
+		}
+		switch name {
+		case "BEGIN":
+			if todo == nil && strings.EqualFold(value, "VTODO") {
+				todo = &vtodo{}
+				depth = 1
+			} else if depth > 0 {
+				depth++ // nested component such as VALARM
+			}
+			continue
+		case "END":
+			if depth > 0 {
+				depth--
+				if depth == 0 {
+					return todo, validateVTODO(todo)
+				}
+			}
+			continue
+		}
+		if depth != 1 {
+			continue
+		}
+
+		switch name {
+		case "UID":
+			todo.UID = unescapeICalText(value)
+		case "SUMMARY":
+			todo.Summary = unescapeICalText(value)
+		case "DESCRIPTION":
+			todo.Description = unescapeICalText(value)
+		case "X-KEPT-RECIPIENT":
+			todo.Recipient = unescapeICalText(value)
+		case "STATUS":
+			todo.Status = strings.ToUpper(value)
+		case "DUE":
+			due, err := parseICalTime(value, params)
+			if err != nil {
+				return nil, fmt.Errorf("invalid DUE: %w", err)
+			}
+			todo.Due = &due
+		case "COMPLETED":
+			completed, err := parseICalTime(value, params)
+			if err == nil {
+				todo.Completed = &completed
+			}
+		}
+	}
+
+	if todo == nil {
+		return nil, fmt.Errorf("no VTODO component found")
+	}
+	return nil, fmt.Errorf("unterminated VTODO component")
+}
+
+func validateVTODO(t *vtodo) error {
+	if t.UID == "" {
+		return fmt.Errorf("VTODO is missing UID")
+	}
+	if strings.TrimSpace(t.Summary) == "" {
+		return fmt.Errorf("VTODO is missing SUMMARY")
+	}
+	// Some clients complete a task without touching STATUS.
+	if t.Status == "" && t.Completed != nil {
+		t.Status = "COMPLETED"
+	}
+	return nil
+}
+
+// recipientFromVTODO works out who a VTODO was promised to. Clients don't
+// always preserve X- properties, so fall back to the line we write into
+// DESCRIPTION.
+func recipientFromVTODO(t *vtodo) string {
+	if r := strings.TrimSpace(t.Recipient); r != "" {
+		return r
+	}
+	for _, line := range strings.Split(t.Description, "\n") {
+		if rest, ok := strings.CutPrefix(strings.TrimSpace(line), "Promised to:"); ok {
+			if r := strings.TrimSpace(rest); r != "" {
+				return r
+			}
+		}
+	}
+	return ""
+}
+
+func unfoldICalLines(data []byte) ([]string, error) {
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
+
+// splitICalLine splits "NAME;PARAM=x:value" into its parts. Parameter values
+// may be quoted and contain ':' or ';'.
+func splitICalLine(line string) (string, map[string]string, string, bool) {
+	inQuotes := false
+	colon := -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon < 0 {
+		return "", nil, "", false
+	}
+
+	head, value := line[:colon], line[colon+1:]
+	parts := strings.Split(head, ";")
+	params := map[string]string{}
+	for _, p := range parts[1:] {
+		if k, v, ok := strings.Cut(p, "="); ok {
+			params[strings.ToUpper(k)] = strings.Trim(v, `"`)
+		}
+	}
+	return strings.ToUpper(parts[0]), params, value, true
+}
+
+// parseICalTime parses DATE-TIME and DATE values. Floating times and unknown
+// TZIDs are read as UTC; all-day dates resolve to the end of that day so the
+// promise isn't auto-kept the moment the day starts.
+func parseICalTime(value string, params map[string]string) (time.Time, error) {
+	if params["VALUE"] == "DATE" || len(value) == 8 {
+		d, err := time.Parse("20060102", value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return d.Add(24*time.Hour - time.Second), nil
+	}
+	if strings.HasSuffix(value, "Z") {
+		return time.Parse(icalTimeUTC, value)
+	}
+	loc := time.UTC
+	if tzid := params["TZID"]; tzid != "" {
+		if l, err := time.LoadLocation(tzid); err == nil {
+			loc = l
+		}
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.UTC(), nil
+}
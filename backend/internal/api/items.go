@@ -0,0 +1,280 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxItems caps the checklist of a single promise.
+const maxItems = 100
+
+// itemResponse is an item together with whether changing it kept its
+// promise.
+type itemResponse struct {
+	models.PromiseItem
+	PromiseKept bool `json:"promise_kept,omitempty"`
+}
+
+// getItem loads an item of promiseID from the itemId route parameter.
+func getItem(c *fiber.Ctx, st store.Store, promiseID int) (*models.PromiseItem, error) {
+	itemID, err := strconv.Atoi(c.Params("itemId"))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid item ID")
+	}
+	item, err := st.Items().Get(itemID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && item.PromiseID != promiseID) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Item not found")
+	}
+	return item, err
+}
+
+// applyItemEdit returns it with the fields present in req applied.
+func applyItemEdit(it models.PromiseItem, req models.ItemRequest) (models.PromiseItem, error) {
+	if req.Title.Set {
+		if req.Title.Value == nil || strings.TrimSpace(*req.Title.Value) == "" {
+			return it, fiber.NewError(fiber.StatusBadRequest, "Title can't be empty")
+		}
+		it.Title = strings.TrimSpace(*req.Title.Value)
+	}
+	if req.DueDate.Set {
+		it.DueDate = req.DueDate.Value
+	}
+	if req.Done.Set {
+		it.Done = req.Done.Value != nil && *req.Done.Value
+	}
+	return it, nil
+}
+
+// keepIfDone keeps an active promise with KeepWhenDone set once all of its
+// items are done, the way UpdatePromiseStateHandler would. It returns the
+// kept promise, or nil if it wasn't kept.
+func keepIfDone(tx store.Store, promiseID int) (*models.Promise, error) {
+	promise, err := tx.Promises().Get(promiseID)
+	if err != nil {
+		return nil, err
+	}
+	if !promise.KeepWhenDone || promise.CurrentState != "active" || promise.Progress == nil || *promise.Progress < 100 {
+		return nil, nil
+	}
+	if _, err := applyPromiseState(tx, promise, "kept", "All checklist items done", nil); err != nil {
+		return nil, err
+	}
+	return tx.Promises().Get(promiseID)
+}
+
+// touchPromise bumps the version of a promise whose checklist changed and
+// returns its new ETag.
+func touchPromise(tx store.Store, promiseID int) (string, error) {
+	if err := tx.Promises().Touch(promiseID); err != nil {
+		return "", err
+	}
+	promise, err := tx.Promises().Get(promiseID)
+	if err != nil {
+		return "", err
+	}
+	return promiseETag(promise), nil
+}
+
+// ListItemsHandler returns a promise's checklist in order.
+func ListItemsHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		promiseID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
+		}
+
+		if _, err := getPromiseFor(st, promiseID, userID, models.RoleViewer); err != nil {
+			return err
+		}
+		items, err := st.Items().ListByPromise(promiseID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(items)
+	}
+}
+
+// CreateItemHandler appends an item to a promise's checklist, or inserts it
+// at position. Checklists are part of their promise, so like other writes to
+// it this requires If-Match and returns the promise's new ETag.
+func CreateItemHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		promiseID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
+		}
+
+		var req models.ItemRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		item, err := applyItemEdit(models.PromiseItem{PromiseID: promiseID}, req)
+		if err != nil {
+			return err
+		}
+		if item.Title == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Title is required")
+		}
+
+		var etag string
+		err = st.InTx(func(tx store.Store) error {
+			promise, err := getPromiseFor(tx, promiseID, userID, models.RoleEditor)
+			if err != nil {
+				return err
+			}
+			if err := checkIfMatch(c, promise); err != nil {
+				return err
+			}
+			// Item titles would leak what the promise is about
+			if promise.Encrypted {
+				return fiber.NewError(fiber.StatusBadRequest, "Encrypted promises can't have checklist items")
+			}
+			existing, err := tx.Items().ListByPromise(promiseID)
+			if err != nil {
+				return err
+			}
+			if len(existing) >= maxItems {
+				return fiber.NewError(fiber.StatusConflict, "Promises can have at most 100 checklist items")
+			}
+			if err := tx.Items().Create(&item); err != nil {
+				return err
+			}
+			if req.Position.Set && req.Position.Value != nil && *req.Position.Value < item.Position {
+				if err := tx.Items().Move(item.ID, *req.Position.Value); err != nil {
+					return err
+				}
+				moved, err := tx.Items().Get(item.ID)
+				if err != nil {
+					return err
+				}
+				item = *moved
+			}
+			etag, err = touchPromise(tx, promiseID)
+			return err
+		})
+		if err != nil {
+			return staleResponse(c, st, promiseID, err)
+		}
+		c.Set(fiber.HeaderETag, etag)
+		return c.Status(fiber.StatusCreated).JSON(item)
+	}
+}
+
+// UpdateItemHandler edits, ticks off or moves a checklist item. Finishing
+// the last open item keeps the promise if it has keep_when_done set. Like
+// CreateItemHandler it requires If-Match.
+func UpdateItemHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		promiseID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
+		}
+
+		var req models.ItemRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		if req.Position.Set && req.Position.Value == nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Position can't be null")
+		}
+
+		var resp itemResponse
+		var kept *models.Promise
+		var etag string
+		err = st.InTx(func(tx store.Store) error {
+			promise, err := getPromiseFor(tx, promiseID, userID, models.RoleEditor)
+			if err != nil {
+				return err
+			}
+			if err := checkIfMatch(c, promise); err != nil {
+				return err
+			}
+			item, err := getItem(c, tx, promiseID)
+			if err != nil {
+				return err
+			}
+			wasDone := item.Done
+			updated, err := applyItemEdit(*item, req)
+			if err != nil {
+				return err
+			}
+			if err := tx.Items().Update(&updated); err != nil {
+				return err
+			}
+			if req.Position.Set {
+				if err := tx.Items().Move(updated.ID, *req.Position.Value); err != nil {
+					return err
+				}
+			}
+			if item, err = tx.Items().Get(updated.ID); err != nil {
+				return err
+			}
+			resp.PromiseItem = *item
+			if etag, err = touchPromise(tx, promiseID); err != nil {
+				return err
+			}
+			if item.Done && !wasDone {
+				if kept, err = keepIfDone(tx, promiseID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return staleResponse(c, st, promiseID, err)
+		}
+		if kept != nil {
+			etag = promiseETag(kept)
+			resp.PromiseKept = true
+			notifyRecipient(st, *kept, recipientEmailKept)
+		}
+		c.Set(fiber.HeaderETag, etag)
+		return c.JSON(resp)
+	}
+}
+
+// DeleteItemHandler removes an item from a promise's checklist. Like
+// CreateItemHandler it requires If-Match.
+func DeleteItemHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		promiseID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
+		}
+
+		var etag string
+		err = st.InTx(func(tx store.Store) error {
+			promise, err := getPromiseFor(tx, promiseID, userID, models.RoleEditor)
+			if err != nil {
+				return err
+			}
+			if err := checkIfMatch(c, promise); err != nil {
+				return err
+			}
+			item, err := getItem(c, tx, promiseID)
+			if err != nil {
+				return err
+			}
+			if err := tx.Items().Delete(item.ID); err != nil {
+				return err
+			}
+			etag, err = touchPromise(tx, promiseID)
+			return err
+		})
+		if err != nil {
+			return staleResponse(c, st, promiseID, err)
+		}
+		c.Set(fiber.HeaderETag, etag)
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
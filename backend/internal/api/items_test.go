@@ -0,0 +1,115 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+
+	"kept/internal/models"
+)
+
+func TestChecklistItems(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "aliceitems")
+	bob := registerUser(t, app, "bobitems")
+
+	_, body := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Alex", Description: "Help Alex move", KeepWhenDone: true})
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	itemsPath := "/api/promises/" + strconv.Itoa(promise.ID) + "/items"
+	etag := `"` + strconv.Itoa(promise.Version) + `"`
+
+	// Checklist changes are writes to the promise and need its version
+	if resp, _ := doJSON(t, app, "POST", itemsPath, alice.Token, map[string]any{"title": "Rent a van"}); resp.StatusCode != 428 {
+		t.Fatalf("Expected status 428 without If-Match, got %d", resp.StatusCode)
+	}
+	if resp, body := doJSONIfMatch(t, app, "POST", itemsPath, alice.Token, `"0"`, map[string]any{"title": "Rent a van"}); resp.StatusCode != 412 || !strings.Contains(string(body), "Help Alex move") {
+		t.Fatalf("Expected status 412 with the promise for a stale version, got %d: %s", resp.StatusCode, body)
+	}
+
+	var items []models.PromiseItem
+	for _, title := range []string{"Rent a van", "Carry boxes"} {
+		resp, body := doJSONIfMatch(t, app, "POST", itemsPath, alice.Token, etag, map[string]any{"title": title})
+		if resp.StatusCode != 201 {
+			t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+		}
+		etag = resp.Header.Get("ETag")
+		var item models.PromiseItem
+		json.Unmarshal(body, &item)
+		items = append(items, item)
+	}
+	if resp, _ := doJSONIfMatch(t, app, "POST", itemsPath, alice.Token, etag, map[string]any{"title": " "}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for an empty title, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSONIfMatch(t, app, "POST", itemsPath, bob.Token, etag, map[string]any{"title": "Sneak in"}); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 for another user, got %d", resp.StatusCode)
+	}
+
+	// Inserting at a position shifts the rest
+	resp, body := doJSONIfMatch(t, app, "POST", itemsPath, alice.Token, etag, map[string]any{"title": "Book the day off", "position": 0})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	etag = resp.Header.Get("ETag")
+	var first models.PromiseItem
+	json.Unmarshal(body, &first)
+	_, body = doJSON(t, app, "GET", itemsPath, alice.Token, nil)
+	json.Unmarshal(body, &items)
+	if len(items) != 3 || items[0].ID != first.ID || items[1].Title != "Rent a van" || items[2].Position != 2 {
+		t.Fatalf("Unexpected items: %s", body)
+	}
+
+	// Progress follows the items, and the last one keeps the promise
+	for i, item := range items {
+		resp, body := doJSONIfMatch(t, app, "PATCH", itemsPath+"/"+strconv.Itoa(item.ID), alice.Token, etag, map[string]any{"done": true})
+		if resp.StatusCode != 200 {
+			t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+		}
+		etag = resp.Header.Get("ETag")
+		var result struct {
+			models.PromiseItem
+			PromiseKept bool `json:"promise_kept"`
+		}
+		json.Unmarshal(body, &result)
+		if !result.Done || result.PromiseKept != (i == len(items)-1) {
+			t.Fatalf("Unexpected result for item %d: %s", i, body)
+		}
+		if i == 0 {
+			_, body = doJSON(t, app, "GET", "/api/promises/"+strconv.Itoa(promise.ID), alice.Token, nil)
+			json.Unmarshal(body, &promise)
+			if promise.Progress == nil || *promise.Progress != 33 {
+				t.Fatalf("Expected 33%% progress, got %s", body)
+			}
+			// Each item change is a new version of the promise
+			if promise.Version != 5 || etag != `"5"` {
+				t.Fatalf("Expected item changes to bump the version to 5, got %s (ETag %s)", body, etag)
+			}
+		}
+	}
+	_, body = doJSON(t, app, "GET", "/api/promises/"+strconv.Itoa(promise.ID), alice.Token, nil)
+	json.Unmarshal(body, &promise)
+	if promise.CurrentState != "kept" || *promise.Progress != 100 || promise.Events[len(promise.Events)-1].State != "kept" {
+		t.Fatalf("Expected the promise to be kept, got %s", body)
+	}
+	// Keeping the promise is part of the same write
+	if want := `"` + strconv.Itoa(promise.Version) + `"`; etag != want {
+		t.Fatalf("Expected ETag %s for the kept promise, got %s", want, etag)
+	}
+
+	if resp, _ := doJSONIfMatch(t, app, "DELETE", itemsPath+"/"+strconv.Itoa(items[0].ID), bob.Token, etag, nil); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 for another user, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "DELETE", itemsPath+"/"+strconv.Itoa(items[0].ID), alice.Token, nil); resp.StatusCode != 428 {
+		t.Fatalf("Expected status 428 without If-Match, got %d", resp.StatusCode)
+	}
+	resp, _ = doJSONIfMatch(t, app, "DELETE", itemsPath+"/"+strconv.Itoa(items[0].ID), alice.Token, etag, nil)
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	etag = resp.Header.Get("ETag")
+	if resp, _ := doJSONIfMatch(t, app, "PATCH", itemsPath+"/"+strconv.Itoa(items[0].ID), alice.Token, etag, map[string]any{"done": false}); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 for a deleted item, got %d", resp.StatusCode)
+	}
+}
@@ -1,6 +1,7 @@
 package api
 
 import (
+	"os"
 	"strings"
 
 	"kept/internal/auth"
@@ -34,3 +35,22 @@ func AuthMiddleware() fiber.Handler {
 		return c.Next()
 	}
 }
+
+// AdminMiddleware allows only the users listed in ADMIN_USERNAMES
+// (comma-separated). It must run after AuthMiddleware.
+func AdminMiddleware() fiber.Handler {
+	admins := map[string]bool{}
+	for _, name := range strings.Split(os.Getenv("ADMIN_USERNAMES"), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			admins[name] = true
+		}
+	}
+
+	return func(c *fiber.Ctx) error {
+		username, _ := c.Locals("username").(string)
+		if !admins[username] {
+			return fiber.NewError(fiber.StatusForbidden, "Admin access required")
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// eventNudged records a partner nudging a promise. It doesn't change the
+// promise's state.
+const eventNudged = "nudged"
+
+const (
+	// maxPartners is how many accountability partners a user can ask for,
+	// including those who haven't accepted yet.
+	maxPartners = 2
+	// nudgeInterval is how long a partner has to wait before nudging the
+	// same promise again.
+	nudgeInterval         = 12 * time.Hour
+	maxNudgeMessageLength = 280
+)
+
+// checkPartnerVisible checks that a promise shown to partners is one they
+// can read: a personal promise the server can decrypt.
+func checkPartnerVisible(p *models.Promise) error {
+	if !p.PartnerVisible {
+		return nil
+	}
+	if p.Encrypted {
+		return fiber.NewError(fiber.StatusBadRequest, "Encrypted promises can't be shown to partners")
+	}
+	if p.WorkspaceID != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Only personal promises can be shown to partners")
+	}
+	return nil
+}
+
+// isPartner reports whether partnerID has accepted to hold userID
+// accountable.
+func isPartner(st store.Store, userID, partnerID int) (bool, error) {
+	p, err := st.Partnerships().Find(userID, partnerID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return p.AcceptedAt != nil, nil
+}
+
+// getPartnership loads a partnership userID is part of.
+func getPartnership(c *fiber.Ctx, st store.Store, userID int) (*models.Partnership, error) {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid partnership ID")
+	}
+	p, err := st.Partnerships().Get(id)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && p.UserID != userID && p.PartnerID != userID) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Partnership not found")
+	}
+	return p, err
+}
+
+// ListPartnershipsHandler returns the user's partnerships: the partners they
+// asked for and the users who asked them, pending or accepted.
+func ListPartnershipsHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		partnerships, err := st.Partnerships().ListByUser(userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(partnerships)
+	}
+}
+
+// InvitePartnerHandler asks another user, by username, to be the user's
+// accountability partner.
+func InvitePartnerHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.PartnerRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		partner, err := st.Users().GetByUsername(strings.TrimSpace(req.Username))
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "User not found")
+		}
+		if err != nil {
+			return err
+		}
+		if partner.ID == userID {
+			return fiber.NewError(fiber.StatusBadRequest, "You can't be your own partner")
+		}
+
+		existing, err := st.Partnerships().ListByUser(userID)
+		if err != nil {
+			return err
+		}
+		asked := 0
+		for _, p := range existing {
+			if p.UserID == userID {
+				asked++
+			}
+		}
+		if asked >= maxPartners {
+			return fiber.NewError(fiber.StatusConflict, fmt.Sprintf("You can have at most %d accountability partners", maxPartners))
+		}
+
+		partnership := &models.Partnership{UserID: userID, PartnerID: partner.ID}
+		err = st.Partnerships().Create(partnership)
+		if errors.Is(err, store.ErrConflict) {
+			return fiber.NewError(fiber.StatusConflict, "Already asked")
+		}
+		if err != nil {
+			return err
+		}
+		if partnership, err = st.Partnerships().Get(partnership.ID); err != nil {
+			return err
+		}
+
+		payload := PushPayload{
+			Title: partnership.Username + " asked you to be their accountability partner",
+			Icon:  "/Static/logos/Kept Mascot Colored.svg",
+			Tag:   fmt.Sprintf("kept-partner-%d", partnership.ID),
+			Data:  map[string]interface{}{"partnership_id": partnership.ID},
+		}
+		if err := SendPushToUser(st, partner.ID, payload); err != nil {
+			log.Printf("Failed to tell user %d about partnership %d: %v", partner.ID, partnership.ID, err)
+		}
+		return c.Status(fiber.StatusCreated).JSON(partnership)
+	}
+}
+
+// AcceptPartnershipHandler lets the asked partner accept.
+func AcceptPartnershipHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		partnership, err := getPartnership(c, st, userID)
+		if err != nil {
+			return err
+		}
+		if partnership.PartnerID != userID {
+			return fiber.NewError(fiber.StatusForbidden, "Only the partner can accept")
+		}
+		if partnership.AcceptedAt != nil {
+			return fiber.NewError(fiber.StatusConflict, "Already accepted")
+		}
+		if err := st.Partnerships().Accept(partnership.ID); err != nil {
+			return err
+		}
+		if partnership, err = st.Partnerships().Get(partnership.ID); err != nil {
+			return err
+		}
+		return c.JSON(partnership)
+	}
+}
+
+// DeletePartnershipHandler ends a partnership, or withdraws or declines a
+// pending one. Either side can do so.
+func DeletePartnershipHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		partnership, err := getPartnership(c, st, userID)
+		if err != nil {
+			return err
+		}
+		if err := st.Partnerships().Delete(partnership.ID); err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
+
+// partnerPromise is what an accountability partner sees of a promise.
+type partnerPromise struct {
+	ID          int            `json:"id"`
+	Recipient   string         `json:"recipient"`
+	Description string         `json:"description"`
+	DueDate     *time.Time     `json:"due_date,omitempty"`
+	State       string         `json:"current_state"`
+	CreatedAt   time.Time      `json:"created_at"`
+	Events      []models.Event `json:"events"`
+}
+
+// PartnerPromisesHandler returns the promises the user whose partner the
+// current user is has shown to partners, with their events.
+func PartnerPromisesHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		partnership, err := getPartnership(c, st, userID)
+		if err != nil {
+			return err
+		}
+		if partnership.PartnerID != userID || partnership.AcceptedAt == nil {
+			return fiber.NewError(fiber.StatusForbidden, "Not authorized")
+		}
+		promises, err := st.Promises().ListByUser(partnership.UserID, store.PromiseFilter{PartnerVisible: true})
+		if err != nil {
+			return err
+		}
+
+		visible := []partnerPromise{}
+		for _, p := range promises {
+			events, err := st.Events().ListByPromise(p.ID)
+			if err != nil {
+				return err
+			}
+			visible = append(visible, partnerPromise{
+				ID:          p.ID,
+				Recipient:   p.Recipient,
+				Description: p.Description,
+				DueDate:     p.DueDate,
+				State:       p.CurrentState,
+				CreatedAt:   p.CreatedAt,
+				Events:      events,
+			})
+		}
+		return c.JSON(visible)
+	}
+}
+
+// NudgePromiseHandler lets a partner nudge the owner of a promise shown to
+// them. The nudge is pushed to the owner and logged as an event. Each
+// partner can nudge a promise once per nudgeInterval.
+func NudgePromiseHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		promiseID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
+		}
+
+		var req models.NudgeRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		req.Message = strings.TrimSpace(req.Message)
+		if utf8.RuneCountInString(req.Message) > maxNudgeMessageLength {
+			return fiber.NewError(fiber.StatusBadRequest, "Nudge messages must be at most 280 characters")
+		}
+
+		// Promises not shown to the user are reported as missing
+		promise, err := st.Promises().Get(promiseID)
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+		partner := false
+		if err == nil && promise.PartnerVisible && promise.WorkspaceID == nil {
+			if partner, err = isPartner(st, promise.UserID, userID); err != nil {
+				return err
+			}
+		}
+		if !partner {
+			return fiber.NewError(fiber.StatusNotFound, "Promise not found")
+		}
+		if promise.CurrentState != "active" {
+			return fiber.NewError(fiber.StatusConflict, "Only active promises can be nudged")
+		}
+
+		events, err := st.Events().ListByPromise(promiseID)
+		if err != nil {
+			return err
+		}
+		for _, e := range events {
+			if e.State != eventNudged || e.ActorUserID == nil || *e.ActorUserID != userID {
+				continue
+			}
+			if wait := nudgeInterval - time.Since(e.CreatedAt); wait > 0 {
+				c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(wait.Seconds())+1))
+				return fiber.NewError(fiber.StatusTooManyRequests, "You nudged this promise recently")
+			}
+		}
+
+		event := models.Event{PromiseID: promiseID, State: eventNudged, ReflectionNote: req.Message, ActorUserID: &userID}
+		if err := st.Events().Create(&event); err != nil {
+			return err
+		}
+
+		nudger, err := st.Users().GetByID(userID)
+		if err != nil {
+			return err
+		}
+		body := promise.Description
+		if req.Message != "" {
+			body = req.Message
+		}
+		payload := PushPayload{
+			Title: fmt.Sprintf("%s nudged you about your promise to %s", nudger.Username, promise.Recipient),
+			Body:  body,
+			Icon:  "/Static/logos/Kept Mascot Colored.svg",
+			Badge: "/Static/logos/Kept Mascot Colored.svg",
+			Tag:   fmt.Sprintf("kept-nudge-%d", promise.ID),
+			Data:  map[string]interface{}{"promise_id": promise.ID},
+		}
+		if err := SendPushToUser(st, promise.UserID, payload); err != nil {
+			log.Printf("Failed to send nudge for promise %d: %v", promise.ID, err)
+		}
+		return c.Status(fiber.StatusCreated).JSON(event)
+	}
+}
@@ -0,0 +1,123 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"kept/internal/models"
+)
+
+func TestAccountabilityPartners(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "alicep")
+	bob := registerUser(t, app, "bobp")
+	carol := registerUser(t, app, "carolp")
+	dave := registerUser(t, app, "davep")
+
+	for _, invite := range []struct {
+		username string
+		status   int
+	}{
+		{"alicep", 400},
+		{"nobody", 404},
+		{"bobp", 201},
+		{"bobp", 409},
+		{"carolp", 201},
+		{"davep", 409},
+	} {
+		resp, body := doJSON(t, app, "POST", "/api/partners/", alice.Token, models.PartnerRequest{Username: invite.username})
+		if resp.StatusCode != invite.status {
+			t.Fatalf("Asking %s: expected status %d, got %d: %s", invite.username, invite.status, resp.StatusCode, body)
+		}
+	}
+
+	var partnerships []models.Partnership
+	_, body := doJSON(t, app, "GET", "/api/partners/", bob.Token, nil)
+	json.Unmarshal(body, &partnerships)
+	if len(partnerships) != 1 || partnerships[0].Username != "alicep" || partnerships[0].AcceptedAt != nil {
+		t.Fatalf("Unexpected partnerships: %s", body)
+	}
+	partnerPath := "/api/partners/" + strconv.Itoa(partnerships[0].ID)
+	if resp, _ := doJSON(t, app, "POST", partnerPath+"/accept", alice.Token, nil); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 accepting your own request, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "GET", partnerPath+"/promises", bob.Token, nil); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 before accepting, got %d", resp.StatusCode)
+	}
+	if resp, body := doJSON(t, app, "POST", partnerPath+"/accept", bob.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+
+	// Partners see only the promises shown to them
+	resp, body := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Gym", Description: "Train twice a week", PartnerVisible: true})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var shown models.Promise
+	json.Unmarshal(body, &shown)
+	_, body = doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Diary", Description: "Private"})
+	var private models.Promise
+	json.Unmarshal(body, &private)
+
+	var visible []struct {
+		ID     int            `json:"id"`
+		Events []models.Event `json:"events"`
+	}
+	_, body = doJSON(t, app, "GET", partnerPath+"/promises", bob.Token, nil)
+	json.Unmarshal(body, &visible)
+	if len(visible) != 1 || visible[0].ID != shown.ID || len(visible[0].Events) != 1 {
+		t.Fatalf("Expected the shown promise with its events, got %s", body)
+	}
+	if resp, _ := doJSON(t, app, "GET", partnerPath+"/promises", dave.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 for an outsider, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "GET", "/api/promises/"+strconv.Itoa(shown.ID), bob.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected partners to have no access to the promise itself, got %d", resp.StatusCode)
+	}
+
+	// Nudges are logged and rate-limited per partner
+	nudgePath := "/api/promises/" + strconv.Itoa(shown.ID) + "/nudge"
+	if resp, _ := doJSON(t, app, "POST", "/api/promises/"+strconv.Itoa(private.ID)+"/nudge", bob.Token, models.NudgeRequest{}); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 nudging a hidden promise, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "POST", nudgePath, carol.Token, models.NudgeRequest{}); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 nudging as a pending partner, got %d", resp.StatusCode)
+	}
+	resp, body = doJSON(t, app, "POST", nudgePath, bob.Token, models.NudgeRequest{Message: "How's it going?"})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var nudge models.Event
+	json.Unmarshal(body, &nudge)
+	if nudge.State != "nudged" || nudge.ReflectionNote != "How's it going?" || nudge.ActorUserID == nil || *nudge.ActorUserID != bob.User.ID {
+		t.Fatalf("Unexpected nudge: %s", body)
+	}
+	resp, _ = doJSON(t, app, "POST", nudgePath, bob.Token, models.NudgeRequest{})
+	if resp.StatusCode != 429 || resp.Header.Get("Retry-After") == "" {
+		t.Fatalf("Expected status 429 with Retry-After, got %d", resp.StatusCode)
+	}
+	var promise models.Promise
+	_, body = doJSON(t, app, "GET", "/api/promises/"+strconv.Itoa(shown.ID), alice.Token, nil)
+	json.Unmarshal(body, &promise)
+	if promise.CurrentState != "active" {
+		t.Fatalf("Expected nudges to leave the state alone, got %s", body)
+	}
+
+	// Hiding the promise or ending the partnership takes access away
+	if resp, body := doJSONIfMatch(t, app, "PATCH", "/api/promises/"+strconv.Itoa(shown.ID), alice.Token, "*", map[string]any{"partner_visible": false}); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	_, body = doJSON(t, app, "GET", partnerPath+"/promises", bob.Token, nil)
+	json.Unmarshal(body, &visible)
+	if len(visible) != 0 {
+		t.Fatalf("Expected no shown promises, got %s", body)
+	}
+	if resp, _ := doJSON(t, app, "DELETE", partnerPath, bob.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected either side to end the partnership, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "GET", partnerPath+"/promises", bob.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 after ending the partnership, got %d", resp.StatusCode)
+	}
+}
@@ -1,14 +1,204 @@
 package api
 
 import (
-	"database/sql"
+	"errors"
 	"kept/internal/models"
+	"kept/internal/store"
 	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func CreatePromiseHandler(db *sql.DB) fiber.Handler {
+// validPromiseStates lists the states a promise can be moved to.
+var validPromiseStates = map[string]bool{"active": true, "kept": true, "broken": true, "postponed": true}
+
+// validReminderFrequencies lists the recurring reminder frequencies; empty
+// means no recurring reminders.
+var validReminderFrequencies = map[string]bool{"": true, "daily": true, "weekly": true, "monthly": true}
+
+// insertPromise creates an active promise together with its initial event
+// and tags, linked to the requested contact or else to the one its recipient
+// names. Promises go into a workspace if the user can edit its promises,
+// and start a series if they recur.
+func insertPromise(tx store.Store, userID int, req models.CreatePromiseRequest) (*models.Promise, error) {
+	promise := &models.Promise{
+		UserID:            userID,
+		Recipient:         req.Recipient,
+		RecipientEmail:    req.RecipientEmail,
+		NotifyRecipient:   req.NotifyRecipient,
+		Description:       req.Description,
+		DueDate:           req.DueDate,
+		ReminderFrequency: req.ReminderFrequency,
+		Encrypted:         req.Encrypted,
+		Envelope:          req.Envelope,
+		PartnerVisible:    req.PartnerVisible,
+		KeepWhenDone:      req.KeepWhenDone,
+	}
+	if err := checkRecipientNotification(promise); err != nil {
+		return nil, err
+	}
+	if req.WorkspaceID != nil {
+		if req.Encrypted {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Encrypted promises can't be added to a workspace")
+		}
+		workspace, err := getWorkspaceFor(tx, *req.WorkspaceID, userID, models.RoleEditor)
+		if err != nil {
+			return nil, err
+		}
+		promise.WorkspaceID = &workspace.ID
+	}
+	if err := checkPartnerVisible(promise); err != nil {
+		return nil, err
+	}
+	var series *models.Series
+	if req.Recurrence != nil {
+		var err error
+		if series, err = newSeries(req.Recurrence, req.DueDate); err != nil {
+			return nil, err
+		}
+	}
+	tags, err := resolveTags(tx, userID, req.TagIDs)
+	if err != nil {
+		return nil, err
+	}
+	if req.ContactID != nil {
+		contact, err := resolveContact(tx, userID, *req.ContactID)
+		if err != nil {
+			return nil, err
+		}
+		if promise.Recipient == "" && !promise.Encrypted {
+			promise.Recipient = contact.Name
+		}
+		promise.ContactID = &contact.ID
+	} else if err := linkContact(tx, promise); err != nil {
+		return nil, err
+	}
+	if series != nil {
+		series.UserID, series.WorkspaceID = userID, promise.WorkspaceID
+		if err := tx.Series().Create(series); err != nil {
+			return nil, err
+		}
+		promise.SeriesID = &series.ID
+	}
+	if err := tx.Promises().Create(promise); err != nil {
+		return nil, err
+	}
+	if series != nil {
+		if err := tx.Series().SetCurrent(series.ID, promise.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Tags().SetForPromise(promise.ID, userID, tagIDs(tags)); err != nil {
+		return nil, err
+	}
+
+	// Create initial event
+	if err := tx.Events().Create(&models.Event{PromiseID: promise.ID, State: "active"}); err != nil {
+		return nil, err
+	}
+
+	return promise, nil
+}
+
+// applyPromiseState moves a promise to a new state and records the event.
+// The caller is responsible for checking access. It returns the state that
+// was actually stored, or store.ErrStale if p's version is out of date, and
+// brings p's state and version up to date.
+func applyPromiseState(tx store.Store, p *models.Promise, state, reflectionNote string, noteEnvelope *models.Envelope) (string, error) {
+	promiseID := p.ID
+	// If the client requested "postponed", permanently convert to "kept" in storage
+	storedState := state
+	if state == "postponed" {
+		storedState = "kept"
+	}
+
+	// Update promise state (we do not apply postponed new due dates — postponed is converted to kept)
+	if err := tx.Promises().SetState(promiseID, p.Version, storedState); err != nil {
+		return "", err
+	}
+	p.CurrentState = storedState
+	p.Version++
+
+	// Create event (store the converted state)
+	event := models.Event{PromiseID: promiseID, State: storedState, ReflectionNote: reflectionNote, Envelope: noteEnvelope}
+	if err := tx.Events().Create(&event); err != nil {
+		return "", err
+	}
+	if err := rearmStateChangeReminders(tx, promiseID, event.CreatedAt); err != nil {
+		return "", err
+	}
+	if err := settleReminders(tx, promiseID, storedState == "active"); err != nil {
+		return "", err
+	}
+
+	return storedState, nil
+}
+
+// promiseRole returns the role userID has on a promise: owner of their
+// personal promises, their member role for promises in a workspace, or ""
+// without access.
+func promiseRole(st store.Store, p *models.Promise, userID int) (string, error) {
+	return ownerRole(st, p.UserID, p.WorkspaceID, userID)
+}
+
+// ownerRole returns the role userID has on something created by ownerID,
+// optionally in a workspace, the way promiseRole does.
+func ownerRole(st store.Store, ownerID int, workspaceID *int, userID int) (string, error) {
+	if workspaceID == nil {
+		if ownerID == userID {
+			return models.RoleOwner, nil
+		}
+		return "", nil
+	}
+	workspace, err := st.Workspaces().Get(*workspaceID, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return workspace.Role, nil
+}
+
+// getPromiseFor loads a promise userID has at least the given role on,
+// returning 404 if it doesn't exist and 403 if the user may not access it
+// that way.
+func getPromiseFor(st store.Store, promiseID, userID int, role string) (*models.Promise, error) {
+	promise, err := st.Promises().Get(promiseID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Promise not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	has, err := promiseRole(st, promise, userID)
+	if err != nil {
+		return nil, err
+	}
+	if !hasRole(has, role) {
+		return nil, fiber.NewError(fiber.StatusForbidden, "Not authorized")
+	}
+	return promise, nil
+}
+
+// checkEncryptedCreate validates a request for an end-to-end encrypted
+// promise: the content must only be in the envelope, and the user must have
+// set up a passphrase.
+func checkEncryptedCreate(st store.Store, userID int, req models.CreatePromiseRequest) error {
+	if req.Recipient != "" || req.Description != "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Encrypted promises must not include a plaintext recipient or description")
+	}
+	if !validEnvelope(req.Envelope) {
+		return fiber.NewError(fiber.StatusBadRequest, "Encrypted promises need a valid envelope")
+	}
+	_, err := st.Users().GetE2EKeyCheck(userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return fiber.NewError(fiber.StatusBadRequest, "Set up end-to-end encryption before creating encrypted promises")
+	}
+	return err
+}
+
+func CreatePromiseHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 
@@ -16,101 +206,67 @@ func CreatePromiseHandler(db *sql.DB) fiber.Handler {
 		if err := c.BodyParser(&req); err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
 		}
-
-		if req.Recipient == "" || req.Description == "" {
-			return fiber.NewError(fiber.StatusBadRequest, "Recipient and description are required")
-		}
-
-		tx, err := db.Begin()
-		if err != nil {
+		if err := applyQuickAdd(&req); err != nil {
 			return err
 		}
-		defer tx.Rollback()
 
-		// Insert promise
-		result, err := tx.Exec(
-			`INSERT INTO promises (user_id, recipient, description, due_date, current_state, reminder_frequency, last_reminded_at) 
-			VALUES (?, ?, ?, ?, 'active', ?, ?)`,
-			userID, req.Recipient, req.Description, req.DueDate, req.ReminderFrequency, nil,
-		)
-		if err != nil {
-			return err
+		if req.Encrypted {
+			if err := checkEncryptedCreate(st, userID, req); err != nil {
+				return err
+			}
+		} else if req.Envelope != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Envelope requires encrypted to be set")
+		} else if (req.Recipient == "" && req.ContactID == nil) || req.Description == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Recipient and description are required")
 		}
 
-		promiseID, _ := result.LastInsertId()
-
-		// Create initial event
-		_, err = tx.Exec(
-			"INSERT INTO promise_events (promise_id, state) VALUES (?, 'active')",
-			promiseID,
-		)
-		if err != nil {
+		var promise *models.Promise
+		err := st.InTx(func(tx store.Store) error {
+			var err error
+			promise, err = insertPromise(tx, userID, req)
 			return err
-		}
-
-		if err := tx.Commit(); err != nil {
+		})
+		if err != nil {
 			return err
 		}
-
-		// Get the created promise
-		var promise models.Promise
-		err = db.QueryRow(
-			`SELECT id, user_id, recipient, description, due_date, current_state, reminder_frequency, last_reminded_at, created_at, updated_at 
-			FROM promises WHERE id = ?`,
-			promiseID,
-		).Scan(
-			&promise.ID, &promise.UserID, &promise.Recipient, &promise.Description,
-			&promise.DueDate, &promise.CurrentState, &promise.ReminderFrequency, &promise.LastRemindedAt, &promise.CreatedAt, &promise.UpdatedAt,
-		)
-		if err != nil {
+		if err := attachTags(st, userID, promise); err != nil {
 			return err
 		}
+		notifyRecipient(st, *promise, recipientEmailMade)
 
 		return c.Status(fiber.StatusCreated).JSON(promise)
 	}
 }
 
-func ListPromisesHandler(db *sql.DB) fiber.Handler {
+func ListPromisesHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 
-		state := c.Query("state")
-		
-		query := `SELECT id, user_id, recipient, description, due_date, current_state, reminder_frequency, last_reminded_at, created_at, updated_at 
-				FROM promises WHERE user_id = ?`
-		args := []interface{}{userID}
-
-		if state != "" {
-			query += " AND current_state = ?"
-			args = append(args, state)
+		filter := store.PromiseFilter{State: c.Query("state"), Text: c.Query("q")}
+		if err := tagFilter(c, &filter); err != nil {
+			return err
 		}
-
-		query += " ORDER BY (current_state = 'kept') DESC, created_at DESC"
-
-		rows, err := db.Query(query, args...)
+		contactID, err := strconv.Atoi(c.Query("contact", "0"))
 		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid contact filter")
+		}
+		filter.ContactID = contactID
+		if err := workspaceFilter(c, st, userID, &filter); err != nil {
 			return err
 		}
-		defer rows.Close()
-
-		promises := []models.Promise{}
-		for rows.Next() {
-			var p models.Promise
-			err := rows.Scan(
-				&p.ID, &p.UserID, &p.Recipient, &p.Description,
-				&p.DueDate, &p.CurrentState, &p.ReminderFrequency, &p.LastRemindedAt, &p.CreatedAt, &p.UpdatedAt,
-			)
-			if err != nil {
-				return err
-			}
-			promises = append(promises, p)
+		promises, err := st.Promises().ListByUser(userID, filter)
+		if err != nil {
+			return err
+		}
+		if err := attachTagsToList(st, userID, promises); err != nil {
+			return err
 		}
 
-		return c.JSON(promises)
+		return sendList(c, promises)
 	}
 }
 
-func GetPromiseHandler(db *sql.DB) fiber.Handler {
+func GetPromiseHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 		promiseID, err := strconv.Atoi(c.Params("id"))
@@ -118,17 +274,9 @@ func GetPromiseHandler(db *sql.DB) fiber.Handler {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
 		}
 
-		var promise models.Promise
-		err = db.QueryRow(
-			`SELECT id, user_id, recipient, description, due_date, current_state, reminder_frequency, last_reminded_at, created_at, updated_at 
-			FROM promises WHERE id = ? AND user_id = ?`,
-			promiseID, userID,
-		).Scan(
-			&promise.ID, &promise.UserID, &promise.Recipient, &promise.Description,
-			&promise.DueDate, &promise.CurrentState, &promise.ReminderFrequency, &promise.LastRemindedAt, &promise.CreatedAt, &promise.UpdatedAt,
-		)
-
-		if err == sql.ErrNoRows {
+		// Promises the user can't see are reported as missing
+		promise, err := getPromiseFor(st, promiseID, userID, models.RoleViewer)
+		if fiberErr, ok := err.(*fiber.Error); ok && fiberErr.Code == fiber.StatusForbidden {
 			return fiber.NewError(fiber.StatusNotFound, "Promise not found")
 		}
 		if err != nil {
@@ -136,36 +284,26 @@ func GetPromiseHandler(db *sql.DB) fiber.Handler {
 		}
 
 		// Get events
-		rows, err := db.Query(
-			`SELECT id, promise_id, state, reflection_note, created_at 
-			FROM promise_events WHERE promise_id = ? ORDER BY created_at ASC`,
-			promiseID,
-		)
+		events, err := st.Events().ListByPromise(promiseID)
 		if err != nil {
 			return err
 		}
-		defer rows.Close()
-
-		events := []models.Event{}
-		for rows.Next() {
-			var e models.Event
-			var note sql.NullString
-			err := rows.Scan(&e.ID, &e.PromiseID, &e.State, &note, &e.CreatedAt)
-			if err != nil {
-				return err
-			}
-			if note.Valid {
-				e.ReflectionNote = note.String
-			}
-			events = append(events, e)
-		}
 
 		promise.Events = events
+		if err := attachTags(st, userID, promise); err != nil {
+			return err
+		}
+		if promise.RecipientLink, err = recipientLink(promise); err != nil {
+			return err
+		}
+		c.Set(fiber.HeaderETag, promiseETag(promise))
 		return c.JSON(promise)
 	}
 }
 
-func UpdatePromiseStateHandler(db *sql.DB) fiber.Handler {
+// UpdatePromiseStateHandler moves a promise to a new state. It requires
+// If-Match with the promise's current ETag.
+func UpdatePromiseStateHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 		promiseID, err := strconv.Atoi(c.Params("id"))
@@ -178,63 +316,59 @@ func UpdatePromiseStateHandler(db *sql.DB) fiber.Handler {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
 		}
 
-		validStates := map[string]bool{"active": true, "kept": true, "broken": true, "postponed": true}
-		if !validStates[req.State] {
+		if !validPromiseStates[req.State] {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid state")
 		}
 
-		tx, err := db.Begin()
-		if err != nil {
-			return err
-		}
-		defer tx.Rollback()
-
-		// Check ownership
-		var currentUserID int
-		err = tx.QueryRow("SELECT user_id FROM promises WHERE id = ?", promiseID).Scan(&currentUserID)
-		if err == sql.ErrNoRows {
-			return fiber.NewError(fiber.StatusNotFound, "Promise not found")
-		}
-		if err != nil {
-			return err
-		}
-		if currentUserID != userID {
-			return fiber.NewError(fiber.StatusForbidden, "Not authorized")
-		}
-
-		// If the client requested "postponed", permanently convert to "kept" in storage
-		storedState := req.State
-		if req.State == "postponed" {
-			storedState = "kept"
-		}
+		var etag string
+		var kept *models.Promise
+		err = st.InTx(func(tx store.Store) error {
+			promise, err := getPromiseFor(tx, promiseID, userID, models.RoleEditor)
+			if err != nil {
+				return err
+			}
+			if err := checkIfMatch(c, promise); err != nil {
+				return err
+			}
 
-		// Update promise state (we do not apply postponed new due dates — postponed is converted to kept)
-		_, err = tx.Exec(
-			"UPDATE promises SET current_state = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
-			storedState, promiseID,
-		)
-		if err != nil {
-			return err
-		}
+			// Notes on encrypted promises must be encrypted too
+			if promise.Encrypted && req.ReflectionNote != "" {
+				return fiber.NewError(fiber.StatusBadRequest, "Reflection notes on encrypted promises must be sent as note_envelope")
+			}
+			if req.NoteEnvelope != nil && (!promise.Encrypted || !validEnvelope(req.NoteEnvelope)) {
+				return fiber.NewError(fiber.StatusBadRequest, "Invalid note_envelope")
+			}
 
-		// Create event (store the converted state)
-		_, err = tx.Exec(
-			"INSERT INTO promise_events (promise_id, state, reflection_note) VALUES (?, ?, ?)",
-			promiseID, storedState, req.ReflectionNote,
-		)
+			before := promise.CurrentState
+			if _, err := applyPromiseState(tx, promise, req.State, req.ReflectionNote, req.NoteEnvelope); err != nil {
+				return err
+			}
+			updated, err := tx.Promises().Get(promiseID)
+			if err != nil {
+				return err
+			}
+			etag = promiseETag(updated)
+			if req.State == "kept" && before != "kept" {
+				kept = updated
+			}
+			return nil
+		})
 		if err != nil {
-			return err
+			return staleResponse(c, st, promiseID, err)
 		}
-
-		if err := tx.Commit(); err != nil {
-			return err
+		if kept != nil {
+			notifyRecipient(st, *kept, recipientEmailKept)
 		}
 
+		c.Set(fiber.HeaderETag, etag)
 		return c.JSON(fiber.Map{"success": true})
 	}
 }
 
-func UpdatePromiseHandler(db *sql.DB) fiber.Handler {
+// UpdatePromiseHandler edits a promise. Only the fields present in the
+// request change, and each change is recorded as a revision. It requires
+// If-Match with the promise's current ETag.
+func UpdatePromiseHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 		promiseID, err := strconv.Atoi(c.Params("id"))
@@ -247,54 +381,128 @@ func UpdatePromiseHandler(db *sql.DB) fiber.Handler {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
 		}
 
-		// Check ownership
-		var currentUserID int
-		err = db.QueryRow("SELECT user_id FROM promises WHERE id = ?", promiseID).Scan(&currentUserID)
-		if err == sql.ErrNoRows {
-			return fiber.NewError(fiber.StatusNotFound, "Promise not found")
-		}
+		promise, err := getPromiseFor(st, promiseID, userID, models.RoleEditor)
 		if err != nil {
 			return err
 		}
-		if currentUserID != userID {
-			return fiber.NewError(fiber.StatusForbidden, "Not authorized")
+		if err := checkIfMatch(c, promise); err != nil {
+			return staleResponse(c, st, promiseID, err)
 		}
-
-		// Convert reminder frequency to string for storage (null remains null)
-		var reminderFreqStr *string
-		if req.ReminderFrequency != nil {
-			freqStr := strconv.Itoa(*req.ReminderFrequency)
-			reminderFreqStr = &freqStr
+		if err := attachTags(st, userID, promise); err != nil {
+			return err
 		}
 
-		// Update the promise fields
-		_, err = db.Exec(
-			"UPDATE promises SET reminder_frequency = ?, due_date = ?, updated_at = CURRENT_TIMESTAMP WHERE id = ?",
-			reminderFreqStr, req.DueDate, promiseID,
-		)
+		updated, err := applyPromiseEdit(*promise, req)
 		if err != nil {
 			return err
 		}
-
-		// Get the updated promise
-		var promise models.Promise
-		err = db.QueryRow(
-			`SELECT id, user_id, recipient, description, due_date, current_state, reminder_frequency, last_reminded_at, created_at, updated_at 
-			FROM promises WHERE id = ?`,
-			promiseID,
-		).Scan(
-			&promise.ID, &promise.UserID, &promise.Recipient, &promise.Description,
-			&promise.DueDate, &promise.CurrentState, &promise.ReminderFrequency, &promise.LastRemindedAt, &promise.CreatedAt, &promise.UpdatedAt,
-		)
+		if req.TagIDs.Set {
+			var ids []int
+			if req.TagIDs.Value != nil {
+				ids = *req.TagIDs.Value
+			}
+			if updated.Tags, err = resolveTags(st, userID, ids); err != nil {
+				return err
+			}
+		}
+		if req.ContactID.Set {
+			updated.ContactID = nil
+			if req.ContactID.Value != nil {
+				contact, err := resolveContact(st, userID, *req.ContactID.Value)
+				if err != nil {
+					return err
+				}
+				updated.ContactID = &contact.ID
+			}
+		}
+		err = st.InTx(func(tx store.Store) error {
+			if err := updatePromise(tx, promise, &updated, actorUser, userID); err != nil {
+				return err
+			}
+			if sameTime(promise.DueDate, updated.DueDate) {
+				return nil
+			}
+			return rescheduleDueReminders(tx, &updated, req.RearmReminders)
+		})
 		if err != nil {
+			return staleResponse(c, st, promiseID, err)
+		}
+		if err := attachTags(st, userID, &updated); err != nil {
 			return err
 		}
+		// Recipients hear about the promise once they are told about it
+		if updated.NotifyRecipient && (!promise.NotifyRecipient || updated.RecipientEmail != promise.RecipientEmail) {
+			notifyRecipient(st, updated, recipientEmailMade)
+		}
 
-		return c.JSON(promise)
+		c.Set(fiber.HeaderETag, promiseETag(&updated))
+		return c.JSON(updated)
 	}
 }
 
-func DeletePromiseHandler(db *sql.DB) fiber.Handler {
+// applyPromiseEdit returns p with the fields present in req applied.
+func applyPromiseEdit(p models.Promise, req models.UpdatePromiseRequest) (models.Promise, error) {
+	if p.Encrypted && (req.Recipient.Set || req.Description.Set) {
+		return p, fiber.NewError(fiber.StatusBadRequest, "Encrypted promises must be edited through the envelope")
+	}
+	if req.Envelope.Set {
+		if !p.Encrypted {
+			return p, fiber.NewError(fiber.StatusBadRequest, "Only encrypted promises have an envelope")
+		}
+		if !validEnvelope(req.Envelope.Value) {
+			return p, fiber.NewError(fiber.StatusBadRequest, "Invalid envelope")
+		}
+		p.Envelope = req.Envelope.Value
+	}
+	if req.Recipient.Set {
+		if req.Recipient.Value == nil || *req.Recipient.Value == "" {
+			return p, fiber.NewError(fiber.StatusBadRequest, "Recipient can't be empty")
+		}
+		p.Recipient = *req.Recipient.Value
+	}
+	if req.Description.Set {
+		if req.Description.Value == nil || *req.Description.Value == "" {
+			return p, fiber.NewError(fiber.StatusBadRequest, "Description can't be empty")
+		}
+		p.Description = *req.Description.Value
+	}
+	if req.RecipientEmail.Set {
+		p.RecipientEmail = ""
+		if req.RecipientEmail.Value != nil {
+			p.RecipientEmail = *req.RecipientEmail.Value
+		}
+	}
+	if req.NotifyRecipient.Set {
+		p.NotifyRecipient = req.NotifyRecipient.Value != nil && *req.NotifyRecipient.Value
+	}
+	if req.DueDate.Set {
+		p.DueDate = req.DueDate.Value
+	}
+	if req.ReminderFrequency.Set {
+		p.ReminderFrequency = ""
+		if req.ReminderFrequency.Value != nil {
+			p.ReminderFrequency = *req.ReminderFrequency.Value
+		}
+		if !validReminderFrequencies[p.ReminderFrequency] {
+			return p, fiber.NewError(fiber.StatusBadRequest, "Invalid reminder frequency")
+		}
+	}
+	if req.KeepWhenDone.Set {
+		p.KeepWhenDone = req.KeepWhenDone.Value != nil && *req.KeepWhenDone.Value
+	}
+	if req.PartnerVisible.Set {
+		p.PartnerVisible = req.PartnerVisible.Value != nil && *req.PartnerVisible.Value
+		if err := checkPartnerVisible(&p); err != nil {
+			return p, err
+		}
+	}
+	return p, checkRecipientNotification(&p)
+}
+
+// DeletePromiseHandler moves a promise to the trash. It can be restored
+// until the trash is purged. It requires If-Match with the promise's current
+// ETag.
+func DeletePromiseHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 		promiseID, err := strconv.Atoi(c.Params("id"))
@@ -302,76 +510,84 @@ func DeletePromiseHandler(db *sql.DB) fiber.Handler {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
 		}
 
-		result, err := db.Exec("DELETE FROM promises WHERE id = ? AND user_id = ?", promiseID, userID)
+		// Promises the user can't see are reported as missing
+		promise, err := getPromiseFor(st, promiseID, userID, models.RoleViewer)
+		if fiberErr, ok := err.(*fiber.Error); ok && fiberErr.Code == fiber.StatusForbidden {
+			return fiber.NewError(fiber.StatusNotFound, "Promise not found")
+		}
 		if err != nil {
 			return err
 		}
+		if role, err := promiseRole(st, promise, userID); err != nil {
+			return err
+		} else if !hasRole(role, models.RoleEditor) {
+			return fiber.NewError(fiber.StatusForbidden, "Not authorized")
+		}
+		if err := checkIfMatch(c, promise); err != nil {
+			return staleResponse(c, st, promiseID, err)
+		}
 
-		rows, _ := result.RowsAffected()
-		if rows == 0 {
+		err = trashPromise(st, promiseID, userID)
+		if errors.Is(err, store.ErrNotFound) {
 			return fiber.NewError(fiber.StatusNotFound, "Promise not found")
 		}
+		if err != nil {
+			return err
+		}
 
 		return c.JSON(fiber.Map{"success": true})
 	}
 }
 
-func GetTimelineHandler(db *sql.DB) fiber.Handler {
+func GetTimelineHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 
-		query := `
-			SELECT 
-				p.id, p.user_id, p.recipient, p.description, p.due_date, 
-				p.current_state, p.reminder_frequency, p.last_reminded_at, p.created_at, p.updated_at,
-				pe.id as event_id, pe.state, pe.reflection_note, pe.created_at as event_created_at
-			FROM promises p
-			LEFT JOIN promise_events pe ON p.id = pe.promise_id
-			WHERE p.user_id = ?
-			ORDER BY pe.created_at DESC, p.created_at DESC
-		`
-
-		rows, err := db.Query(query, userID)
+		var filter store.PromiseFilter
+		if err := tagFilter(c, &filter); err != nil {
+			return err
+		}
+		if err := workspaceFilter(c, st, userID, &filter); err != nil {
+			return err
+		}
+		promises, err := st.Promises().ListByUser(userID, filter)
+		if err != nil {
+			return err
+		}
+		if err := attachTagsToList(st, userID, promises); err != nil {
+			return err
+		}
+		var events []models.Event
+		if filter.WorkspaceID != 0 {
+			events, err = st.Events().ListByWorkspace(filter.WorkspaceID)
+		} else {
+			events, err = st.Events().ListByUser(userID)
+		}
 		if err != nil {
 			return err
 		}
-		defer rows.Close()
 
-		promiseMap := make(map[int]*models.Promise)
-		var orderedPromises []int
+		promiseMap := make(map[int]*models.Promise, len(promises))
+		for i := range promises {
+			promises[i].Events = []models.Event{}
+			promiseMap[promises[i].ID] = &promises[i]
+		}
 
-		for rows.Next() {
-			var p models.Promise
-			var e models.Event
-			var eventID sql.NullInt64
-			var eventState sql.NullString
-			var eventNote sql.NullString
-			var eventCreatedAt sql.NullTime
-
-			err := rows.Scan(
-				&p.ID, &p.UserID, &p.Recipient, &p.Description, &p.DueDate,
-				&p.CurrentState, &p.ReminderFrequency, &p.LastRemindedAt, &p.CreatedAt, &p.UpdatedAt,
-				&eventID, &eventState, &eventNote, &eventCreatedAt,
-			)
-			if err != nil {
-				return err
+		// Promises are ordered by their most recent event, events newest first
+		var orderedPromises []int
+		for _, e := range events {
+			p, ok := promiseMap[e.PromiseID]
+			if !ok {
+				continue // filtered out
 			}
-
-			if _, exists := promiseMap[p.ID]; !exists {
-				p.Events = []models.Event{}
-				promiseMap[p.ID] = &p
+			if len(p.Events) == 0 {
 				orderedPromises = append(orderedPromises, p.ID)
 			}
-
-			if eventID.Valid {
-				e.ID = int(eventID.Int64)
-				e.PromiseID = p.ID
-				e.State = eventState.String
-				if eventNote.Valid {
-					e.ReflectionNote = eventNote.String
-				}
-				e.CreatedAt = eventCreatedAt.Time
-				promiseMap[p.ID].Events = append(promiseMap[p.ID].Events, e)
+			p.Events = append(p.Events, e)
+		}
+		for _, p := range promises {
+			if len(p.Events) == 0 {
+				orderedPromises = append(orderedPromises, p.ID)
 			}
 		}
 
@@ -380,6 +596,6 @@ func GetTimelineHandler(db *sql.DB) fiber.Handler {
 			timeline = append(timeline, *promiseMap[id])
 		}
 
-		return c.JSON(timeline)
+		return sendList(c, timeline)
 	}
 }
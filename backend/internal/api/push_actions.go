@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"kept/internal/auth"
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// pushActionTTL is how long the buttons on a reminder keep working.
+const pushActionTTL = 24 * time.Hour
+
+// Actions on reminder notifications. Kept and broken resolve the promise,
+// snooze and tomorrow remind the user again an hour or a day later.
+const (
+	pushActionKept     = "kept"
+	pushActionBroken   = "broken"
+	pushActionSnooze   = "snooze"
+	pushActionTomorrow = "tomorrow"
+)
+
+var snoozeDurations = map[string]time.Duration{
+	pushActionSnooze:   time.Hour,
+	pushActionTomorrow: 24 * time.Hour,
+}
+
+// reminderActions are the buttons on reminder notifications.
+var reminderActions = []PushAction{
+	{Action: pushActionKept, Title: "Kept"},
+	{Action: pushActionSnooze, Title: "Snooze 1h"},
+	{Action: pushActionTomorrow, Title: "Tomorrow"},
+}
+
+// withPushActions returns a copy of a reminder payload for userID with the
+// notification buttons, the URL they post to and a signed token to send.
+func withPushActions(payload PushPayload, userID, promiseID int) (PushPayload, error) {
+	token, err := auth.GeneratePushActionToken(userID, promiseID, pushActionTTL)
+	if err != nil {
+		return payload, err
+	}
+	data := map[string]interface{}{}
+	for k, v := range payload.Data {
+		data[k] = v
+	}
+	data["actions_url"] = strings.TrimSuffix(getAppURL(), "/") + "/api/push-actions"
+	data["action_token"] = token
+	payload.Data = data
+	payload.Actions = reminderActions
+	return payload, nil
+}
+
+// PurgeUsedPushActions forgets used push action tokens once they expired.
+func PurgeUsedPushActions(st store.Store) error {
+	n, err := st.PushActions().PurgeExpired(time.Now())
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Purged %d used push action tokens", n)
+	}
+	return nil
+}
+
+// PushActionHandler applies a notification button for the user the
+// notification was sent to, without a login: kept and broken resolve the
+// promise and snooze and tomorrow set an absolute reminder. The token comes
+// in the body and works once; resolving a promise that is already in that
+// state uses it up without doing anything.
+func PushActionHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		invalid := fiber.NewError(fiber.StatusNotFound, "This action is no longer valid")
+		var req models.PushActionRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		claims, err := auth.ValidatePushActionToken(req.Token)
+		if err != nil {
+			return invalid
+		}
+		action := c.Params("action")
+		if action != pushActionKept && action != pushActionBroken && snoozeDurations[action] == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Unknown action")
+		}
+
+		var kept *models.Promise
+		var reminder *models.Reminder
+		err = st.InTx(func(tx store.Store) error {
+			if err := tx.PushActions().Use(claims.ID, claims.ExpiresAt.Time); err != nil {
+				if errors.Is(err, store.ErrConflict) {
+					return invalid
+				}
+				return err
+			}
+			promise, err := getPromiseFor(tx, claims.PromiseID, claims.UserID, models.RoleEditor)
+			if err != nil {
+				return err
+			}
+			if promise.CurrentState == action {
+				return nil
+			}
+			if promise.CurrentState != "active" {
+				return fiber.NewError(fiber.StatusConflict, "Promise is already resolved")
+			}
+			if snooze := snoozeDurations[action]; snooze != 0 {
+				reminder = &models.Reminder{
+					PromiseID: promise.ID,
+					UserID:    claims.UserID,
+					Anchor:    models.AnchorAbsolute,
+					RemindAt:  time.Now().Add(snooze),
+				}
+				return tx.Reminders().Create(reminder)
+			}
+			if _, err := applyPromiseState(tx, promise, action, "", nil); err != nil {
+				return err
+			}
+			if action == pushActionKept {
+				if kept, err = tx.Promises().Get(promise.ID); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Promise not found")
+		}
+		if err != nil {
+			return err
+		}
+		if kept != nil {
+			notifyRecipient(st, *kept, recipientEmailKept)
+		}
+		if reminder != nil {
+			log.Printf("Snoozed promise %d for user %d until %s", claims.PromiseID, claims.UserID, reminder.RemindAt.Format(time.RFC3339))
+			return c.Status(fiber.StatusCreated).JSON(reminder)
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
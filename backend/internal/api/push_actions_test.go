@@ -0,0 +1,168 @@
+package api_test
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"kept/internal/api"
+	"kept/internal/auth"
+	"kept/internal/models"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+)
+
+func TestPushActions(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "alicepushactions")
+	bob := registerUser(t, app, "bobpushactions")
+
+	_, body := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the drill"})
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+
+	token := func(userID int, ttl time.Duration) models.PushActionRequest {
+		t.Helper()
+		token, err := auth.GeneratePushActionToken(userID, promise.ID, ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return models.PushActionRequest{Token: token}
+	}
+	for _, tc := range []struct {
+		action string
+		req    any
+		status int
+	}{
+		{"delete", token(alice.User.ID, time.Hour), 400},
+		{"kept", token(alice.User.ID, -time.Minute), 404},
+		{"kept", models.PushActionRequest{Token: alice.Token}, 404},
+		{"kept", nil, 400},
+		{"kept", token(bob.User.ID, time.Hour), 403},
+	} {
+		if resp, body := doJSON(t, app, "POST", "/api/push-actions/"+tc.action, "", tc.req); resp.StatusCode != tc.status {
+			t.Fatalf("Expected status %d for %s with %+v, got %d: %s", tc.status, tc.action, tc.req, resp.StatusCode, body)
+		}
+	}
+
+	// Snoozing reminds the user again without a login, once per token
+	before := time.Now()
+	snooze := token(alice.User.ID, time.Hour)
+	resp, body := doJSON(t, app, "POST", "/api/push-actions/tomorrow", "", snooze)
+	var reminder models.Reminder
+	json.Unmarshal(body, &reminder)
+	if resp.StatusCode != 201 || reminder.Anchor != models.AnchorAbsolute || reminder.UserID != alice.User.ID ||
+		reminder.RemindAt.Before(before.Add(24*time.Hour).Truncate(time.Second)) {
+		t.Fatalf("Expected a reminder tomorrow, got %d: %s", resp.StatusCode, body)
+	}
+	for _, action := range []string{"snooze", "kept"} {
+		if resp, _ := doJSON(t, app, "POST", "/api/push-actions/"+action, "", snooze); resp.StatusCode != 404 {
+			t.Fatalf("Expected status 404 reusing a token for %s, got %d", action, resp.StatusCode)
+		}
+	}
+
+	// Keeping it resolves the promise and cancels the snooze
+	if resp, body := doJSON(t, app, "POST", "/api/push-actions/kept", "", token(alice.User.ID, time.Hour)); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	stored, err := st.Promises().Get(promise.ID)
+	if err != nil || stored.CurrentState != "kept" {
+		t.Fatalf("Expected the promise to be kept, got %+v (%v)", stored, err)
+	}
+	if got, err := st.Reminders().Get(reminder.ID, alice.User.ID); err != nil || got.Status != models.ReminderCancelled {
+		t.Fatalf("Expected the snooze to be cancelled, got %+v (%v)", got, err)
+	}
+	if resp, _ := doJSON(t, app, "POST", "/api/push-actions/kept", "", token(alice.User.ID, time.Hour)); resp.StatusCode != 200 {
+		t.Fatalf("Expected keeping a kept promise to do nothing, got %d", resp.StatusCode)
+	}
+	// A failed action doesn't use up the token
+	retry := token(alice.User.ID, time.Hour)
+	for _, action := range []string{"snooze", "broken"} {
+		if resp, _ := doJSON(t, app, "POST", "/api/push-actions/"+action, "", retry); resp.StatusCode != 409 {
+			t.Fatalf("Expected status 409 for %s on a kept promise, got %d", action, resp.StatusCode)
+		}
+	}
+
+	// Trashed promises can't be acted on
+	doJSONIfMatch(t, app, "DELETE", "/api/promises/"+strconv.Itoa(promise.ID), alice.Token, "*", nil)
+	if resp, _ := doJSON(t, app, "POST", "/api/push-actions/kept", "", token(alice.User.ID, time.Hour)); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 for a trashed promise, got %d", resp.StatusCode)
+	}
+
+	// Used tokens are forgotten once they expire
+	if err := api.PurgeUsedPushActions(st); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st.PushActions().PurgeExpired(time.Now().Add(2 * time.Hour)); err != nil || n != 3 {
+		t.Fatalf("Expected three used tokens, got %d (%v)", n, err)
+	}
+}
+
+func TestPushPayloadIsNotLogged(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "alicepushlog")
+
+	var received int
+	push := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received++
+		w.WriteHeader(201)
+	}))
+	defer push.Close()
+	private, public, err := webpush.GenerateVAPIDKeys()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VAPID_SUBJECT", "mailto:test@example.com")
+	t.Setenv("VAPID_PUBLIC_KEY", public)
+	t.Setenv("VAPID_PRIVATE_KEY", private)
+
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	sub := models.PushSubscription{
+		Endpoint: push.URL + "/push/" + strings.Repeat("x", 50),
+		P256dh:   base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+		Auth:     base64.RawURLEncoding.EncodeToString(secret),
+	}
+	if resp, body := doJSON(t, app, "POST", "/api/push/subscribe", alice.Token, sub); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+
+	_, body := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the secret drill"})
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	reminder := models.Reminder{PromiseID: promise.ID, UserID: alice.User.ID, Anchor: models.AnchorAbsolute, RemindAt: time.Now().Add(-time.Minute)}
+	if err := st.Reminders().Create(&reminder); err != nil {
+		t.Fatal(err)
+	}
+
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+	if err := api.ProcessScheduledReminders(st); err != nil {
+		t.Fatal(err)
+	}
+	if received != 1 {
+		t.Fatalf("Expected one push to be sent, got %d: %s", received, logs.String())
+	}
+	for _, secret := range []string{"action_token", "eyJ", "secret drill"} {
+		if strings.Contains(logs.String(), secret) {
+			t.Fatalf("Expected %q not to be logged, got %s", secret, logs.String())
+		}
+	}
+}
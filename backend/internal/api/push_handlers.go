@@ -1,14 +1,15 @@
 package api
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"kept/internal/models"
+	"kept/internal/store"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func SubscribePushHandler(db *sql.DB) fiber.Handler {
+func SubscribePushHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 
@@ -22,15 +23,8 @@ func SubscribePushHandler(db *sql.DB) fiber.Handler {
 		}
 
 		// Upsert subscription
-		_, err := db.Exec(
-			`INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth) 
-			VALUES (?, ?, ?, ?)
-			ON CONFLICT(user_id, endpoint) DO UPDATE SET
-			p256dh = excluded.p256dh,
-			auth = excluded.auth`,
-			userID, sub.Endpoint, sub.P256dh, sub.Auth,
-		)
-		if err != nil {
+		sub.UserID = userID
+		if err := st.Subscriptions().Upsert(sub); err != nil {
 			return err
 		}
 
@@ -38,7 +32,7 @@ func SubscribePushHandler(db *sql.DB) fiber.Handler {
 	}
 }
 
-func UnsubscribePushHandler(db *sql.DB) fiber.Handler {
+func UnsubscribePushHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 
@@ -49,11 +43,7 @@ func UnsubscribePushHandler(db *sql.DB) fiber.Handler {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
 		}
 
-		_, err := db.Exec(
-			"DELETE FROM push_subscriptions WHERE user_id = ? AND endpoint = ?",
-			userID, body.Endpoint,
-		)
-		if err != nil {
+		if err := st.Subscriptions().Delete(userID, body.Endpoint); err != nil {
 			return err
 		}
 
@@ -62,15 +52,12 @@ func UnsubscribePushHandler(db *sql.DB) fiber.Handler {
 }
 
 // TestPushHandler returns a sample push payload about the user's most recent promise.
-func TestPushHandler(db *sql.DB) fiber.Handler {
+func TestPushHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 
-		row := db.QueryRow("SELECT id, recipient, description FROM promises WHERE user_id = ? ORDER BY updated_at DESC LIMIT 1", userID)
-		var id int
-		var recipient, description string
-		err := row.Scan(&id, &recipient, &description)
-		if err == sql.ErrNoRows {
+		promise, err := st.Promises().MostRecentByUser(userID)
+		if errors.Is(err, store.ErrNotFound) {
 			return c.JSON(fiber.Map{
 				"title": "Kept — Test Reminder",
 				"body":  "You have no promises yet. Create one to receive periodic reminders.",
@@ -81,14 +68,17 @@ func TestPushHandler(db *sql.DB) fiber.Handler {
 		}
 
 		title := "Reminder: Check your promise"
-		body := fmt.Sprintf("Remember your promise to %s: %s", recipient, description)
+		body := fmt.Sprintf("Remember your promise to %s: %s", promise.Recipient, promise.Description)
+		if promise.Encrypted {
+			body = "Remember your promise. " + encryptedReminderBody
+		}
 
 		return c.JSON(fiber.Map{
 			"title": title,
 			"body":  body,
 			"options": fiber.Map{
 				"tag": "kept-test",
-				"data": fiber.Map{"promise_id": id},
+				"data": fiber.Map{"promise_id": promise.ID},
 			},
 		})
 	}
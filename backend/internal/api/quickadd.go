@@ -0,0 +1,75 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"kept/internal/models"
+	"kept/internal/quickadd"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxQuickAddLength caps the text parsed for a promise.
+const maxQuickAddLength = 500
+
+// parseQuickAdd parses text in the IANA time zone tz.
+func parseQuickAdd(text, tz string) (quickadd.Result, error) {
+	if strings.TrimSpace(text) == "" {
+		return quickadd.Result{}, fiber.NewError(fiber.StatusBadRequest, "Text is required")
+	}
+	if len(text) > maxQuickAddLength {
+		return quickadd.Result{}, fiber.NewError(fiber.StatusBadRequest, "Text must be at most 500 characters")
+	}
+	loc, err := quickadd.Location(tz)
+	if err != nil {
+		return quickadd.Result{}, fiber.NewError(fiber.StatusBadRequest, "Unknown time zone")
+	}
+	return quickadd.Parse(text, time.Now().In(loc)), nil
+}
+
+// applyQuickAdd fills the fields of req that were left out from its text.
+// Fields that were sent win over what the text says.
+func applyQuickAdd(req *models.CreatePromiseRequest) error {
+	if req.Text == "" {
+		return nil
+	}
+	// The text would reveal what the envelope hides
+	if req.Encrypted {
+		return fiber.NewError(fiber.StatusBadRequest, "Encrypted promises can't be created from text")
+	}
+	parsed, err := parseQuickAdd(req.Text, req.TimeZone)
+	if err != nil {
+		return err
+	}
+	if req.Recipient == "" && req.ContactID == nil {
+		req.Recipient = parsed.Recipient
+	}
+	if req.Description == "" {
+		req.Description = parsed.Description
+	}
+	if req.DueDate == nil && parsed.DueDate != nil {
+		due := parsed.DueDate.UTC()
+		req.DueDate = &due
+	}
+	if req.ReminderFrequency == "" {
+		req.ReminderFrequency = parsed.ReminderFrequency
+	}
+	return nil
+}
+
+// ParsePromiseHandler previews what a quick-add text would create, without
+// creating anything.
+func ParsePromiseHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var req models.ParsePromiseRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		parsed, err := parseQuickAdd(req.Text, req.TimeZone)
+		if err != nil {
+			return err
+		}
+		return c.JSON(parsed)
+	}
+}
@@ -0,0 +1,51 @@
+package api_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"kept/internal/models"
+	"kept/internal/quickadd"
+)
+
+func TestQuickAdd(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "alicequickadd")
+
+	text := "Promise Sam I'll return the drill tomorrow at 6pm, remind me daily"
+	if resp, _ := doJSON(t, app, "POST", "/api/promises/parse", alice.Token, models.ParsePromiseRequest{Text: text, TimeZone: "Mars/Olympus"}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for an unknown time zone, got %d", resp.StatusCode)
+	}
+	resp, body := doJSON(t, app, "POST", "/api/promises/parse", alice.Token, models.ParsePromiseRequest{Text: text, TimeZone: "Asia/Tokyo"})
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	var preview quickadd.Result
+	json.Unmarshal(body, &preview)
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	y, m, d := time.Now().In(tokyo).AddDate(0, 0, 1).Date()
+	want := time.Date(y, m, d, 18, 0, 0, 0, tokyo)
+	if preview.Recipient != "Sam" || preview.Description != "Return the drill" || preview.ReminderFrequency != "daily" || preview.Confidence != 1 {
+		t.Fatalf("Unexpected preview: %s", body)
+	}
+	if preview.DueDate == nil || !preview.DueDate.Equal(want) {
+		t.Fatalf("Expected the drill due %s, got %s", want, body)
+	}
+
+	// Fields that are sent win over the text
+	resp, body = doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Samuel", Text: text, TimeZone: "Asia/Tokyo"})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	if promise.Recipient != "Samuel" || promise.Description != "Return the drill" || promise.ReminderFrequency != "daily" || !promise.DueDate.Equal(want) {
+		t.Fatalf("Unexpected promise: %s", body)
+	}
+
+	if resp, _ := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Text: "tomorrow"}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 without a description, got %d", resp.StatusCode)
+	}
+}
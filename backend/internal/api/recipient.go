@@ -0,0 +1,351 @@
+package api
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/mail"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"kept/internal/auth"
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Event states recording how the recipient answered the outcome of a
+// promise. They don't change the promise's state.
+const (
+	eventConfirmed = "confirmed"
+	eventDisputed  = "disputed"
+)
+
+// errAlreadyAnswered refuses a second answer to the same outcome.
+var errAlreadyAnswered = fiber.NewError(fiber.StatusConflict, "You already answered")
+
+const (
+	recipientLinkTTL       = 60 * 24 * time.Hour
+	maxRecipientNoteLength = 1000
+)
+
+// Kinds of recipient emails.
+const (
+	recipientEmailMade = "made"
+	recipientEmailKept = "kept"
+)
+
+// checkRecipientNotification normalizes the recipient email of p and checks
+// that notifications can be sent: the server must be able to read the
+// promise and know where to send them.
+func checkRecipientNotification(p *models.Promise) error {
+	p.RecipientEmail = strings.TrimSpace(p.RecipientEmail)
+	if p.RecipientEmail != "" {
+		addr, err := mail.ParseAddress(p.RecipientEmail)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid recipient email")
+		}
+		p.RecipientEmail = addr.Address
+	}
+	if p.NotifyRecipient && p.Encrypted {
+		return fiber.NewError(fiber.StatusBadRequest, "Encrypted promises can't notify the recipient")
+	}
+	if p.NotifyRecipient && p.RecipientEmail == "" {
+		return fiber.NewError(fiber.StatusBadRequest, "Notifying the recipient needs their email")
+	}
+	return nil
+}
+
+// recipientLink returns the link that lets the recipient of p see it and
+// answer its outcome, or "" if p doesn't notify its recipient.
+func recipientLink(p *models.Promise) (string, error) {
+	if !p.NotifyRecipient || p.Encrypted || p.RecipientEmail == "" {
+		return "", nil
+	}
+	token, err := auth.GenerateRecipientToken(p.ID, p.RecipientEmail, recipientLinkTTL)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(getAppURL(), "/") + "/api/recipient/" + token, nil
+}
+
+var recipientEmailTemplate = template.Must(template.New("recipient").Parse(`<!DOCTYPE html>
+<html>
+<body style="font-family: sans-serif; color: #1f2937; max-width: 560px; margin: 0 auto; padding: 24px;">
+  {{if eq .Kind "kept"}}
+  <h2>{{.From}} kept a promise to you</h2>
+  {{else}}
+  <h2>{{.From}} made you a promise</h2>
+  {{end}}
+  <p style="font-size: 18px;">{{.Description}}</p>
+  {{if .DueDate}}<p>Due {{.DueDate}}</p>{{end}}
+  <p>
+    <a href="{{.Link}}" style="display: inline-block; padding: 10px 16px; background: #2563eb; color: #fff; border-radius: 6px; text-decoration: none;">
+      {{if eq .Kind "kept"}}Confirm or dispute{{else}}View the promise{{end}}
+    </a>
+  </p>
+  <p style="color: #6b7280; font-size: 12px;">{{.From}} asked Kept to keep you posted. You don't need an account.</p>
+</body>
+</html>
+`))
+
+// GenerateRecipientEmail renders the email telling the recipient of a
+// promise that it was made or kept.
+func GenerateRecipientEmail(p models.Promise, from, kind, link string) (string, error) {
+	dueDate := ""
+	if p.DueDate != nil {
+		dueDate = formatEmailDate(*p.DueDate)
+	}
+	var buf bytes.Buffer
+	err := recipientEmailTemplate.Execute(&buf, map[string]string{
+		"Kind":        kind,
+		"From":        from,
+		"Description": p.Description,
+		"DueDate":     dueDate,
+		"Link":        link,
+	})
+	return buf.String(), err
+}
+
+// notifyRecipient emails the recipient of p in the background that it was
+// made or kept. Nothing is sent unless SMTP is configured and p notifies its
+// recipient.
+func notifyRecipient(st store.Store, p models.Promise, kind string) {
+	if !p.NotifyRecipient || p.Encrypted {
+		return
+	}
+	config, err := GetSMTPConfig()
+	if err != nil {
+		log.Printf("SMTP not configured, skipping recipient email: %v", err)
+		return
+	}
+	go func() {
+		if err := sendRecipientEmail(st, config, p, kind); err != nil {
+			log.Printf("Failed to email the recipient of promise %d: %v", p.ID, err)
+		}
+	}()
+}
+
+func sendRecipientEmail(st store.Store, config *SMTPConfig, p models.Promise, kind string) error {
+	owner, err := st.Users().GetByID(p.UserID)
+	if err != nil {
+		return err
+	}
+	link, err := recipientLink(&p)
+	if err != nil {
+		return err
+	}
+	html, err := GenerateRecipientEmail(p, owner.Username, kind, link)
+	if err != nil {
+		return err
+	}
+	subject := owner.Username + " made you a promise"
+	if kind == recipientEmailKept {
+		subject = owner.Username + " kept a promise to you"
+	}
+	return sendSMTPEmail(config, p.RecipientEmail, subject, html)
+}
+
+// recipientView is what the recipient of a promise sees through their link.
+type recipientView struct {
+	From        string     `json:"from"`
+	Recipient   string     `json:"recipient"`
+	Description string     `json:"description"`
+	DueDate     *time.Time `json:"due_date,omitempty"`
+	State       string     `json:"current_state"`
+	CreatedAt   time.Time  `json:"created_at"`
+	// Response is the recipient's answer to the current outcome, if any.
+	Response string `json:"response,omitempty"`
+}
+
+// recipientPromise loads the promise a recipient link is for. Links stop
+// working when the promise is trashed, its notifications are turned off or
+// the recipient email changes.
+func recipientPromise(st store.Store, token string) (*models.Promise, error) {
+	invalid := fiber.NewError(fiber.StatusNotFound, "This link is no longer valid")
+	claims, err := auth.ValidateRecipientToken(token)
+	if err != nil {
+		return nil, invalid
+	}
+	promise, err := st.Promises().Get(claims.PromiseID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !promise.NotifyRecipient || promise.Encrypted || auth.RecipientEmailHash(promise.RecipientEmail) != claims.EmailHash {
+		return nil, invalid
+	}
+	return promise, nil
+}
+
+// recipientResponse returns the recipient's latest answer, unless the
+// promise changed state since.
+func recipientResponse(events []models.Event) string {
+	response := ""
+	for _, e := range events {
+		switch e.State {
+		case eventConfirmed, eventDisputed:
+			response = e.State
+		case eventNudged:
+			// Nudges don't change the promise
+		default:
+			response = ""
+		}
+	}
+	return response
+}
+
+func buildRecipientView(st store.Store, promise *models.Promise) (*recipientView, error) {
+	owner, err := st.Users().GetByID(promise.UserID)
+	if err != nil {
+		return nil, err
+	}
+	events, err := st.Events().ListByPromise(promise.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &recipientView{
+		From:        owner.Username,
+		Recipient:   promise.Recipient,
+		Description: promise.Description,
+		DueDate:     promise.DueDate,
+		State:       promise.CurrentState,
+		CreatedAt:   promise.CreatedAt,
+		Response:    recipientResponse(events),
+	}, nil
+}
+
+var recipientPageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <meta name="robots" content="noindex">
+  <title>A promise from {{.From}}</title>
+</head>
+<body style="font-family: sans-serif; color: #1f2937; max-width: 560px; margin: 0 auto; padding: 24px;">
+  <p>{{.From}} promised {{.Recipient}}:</p>
+  <h2>{{.Description}}</h2>
+  {{if .DueDate}}<p>Due {{.DueDate.Format "January 2, 2006"}}</p>{{end}}
+  {{if eq .State "kept"}}
+    <p><strong>{{.From}} says this promise was kept.</strong></p>
+    {{if .Response}}<p>You {{.Response}} this.</p>{{else}}
+    <form method="post">
+      <p><textarea name="note" rows="3" cols="40" maxlength="1000" placeholder="Add a note (optional)"></textarea></p>
+      <button type="submit" name="response" value="confirmed">Yes, it was kept</button>
+      <button type="submit" name="response" value="disputed">No, it wasn't</button>
+    </form>
+    {{end}}
+  {{else if eq .State "broken"}}
+    <p>{{.From}} says this promise was broken.</p>
+  {{else}}
+    <p>This promise is still open. You'll get another email when it's kept.</p>
+  {{end}}
+</body>
+</html>
+`))
+
+// wantsHTML reports whether the client prefers a page over JSON, as
+// browsers following an emailed link do.
+func wantsHTML(c *fiber.Ctx) bool {
+	return c.Accepts(fiber.MIMEApplicationJSON, fiber.MIMETextHTML) == fiber.MIMETextHTML
+}
+
+func renderRecipientPage(c *fiber.Ctx, view *recipientView) error {
+	var buf bytes.Buffer
+	if err := recipientPageTemplate.Execute(&buf, view); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Type("html", "utf-8")
+	return c.Send(buf.Bytes())
+}
+
+// RecipientViewHandler shows the recipient of a promise what was promised,
+// as a page or, for API clients, JSON. It needs no account.
+func RecipientViewHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		promise, err := recipientPromise(st, c.Params("token"))
+		if err != nil {
+			return err
+		}
+		view, err := buildRecipientView(st, promise)
+		if err != nil {
+			return err
+		}
+		if wantsHTML(c) {
+			return renderRecipientPage(c, view)
+		}
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		return c.JSON(view)
+	}
+}
+
+// RecipientRespondHandler records the recipient confirming or disputing that
+// a kept promise was kept, and lets the owner know. The recipient answers
+// once each time the promise is kept, so a link can't be used to flood the
+// owner with notifications.
+func RecipientRespondHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		promise, err := recipientPromise(st, c.Params("token"))
+		if err != nil {
+			return err
+		}
+
+		var req models.RecipientResponse
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		if req.Response != eventConfirmed && req.Response != eventDisputed {
+			return fiber.NewError(fiber.StatusBadRequest, "Response must be confirmed or disputed")
+		}
+		req.Note = strings.TrimSpace(req.Note)
+		if utf8.RuneCountInString(req.Note) > maxRecipientNoteLength {
+			return fiber.NewError(fiber.StatusBadRequest, "Notes must be at most 1000 characters")
+		}
+		if promise.CurrentState != "kept" {
+			return fiber.NewError(fiber.StatusConflict, "This promise hasn't been kept yet")
+		}
+
+		event := models.Event{PromiseID: promise.ID, State: req.Response, ReflectionNote: req.Note}
+		err = st.InTx(func(tx store.Store) error {
+			events, err := tx.Events().ListByPromise(promise.ID)
+			if err != nil {
+				return err
+			}
+			if recipientResponse(events) != "" {
+				return errAlreadyAnswered
+			}
+			return tx.Events().Create(&event)
+		})
+		if errors.Is(err, errAlreadyAnswered) && wantsHTML(c) {
+			// The page shows the answer already given
+			return c.Redirect(c.OriginalURL(), fiber.StatusSeeOther)
+		}
+		if err != nil {
+			return err
+		}
+
+		payload := PushPayload{
+			Title: fmt.Sprintf("%s %s your promise", promise.Recipient, req.Response),
+			Body:  promise.Description,
+			Icon:  "/Static/logos/Kept Mascot Colored.svg",
+			Tag:   fmt.Sprintf("kept-recipient-%d", promise.ID),
+			Data:  map[string]interface{}{"promise_id": promise.ID},
+		}
+		if err := SendPushToUser(st, promise.UserID, payload); err != nil {
+			log.Printf("Failed to tell the owner of promise %d about the recipient's response: %v", promise.ID, err)
+		}
+
+		if wantsHTML(c) {
+			return c.Redirect(c.OriginalURL(), fiber.StatusSeeOther)
+		}
+		return c.Status(fiber.StatusCreated).JSON(event)
+	}
+}
@@ -0,0 +1,136 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"kept/internal/models"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestRecipientLinks(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	auth := registerUser(t, app, "promiser")
+
+	resp, _ := doJSON(t, app, "POST", "/api/promises/", auth.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the drill", NotifyRecipient: true})
+	if resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 without a recipient email, got %d", resp.StatusCode)
+	}
+	resp, body := doJSON(t, app, "POST", "/api/promises/", auth.Token, models.CreatePromiseRequest{
+		Recipient: "Sam", Description: "Return the drill", RecipientEmail: "Sam <sam@example.com>", NotifyRecipient: true,
+	})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	if promise.RecipientEmail != "sam@example.com" || !promise.NotifyRecipient {
+		t.Fatalf("Unexpected recipient settings: %s", body)
+	}
+	promisePath := "/api/promises/" + strconv.Itoa(promise.ID)
+
+	_, body = doJSON(t, app, "GET", promisePath, auth.Token, nil)
+	json.Unmarshal(body, &promise)
+	i := strings.Index(promise.RecipientLink, "/api/recipient/")
+	if i < 0 {
+		t.Fatalf("Expected a recipient link, got %q", promise.RecipientLink)
+	}
+	link := promise.RecipientLink[i:]
+
+	visit := func(method, path, accept, contentType, payload string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(payload))
+		req.Header.Set("Accept", accept)
+		if contentType != "" {
+			req.Header.Set("Content-Type", contentType)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	status, page := visit("GET", link, "text/html", "", "")
+	if status != 200 || !strings.Contains(page, "Return the drill") || strings.Contains(page, "<form") {
+		t.Fatalf("Expected a page without a form while the promise is open, got %d: %s", status, page)
+	}
+	status, body2 := visit("POST", link, fiber.MIMEApplicationJSON, fiber.MIMEApplicationJSON, `{"response": "confirmed"}`)
+	if status != 409 {
+		t.Fatalf("Expected status 409 before the promise is kept, got %d: %s", status, body2)
+	}
+
+	doJSONIfMatch(t, app, "PUT", promisePath+"/state", auth.Token, "*", models.UpdatePromiseStateRequest{State: "kept"})
+
+	status, body2 = visit("POST", link, fiber.MIMEApplicationJSON, fiber.MIMEApplicationJSON, `{"response": "maybe"}`)
+	if status != 400 {
+		t.Fatalf("Expected status 400 for an invalid response, got %d", status)
+	}
+	status, body2 = visit("POST", link, fiber.MIMEApplicationJSON, fiber.MIMEApplicationJSON, `{"response": "confirmed", "note": "Got it back, thanks"}`)
+	if status != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", status, body2)
+	}
+	status, body2 = visit("GET", link, fiber.MIMEApplicationJSON, "", "")
+	var view struct {
+		From     string `json:"from"`
+		State    string `json:"current_state"`
+		Response string `json:"response"`
+	}
+	json.Unmarshal([]byte(body2), &view)
+	if status != 200 || view.From != "promiser" || view.State != "kept" || view.Response != "confirmed" {
+		t.Fatalf("Unexpected view: %d %s", status, body2)
+	}
+
+	// The recipient answers once per outcome
+	for _, response := range []string{"confirmed", "disputed"} {
+		if status, _ := visit("POST", link, fiber.MIMEApplicationJSON, fiber.MIMEApplicationJSON, `{"response": "`+response+`"}`); status != 409 {
+			t.Fatalf("Expected status 409 answering %s again, got %d", response, status)
+		}
+	}
+	if status, page := visit("GET", link, "text/html", "", ""); status != 200 || strings.Contains(page, "<form") || !strings.Contains(page, "You confirmed this") {
+		t.Fatalf("Expected the answer without a form, got %d: %s", status, page)
+	}
+
+	// Once kept again, browsers post the form and are sent back to the page
+	doJSONIfMatch(t, app, "PUT", promisePath+"/state", auth.Token, "*", models.UpdatePromiseStateRequest{State: "active"})
+	doJSONIfMatch(t, app, "PUT", promisePath+"/state", auth.Token, "*", models.UpdatePromiseStateRequest{State: "kept"})
+	for range 2 {
+		status, _ = visit("POST", link, "text/html", fiber.MIMEApplicationForm, "response=disputed&note=")
+		if status != 303 {
+			t.Fatalf("Expected a redirect after posting the form, got %d", status)
+		}
+	}
+	_, body = doJSON(t, app, "GET", promisePath, auth.Token, nil)
+	json.Unmarshal(body, &promise)
+	states := []string{}
+	for _, e := range promise.Events {
+		states = append(states, e.State)
+	}
+	if strings.Join(states, ",") != "active,kept,confirmed,active,kept,disputed" || promise.Events[2].ReflectionNote != "Got it back, thanks" {
+		t.Fatalf("Expected one response per outcome as events, got %+v", promise.Events)
+	}
+
+	if status, _ := visit("GET", link+"x", fiber.MIMEApplicationJSON, "", ""); status != 404 {
+		t.Fatalf("Expected status 404 for a tampered link, got %d", status)
+	}
+
+	// Changing the address revokes the old link
+	resp, body = doJSONIfMatch(t, app, "PATCH", promisePath, auth.Token, "*", map[string]any{"recipient_email": "samuel@example.com"})
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	if status, _ := visit("GET", link, fiber.MIMEApplicationJSON, "", ""); status != 404 {
+		t.Fatalf("Expected status 404 for a revoked link, got %d", status)
+	}
+	resp, _ = doJSONIfMatch(t, app, "PATCH", promisePath, auth.Token, "*", map[string]any{"recipient_email": nil})
+	if resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 clearing the email of a notifying promise, got %d", resp.StatusCode)
+	}
+}
@@ -1,39 +1,25 @@
 package api
 
 import (
-	"database/sql"
+	"errors"
 	"fmt"
 	"kept/internal/models"
+	"kept/internal/store"
 	"log"
 	"time"
 )
 
 // ProcessRecurringReminders checks for active promises with recurring reminders
 // and triggers push notifications if enough time has passed since the last reminder.
-func ProcessRecurringReminders(db *sql.DB) error {
-	query := `
-		SELECT id, user_id, recipient, description, reminder_frequency, last_reminded_at, created_at 
-		FROM promises 
-		WHERE current_state = 'active' AND reminder_frequency != '' AND reminder_frequency IS NOT NULL
-	`
-
-	rows, err := db.Query(query)
+func ProcessRecurringReminders(st store.Store) error {
+	promises, err := st.Promises().ListWithRecurringReminders()
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var p models.Promise
-		// partially scan relevant fields
-		err := rows.Scan(&p.ID, &p.UserID, &p.Recipient, &p.Description, &p.ReminderFrequency, &p.LastRemindedAt, &p.CreatedAt)
-		if err != nil {
-			log.Printf("Error scanning promise for reminder: %v", err)
-			continue
-		}
 
+	for _, p := range promises {
 		if shouldRemind(p) {
-			if err := sendRecurringReminder(db, p); err != nil {
+			if err := sendRecurringReminder(st, p); err != nil {
 				log.Printf("Failed to send reminder for promise %d: %v", p.ID, err)
 			}
 		}
@@ -42,54 +28,89 @@ func ProcessRecurringReminders(db *sql.DB) error {
 }
 
 // ProcessScheduledReminders checks for one-time reminders that are due
-// and sends push notifications for them.
-func ProcessScheduledReminders(db *sql.DB) error {
-	query := `
-		SELECT r.id, r.promise_id, r.user_id, r.remind_at, p.recipient, p.description
-		FROM reminders r
-		JOIN promises p ON r.promise_id = p.id
-		WHERE r.is_sent = FALSE AND r.remind_at <= CURRENT_TIMESTAMP
-	`
-
-	rows, err := db.Query(query)
+// and sends push notifications for them. Reminders whose push reached
+// nobody are marked failed rather than retried.
+func ProcessScheduledReminders(st store.Store) error {
+	due, err := st.Reminders().ListDue(time.Now())
 	if err != nil {
 		return err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var reminderID, promiseID, userID int
-		var remindAt time.Time
-		var recipient, description string
-
-		err := rows.Scan(&reminderID, &promiseID, &userID, &remindAt, &recipient, &description)
-		if err != nil {
-			log.Printf("Error scanning reminder: %v", err)
-			continue
-		}
+	for _, r := range due {
+		reminderID, promiseID, userID := r.ID, r.PromiseID, r.UserID
+		title, body := reminderText(r.Recipient, r.Description, r.Encrypted)
 
 		payload := PushPayload{
-			Title: fmt.Sprintf("Reminder about your promise to: %s", recipient),
-			Body:  description,
+			Title: title,
+			Body:  body,
 			Icon:  "/Static/logos/Kept Mascot Colored.svg",
 			Badge: "/Static/logos/Kept Mascot Colored.svg",
 			Tag:   fmt.Sprintf("kept-reminder-%d", reminderID),
 			Data:  map[string]interface{}{"promise_id": promiseID, "reminder_id": reminderID},
 		}
 
-		if err := SendPushToUser(db, userID, payload); err != nil {
+		userIDs, err := reminderRecipients(st, userID, r.WorkspaceID)
+		if err != nil {
+			log.Printf("Failed to find who to send scheduled reminder %d to: %v", reminderID, err)
+			continue
+		}
+		if err := sendPushToUsers(st, userIDs, promiseID, payload); err != nil {
 			log.Printf("Failed to send scheduled reminder %d: %v", reminderID, err)
+			if err := st.Reminders().SetStatus(reminderID, models.ReminderFailed); err != nil {
+				log.Printf("Failed to mark reminder %d as failed: %v", reminderID, err)
+			}
 			continue
 		}
 
 		// Mark reminder as sent
-		_, err = db.Exec("UPDATE reminders SET is_sent = TRUE WHERE id = ?", reminderID)
-		if err != nil {
+		if err := st.Reminders().SetStatus(reminderID, models.ReminderSent); err != nil {
 			log.Printf("Failed to mark reminder %d as sent: %v", reminderID, err)
 		} else {
-			log.Printf("Sent scheduled reminder %d for promise %d to user %d", reminderID, promiseID, userID)
+			log.Printf("Sent scheduled reminder %d for promise %d to users %v", reminderID, promiseID, userIDs)
+		}
+	}
+	return nil
+}
+
+// reminderRecipients returns who a reminder about a promise goes to: the
+// given user for personal promises, or the owners and editors of the
+// promise's workspace.
+func reminderRecipients(st store.Store, userID int, workspaceID *int) ([]int, error) {
+	if workspaceID == nil {
+		return []int{userID}, nil
+	}
+	members, err := st.Workspaces().ListMembers(*workspaceID)
+	if err != nil {
+		return nil, err
+	}
+	var userIDs []int
+	for _, m := range members {
+		if hasRole(m.Role, models.RoleEditor) {
+			userIDs = append(userIDs, m.UserID)
+		}
+	}
+	return userIDs, nil
+}
+
+// sendPushToUsers sends a reminder about a promise to each user, with
+// buttons that act on it for them. It only fails if sending failed for all
+// of them, so that one member's broken subscription doesn't make everyone
+// get the reminder again.
+func sendPushToUsers(st store.Store, userIDs []int, promiseID int, payload PushPayload) error {
+	var errs []error
+	for _, userID := range userIDs {
+		userPayload, err := withPushActions(payload, userID, promiseID)
+		if err == nil {
+			err = SendPushToUser(st, userID, userPayload)
+		}
+		if err != nil {
+			log.Printf("Failed to send push to user %d: %v", userID, err)
+			errs = append(errs, err)
 		}
 	}
+	if len(errs) > 0 && len(errs) == len(userIDs) {
+		return errors.Join(errs...)
+	}
 	return nil
 }
 
@@ -114,26 +135,30 @@ func shouldRemind(p models.Promise) bool {
 	return time.Since(lastReminded) >= duration
 }
 
-func sendRecurringReminder(db *sql.DB, p models.Promise) error {
+func sendRecurringReminder(st store.Store, p models.Promise) error {
+	title, body := reminderText(p.Recipient, p.Description, p.Encrypted)
 	payload := PushPayload{
-		Title: fmt.Sprintf("Reminder about your promise to: %s", p.Recipient),
-		Body:  p.Description,
+		Title: title,
+		Body:  body,
 		Icon:  "/Static/logos/Kept Mascot Colored.svg",
 		Badge: "/Static/logos/Kept Mascot Colored.svg",
 		Tag:   fmt.Sprintf("kept-recurring-%d", p.ID),
 		Data:  map[string]interface{}{"promise_id": p.ID},
 	}
 
-	if err := SendPushToUser(db, p.UserID, payload); err != nil {
+	userIDs, err := reminderRecipients(st, p.UserID, p.WorkspaceID)
+	if err != nil {
+		return err
+	}
+	if err := sendPushToUsers(st, userIDs, p.ID, payload); err != nil {
 		return err
 	}
 
 	// Update last_reminded_at
-	_, err := db.Exec("UPDATE promises SET last_reminded_at = CURRENT_TIMESTAMP WHERE id = ?", p.ID)
-	if err != nil {
+	if err := st.Promises().MarkReminded(p.ID, time.Now()); err != nil {
 		return fmt.Errorf("failed to update last_reminded_at: %w", err)
 	}
 
-	log.Printf("Sent recurring reminder for promise %d to user %d", p.ID, p.UserID)
+	log.Printf("Sent recurring reminder for promise %d to users %v", p.ID, userIDs)
 	return nil
 }
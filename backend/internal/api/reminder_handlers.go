@@ -1,15 +1,179 @@
 package api
 
 import (
-	"database/sql"
+	"errors"
 	"kept/internal/models"
+	"kept/internal/store"
 	"strconv"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func CreateReminderHandler(db *sql.DB) fiber.Handler {
+// maxRemindersPerRequest caps the reminders one request can create.
+const maxRemindersPerRequest = 20
+
+// lastStateChange returns when a promise's state last changed, or when it
+// was made if it never has.
+func lastStateChange(st store.Store, p *models.Promise) (time.Time, error) {
+	events, err := st.Events().ListByPromise(p.ID)
+	if err != nil {
+		return time.Time{}, err
+	}
+	for i := len(events) - 1; i >= 0; i-- {
+		if validPromiseStates[events[i].State] {
+			return events[i].CreatedAt, nil
+		}
+	}
+	return p.CreatedAt, nil
+}
+
+// reminderTime returns when a reminder with a relative anchor goes off for
+// p. changedAt is when p's state last changed.
+func reminderTime(p *models.Promise, anchor string, offsetMinutes int, changedAt time.Time) (time.Time, error) {
+	offset := time.Duration(offsetMinutes) * time.Minute
+	switch anchor {
+	case models.AnchorDue:
+		if p.DueDate == nil {
+			return time.Time{}, fiber.NewError(fiber.StatusBadRequest, "Promise has no due date")
+		}
+		return p.DueDate.Add(-offset), nil
+	case models.AnchorCreated:
+		return p.CreatedAt.Add(offset), nil
+	case models.AnchorStateChange:
+		return changedAt.Add(offset), nil
+	default:
+		return time.Time{}, fiber.NewError(fiber.StatusBadRequest, "Invalid reminder anchor")
+	}
+}
+
+// applyReminderRequest validates req and sets the anchor, offset and time of
+// a reminder about p.
+func applyReminderRequest(st store.Store, p *models.Promise, req models.ReminderRequest, r *models.Reminder) error {
+	anchor := req.Anchor
+	if anchor == "" {
+		anchor = models.AnchorDue
+		if req.RemindAt != nil {
+			anchor = models.AnchorAbsolute
+		}
+	}
+	if req.OffsetMinutes < 0 {
+		return fiber.NewError(fiber.StatusBadRequest, "Reminder offsets can't be negative")
+	}
+
+	if anchor == models.AnchorAbsolute {
+		if req.RemindAt == nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Absolute reminders need remind_at")
+		}
+		r.Anchor, r.OffsetMinutes, r.RemindAt = anchor, 0, *req.RemindAt
+		return nil
+	}
+	if req.RemindAt != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "remind_at is only for absolute reminders")
+	}
+	var changedAt time.Time
+	if anchor == models.AnchorStateChange {
+		var err error
+		if changedAt, err = lastStateChange(st, p); err != nil {
+			return err
+		}
+	}
+	remindAt, err := reminderTime(p, anchor, req.OffsetMinutes, changedAt)
+	if err != nil {
+		return err
+	}
+	r.Anchor, r.OffsetMinutes, r.RemindAt = anchor, req.OffsetMinutes, remindAt
+	return nil
+}
+
+// rearmStateChangeReminders moves the reminders anchored to a promise's
+// last state change after a change at changedAt, sent or not.
+func rearmStateChangeReminders(tx store.Store, promiseID int, changedAt time.Time) error {
+	reminders, err := tx.Reminders().ListByPromise(promiseID)
+	if err != nil {
+		return err
+	}
+	for _, r := range reminders {
+		if r.Anchor != models.AnchorStateChange {
+			continue
+		}
+		r.RemindAt = changedAt.Add(time.Duration(r.OffsetMinutes) * time.Minute)
+		r.Status = models.ReminderPending
+		if err := tx.Reminders().Update(&r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sameTime reports whether two optional times are both unset or equal.
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// reminderStatus returns the status a reminder about p that hasn't gone off
+// should have.
+func reminderStatus(p *models.Promise) string {
+	if p.CurrentState != "active" || p.DeletedAt != nil {
+		return models.ReminderCancelled
+	}
+	return models.ReminderPending
+}
+
+// settleReminders cancels a promise's pending reminders once it is resolved
+// or trashed, and restores the cancelled ones still ahead once it is active
+// again.
+func settleReminders(tx store.Store, promiseID int, active bool) error {
+	if !active {
+		return tx.Reminders().CancelPending(promiseID)
+	}
+	return tx.Reminders().RestoreCancelled(promiseID, time.Now())
+}
+
+// rescheduleDueReminders moves the reminders anchored to a promise's due
+// date that haven't gone off after it changed. With rearm, sent or failed
+// ones whose new time is still ahead go off again. Without a due date the
+// ones that haven't gone off are dropped.
+func rescheduleDueReminders(tx store.Store, p *models.Promise, rearm bool) error {
+	reminders, err := tx.Reminders().ListByPromise(p.ID)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	for _, r := range reminders {
+		if r.Anchor != models.AnchorDue {
+			continue
+		}
+		done := r.Status == models.ReminderSent || r.Status == models.ReminderFailed
+		if p.DueDate == nil {
+			if !done {
+				if err := tx.Reminders().Delete(r.ID, r.UserID); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		r.RemindAt = p.DueDate.Add(-time.Duration(r.OffsetMinutes) * time.Minute)
+		if done {
+			if !rearm || !r.RemindAt.After(now) {
+				continue
+			}
+			r.Status = reminderStatus(p)
+		}
+		if err := tx.Reminders().Update(&r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CreateReminderHandler creates a reminder about a promise, or several with
+// a reminders list, in which case it responds with the list. Reminders
+// don't change the promise's version, so this doesn't need If-Match.
+func CreateReminderHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 		promiseID, err := strconv.Atoi(c.Params("promiseId"))
@@ -21,88 +185,131 @@ func CreateReminderHandler(db *sql.DB) fiber.Handler {
 		if err := c.BodyParser(&req); err != nil {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
 		}
+		requests := req.Reminders
+		if len(requests) == 0 {
+			requests = []models.ReminderRequest{{Anchor: req.Anchor, OffsetMinutes: req.OffsetMinutes, RemindAt: req.RemindAt}}
+		}
+		if len(requests) > maxRemindersPerRequest {
+			return fiber.NewError(fiber.StatusBadRequest, "At most 20 reminders can be created at once")
+		}
 
-		// Get promise and check ownership
-		var dueDate sql.NullTime
-		var promiseUserID int
-		err = db.QueryRow(
-			"SELECT user_id, due_date FROM promises WHERE id = ?",
-			promiseID,
-		).Scan(&promiseUserID, &dueDate)
-
-		if err == sql.ErrNoRows {
-			return fiber.NewError(fiber.StatusNotFound, "Promise not found")
+		promise, err := getPromiseFor(st, promiseID, userID, models.RoleEditor)
+		if err != nil {
+			return err
 		}
+
+		reminders := make([]models.Reminder, len(requests))
+		err = st.InTx(func(tx store.Store) error {
+			for i, r := range requests {
+				reminders[i] = models.Reminder{PromiseID: promiseID, UserID: userID, Status: reminderStatus(promise)}
+				if err := applyReminderRequest(tx, promise, r, &reminders[i]); err != nil {
+					return err
+				}
+				if err := tx.Reminders().Create(&reminders[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
 		if err != nil {
 			return err
 		}
-		if promiseUserID != userID {
-			return fiber.NewError(fiber.StatusForbidden, "Not authorized")
+
+		if len(req.Reminders) > 0 {
+			return c.Status(fiber.StatusCreated).JSON(reminders)
 		}
-		if !dueDate.Valid {
-			return fiber.NewError(fiber.StatusBadRequest, "Promise has no due date")
+		return c.Status(fiber.StatusCreated).JSON(reminders[0])
+	}
+}
+
+// UpdateReminderHandler changes when one of the user's reminders goes off.
+// The reminder goes off again even if it was already sent, unless the
+// promise is resolved or trashed.
+func UpdateReminderHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		reminderID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid reminder ID")
 		}
 
-		// Calculate remind_at time
-		remindAt := dueDate.Time.Add(time.Duration(-req.OffsetMinutes) * time.Minute)
+		var req models.ReminderRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
 
-		// Insert reminder
-		result, err := db.Exec(
-			`INSERT INTO reminders (promise_id, user_id, remind_at, offset_minutes) 
-			VALUES (?, ?, ?, ?)`,
-			promiseID, userID, remindAt, req.OffsetMinutes,
-		)
+		reminder, err := st.Reminders().Get(reminderID, userID)
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Reminder not found")
+		}
 		if err != nil {
 			return err
 		}
-
-		reminderID, _ := result.LastInsertId()
-
-		reminder := models.Reminder{
-			ID:            int(reminderID),
-			PromiseID:     promiseID,
-			UserID:        userID,
-			RemindAt:      remindAt,
-			OffsetMinutes: req.OffsetMinutes,
-			IsSent:        false,
+		promise, err := getPromiseFor(st, reminder.PromiseID, userID, models.RoleEditor)
+		if err != nil {
+			return err
+		}
+		if err := applyReminderRequest(st, promise, req, reminder); err != nil {
+			return err
+		}
+		reminder.Status = reminderStatus(promise)
+		if err := st.Reminders().Update(reminder); err != nil {
+			return err
 		}
 
-		return c.Status(fiber.StatusCreated).JSON(reminder)
+		return c.JSON(reminder)
 	}
 }
 
-func ListRemindersHandler(db *sql.DB) fiber.Handler {
+// ListRemindersHandler lists the user's reminders with their status and a
+// summary of the promise each is about. Reminders about promises the user
+// can no longer see, such as those in a workspace they left, are left out.
+func ListRemindersHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 
-		rows, err := db.Query(
-			`SELECT id, promise_id, user_id, remind_at, offset_minutes, is_sent, created_at 
-			FROM reminders WHERE user_id = ? ORDER BY remind_at ASC`,
-			userID,
-		)
+		reminders, err := st.Reminders().ListByUser(userID)
 		if err != nil {
 			return err
 		}
-		defer rows.Close()
-
-		reminders := []models.Reminder{}
-		for rows.Next() {
-			var r models.Reminder
-			err := rows.Scan(
-				&r.ID, &r.PromiseID, &r.UserID, &r.RemindAt,
-				&r.OffsetMinutes, &r.IsSent, &r.CreatedAt,
-			)
-			if err != nil {
-				return err
+		// nil summaries mark promises the user can't see
+		summaries := map[int]*models.ReminderPromise{}
+		visible := []models.Reminder{}
+		for _, r := range reminders {
+			summary, ok := summaries[r.PromiseID]
+			if !ok {
+				p, err := st.Promises().Get(r.PromiseID)
+				if err != nil {
+					return err
+				}
+				role, err := promiseRole(st, p, userID)
+				if err != nil {
+					return err
+				}
+				if hasRole(role, models.RoleViewer) {
+					summary = &models.ReminderPromise{
+						Recipient:    p.Recipient,
+						Description:  p.Description,
+						DueDate:      p.DueDate,
+						CurrentState: p.CurrentState,
+						Encrypted:    p.Encrypted,
+						Envelope:     p.Envelope,
+					}
+				}
+				summaries[r.PromiseID] = summary
+			}
+			if summary == nil {
+				continue
 			}
-			reminders = append(reminders, r)
+			r.Promise = summary
+			visible = append(visible, r)
 		}
 
-		return c.JSON(reminders)
+		return c.JSON(visible)
 	}
 }
 
-func DeleteReminderHandler(db *sql.DB) fiber.Handler {
+func DeleteReminderHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 		reminderID, err := strconv.Atoi(c.Params("id"))
@@ -110,19 +317,14 @@ func DeleteReminderHandler(db *sql.DB) fiber.Handler {
 			return fiber.NewError(fiber.StatusBadRequest, "Invalid reminder ID")
 		}
 
-		result, err := db.Exec(
-			"DELETE FROM reminders WHERE id = ? AND user_id = ?",
-			reminderID, userID,
-		)
+		err = st.Reminders().Delete(reminderID, userID)
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Reminder not found")
+		}
 		if err != nil {
 			return err
 		}
 
-		rows, _ := result.RowsAffected()
-		if rows == 0 {
-			return fiber.NewError(fiber.StatusNotFound, "Reminder not found")
-		}
-
 		return c.JSON(fiber.Map{"success": true})
 	}
 }
@@ -0,0 +1,255 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"kept/internal/api"
+	"kept/internal/models"
+)
+
+func TestFlexibleReminders(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "alicereminders")
+	bob := registerUser(t, app, "bobreminders")
+
+	_, body := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the drill"})
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	path := "/api/reminders/promise/" + strconv.Itoa(promise.ID)
+	// Stored times may be rounded to the microsecond
+	near := func(a, b time.Time) bool { return a.Sub(b).Abs() < time.Millisecond }
+
+	at := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	for _, req := range []any{
+		models.CreateReminderRequest{OffsetMinutes: 60},
+		models.CreateReminderRequest{Anchor: "someday"},
+		models.CreateReminderRequest{Anchor: models.AnchorAbsolute},
+		models.CreateReminderRequest{Anchor: models.AnchorCreated, OffsetMinutes: -5},
+		models.CreateReminderRequest{Anchor: models.AnchorCreated, RemindAt: &at},
+		models.CreateReminderRequest{Reminders: make([]models.ReminderRequest, 21)},
+	} {
+		if resp, body := doJSON(t, app, "POST", path, alice.Token, req); resp.StatusCode != 400 {
+			t.Fatalf("Expected status 400 for %+v, got %d: %s", req, resp.StatusCode, body)
+		}
+	}
+
+	// A single reminder comes back as an object
+	resp, body := doJSON(t, app, "POST", path, alice.Token, models.CreateReminderRequest{RemindAt: &at})
+	var absolute models.Reminder
+	json.Unmarshal(body, &absolute)
+	if resp.StatusCode != 201 || absolute.Anchor != models.AnchorAbsolute || !absolute.RemindAt.Equal(at) {
+		t.Fatalf("Expected an absolute reminder, got %d: %s", resp.StatusCode, body)
+	}
+
+	before := time.Now().UTC()
+	resp, body = doJSON(t, app, "POST", path, alice.Token, models.CreateReminderRequest{Reminders: []models.ReminderRequest{
+		{Anchor: models.AnchorCreated, OffsetMinutes: 30},
+		{Anchor: models.AnchorStateChange, OffsetMinutes: 24 * 60},
+	}})
+	var reminders []models.Reminder
+	json.Unmarshal(body, &reminders)
+	if resp.StatusCode != 201 || len(reminders) != 2 {
+		t.Fatalf("Expected two reminders, got %d: %s", resp.StatusCode, body)
+	}
+	if !near(reminders[0].RemindAt, promise.CreatedAt.Add(30*time.Minute)) {
+		t.Fatalf("Expected the reminder 30 minutes after creation, got %s", body)
+	}
+	afterChange := reminders[1]
+	if afterChange.RemindAt.Before(promise.CreatedAt.Add(24*time.Hour)) || afterChange.RemindAt.After(before.Add(24*time.Hour)) {
+		t.Fatalf("Expected the reminder a day after the promise was made, got %s", body)
+	}
+
+	// Changing the state moves reminders anchored to it
+	time.Sleep(10 * time.Millisecond)
+	changed := time.Now().UTC()
+	if resp, _ := doJSONIfMatch(t, app, "PUT", "/api/promises/"+strconv.Itoa(promise.ID)+"/state", alice.Token, "*", models.UpdatePromiseStateRequest{State: "broken"}); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	_, body = doJSON(t, app, "GET", "/api/reminders/", alice.Token, nil)
+	json.Unmarshal(body, &reminders)
+	for _, r := range reminders {
+		if r.ID == afterChange.ID && r.RemindAt.Before(changed.Add(24*time.Hour)) {
+			t.Fatalf("Expected the reminder a day after the state change, got %s", body)
+		}
+	}
+
+	reminderPath := "/api/reminders/" + strconv.Itoa(absolute.ID)
+	if resp, _ := doJSON(t, app, "PATCH", reminderPath, bob.Token, models.ReminderRequest{Anchor: models.AnchorCreated}); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 for another user's reminder, got %d", resp.StatusCode)
+	}
+	resp, body = doJSON(t, app, "PATCH", reminderPath, alice.Token, models.ReminderRequest{Anchor: models.AnchorCreated, OffsetMinutes: 90})
+	var updated models.Reminder
+	json.Unmarshal(body, &updated)
+	if resp.StatusCode != 200 || updated.Anchor != models.AnchorCreated || updated.OffsetMinutes != 90 || !near(updated.RemindAt, promise.CreatedAt.Add(90*time.Minute)) {
+		t.Fatalf("Expected the reminder to be re-anchored, got %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := doJSON(t, app, "PATCH", reminderPath, alice.Token, models.ReminderRequest{OffsetMinutes: 10}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for a due date reminder without a due date, got %d", resp.StatusCode)
+	}
+}
+
+func TestDueDateMovesReminders(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "aliceduemoves")
+
+	due := time.Now().Add(72 * time.Hour).UTC().Truncate(time.Second)
+	_, body := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the drill", DueDate: &due})
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	path := "/api/promises/" + strconv.Itoa(promise.ID)
+
+	at := due.Add(time.Hour)
+	_, body = doJSON(t, app, "POST", "/api/reminders/promise/"+strconv.Itoa(promise.ID), alice.Token, models.CreateReminderRequest{Reminders: []models.ReminderRequest{
+		{OffsetMinutes: 60},
+		{OffsetMinutes: 24 * 60},
+		{RemindAt: &at},
+	}})
+	var created []models.Reminder
+	json.Unmarshal(body, &created)
+	if len(created) != 3 {
+		t.Fatalf("Expected three reminders, got %s", body)
+	}
+	unsent, sent, absolute := created[0].ID, created[1].ID, created[2].ID
+	if err := st.Reminders().SetStatus(sent, models.ReminderSent); err != nil {
+		t.Fatal(err)
+	}
+	reminders := func() map[int]models.Reminder {
+		t.Helper()
+		list, err := st.Reminders().ListByPromise(promise.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		byID := map[int]models.Reminder{}
+		for _, r := range list {
+			byID[r.ID] = r
+		}
+		return byID
+	}
+
+	// Unsent reminders follow the due date, sent ones stay sent
+	due = due.Add(48 * time.Hour)
+	if resp, body := doJSONIfMatch(t, app, "PATCH", path, alice.Token, "*", models.UpdatePromiseRequest{DueDate: models.Some(due)}); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	byID := reminders()
+	if r := byID[unsent]; r.Status != models.ReminderPending || !r.RemindAt.Equal(due.Add(-time.Hour)) {
+		t.Fatalf("Expected the unsent reminder an hour before the new due date, got %+v", r)
+	}
+	if r := byID[sent]; r.Status != models.ReminderSent || !r.RemindAt.Equal(created[1].RemindAt) {
+		t.Fatalf("Expected the sent reminder to be left alone, got %+v", r)
+	}
+	if r := byID[absolute]; !r.RemindAt.Equal(at) {
+		t.Fatalf("Expected the absolute reminder to be left alone, got %+v", r)
+	}
+
+	// Sent reminders can be re-armed
+	due = due.Add(24 * time.Hour)
+	doJSONIfMatch(t, app, "PATCH", path, alice.Token, "*", models.UpdatePromiseRequest{DueDate: models.Some(due), RearmReminders: true})
+	if r := reminders()[sent]; r.Status != models.ReminderPending || !r.RemindAt.Equal(due.Add(-24*time.Hour)) {
+		t.Fatalf("Expected the sent reminder to be re-armed, got %+v", r)
+	}
+
+	// Task apps move them too
+	req := httptest.NewRequest("GET", "/caldav/aliceduemoves/promises/kept-"+strconv.Itoa(promise.ID)+".ics", nil)
+	req.Header.Set("Authorization", basicAuth("aliceduemoves", "password123"))
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ics strings.Builder
+	if _, err := io.Copy(&ics, resp.Body); err != nil {
+		t.Fatal(err)
+	}
+	due = due.Add(24 * time.Hour)
+	moved := regexp.MustCompile(`DUE:[0-9TZ]+`).ReplaceAllString(ics.String(), "DUE:"+due.Format("20060102T150405Z"))
+	req = httptest.NewRequest("PUT", "/caldav/aliceduemoves/promises/kept-"+strconv.Itoa(promise.ID)+".ics", strings.NewReader(moved))
+	req.Header.Set("Authorization", basicAuth("aliceduemoves", "password123"))
+	if resp, _ := app.Test(req); resp.StatusCode != 204 {
+		t.Fatalf("Expected status 204, got %d", resp.StatusCode)
+	}
+	if r := reminders()[unsent]; !r.RemindAt.Equal(due.Add(-time.Hour)) {
+		t.Fatalf("Expected the reminder to follow the task app, got %+v", r)
+	}
+
+	// Without a due date unsent reminders are dropped
+	st.Reminders().SetStatus(sent, models.ReminderSent)
+	doJSONIfMatch(t, app, "PATCH", path, alice.Token, "*", models.UpdatePromiseRequest{DueDate: models.Optional[time.Time]{Set: true}})
+	byID = reminders()
+	if _, ok := byID[unsent]; ok || len(byID) != 2 {
+		t.Fatalf("Expected only the sent and absolute reminders to be left, got %+v", byID)
+	}
+}
+
+func TestRemindersFollowPromiseState(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "alicesettles")
+
+	_, body := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the drill"})
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	path := "/api/promises/" + strconv.Itoa(promise.ID)
+
+	past, ahead := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	_, body = doJSON(t, app, "POST", "/api/reminders/promise/"+strconv.Itoa(promise.ID), alice.Token, models.CreateReminderRequest{Reminders: []models.ReminderRequest{
+		{RemindAt: &past},
+		{RemindAt: &ahead},
+	}})
+	var created []models.Reminder
+	json.Unmarshal(body, &created)
+	if len(created) != 2 || created[0].Status != models.ReminderPending {
+		t.Fatalf("Expected two pending reminders, got %s", body)
+	}
+	if err := api.ProcessScheduledReminders(st); err != nil {
+		t.Fatal(err)
+	}
+	statuses := func() []string {
+		t.Helper()
+		_, body := doJSON(t, app, "GET", "/api/reminders/", alice.Token, nil)
+		var list []models.Reminder
+		json.Unmarshal(body, &list)
+		var statuses []string
+		for _, r := range list {
+			if r.Promise == nil || r.Promise.Description != "Return the drill" {
+				t.Fatalf("Expected reminders with their promise, got %s", body)
+			}
+			statuses = append(statuses, r.Status+"/"+r.Promise.CurrentState)
+		}
+		return statuses
+	}
+	if got := strings.Join(statuses(), " "); got != "sent/active pending/active" {
+		t.Fatalf("Expected the past reminder to be sent, got %s", got)
+	}
+
+	// Keeping the promise cancels what's left, reopening it restores it
+	doJSONIfMatch(t, app, "PUT", path+"/state", alice.Token, "*", models.UpdatePromiseStateRequest{State: "kept"})
+	if got := strings.Join(statuses(), " "); got != "sent/kept cancelled/kept" {
+		t.Fatalf("Expected the pending reminder to be cancelled, got %s", got)
+	}
+	if resp, body := doJSON(t, app, "PATCH", "/api/reminders/"+strconv.Itoa(created[1].ID), alice.Token, models.ReminderRequest{RemindAt: &ahead}); resp.StatusCode != 200 || !strings.Contains(string(body), `"cancelled"`) {
+		t.Fatalf("Expected the edited reminder to stay cancelled, got %d: %s", resp.StatusCode, body)
+	}
+	doJSONIfMatch(t, app, "PUT", path+"/state", alice.Token, "*", models.UpdatePromiseStateRequest{State: "active"})
+	if got := strings.Join(statuses(), " "); got != "sent/active pending/active" {
+		t.Fatalf("Expected the reminder to be restored, got %s", got)
+	}
+
+	// So do trashing and restoring it
+	doJSONIfMatch(t, app, "DELETE", path, alice.Token, "*", nil)
+	if list, err := st.Reminders().ListByPromise(promise.ID); err != nil || len(list) != 2 || list[1].Status != models.ReminderCancelled {
+		t.Fatalf("Expected the reminder to be cancelled in the trash, got %+v (%v)", list, err)
+	}
+	doJSON(t, app, "POST", "/api/trash/"+strconv.Itoa(promise.ID)+"/restore", alice.Token, nil)
+	if due, err := st.Reminders().ListDue(ahead); err != nil || len(due) != 1 || due[0].ID != created[1].ID {
+		t.Fatalf("Expected the reminder to be due again, got %+v (%v)", due, err)
+	}
+}
@@ -0,0 +1,159 @@
+package api
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Revision actors: what made an edit.
+const (
+	actorUser   = "user"
+	actorCalDAV = "caldav"
+)
+
+// updatePromise saves after, an edited copy of before, and records a
+// revision for every field that changed. A new recipient relinks the
+// promise to a contact unless the edit picked one. actorUserID may be zero
+// for edits nobody in particular made.
+func updatePromise(tx store.Store, before, after *models.Promise, actor string, actorUserID int) error {
+	sameContact := (before.ContactID == nil) == (after.ContactID == nil) &&
+		(before.ContactID == nil || *before.ContactID == *after.ContactID)
+	if after.Recipient != before.Recipient && sameContact {
+		if err := linkContact(tx, after); err != nil {
+			return err
+		}
+	}
+	revisions, err := promiseRevisions(before, after)
+	if err != nil || len(revisions) == 0 {
+		return err
+	}
+	if err := tx.Promises().Update(after); err != nil {
+		return err
+	}
+	for _, rev := range revisions {
+		if rev.Field == "tags" {
+			if err := tx.Tags().SetForPromise(after.ID, actorUserID, tagIDs(after.Tags)); err != nil {
+				return err
+			}
+		}
+		rev.PromiseID = after.ID
+		rev.Actor = actor
+		if actorUserID != 0 {
+			rev.ActorUserID = &actorUserID
+		}
+		if err := tx.Revisions().Create(&rev); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// promiseRevisions lists the editable fields that differ between two
+// versions of a promise.
+func promiseRevisions(before, after *models.Promise) ([]models.Revision, error) {
+	oldEnvelope, err := envelopeValue(before.Envelope)
+	if err != nil {
+		return nil, err
+	}
+	newEnvelope, err := envelopeValue(after.Envelope)
+	if err != nil {
+		return nil, err
+	}
+	fields := []struct {
+		name               string
+		oldValue, newValue *string
+	}{
+		{"recipient", stringValue(before.Recipient), stringValue(after.Recipient)},
+		{"recipient_email", stringValue(before.RecipientEmail), stringValue(after.RecipientEmail)},
+		{"notify_recipient", boolValue(before.NotifyRecipient), boolValue(after.NotifyRecipient)},
+		{"description", stringValue(before.Description), stringValue(after.Description)},
+		{"envelope", oldEnvelope, newEnvelope},
+		{"due_date", timeValue(before.DueDate), timeValue(after.DueDate)},
+		{"reminder_frequency", stringValue(before.ReminderFrequency), stringValue(after.ReminderFrequency)},
+		{"tags", tagsValue(before.Tags), tagsValue(after.Tags)},
+		{"contact_id", intValue(before.ContactID), intValue(after.ContactID)},
+		{"partner_visible", boolValue(before.PartnerVisible), boolValue(after.PartnerVisible)},
+		{"keep_when_done", boolValue(before.KeepWhenDone), boolValue(after.KeepWhenDone)},
+	}
+
+	var revisions []models.Revision
+	for _, f := range fields {
+		if (f.oldValue == nil) == (f.newValue == nil) && (f.oldValue == nil || *f.oldValue == *f.newValue) {
+			continue
+		}
+		revisions = append(revisions, models.Revision{Field: f.name, OldValue: f.oldValue, NewValue: f.newValue})
+	}
+	return revisions, nil
+}
+
+func stringValue(s string) *string {
+	if s == "" {
+		return nil
+	}
+	return &s
+}
+
+func boolValue(b bool) *string {
+	return stringValue(strconv.FormatBool(b))
+}
+
+func intValue(n *int) *string {
+	if n == nil {
+		return nil
+	}
+	return stringValue(strconv.Itoa(*n))
+}
+
+func timeValue(t *time.Time) *string {
+	if t == nil {
+		return nil
+	}
+	return stringValue(t.UTC().Format(time.RFC3339))
+}
+
+// tagsValue lists tag names, comma-separated.
+func tagsValue(tags []models.Tag) *string {
+	names := make([]string, len(tags))
+	for i, t := range tags {
+		names[i] = t.Name
+	}
+	return stringValue(strings.Join(names, ", "))
+}
+
+func envelopeValue(e *models.Envelope) (*string, error) {
+	if e == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	return stringValue(string(data)), nil
+}
+
+// ListRevisionsHandler returns the edit history of a promise, oldest first.
+func ListRevisionsHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		promiseID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
+		}
+
+		if _, err := getPromiseFor(st, promiseID, userID, models.RoleViewer); err != nil {
+			return err
+		}
+		revisions, err := st.Revisions().ListByPromise(promiseID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(revisions)
+	}
+}
@@ -0,0 +1,86 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"kept/internal/models"
+)
+
+func TestPatchPromise(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	auth := registerUser(t, app, "patchuser")
+
+	due := time.Date(2030, 5, 1, 12, 0, 0, 0, time.UTC)
+	create := models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the ladder", DueDate: &due, ReminderFrequency: "weekly"}
+	_, body := doJSON(t, app, "POST", "/api/promises/", auth.Token, create)
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	path := "/api/promises/" + strconv.Itoa(promise.ID)
+
+	// Fields that are left out keep their values
+	resp, body := doJSONIfMatch(t, app, "PATCH", path, auth.Token, "*", models.UpdatePromiseRequest{Description: models.Some("Return the ladder and the drill")})
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	var updated models.Promise
+	json.Unmarshal(body, &updated)
+	if updated.Description != "Return the ladder and the drill" || updated.Recipient != "Sam" ||
+		updated.DueDate == nil || !updated.DueDate.Equal(due) || updated.ReminderFrequency != "weekly" {
+		t.Fatalf("Unexpected promise after patch: %s", body)
+	}
+
+	// null clears the due date and frequency
+	resp, body = doJSONIfMatch(t, app, "PATCH", path, auth.Token, "*", map[string]any{"due_date": nil, "reminder_frequency": nil})
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	_, body = doJSON(t, app, "GET", path, auth.Token, nil)
+	updated = models.Promise{}
+	json.Unmarshal(body, &updated)
+	if updated.DueDate != nil || updated.ReminderFrequency != "" || updated.Recipient != "Sam" {
+		t.Fatalf("Expected due date and frequency to be cleared, got %s", body)
+	}
+
+	for name, req := range map[string]any{
+		"empty recipient":   map[string]any{"recipient": ""},
+		"null description":  map[string]any{"description": nil},
+		"unknown frequency": map[string]any{"reminder_frequency": "hourly"},
+		"envelope":          map[string]any{"envelope": models.Envelope{Alg: "AES-GCM-256", Nonce: "bm9uY2U=", Ciphertext: "Y2lwaGVy"}},
+	} {
+		if resp, _ := doJSONIfMatch(t, app, "PATCH", path, auth.Token, "*", req); resp.StatusCode != 400 {
+			t.Errorf("%s: expected status 400, got %d", name, resp.StatusCode)
+		}
+	}
+
+	// A request without changes records nothing
+	doJSONIfMatch(t, app, "PATCH", path, auth.Token, "*", map[string]any{"recipient": "Sam"})
+
+	resp, body = doJSON(t, app, "GET", path+"/revisions", auth.Token, nil)
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	var revisions []models.Revision
+	json.Unmarshal(body, &revisions)
+	if len(revisions) != 3 {
+		t.Fatalf("Expected 3 revisions, got %s", body)
+	}
+	if r := revisions[0]; r.Field != "description" || *r.OldValue != "Return the ladder" ||
+		*r.NewValue != "Return the ladder and the drill" || r.Actor != "user" || *r.ActorUserID != auth.User.ID {
+		t.Fatalf("Unexpected description revision: %+v", r)
+	}
+	if r := revisions[1]; r.Field != "due_date" || *r.OldValue != "2030-05-01T12:00:00Z" || r.NewValue != nil {
+		t.Fatalf("Unexpected due date revision: %+v", r)
+	}
+	if r := revisions[2]; r.Field != "reminder_frequency" || *r.OldValue != "weekly" || r.NewValue != nil {
+		t.Fatalf("Unexpected frequency revision: %+v", r)
+	}
+
+	other := registerUser(t, app, "nosyuser")
+	if resp, _ := doJSON(t, app, "GET", path+"/revisions", other.Token, nil); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 for another user's history, got %d", resp.StatusCode)
+	}
+}
@@ -1,14 +1,21 @@
 package api
 
 import (
-	"database/sql"
+	"kept/internal/backup"
+	"kept/internal/store"
 	"os"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
 )
 
-func SetupRoutes(app *fiber.App, db *sql.DB) {
+// RequestMethods extends Fiber's default methods with the WebDAV verbs used by
+// the CalDAV endpoints. Apps passed to SetupRoutes must be created with it.
+var RequestMethods = append(append([]string{}, fiber.DefaultMethods...), "PROPFIND", "REPORT")
+
+// SetupRoutes registers all routes. backups is nil when the database doesn't
+// support snapshots.
+func SetupRoutes(app *fiber.App, st store.Store, backups *backup.Manager) {
 	api := app.Group("/api")
 
 	// Check if registration is disabled
@@ -24,45 +31,160 @@ func SetupRoutes(app *fiber.App, db *sql.DB) {
 	// Auth routes
 	auth := api.Group("/auth")
 	if !disableRegistration {
-		auth.Post("/register", RegisterHandler(db))
+		auth.Post("/register", RegisterHandler(st))
 	}
-	auth.Post("/login", LoginHandler(db))
-	auth.Post("/refresh", RefreshTokenHandler(db))
-	auth.Post("/logout", LogoutHandler(db))
+	auth.Post("/login", LoginHandler(st))
+	auth.Post("/refresh", RefreshTokenHandler(st))
+	auth.Post("/logout", LogoutHandler(st))
 
 	// VAPID public key endpoint (public - must be before protected routes for proper routing)
 	api.Get("/push/vapid-public-key", VapidPublicKeyHandler())
 
+	// Links emailed to promise recipients (signed, no account needed)
+	api.Get("/recipient/:token", RecipientViewHandler(st))
+	api.Post("/recipient/:token", RecipientRespondHandler(st))
+
+	// Buttons on push notifications (signed, no login needed)
+	api.Post("/push-actions/:action", PushActionHandler(st))
+
+	// Read-only share links (public)
+	api.Get("/shared/:token", SharedPromiseHandler(st))
+
 	// Protected routes
 	protected := api.Group("/", AuthMiddleware())
 
 	// Promise routes
 	promises := protected.Group("/promises")
-	promises.Post("/", CreatePromiseHandler(db))
-	promises.Get("/", ListPromisesHandler(db))
-	promises.Get("/:id", GetPromiseHandler(db))
-	promises.Put("/:id/state", UpdatePromiseStateHandler(db))
-	promises.Put("/:id", UpdatePromiseHandler(db))
-	promises.Delete("/:id", DeletePromiseHandler(db))
+	promises.Post("/", CreatePromiseHandler(st))
+	promises.Post("/parse", ParsePromiseHandler())
+	promises.Get("/", ListPromisesHandler(st))
+	promises.Get("/:id", GetPromiseHandler(st))
+	promises.Put("/:id/state", UpdatePromiseStateHandler(st))
+	promises.Put("/:id", UpdatePromiseHandler(st))
+	promises.Patch("/:id", UpdatePromiseHandler(st))
+	promises.Get("/:id/revisions", ListRevisionsHandler(st))
+	promises.Post("/:id/shares", CreateShareLinkHandler(st))
+	promises.Get("/:id/shares", ListShareLinksHandler(st))
+	promises.Delete("/:id/shares/:shareId", RevokeShareLinkHandler(st))
+	promises.Post("/:id/nudge", NudgePromiseHandler(st))
+	promises.Get("/:id/items", ListItemsHandler(st))
+	promises.Post("/:id/items", CreateItemHandler(st))
+	promises.Patch("/:id/items/:itemId", UpdateItemHandler(st))
+	promises.Delete("/:id/items/:itemId", DeleteItemHandler(st))
+	promises.Delete("/:id", DeletePromiseHandler(st))
+
+	// Tag routes
+	tags := protected.Group("/tags")
+	tags.Get("/", ListTagsHandler(st))
+	tags.Post("/", CreateTagHandler(st))
+	tags.Patch("/:id", UpdateTagHandler(st))
+	tags.Delete("/:id", DeleteTagHandler(st))
+
+	// Contact routes
+	contacts := protected.Group("/contacts")
+	contacts.Get("/", ListContactsHandler(st))
+	contacts.Post("/", CreateContactHandler(st))
+	contacts.Get("/:id", GetContactHandler(st))
+	contacts.Patch("/:id", UpdateContactHandler(st))
+	contacts.Delete("/:id", DeleteContactHandler(st))
+	contacts.Post("/:id/merge", MergeContactHandler(st))
+	contacts.Get("/:id/history", ContactHistoryHandler(st))
+	contacts.Get("/:id/stats", ContactStatsHandler(st))
+
+	// Template routes
+	templates := protected.Group("/templates")
+	templates.Get("/", ListTemplatesHandler(st))
+	templates.Post("/", CreateTemplateHandler(st))
+	templates.Get("/:id", GetTemplateHandler(st))
+	templates.Patch("/:id", UpdateTemplateHandler(st))
+	templates.Delete("/:id", DeleteTemplateHandler(st))
+	templates.Post("/:id/promises", CreateFromTemplateHandler(st))
+
+	// Search over promises and reflection notes
+	protected.Get("/search", SearchHandler(st))
+
+	// Workspace routes
+	workspaces := protected.Group("/workspaces")
+	workspaces.Get("/", ListWorkspacesHandler(st))
+	workspaces.Post("/", CreateWorkspaceHandler(st))
+	workspaces.Get("/:id", GetWorkspaceHandler(st))
+	workspaces.Patch("/:id", UpdateWorkspaceHandler(st))
+	workspaces.Delete("/:id", DeleteWorkspaceHandler(st))
+	workspaces.Patch("/:id/members/:userId", UpdateMemberHandler(st))
+	workspaces.Delete("/:id/members/:userId", RemoveMemberHandler(st))
+	workspaces.Post("/:id/invitations", InviteMemberHandler(st))
+	workspaces.Get("/:id/invitations", ListWorkspaceInvitationsHandler(st))
+	workspaces.Delete("/:id/invitations/:invitationId", CancelInvitationHandler(st))
+
+	// Invitations to the user's workspaces
+	invitations := protected.Group("/invitations")
+	invitations.Get("/", ListMyInvitationsHandler(st))
+	invitations.Post("/:id/accept", AcceptInvitationHandler(st))
+	invitations.Post("/:id/decline", DeclineInvitationHandler(st))
+
+	// Recurring promise routes
+	series := protected.Group("/series")
+	series.Get("/", ListSeriesHandler(st))
+	series.Get("/:id", GetSeriesHandler(st))
+	series.Patch("/:id", UpdateSeriesHandler(st))
+	series.Post("/:id/stop", StopSeriesHandler(st))
+
+	// Accountability partner routes
+	partners := protected.Group("/partners")
+	partners.Get("/", ListPartnershipsHandler(st))
+	partners.Post("/", InvitePartnerHandler(st))
+	partners.Post("/:id/accept", AcceptPartnershipHandler(st))
+	partners.Delete("/:id", DeletePartnershipHandler(st))
+	partners.Get("/:id/promises", PartnerPromisesHandler(st))
+
+	// Trash routes
+	trash := protected.Group("/trash")
+	trash.Get("/", ListTrashHandler(st))
+	trash.Post("/:id/restore", RestorePromiseHandler(st))
+	trash.Delete("/:id", DeleteTrashedPromiseHandler(st))
 
 	// Timeline route
-	protected.Get("/timeline", GetTimelineHandler(db))
+	protected.Get("/timeline", GetTimelineHandler(st))
 
 	// Reminder routes
 	reminders := protected.Group("/reminders")
-	reminders.Post("/promise/:promiseId", CreateReminderHandler(db))
-	reminders.Get("/", ListRemindersHandler(db))
-	reminders.Delete("/:id", DeleteReminderHandler(db))
+	reminders.Post("/promise/:promiseId", CreateReminderHandler(st))
+	reminders.Get("/", ListRemindersHandler(st))
+	reminders.Patch("/:id", UpdateReminderHandler(st))
+	reminders.Delete("/:id", DeleteReminderHandler(st))
 
 	// Push subscription routes
 	push := protected.Group("/push")
-	push.Post("/subscribe", SubscribePushHandler(db))
-	push.Delete("/unsubscribe", UnsubscribePushHandler(db))
+	push.Post("/subscribe", SubscribePushHandler(st))
+	push.Delete("/unsubscribe", UnsubscribePushHandler(st))
 
 	// User profile routes
 	user := protected.Group("/user")
-	user.Get("/profile", GetUserProfileHandler(db))
-	user.Put("/email", UpdateUserEmailHandler(db))
+	user.Get("/profile", GetUserProfileHandler(st))
+	user.Put("/email", UpdateUserEmailHandler(st))
+	user.Get("/e2e", GetE2EKeyCheckHandler(st))
+	user.Put("/e2e", SetE2EKeyCheckHandler(st))
+	user.Delete("/e2e", DeleteE2EKeyCheckHandler(st))
+	user.Get("/app-passwords", ListAppPasswordsHandler(st))
+	user.Post("/app-passwords", CreateAppPasswordHandler(st))
+	user.Delete("/app-passwords/:id", DeleteAppPasswordHandler(st))
+
+	// Admin routes (users listed in ADMIN_USERNAMES)
+	admin := protected.Group("/admin", AdminMiddleware())
+	admin.Get("/backups", ListBackupsHandler(backups))
+	admin.Post("/backups", CreateBackupHandler(backups))
+	admin.Post("/backups/:name/restore", RestoreBackupHandler(backups))
+
+	// CalDAV: exposes promises as VTODOs for task apps (HTTP Basic auth)
+	app.Get("/.well-known/caldav", CalDAVWellKnownHandler())
+	app.Add("PROPFIND", "/.well-known/caldav", CalDAVWellKnownHandler())
+	dav := app.Group("/caldav", CalDAVAuthMiddleware(st))
+	dav.Options("/*", CalDAVOptionsHandler())
+	dav.Add("PROPFIND", "/*", CalDAVPropfindHandler(st))
+	dav.Add("REPORT", "/*", CalDAVReportHandler(st))
+	dav.Get("/*", CalDAVGetHandler(st))
+	dav.Put("/*", CalDAVPutHandler(st))
+	dav.Delete("/*", CalDAVDeleteHandler(st))
 
 	// Health check
 	app.Get("/health", func(c *fiber.Ctx) error {
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultSearchLimit = 50
+	maxSearchLimit     = 100
+)
+
+// searchDate reads a from or to query parameter, either an RFC 3339 time or
+// a date. A to date covers the whole day in UTC.
+func searchDate(c *fiber.Ctx, name string) (*time.Time, error) {
+	value := c.Query(name)
+	if value == "" {
+		return nil, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return &t, nil
+	}
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid "+name+" date")
+	}
+	if name == "to" {
+		t = t.AddDate(0, 0, 1).Add(-time.Nanosecond)
+	}
+	return &t, nil
+}
+
+// SearchHandler searches the user's promises and reflection notes, or those
+// of a workspace, and returns the hits best first with their promises.
+func SearchHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		q := store.SearchQuery{Text: strings.TrimSpace(c.Query("q")), State: c.Query("state"), Limit: defaultSearchLimit}
+		if q.Text == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Search text is required")
+		}
+		if q.State != "" && !validPromiseStates[q.State] {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid state filter")
+		}
+		var err error
+		if q.From, err = searchDate(c, "from"); err != nil {
+			return err
+		}
+		if q.To, err = searchDate(c, "to"); err != nil {
+			return err
+		}
+		if limit := c.Query("limit"); limit != "" {
+			q.Limit, err = strconv.Atoi(limit)
+			if err != nil || q.Limit < 1 || q.Limit > maxSearchLimit {
+				return fiber.NewError(fiber.StatusBadRequest, "Limit must be between 1 and 100")
+			}
+		}
+		var filter store.PromiseFilter
+		if err := workspaceFilter(c, st, userID, &filter); err != nil {
+			return err
+		}
+		q.WorkspaceID = filter.WorkspaceID
+
+		hits, err := st.Search().Search(userID, q)
+		if err != nil {
+			return err
+		}
+		promises := map[int]*models.Promise{}
+		var list []*models.Promise
+		for i := range hits {
+			p, ok := promises[hits[i].PromiseID]
+			if !ok {
+				if p, err = st.Promises().Get(hits[i].PromiseID); err != nil {
+					return err
+				}
+				promises[p.ID] = p
+				list = append(list, p)
+			}
+			hits[i].Promise = p
+		}
+		if err := attachTags(st, userID, list...); err != nil {
+			return err
+		}
+		return c.JSON(hits)
+	}
+}
@@ -0,0 +1,75 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"kept/internal/models"
+)
+
+func TestSearch(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "alicesearch")
+	bob := registerUser(t, app, "bobsearch")
+
+	resp, body := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the drill"})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var drill models.Promise
+	json.Unmarshal(body, &drill)
+	doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Mom", Description: "Call on Sunday"})
+	doJSON(t, app, "POST", "/api/promises/", bob.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Borrow the drill"})
+	resp, body = doJSONIfMatch(t, app, "PUT", "/api/promises/"+strconv.Itoa(drill.ID)+"/state", alice.Token, "*",
+		models.UpdatePromiseStateRequest{State: "kept", ReflectionNote: "Sam needed the drill bits too"})
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+
+	for _, path := range []string{"/api/search", "/api/search?q=drill&state=lost", "/api/search?q=drill&from=yesterday", "/api/search?q=drill&limit=500"} {
+		if resp, _ := doJSON(t, app, "GET", path, alice.Token, nil); resp.StatusCode != 400 {
+			t.Fatalf("Expected status 400 for %s, got %d", path, resp.StatusCode)
+		}
+	}
+
+	var hits []models.SearchHit
+	resp, body = doJSON(t, app, "GET", "/api/search?q=dril", alice.Token, nil)
+	json.Unmarshal(body, &hits)
+	if resp.StatusCode != 200 || len(hits) != 2 {
+		t.Fatalf("Expected the promise and its note, got %d: %s", resp.StatusCode, body)
+	}
+	for _, hit := range hits {
+		if hit.PromiseID != drill.ID || hit.Promise == nil || hit.Promise.Description != "Return the drill" || hit.Promise.Tags == nil {
+			t.Fatalf("Expected hits with their promise, got %s", body)
+		}
+	}
+
+	today := time.Now().UTC().Format(time.DateOnly)
+	resp, body = doJSON(t, app, "GET", "/api/search?q=drill+bits&state=kept&from="+today+"&to="+today, alice.Token, nil)
+	json.Unmarshal(body, &hits)
+	if resp.StatusCode != 200 || len(hits) != 1 || hits[0].Kind != models.SearchHitNote || hits[0].Snippet != "Sam needed the <mark>drill</mark> <mark>bits</mark> too" {
+		t.Fatalf("Expected the note, got %d: %s", resp.StatusCode, body)
+	}
+	yesterday := time.Now().UTC().AddDate(0, 0, -1).Format(time.DateOnly)
+	_, body = doJSON(t, app, "GET", "/api/search?q=drill&to="+yesterday, alice.Token, nil)
+	json.Unmarshal(body, &hits)
+	if len(hits) != 0 {
+		t.Fatalf("Expected no hits before today, got %s", body)
+	}
+
+	// Other users' promises and workspaces stay out of reach
+	_, body = doJSON(t, app, "GET", "/api/search?q=borrow", alice.Token, nil)
+	json.Unmarshal(body, &hits)
+	if len(hits) != 0 {
+		t.Fatalf("Expected another user's promise not to match, got %s", body)
+	}
+	resp, body = doJSON(t, app, "POST", "/api/workspaces/", bob.Token, models.WorkspaceRequest{Name: "Shed"})
+	var ws models.Workspace
+	json.Unmarshal(body, &ws)
+	if resp, _ := doJSON(t, app, "GET", "/api/search?q=drill&workspace="+strconv.Itoa(ws.ID), alice.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 for another user's workspace, got %d", resp.StatusCode)
+	}
+}
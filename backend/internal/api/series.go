@@ -0,0 +1,395 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// maxSeriesInterval caps how many periods apart instances can be.
+const maxSeriesInterval = 365
+
+var validSeriesFrequencies = map[string]bool{
+	models.FrequencyDaily:   true,
+	models.FrequencyWeekly:  true,
+	models.FrequencyMonthly: true,
+	models.FrequencyYearly:  true,
+}
+
+// checkSeriesRule validates a series' frequency, interval and end.
+func checkSeriesRule(s *models.Series) error {
+	if !validSeriesFrequencies[s.Frequency] {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid frequency")
+	}
+	if s.Interval < 1 || s.Interval > maxSeriesInterval {
+		return fiber.NewError(fiber.StatusBadRequest, "Interval must be between 1 and 365")
+	}
+	if s.EndsAt != nil && s.EndsAt.Before(s.StartsAt) {
+		return fiber.NewError(fiber.StatusBadRequest, "A series can't end before it starts")
+	}
+	return nil
+}
+
+// newSeries returns the series a promise due on dueDate starts with
+// recurrence.
+func newSeries(rec *models.Recurrence, dueDate *time.Time) (*models.Series, error) {
+	if dueDate == nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Recurring promises need a due date")
+	}
+	s := &models.Series{Frequency: rec.Frequency, Interval: rec.Interval, StartsAt: *dueDate, EndsAt: rec.EndsAt}
+	if s.Interval == 0 {
+		s.Interval = 1
+	}
+	return s, checkSeriesRule(s)
+}
+
+// addMonths adds n months to t, keeping the day of the month where the
+// target month is long enough and using its last day otherwise.
+func addMonths(t time.Time, n int) time.Time {
+	year, month, day := t.Date()
+	first := time.Date(year, month+time.Month(n), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(day, last)-1)
+}
+
+// occurrence returns when the k-th instance of a series is due, counting the
+// first as 0. Occurrences are counted from StartsAt rather than from each
+// other, so monthly series started on the 31st don't drift.
+func occurrence(s *models.Series, k int) time.Time {
+	n := k * s.Interval
+	switch s.Frequency {
+	case models.FrequencyDaily:
+		return s.StartsAt.AddDate(0, 0, n)
+	case models.FrequencyWeekly:
+		return s.StartsAt.AddDate(0, 0, 7*n)
+	case models.FrequencyMonthly:
+		return addMonths(s.StartsAt, n)
+	default:
+		return addMonths(s.StartsAt, 12*n)
+	}
+}
+
+// nextOccurrence returns the first occurrence of a series after the given
+// time, skipping the first instance.
+func nextOccurrence(s *models.Series, after time.Time) time.Time {
+	period := map[string]time.Duration{
+		models.FrequencyDaily:   24 * time.Hour,
+		models.FrequencyWeekly:  7 * 24 * time.Hour,
+		models.FrequencyMonthly: 28 * 24 * time.Hour,
+		models.FrequencyYearly:  365 * 24 * time.Hour,
+	}[s.Frequency] * time.Duration(s.Interval)
+
+	// Start from an estimate, so long-running series don't walk every
+	// occurrence
+	k := max(1, int(after.Sub(s.StartsAt)/period)-1)
+	for k > 1 && occurrence(s, k).After(after) {
+		k--
+	}
+	for !occurrence(s, k).After(after) {
+		k++
+	}
+	return occurrence(s, k)
+}
+
+// getSeriesFor loads the series in the id route parameter that userID has
+// at least the given role on, the way getPromiseFor does for promises.
+// Series the user can't see at all are reported as missing.
+func getSeriesFor(c *fiber.Ctx, st store.Store, userID int, role string) (*models.Series, error) {
+	id, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid series ID")
+	}
+	series, err := st.Series().Get(id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Series not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	has, err := ownerRole(st, series.UserID, series.WorkspaceID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if has == "" {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Series not found")
+	}
+	if !hasRole(has, role) {
+		return nil, fiber.NewError(fiber.StatusForbidden, "Not authorized")
+	}
+	return series, nil
+}
+
+// seriesStats summarizes a series' instances in the order they fell due.
+func seriesStats(instances []models.Promise) *models.SeriesStats {
+	sorted := append([]models.Promise(nil), instances...)
+	dueOf := func(p models.Promise) time.Time {
+		if p.DueDate != nil {
+			return *p.DueDate
+		}
+		return p.CreatedAt
+	}
+	sort.SliceStable(sorted, func(i, j int) bool { return dueOf(sorted[i]).Before(dueOf(sorted[j])) })
+
+	stats := &models.SeriesStats{Counts: map[string]int{}}
+	for _, p := range sorted {
+		stats.Counts[p.CurrentState]++
+		stats.Total++
+		switch p.CurrentState {
+		case "kept":
+			stats.Streak++
+			stats.LongestStreak = max(stats.LongestStreak, stats.Streak)
+		case "broken":
+			stats.Streak = 0
+		}
+	}
+	if resolved := stats.Counts["kept"] + stats.Counts["broken"]; resolved > 0 {
+		rate := float64(stats.Counts["kept"]) / float64(resolved)
+		stats.HitRate = &rate
+	}
+	return stats
+}
+
+// spawnInstance creates the next instance of a series from its current one,
+// due on dueDate. It copies the content, settings and owner's tags, the
+// checklist with its due dates moved along, and the reminders.
+func spawnInstance(tx store.Store, series *models.Series, from *models.Promise, dueDate time.Time) (*models.Promise, error) {
+	next := &models.Promise{
+		UserID:            from.UserID,
+		WorkspaceID:       from.WorkspaceID,
+		SeriesID:          &series.ID,
+		Recipient:         from.Recipient,
+		RecipientEmail:    from.RecipientEmail,
+		NotifyRecipient:   from.NotifyRecipient,
+		Description:       from.Description,
+		DueDate:           &dueDate,
+		ReminderFrequency: from.ReminderFrequency,
+		Encrypted:         from.Encrypted,
+		Envelope:          from.Envelope,
+		ContactID:         from.ContactID,
+		PartnerVisible:    from.PartnerVisible,
+		KeepWhenDone:      from.KeepWhenDone,
+	}
+	if err := tx.Promises().Create(next); err != nil {
+		return nil, err
+	}
+	if err := tx.Events().Create(&models.Event{PromiseID: next.ID, State: "active"}); err != nil {
+		return nil, err
+	}
+
+	assignments, err := tx.Tags().ListAssignments(from.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if err := tx.Tags().SetForPromise(next.ID, from.UserID, assignments[from.ID]); err != nil {
+		return nil, err
+	}
+
+	var shift time.Duration
+	if from.DueDate != nil {
+		shift = dueDate.Sub(*from.DueDate)
+	}
+	items, err := tx.Items().ListByPromise(from.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, it := range items {
+		item := models.PromiseItem{PromiseID: next.ID, Title: it.Title}
+		if it.DueDate != nil {
+			due := it.DueDate.Add(shift)
+			item.DueDate = &due
+		}
+		if err := tx.Items().Create(&item); err != nil {
+			return nil, err
+		}
+	}
+
+	reminders, err := tx.Reminders().ListByPromise(from.ID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range reminders {
+		reminder := models.Reminder{PromiseID: next.ID, UserID: r.UserID, Anchor: r.Anchor, OffsetMinutes: r.OffsetMinutes}
+		if r.Anchor == models.AnchorAbsolute {
+			reminder.RemindAt = r.RemindAt.Add(shift)
+		} else if reminder.RemindAt, err = reminderTime(next, r.Anchor, r.OffsetMinutes, next.CreatedAt); err != nil {
+			return nil, err
+		}
+		if err := tx.Reminders().Create(&reminder); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Series().SetCurrent(series.ID, next.ID); err != nil {
+		return nil, err
+	}
+	return next, nil
+}
+
+// SpawnSeriesInstances creates the next instance of every running series
+// whose current instance was kept, broken, trashed or fell due. Instances
+// are due on the first occurrence after both now and the current instance's
+// due date, so missed periods are skipped. Series that run past their end
+// are stopped instead.
+func SpawnSeriesInstances(st store.Store) error {
+	now := time.Now()
+	due, err := st.Series().ListDue(now)
+	if err != nil {
+		return err
+	}
+
+	spawned := 0
+	for _, from := range due {
+		var created *models.Promise
+		err := st.InTx(func(tx store.Store) error {
+			series, err := tx.Series().Get(*from.SeriesID)
+			if err != nil {
+				return err
+			}
+			if series.CurrentPromiseID == nil || *series.CurrentPromiseID != from.ID || series.StoppedAt != nil {
+				return nil
+			}
+			after := now
+			if from.DueDate != nil && from.DueDate.After(after) {
+				after = *from.DueDate
+			}
+			dueDate := nextOccurrence(series, after)
+			if series.EndsAt != nil && dueDate.After(*series.EndsAt) {
+				return tx.Series().Stop(series.ID)
+			}
+			created, err = spawnInstance(tx, series, &from, dueDate)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to continue series %d: %v", *from.SeriesID, err)
+			continue
+		}
+		if created != nil {
+			spawned++
+			notifyRecipient(st, *created, recipientEmailMade)
+		}
+	}
+	if spawned > 0 {
+		log.Printf("Created %d recurring promise instances", spawned)
+	}
+	return nil
+}
+
+// ListSeriesHandler returns the user's personal series, or those of the
+// workspace query parameter.
+func ListSeriesHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var filter store.PromiseFilter
+		if err := workspaceFilter(c, st, userID, &filter); err != nil {
+			return err
+		}
+		series, err := st.Series().ListByUser(userID, filter.WorkspaceID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(series)
+	}
+}
+
+// GetSeriesHandler returns a series with its instances, newest first, and
+// its streak and hit rate.
+func GetSeriesHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		series, err := getSeriesFor(c, st, userID, models.RoleViewer)
+		if err != nil {
+			return err
+		}
+		instances, err := st.Promises().ListByUser(userID, store.PromiseFilter{SeriesID: series.ID})
+		if err != nil {
+			return err
+		}
+		if err := attachTagsToList(st, userID, instances); err != nil {
+			return err
+		}
+		sort.SliceStable(instances, func(i, j int) bool { return instances[i].ID > instances[j].ID })
+		series.Instances = instances
+		series.Stats = seriesStats(instances)
+		return c.JSON(series)
+	}
+}
+
+// UpdateSeriesHandler changes a series' rule. A new frequency or interval
+// counts from the current instance's due date, so it stays in place.
+func UpdateSeriesHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.SeriesRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		series, err := getSeriesFor(c, st, userID, models.RoleEditor)
+		if err != nil {
+			return err
+		}
+
+		if req.Frequency.Set || req.Interval.Set {
+			if req.Frequency.Set {
+				series.Frequency = ""
+				if req.Frequency.Value != nil {
+					series.Frequency = *req.Frequency.Value
+				}
+			}
+			if req.Interval.Set {
+				series.Interval = 1
+				if req.Interval.Value != nil {
+					series.Interval = *req.Interval.Value
+				}
+			}
+			if series.CurrentPromiseID != nil {
+				current, err := st.Promises().Get(*series.CurrentPromiseID)
+				if err != nil && !errors.Is(err, store.ErrNotFound) {
+					return err
+				}
+				if err == nil && current.DueDate != nil {
+					series.StartsAt = *current.DueDate
+				}
+			}
+		}
+		if req.EndsAt.Set {
+			series.EndsAt = req.EndsAt.Value
+		}
+		if err := checkSeriesRule(series); err != nil {
+			return err
+		}
+		if err := st.Series().Update(series); err != nil {
+			return err
+		}
+		return c.JSON(series)
+	}
+}
+
+// StopSeriesHandler ends a series. Its instances and stats stay.
+func StopSeriesHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		series, err := getSeriesFor(c, st, userID, models.RoleEditor)
+		if err != nil {
+			return err
+		}
+		err = st.Series().Stop(series.ID)
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusConflict, "Series already stopped")
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
@@ -0,0 +1,118 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"kept/internal/api"
+	"kept/internal/models"
+)
+
+func TestRecurringPromises(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "aliceseries")
+	bob := registerUser(t, app, "bobseries")
+
+	start := time.Now().UTC().Truncate(time.Second).Add(-10 * 24 * time.Hour)
+	if resp, _ := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Grandma", Description: "Call", Recurrence: &models.Recurrence{Frequency: models.FrequencyWeekly}}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 without a due date, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Grandma", Description: "Call", DueDate: &start, Recurrence: &models.Recurrence{Frequency: "hourly"}}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for an unknown frequency, got %d", resp.StatusCode)
+	}
+	resp, body := doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Grandma", Description: "Call", DueDate: &start, Recurrence: &models.Recurrence{Frequency: models.FrequencyWeekly}})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var first models.Promise
+	json.Unmarshal(body, &first)
+	if first.SeriesID == nil {
+		t.Fatalf("Expected the promise to start a series, got %s", body)
+	}
+	seriesPath := "/api/series/" + strconv.Itoa(*first.SeriesID)
+	doJSON(t, app, "POST", "/api/reminders/promise/"+strconv.Itoa(first.ID), alice.Token, models.CreateReminderRequest{OffsetMinutes: 60})
+
+	// The first instance is overdue, so the next one skips the missed week.
+	// Missed instances aren't auto-kept, which would count them as hits.
+	if err := api.AutoKeepOverduePromises(st); err != nil {
+		t.Fatal(err)
+	}
+	if err := api.SpawnSeriesInstances(st); err != nil {
+		t.Fatal(err)
+	}
+	var series models.Series
+	_, body = doJSON(t, app, "GET", seriesPath, alice.Token, nil)
+	json.Unmarshal(body, &series)
+	if len(series.Instances) != 2 || *series.CurrentPromiseID != series.Instances[0].ID || series.Instances[1].CurrentState != "active" {
+		t.Fatalf("Expected a second instance and the missed one still active, got %s", body)
+	}
+	second := series.Instances[0]
+	if want := start.Add(14 * 24 * time.Hour); !second.DueDate.Equal(want) {
+		t.Fatalf("Expected the next instance due %s, got %s", want, second.DueDate)
+	}
+	var reminders []models.Reminder
+	_, body = doJSON(t, app, "GET", "/api/reminders/", alice.Token, nil)
+	json.Unmarshal(body, &reminders)
+	if len(reminders) != 2 || reminders[1].PromiseID != second.ID || reminders[1].OffsetMinutes != 60 {
+		t.Fatalf("Expected the reminder to be copied, got %s", body)
+	}
+
+	// Nothing happens until the current instance is resolved
+	if err := api.SpawnSeriesInstances(st); err != nil {
+		t.Fatal(err)
+	}
+	for id, state := range map[int]string{first.ID: "broken", second.ID: "kept"} {
+		if resp, body := doJSONIfMatch(t, app, "PUT", "/api/promises/"+strconv.Itoa(id)+"/state", alice.Token, "*", models.UpdatePromiseStateRequest{State: state}); resp.StatusCode != 200 {
+			t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+		}
+	}
+	if err := api.SpawnSeriesInstances(st); err != nil {
+		t.Fatal(err)
+	}
+	_, body = doJSON(t, app, "GET", seriesPath, alice.Token, nil)
+	json.Unmarshal(body, &series)
+	if len(series.Instances) != 3 || !series.Instances[0].DueDate.Equal(start.Add(21*24*time.Hour)) {
+		t.Fatalf("Expected a third instance a week later, got %s", body)
+	}
+	stats := series.Stats
+	if stats.Total != 3 || stats.Streak != 1 || stats.LongestStreak != 1 || stats.HitRate == nil || *stats.HitRate != 0.5 {
+		t.Fatalf("Unexpected stats: %s", body)
+	}
+
+	if resp, _ := doJSON(t, app, "GET", seriesPath, bob.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 for another user, got %d", resp.StatusCode)
+	}
+	if resp, body := doJSON(t, app, "PATCH", seriesPath, alice.Token, map[string]any{"interval": 2}); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := doJSON(t, app, "POST", seriesPath+"/stop", alice.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "POST", seriesPath+"/stop", alice.Token, nil); resp.StatusCode != 409 {
+		t.Fatalf("Expected status 409 stopping twice, got %d", resp.StatusCode)
+	}
+
+	// Series stop on their own once the next occurrence is past their end
+	due := time.Date(2025, 1, 31, 9, 0, 0, 0, time.UTC)
+	ends := time.Date(2025, 3, 1, 0, 0, 0, 0, time.UTC)
+	_, body = doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Landlord", Description: "Pay rent", DueDate: &due, Recurrence: &models.Recurrence{Frequency: models.FrequencyMonthly, EndsAt: &ends}})
+	var rent models.Promise
+	json.Unmarshal(body, &rent)
+	if err := api.SpawnSeriesInstances(st); err != nil {
+		t.Fatal(err)
+	}
+	_, body = doJSON(t, app, "GET", "/api/series/"+strconv.Itoa(*rent.SeriesID), alice.Token, nil)
+	json.Unmarshal(body, &series)
+	if series.StoppedAt == nil || len(series.Instances) != 1 {
+		t.Fatalf("Expected the series to stop, got %s", body)
+	}
+	var list []models.Series
+	_, body = doJSON(t, app, "GET", "/api/series/", alice.Token, nil)
+	json.Unmarshal(body, &list)
+	if len(list) != 2 {
+		t.Fatalf("Expected both series, got %s", body)
+	}
+}
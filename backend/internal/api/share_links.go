@@ -0,0 +1,264 @@
+package api
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"strconv"
+	"strings"
+	"time"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// redactableFields are the parts of a shared promise a link can hide.
+var redactableFields = map[string]bool{"recipient": true, "due_date": true, "reflection_notes": true, "events": true}
+
+// newShareToken returns an unguessable share link token.
+func newShareToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func shareURL(token string) string {
+	return strings.TrimSuffix(getAppURL(), "/") + "/api/shared/" + token
+}
+
+// CreateShareLinkHandler creates a read-only public link to a promise. The
+// token is only returned now; the server keeps just its hash. Links don't
+// change the promise's version, so this doesn't need If-Match.
+func CreateShareLinkHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		promiseID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
+		}
+
+		var req models.CreateShareLinkRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		promise, err := getPromiseFor(st, promiseID, userID, models.RoleEditor)
+		if err != nil {
+			return err
+		}
+		if promise.Encrypted {
+			return fiber.NewError(fiber.StatusBadRequest, "Encrypted promises can't be shared")
+		}
+		redact := []string{}
+		seen := map[string]bool{}
+		for _, field := range req.Redact {
+			if !redactableFields[field] {
+				return fiber.NewError(fiber.StatusBadRequest, "Unknown field to redact: "+field)
+			}
+			if !seen[field] {
+				seen[field] = true
+				redact = append(redact, field)
+			}
+		}
+		if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+			return fiber.NewError(fiber.StatusBadRequest, "Expiry must be in the future")
+		}
+
+		token, err := newShareToken()
+		if err != nil {
+			return err
+		}
+		link := &models.ShareLink{PromiseID: promiseID, UserID: userID, Redact: redact, ExpiresAt: req.ExpiresAt}
+		if err := st.ShareLinks().Create(link, hashToken(token)); err != nil {
+			return err
+		}
+		link.Token = token
+		link.URL = shareURL(token)
+		return c.Status(fiber.StatusCreated).JSON(link)
+	}
+}
+
+// ListShareLinksHandler returns a promise's share links, including revoked
+// and expired ones.
+func ListShareLinksHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		promiseID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
+		}
+
+		if _, err := getPromiseFor(st, promiseID, userID, models.RoleEditor); err != nil {
+			return err
+		}
+		links, err := st.ShareLinks().ListByPromise(promiseID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(links)
+	}
+}
+
+// RevokeShareLinkHandler revokes a share link. It stops working right away.
+// Anyone who could create a link to the promise can revoke its links,
+// whoever created them.
+func RevokeShareLinkHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		promiseID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
+		}
+		linkID, err := strconv.Atoi(c.Params("shareId"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid share link ID")
+		}
+
+		if _, err := getPromiseFor(st, promiseID, userID, models.RoleEditor); err != nil {
+			return err
+		}
+		err = st.ShareLinks().Revoke(linkID, promiseID)
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Share link not found")
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
+
+// sharedPromise is what a share link shows, minus the redacted fields.
+type sharedPromise struct {
+	From        string        `json:"from"`
+	Recipient   string        `json:"recipient,omitempty"`
+	Description string        `json:"description"`
+	DueDate     *time.Time    `json:"due_date,omitempty"`
+	State       string        `json:"current_state"`
+	CreatedAt   time.Time     `json:"created_at"`
+	Events      []sharedEvent `json:"events,omitempty"`
+}
+
+type sharedEvent struct {
+	State          string    `json:"state"`
+	ReflectionNote string    `json:"reflection_note,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// buildSharedPromise loads what the share link with the given token shows.
+// Revoked and expired links, and links to trashed promises, are not found.
+func buildSharedPromise(st store.Store, token string) (*sharedPromise, error) {
+	invalid := fiber.NewError(fiber.StatusNotFound, "This link is no longer valid")
+	link, err := st.ShareLinks().GetByTokenHash(hashToken(token))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if link.RevokedAt != nil || (link.ExpiresAt != nil && !time.Now().Before(*link.ExpiresAt)) {
+		return nil, invalid
+	}
+	promise, err := st.Promises().Get(link.PromiseID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, invalid
+	}
+	if err != nil {
+		return nil, err
+	}
+	if promise.Encrypted {
+		return nil, invalid
+	}
+	owner, err := st.Users().GetByID(promise.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	redacted := map[string]bool{}
+	for _, field := range link.Redact {
+		redacted[field] = true
+	}
+	shared := &sharedPromise{
+		From:        owner.Username,
+		Description: promise.Description,
+		State:       promise.CurrentState,
+		CreatedAt:   promise.CreatedAt,
+	}
+	if !redacted["recipient"] {
+		shared.Recipient = promise.Recipient
+	}
+	if !redacted["due_date"] {
+		shared.DueDate = promise.DueDate
+	}
+	if !redacted["events"] {
+		events, err := st.Events().ListByPromise(promise.ID)
+		if err != nil {
+			return nil, err
+		}
+		shared.Events = []sharedEvent{}
+		for _, e := range events {
+			event := sharedEvent{State: e.State, CreatedAt: e.CreatedAt}
+			if !redacted["reflection_notes"] {
+				event.ReflectionNote = e.ReflectionNote
+			}
+			shared.Events = append(shared.Events, event)
+		}
+	}
+	return shared, nil
+}
+
+var sharedPageTemplate = template.Must(template.New("shared").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <meta name="viewport" content="width=device-width, initial-scale=1">
+  <meta name="robots" content="noindex">
+  <title>A promise by {{.From}}</title>
+</head>
+<body style="font-family: sans-serif; color: #1f2937; max-width: 560px; margin: 0 auto; padding: 24px;">
+  <p>{{.From}} promised{{if .Recipient}} {{.Recipient}}{{end}}:</p>
+  <h2>{{.Description}}</h2>
+  <p>Status: <strong>{{.State}}</strong>{{if .DueDate}} &middot; due {{.DueDate.Format "January 2, 2006"}}{{end}}</p>
+  {{if .Events}}
+  <h3>Timeline</h3>
+  <ul style="list-style: none; padding: 0;">
+    {{range .Events}}
+    <li style="margin-bottom: 12px;">
+      <strong>{{.State}}</strong> <span style="color: #6b7280;">{{.CreatedAt.Format "Jan 2, 2006 15:04 MST"}}</span>
+      {{if .ReflectionNote}}<div>{{.ReflectionNote}}</div>{{end}}
+    </li>
+    {{end}}
+  </ul>
+  {{end}}
+  <p style="color: #6b7280; font-size: 12px;">Shared from Kept.</p>
+</body>
+</html>
+`))
+
+// SharedPromiseHandler shows the promise behind a share link, as a page or,
+// for API clients, JSON. It needs no account.
+func SharedPromiseHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		shared, err := buildSharedPromise(st, c.Params("token"))
+		if err != nil {
+			return err
+		}
+
+		c.Set(fiber.HeaderCacheControl, "no-store")
+		c.Set("X-Robots-Tag", "noindex")
+		if !wantsHTML(c) {
+			return c.JSON(shared)
+		}
+		var buf bytes.Buffer
+		if err := sharedPageTemplate.Execute(&buf, shared); err != nil {
+			return err
+		}
+		c.Type("html", "utf-8")
+		return c.Send(buf.Bytes())
+	}
+}
@@ -0,0 +1,125 @@
+package api_test
+
+import (
+	"encoding/json"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"kept/internal/models"
+)
+
+func TestShareLinks(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	auth := registerUser(t, app, "sharer")
+	other := registerUser(t, app, "snooper")
+
+	_, body := doJSON(t, app, "POST", "/api/promises/", auth.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the drill"})
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	promisePath := "/api/promises/" + strconv.Itoa(promise.ID)
+	doJSONIfMatch(t, app, "PUT", promisePath+"/state", auth.Token, "*", models.UpdatePromiseStateRequest{State: "kept", ReflectionNote: "Private thoughts"})
+
+	share := func(req models.CreateShareLinkRequest) models.ShareLink {
+		t.Helper()
+		resp, body := doJSON(t, app, "POST", promisePath+"/shares", auth.Token, req)
+		if resp.StatusCode != 201 {
+			t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+		}
+		var link models.ShareLink
+		json.Unmarshal(body, &link)
+		if link.Token == "" || !strings.HasSuffix(link.URL, "/api/shared/"+link.Token) {
+			t.Fatalf("Expected a token and URL, got %s", body)
+		}
+		return link
+	}
+	visit := func(token, accept string) (int, string) {
+		t.Helper()
+		req := httptest.NewRequest("GET", "/api/shared/"+token, nil)
+		req.Header.Set("Accept", accept)
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	full := share(models.CreateShareLinkRequest{})
+	status, body2 := visit(full.Token, "application/json")
+	var shared struct {
+		From      string `json:"from"`
+		Recipient string `json:"recipient"`
+		State     string `json:"current_state"`
+		Events    []models.Event
+	}
+	json.Unmarshal([]byte(body2), &shared)
+	if status != 200 || shared.From != "sharer" || shared.Recipient != "Sam" || shared.State != "kept" ||
+		len(shared.Events) != 2 || shared.Events[1].ReflectionNote != "Private thoughts" {
+		t.Fatalf("Unexpected shared promise: %d %s", status, body2)
+	}
+	if strings.Contains(body2, `"user_id"`) || strings.Contains(body2, `"id"`) {
+		t.Fatalf("Expected no internal IDs in the shared promise, got %s", body2)
+	}
+
+	redacted := share(models.CreateShareLinkRequest{Redact: []string{"reflection_notes", "recipient"}})
+	status, page := visit(redacted.Token, "text/html")
+	if status != 200 || !strings.Contains(page, "Return the drill") || strings.Contains(page, "Private thoughts") || strings.Contains(page, "Sam") {
+		t.Fatalf("Expected a page without the redacted fields, got %d: %s", status, page)
+	}
+
+	if resp, _ := doJSON(t, app, "POST", promisePath+"/shares", auth.Token, models.CreateShareLinkRequest{Redact: []string{"password"}}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for an unknown field, got %d", resp.StatusCode)
+	}
+	past := time.Now().Add(-time.Minute)
+	if resp, _ := doJSON(t, app, "POST", promisePath+"/shares", auth.Token, models.CreateShareLinkRequest{ExpiresAt: &past}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for an expiry in the past, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "POST", promisePath+"/shares", other.Token, models.CreateShareLinkRequest{}); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 sharing another user's promise, got %d", resp.StatusCode)
+	}
+
+	// Links expire
+	soon := time.Now().Add(300 * time.Millisecond)
+	expiring := share(models.CreateShareLinkRequest{ExpiresAt: &soon})
+	if status, _ := visit(expiring.Token, "application/json"); status != 200 {
+		t.Fatalf("Expected status 200 before the link expires, got %d", status)
+	}
+	time.Sleep(time.Until(soon) + 50*time.Millisecond)
+	if status, _ := visit(expiring.Token, "application/json"); status != 404 {
+		t.Fatalf("Expected status 404 for an expired link, got %d", status)
+	}
+
+	// Revoking stops the link but keeps it listed
+	if resp, _ := doJSON(t, app, "DELETE", promisePath+"/shares/"+strconv.Itoa(full.ID), other.Token, nil); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 revoking another user's link, got %d", resp.StatusCode)
+	}
+	_, body = doJSON(t, app, "POST", "/api/promises/", auth.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Another"})
+	var another models.Promise
+	json.Unmarshal(body, &another)
+	if resp, _ := doJSON(t, app, "DELETE", "/api/promises/"+strconv.Itoa(another.ID)+"/shares/"+strconv.Itoa(full.ID), auth.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 revoking a link through another promise, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "DELETE", promisePath+"/shares/"+strconv.Itoa(full.ID), auth.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if status, _ := visit(full.Token, "application/json"); status != 404 {
+		t.Fatalf("Expected status 404 for a revoked link, got %d", status)
+	}
+	_, body = doJSON(t, app, "GET", promisePath+"/shares", auth.Token, nil)
+	var links []models.ShareLink
+	json.Unmarshal(body, &links)
+	if len(links) != 3 || links[2].RevokedAt == nil || links[0].Token != "" {
+		t.Fatalf("Expected 3 links without tokens, the first revoked, got %s", body)
+	}
+
+	// Trashed promises can't be viewed
+	doJSONIfMatch(t, app, "DELETE", promisePath, auth.Token, "*", nil)
+	if status, _ := visit(redacted.Token, "application/json"); status != 404 {
+		t.Fatalf("Expected status 404 for a trashed promise, got %d", status)
+	}
+}
@@ -0,0 +1,249 @@
+package api
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	defaultTagColor  = "#9ca3af"
+	maxTagNameLength = 50
+)
+
+var tagColorRe = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// resolveTags looks up the user's tags with the given IDs, dropping
+// duplicates. Tags that don't exist or belong to someone else are a bad
+// request.
+func resolveTags(st store.Store, userID int, ids []int) ([]models.Tag, error) {
+	resolved := []models.Tag{}
+	if len(ids) == 0 {
+		return resolved, nil
+	}
+	tags, err := st.Tags().ListByUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	byID := map[int]models.Tag{}
+	for _, t := range tags {
+		byID[t.ID] = t
+	}
+	seen := map[int]bool{}
+	for _, id := range ids {
+		t, ok := byID[id]
+		if !ok {
+			return nil, fiber.NewError(fiber.StatusBadRequest, "Unknown tag "+strconv.Itoa(id))
+		}
+		if !seen[id] {
+			seen[id] = true
+			resolved = append(resolved, t)
+		}
+	}
+	sort.Slice(resolved, func(i, j int) bool { return resolved[i].Name < resolved[j].Name })
+	return resolved, nil
+}
+
+func tagIDs(tags []models.Tag) []int {
+	ids := make([]int, len(tags))
+	for i, t := range tags {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+// attachTags fills in the tags of promises owned by userID.
+func attachTags(st store.Store, userID int, promises ...*models.Promise) error {
+	if len(promises) == 0 {
+		return nil
+	}
+	tags, err := st.Tags().ListByUser(userID)
+	if err != nil {
+		return err
+	}
+	byID := map[int]models.Tag{}
+	for _, t := range tags {
+		byID[t.ID] = t
+	}
+	assignments, err := st.Tags().ListAssignments(userID)
+	if err != nil {
+		return err
+	}
+	for _, p := range promises {
+		p.Tags = []models.Tag{}
+		for _, id := range assignments[p.ID] {
+			p.Tags = append(p.Tags, byID[id])
+		}
+	}
+	return nil
+}
+
+// attachTagsToList is attachTags for a slice of promises.
+func attachTagsToList(st store.Store, userID int, promises []models.Promise) error {
+	ptrs := make([]*models.Promise, len(promises))
+	for i := range promises {
+		ptrs[i] = &promises[i]
+	}
+	return attachTags(st, userID, ptrs...)
+}
+
+// tagFilter reads the tags (comma-separated tag IDs) and tag_match ("any",
+// the default, or "all") query parameters into filter.
+func tagFilter(c *fiber.Ctx, filter *store.PromiseFilter) error {
+	seen := map[int]bool{}
+	for _, part := range strings.Split(c.Query("tags"), ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid tags filter")
+		}
+		if !seen[id] {
+			seen[id] = true
+			filter.TagIDs = append(filter.TagIDs, id)
+		}
+	}
+	switch c.Query("tag_match", "any") {
+	case "any":
+	case "all":
+		filter.AllTags = true
+	default:
+		return fiber.NewError(fiber.StatusBadRequest, "tag_match must be any or all")
+	}
+	return nil
+}
+
+// applyTagRequest validates req and applies it to t.
+func applyTagRequest(t *models.Tag, req models.TagRequest) error {
+	if req.Name.Set {
+		name := ""
+		if req.Name.Value != nil {
+			name = strings.TrimSpace(*req.Name.Value)
+		}
+		if name == "" || utf8.RuneCountInString(name) > maxTagNameLength {
+			return fiber.NewError(fiber.StatusBadRequest, "Tag names must be 1 to 50 characters")
+		}
+		t.Name = name
+	}
+	if req.Color.Set {
+		t.Color = defaultTagColor
+		if req.Color.Value != nil && *req.Color.Value != "" {
+			if !tagColorRe.MatchString(*req.Color.Value) {
+				return fiber.NewError(fiber.StatusBadRequest, "Tag colors must look like #rrggbb")
+			}
+			t.Color = strings.ToLower(*req.Color.Value)
+		}
+	}
+	return nil
+}
+
+// ListTagsHandler returns the user's tags with their promise counts by state.
+func ListTagsHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		tags, err := st.Tags().ListByUser(userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(tags)
+	}
+}
+
+func CreateTagHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.TagRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		req.Name.Set = true
+		if !req.Color.Set {
+			req.Color = models.Some(defaultTagColor)
+		}
+
+		tag := &models.Tag{UserID: userID}
+		if err := applyTagRequest(tag, req); err != nil {
+			return err
+		}
+		err := st.Tags().Create(tag)
+		if errors.Is(err, store.ErrConflict) {
+			return fiber.NewError(fiber.StatusConflict, "A tag with that name already exists")
+		}
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusCreated).JSON(tag)
+	}
+}
+
+func UpdateTagHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		tagID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid tag ID")
+		}
+
+		var req models.TagRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+
+		tags, err := st.Tags().ListByUser(userID)
+		if err != nil {
+			return err
+		}
+		var tag *models.Tag
+		for i := range tags {
+			if tags[i].ID == tagID {
+				tag = &tags[i]
+			}
+		}
+		if tag == nil {
+			return fiber.NewError(fiber.StatusNotFound, "Tag not found")
+		}
+
+		if err := applyTagRequest(tag, req); err != nil {
+			return err
+		}
+		err = st.Tags().Update(tag)
+		if errors.Is(err, store.ErrConflict) {
+			return fiber.NewError(fiber.StatusConflict, "A tag with that name already exists")
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(tag)
+	}
+}
+
+// DeleteTagHandler deletes a tag and removes it from all promises.
+func DeleteTagHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		tagID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid tag ID")
+		}
+
+		err = st.Tags().Delete(tagID, userID)
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Tag not found")
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
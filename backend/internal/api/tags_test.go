@@ -0,0 +1,135 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+
+	"kept/internal/models"
+)
+
+func TestTags(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	auth := registerUser(t, app, "taguser")
+	other := registerUser(t, app, "othertagger")
+
+	resp, body := doJSON(t, app, "POST", "/api/tags/", auth.Token, map[string]any{"name": " work ", "color": "#FF8800"})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var work models.Tag
+	json.Unmarshal(body, &work)
+	if work.Name != "work" || work.Color != "#ff8800" {
+		t.Fatalf("Unexpected tag: %s", body)
+	}
+	_, body = doJSON(t, app, "POST", "/api/tags/", auth.Token, map[string]any{"name": "family"})
+	var family models.Tag
+	json.Unmarshal(body, &family)
+	if family.Color == "" {
+		t.Fatalf("Expected a default color, got %s", body)
+	}
+	if resp, _ := doJSON(t, app, "POST", "/api/tags/", auth.Token, map[string]any{"name": "work"}); resp.StatusCode != 409 {
+		t.Fatalf("Expected status 409 for a duplicate tag, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "POST", "/api/tags/", auth.Token, map[string]any{"name": "bad", "color": "red"}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for an invalid color, got %d", resp.StatusCode)
+	}
+	_, body = doJSON(t, app, "POST", "/api/tags/", other.Token, map[string]any{"name": "theirs"})
+	var theirs models.Tag
+	json.Unmarshal(body, &theirs)
+
+	create := func(description string, tagIDs ...int) models.Promise {
+		resp, body := doJSON(t, app, "POST", "/api/promises/", auth.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: description, TagIDs: tagIDs})
+		if resp.StatusCode != 201 {
+			t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+		}
+		var p models.Promise
+		json.Unmarshal(body, &p)
+		return p
+	}
+	both := create("Both", work.ID, family.ID)
+	workOnly := create("Work only", work.ID)
+	create("Untagged")
+	if len(both.Tags) != 2 || both.Tags[0].Name != "family" || both.Tags[1].Counts["active"] != 1 {
+		t.Fatalf("Unexpected tags on the new promise: %+v", both.Tags)
+	}
+	if resp, _ := doJSON(t, app, "POST", "/api/promises/", auth.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "x", TagIDs: []int{theirs.ID}}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for another user's tag, got %d", resp.StatusCode)
+	}
+
+	list := func(path string) []models.Promise {
+		resp, body := doJSON(t, app, "GET", path, auth.Token, nil)
+		if resp.StatusCode != 200 {
+			t.Fatalf("GET %s: expected status 200, got %d: %s", path, resp.StatusCode, body)
+		}
+		var promises []models.Promise
+		json.Unmarshal(body, &promises)
+		return promises
+	}
+	ids := strconv.Itoa(work.ID) + "," + strconv.Itoa(family.ID)
+	if got := list("/api/promises/?tags=" + ids); len(got) != 2 {
+		t.Fatalf("Expected 2 promises with any of the tags, got %d", len(got))
+	}
+	if got := list("/api/promises/?tags=" + ids + "&tag_match=all"); len(got) != 1 || got[0].ID != both.ID {
+		t.Fatalf("Expected only the promise with both tags, got %+v", got)
+	}
+	if got := list("/api/timeline?tags=" + strconv.Itoa(family.ID)); len(got) != 1 || len(got[0].Events) == 0 {
+		t.Fatalf("Expected the timeline to be filtered by tag, got %+v", got)
+	}
+	if got := list("/api/promises/"); len(got) != 3 || got[0].Tags == nil {
+		t.Fatalf("Expected every promise to list its tags, got %+v", got)
+	}
+	if resp, _ := doJSON(t, app, "GET", "/api/promises/?tag_match=some", auth.Token, nil); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for an invalid tag_match, got %d", resp.StatusCode)
+	}
+
+	// Retagging is an edit with a revision
+	path := "/api/promises/" + strconv.Itoa(workOnly.ID)
+	resp, body = doJSONIfMatch(t, app, "PATCH", path, auth.Token, "*", map[string]any{"tag_ids": []int{family.ID}})
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	var updated models.Promise
+	json.Unmarshal(body, &updated)
+	if len(updated.Tags) != 1 || updated.Tags[0].ID != family.ID || updated.Tags[0].Counts["active"] != 2 {
+		t.Fatalf("Unexpected tags after the edit: %+v", updated.Tags)
+	}
+	_, body = doJSON(t, app, "GET", path+"/revisions", auth.Token, nil)
+	var revisions []models.Revision
+	json.Unmarshal(body, &revisions)
+	if len(revisions) != 1 || revisions[0].Field != "tags" || *revisions[0].OldValue != "work" || *revisions[0].NewValue != "family" {
+		t.Fatalf("Unexpected revisions: %s", body)
+	}
+
+	// Tag counts follow state changes
+	doJSONIfMatch(t, app, "PUT", path+"/state", auth.Token, "*", models.UpdatePromiseStateRequest{State: "kept"})
+	_, body = doJSON(t, app, "GET", "/api/tags/", auth.Token, nil)
+	var tags []models.Tag
+	json.Unmarshal(body, &tags)
+	if len(tags) != 2 || tags[0].Name != "family" || tags[0].Counts["active"] != 1 || tags[0].Counts["kept"] != 1 {
+		t.Fatalf("Unexpected tag counts: %s", body)
+	}
+
+	tagPath := "/api/tags/" + strconv.Itoa(work.ID)
+	resp, body = doJSON(t, app, "PATCH", tagPath, auth.Token, map[string]any{"name": "office"})
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	json.Unmarshal(body, &work)
+	if work.Name != "office" || work.Color != "#ff8800" {
+		t.Fatalf("Expected only the name to change, got %s", body)
+	}
+	if resp, _ := doJSON(t, app, "PATCH", tagPath, other.Token, map[string]any{"name": "mine"}); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 editing another user's tag, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "DELETE", tagPath, auth.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	_, body = doJSON(t, app, "GET", "/api/promises/"+strconv.Itoa(both.ID), auth.Token, nil)
+	var reloaded models.Promise
+	json.Unmarshal(body, &reloaded)
+	if len(reloaded.Tags) != 1 || reloaded.Tags[0].ID != family.ID {
+		t.Fatalf("Expected the deleted tag to be gone, got %+v", reloaded.Tags)
+	}
+}
@@ -0,0 +1,288 @@
+package api
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	maxTemplateNameLength = 100
+	// maxTemplateReminders caps the offset reminders of a template.
+	maxTemplateReminders = 10
+)
+
+// placeholderRe matches a {{placeholder}} in a template description.
+var placeholderRe = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// templatePlaceholders returns the names of the placeholders in
+// description, once each, in order of appearance.
+func templatePlaceholders(description string) []string {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, m := range placeholderRe.FindAllStringSubmatch(description, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			names = append(names, m[1])
+		}
+	}
+	return names
+}
+
+// fillTemplate replaces the placeholders in description with values. A
+// placeholder without a value is a bad request.
+func fillTemplate(description string, values map[string]string) (string, error) {
+	for _, name := range templatePlaceholders(description) {
+		if strings.TrimSpace(values[name]) == "" {
+			return "", fiber.NewError(fiber.StatusBadRequest, "Missing value for {{"+name+"}}")
+		}
+	}
+	return placeholderRe.ReplaceAllStringFunc(description, func(m string) string {
+		return strings.TrimSpace(values[placeholderRe.FindStringSubmatch(m)[1]])
+	}), nil
+}
+
+// withPlaceholders fills in t.Placeholders for a response.
+func withPlaceholders(t *models.Template) *models.Template {
+	t.Placeholders = templatePlaceholders(t.Description)
+	return t
+}
+
+// getOwnedTemplate loads a template from the :id route parameter.
+func getOwnedTemplate(c *fiber.Ctx, st store.Store, userID int) (*models.Template, error) {
+	templateID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid template ID")
+	}
+	template, err := st.Templates().Get(templateID, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Template not found")
+	}
+	return template, err
+}
+
+// applyTemplateRequest validates req and applies it to template.
+func applyTemplateRequest(template *models.Template, req models.TemplateRequest) error {
+	optional := func(o models.Optional[string]) string {
+		if o.Value == nil {
+			return ""
+		}
+		return strings.TrimSpace(*o.Value)
+	}
+	if req.Name.Set {
+		name := optional(req.Name)
+		if name == "" || utf8.RuneCountInString(name) > maxTemplateNameLength {
+			return fiber.NewError(fiber.StatusBadRequest, "Template names must be 1 to 100 characters")
+		}
+		template.Name = name
+	}
+	if req.Recipient.Set {
+		template.Recipient = optional(req.Recipient)
+	}
+	if req.Description.Set {
+		template.Description = optional(req.Description)
+		if template.Description == "" {
+			return fiber.NewError(fiber.StatusBadRequest, "Description is required")
+		}
+	}
+	if req.DueOffsetMinutes.Set {
+		template.DueOffsetMinutes = req.DueOffsetMinutes.Value
+		if template.DueOffsetMinutes != nil && *template.DueOffsetMinutes < 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Due offset can't be negative")
+		}
+	}
+	if req.ReminderFrequency.Set {
+		template.ReminderFrequency = optional(req.ReminderFrequency)
+		if !validReminderFrequencies[template.ReminderFrequency] {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid reminder frequency")
+		}
+	}
+	if req.ReminderOffsets.Set {
+		template.ReminderOffsets = []int{}
+		if req.ReminderOffsets.Value != nil {
+			template.ReminderOffsets = *req.ReminderOffsets.Value
+		}
+		if len(template.ReminderOffsets) > maxTemplateReminders {
+			return fiber.NewError(fiber.StatusBadRequest, "Templates can have at most 10 reminders")
+		}
+		for _, offset := range template.ReminderOffsets {
+			if offset < 0 {
+				return fiber.NewError(fiber.StatusBadRequest, "Reminder offsets can't be negative")
+			}
+		}
+	}
+	return nil
+}
+
+func ListTemplatesHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		templates, err := st.Templates().ListByUser(userID)
+		if err != nil {
+			return err
+		}
+		for i := range templates {
+			withPlaceholders(&templates[i])
+		}
+		return c.JSON(templates)
+	}
+}
+
+func CreateTemplateHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.TemplateRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		req.Name.Set, req.Description.Set = true, true
+
+		template := &models.Template{UserID: userID, ReminderOffsets: []int{}}
+		if err := applyTemplateRequest(template, req); err != nil {
+			return err
+		}
+		if err := st.Templates().Create(template); err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusCreated).JSON(withPlaceholders(template))
+	}
+}
+
+func GetTemplateHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		template, err := getOwnedTemplate(c, st, userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(withPlaceholders(template))
+	}
+}
+
+func UpdateTemplateHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.TemplateRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		template, err := getOwnedTemplate(c, st, userID)
+		if err != nil {
+			return err
+		}
+		if err := applyTemplateRequest(template, req); err != nil {
+			return err
+		}
+		if err := st.Templates().Update(template); err != nil {
+			return err
+		}
+		return c.JSON(withPlaceholders(template))
+	}
+}
+
+// DeleteTemplateHandler deletes a template. Promises created from it are
+// left alone.
+func DeleteTemplateHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		templateID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid template ID")
+		}
+
+		err = st.Templates().Delete(templateID, userID)
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Template not found")
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
+
+// CreateFromTemplateHandler creates a promise from a template the way
+// CreatePromiseHandler does, together with the template's reminders. The
+// due date is the template's offset from now unless the request sets one.
+func CreateFromTemplateHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.UseTemplateRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		template, err := getOwnedTemplate(c, st, userID)
+		if err != nil {
+			return err
+		}
+
+		description, err := fillTemplate(template.Description, req.Values)
+		if err != nil {
+			return err
+		}
+		create := models.CreatePromiseRequest{
+			Recipient:         template.Recipient,
+			Description:       description,
+			DueDate:           req.DueDate,
+			ReminderFrequency: template.ReminderFrequency,
+			TagIDs:            req.TagIDs,
+			ContactID:         req.ContactID,
+			WorkspaceID:       req.WorkspaceID,
+		}
+		if r := strings.TrimSpace(req.Recipient); r != "" {
+			create.Recipient = r
+		}
+		if create.Recipient == "" && create.ContactID == nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Recipient is required")
+		}
+		if create.DueDate == nil && template.DueOffsetMinutes != nil {
+			due := time.Now().UTC().Add(time.Duration(*template.DueOffsetMinutes) * time.Minute).Truncate(time.Minute)
+			create.DueDate = &due
+		}
+		if create.DueDate == nil && len(template.ReminderOffsets) > 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Template reminders need a due date")
+		}
+
+		var promise *models.Promise
+		err = st.InTx(func(tx store.Store) error {
+			var err error
+			if promise, err = insertPromise(tx, userID, create); err != nil {
+				return err
+			}
+			for _, offset := range template.ReminderOffsets {
+				reminder := models.Reminder{
+					PromiseID:     promise.ID,
+					UserID:        userID,
+					RemindAt:      promise.DueDate.Add(time.Duration(-offset) * time.Minute),
+					OffsetMinutes: offset,
+				}
+				if err := tx.Reminders().Create(&reminder); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if err := attachTags(st, userID, promise); err != nil {
+			return err
+		}
+		notifyRecipient(st, *promise, recipientEmailMade)
+
+		return c.Status(fiber.StatusCreated).JSON(promise)
+	}
+}
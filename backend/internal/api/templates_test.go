@@ -0,0 +1,89 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"kept/internal/models"
+)
+
+func TestPromiseTemplates(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "alicetemplates")
+	bob := registerUser(t, app, "bobtemplates")
+
+	if resp, _ := doJSON(t, app, "POST", "/api/templates/", alice.Token, map[string]any{"name": "Review", "description": "Review", "reminder_frequency": "hourly"}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for an invalid reminder frequency, got %d", resp.StatusCode)
+	}
+	resp, body := doJSON(t, app, "POST", "/api/templates/", alice.Token, map[string]any{
+		"name":               "Code review",
+		"recipient":          "Team",
+		"description":        "Review {{pr}} for {{ author }} by {{pr}}",
+		"due_offset_minutes": 2 * 24 * 60,
+		"reminder_frequency": "daily",
+		"reminder_offsets":   []int{60, 24 * 60},
+	})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var template models.Template
+	json.Unmarshal(body, &template)
+	if len(template.Placeholders) != 2 || template.Placeholders[0] != "pr" || template.Placeholders[1] != "author" {
+		t.Fatalf("Unexpected placeholders: %s", body)
+	}
+	templatePath := "/api/templates/" + strconv.Itoa(template.ID)
+	if resp, _ := doJSON(t, app, "GET", templatePath, bob.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 for another user, got %d", resp.StatusCode)
+	}
+
+	if resp, _ := doJSON(t, app, "POST", templatePath+"/promises", alice.Token, map[string]any{"values": map[string]string{"pr": "#42"}}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 for a missing value, got %d", resp.StatusCode)
+	}
+	before := time.Now().UTC()
+	resp, body = doJSON(t, app, "POST", templatePath+"/promises", alice.Token, map[string]any{"values": map[string]string{"pr": "#42", "author": "Sam"}})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	if promise.Description != "Review #42 for Sam by #42" || promise.Recipient != "Team" || promise.ReminderFrequency != "daily" {
+		t.Fatalf("Unexpected promise: %s", body)
+	}
+	if promise.DueDate == nil || promise.DueDate.Before(before.Add(47*time.Hour)) || promise.DueDate.After(before.Add(49*time.Hour)) {
+		t.Fatalf("Expected the promise due in two days, got %s", body)
+	}
+	var reminders []models.Reminder
+	_, body = doJSON(t, app, "GET", "/api/reminders/", alice.Token, nil)
+	json.Unmarshal(body, &reminders)
+	if len(reminders) != 2 || reminders[0].PromiseID != promise.ID || !reminders[0].RemindAt.Equal(promise.DueDate.Add(-24*time.Hour)) {
+		t.Fatalf("Expected the template's reminders, got %s", body)
+	}
+
+	// Without a due date there is nothing to remind before
+	doJSON(t, app, "PATCH", templatePath, alice.Token, map[string]any{"due_offset_minutes": nil})
+	if resp, _ := doJSON(t, app, "POST", templatePath+"/promises", alice.Token, map[string]any{"values": map[string]string{"pr": "#43", "author": "Sam"}}); resp.StatusCode != 400 {
+		t.Fatalf("Expected status 400 without a due date, got %d", resp.StatusCode)
+	}
+	due := time.Now().Add(72 * time.Hour).UTC().Truncate(time.Second)
+	resp, body = doJSON(t, app, "POST", templatePath+"/promises", alice.Token, map[string]any{"values": map[string]string{"pr": "#43", "author": "Sam"}, "recipient": "Sam", "due_date": due})
+	json.Unmarshal(body, &promise)
+	if resp.StatusCode != 201 || promise.Recipient != "Sam" || !promise.DueDate.Equal(due) {
+		t.Fatalf("Expected the request to override the template, got %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := doJSON(t, app, "POST", templatePath+"/promises", bob.Token, map[string]any{"values": map[string]string{"pr": "#44", "author": "Sam"}}); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 for another user, got %d", resp.StatusCode)
+	}
+
+	if resp, _ := doJSON(t, app, "DELETE", templatePath, alice.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	var templates []models.Template
+	_, body = doJSON(t, app, "GET", "/api/templates/", alice.Token, nil)
+	json.Unmarshal(body, &templates)
+	if len(templates) != 0 {
+		t.Fatalf("Expected no templates, got %s", body)
+	}
+}
@@ -0,0 +1,129 @@
+package api
+
+import (
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// defaultTrashRetentionDays is how long trashed promises are kept when
+// TRASH_RETENTION_DAYS isn't set.
+const defaultTrashRetentionDays = 30
+
+// trashRetention reads TRASH_RETENTION_DAYS. Zero means trashed promises are
+// kept until they are deleted by hand.
+func trashRetention() time.Duration {
+	days := defaultTrashRetentionDays
+	if v := os.Getenv("TRASH_RETENTION_DAYS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			log.Printf("Invalid TRASH_RETENTION_DAYS %q, using %d", v, defaultTrashRetentionDays)
+		} else {
+			days = n
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// trashPromise moves a promise to the trash and cancels its reminders.
+func trashPromise(st store.Store, promiseID, userID int) error {
+	return st.InTx(func(tx store.Store) error {
+		if err := tx.Promises().Trash(promiseID, userID); err != nil {
+			return err
+		}
+		return settleReminders(tx, promiseID, false)
+	})
+}
+
+// PurgeExpiredTrash permanently deletes promises that have been in the trash
+// longer than the retention period.
+func PurgeExpiredTrash(st store.Store) error {
+	retention := trashRetention()
+	if retention == 0 {
+		return nil
+	}
+	n, err := st.Promises().PurgeTrash(time.Now().Add(-retention))
+	if err != nil {
+		return err
+	}
+	if n > 0 {
+		log.Printf("Purged %d promises from the trash", n)
+	}
+	return nil
+}
+
+func ListTrashHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		promises, err := st.Promises().ListTrash(userID)
+		if err != nil {
+			return err
+		}
+		if err := attachTagsToList(st, userID, promises); err != nil {
+			return err
+		}
+		return c.JSON(promises)
+	}
+}
+
+func RestorePromiseHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		promiseID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
+		}
+
+		var promise *models.Promise
+		err = st.InTx(func(tx store.Store) error {
+			if err := tx.Promises().Restore(promiseID, userID); err != nil {
+				return err
+			}
+			var err error
+			if promise, err = tx.Promises().Get(promiseID); err != nil {
+				return err
+			}
+			return settleReminders(tx, promiseID, promise.CurrentState == "active")
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Promise not found in trash")
+		}
+		if err != nil {
+			return err
+		}
+		if err := attachTags(st, userID, promise); err != nil {
+			return err
+		}
+		return c.JSON(promise)
+	}
+}
+
+// DeleteTrashedPromiseHandler permanently deletes a promise, with its events
+// and reminders. Only promises already in the trash can be deleted.
+func DeleteTrashedPromiseHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		promiseID, err := strconv.Atoi(c.Params("id"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid promise ID")
+		}
+
+		err = st.Promises().DeleteTrashed(promiseID, userID)
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Promise not found in trash")
+		}
+		if err != nil {
+			return err
+		}
+
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
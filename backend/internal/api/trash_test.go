@@ -0,0 +1,112 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"kept/internal/api"
+	"kept/internal/models"
+)
+
+func TestTrash(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	auth := registerUser(t, app, "trashuser")
+	other := registerUser(t, app, "otheruser")
+
+	resp, body := doJSON(t, app, "POST", "/api/promises/", auth.Token, models.CreatePromiseRequest{Recipient: "Sam", Description: "Return the ladder"})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	id := strconv.Itoa(promise.ID)
+
+	if resp, _ := doJSONIfMatch(t, app, "DELETE", "/api/promises/"+id, auth.Token, "*", nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "GET", "/api/promises/"+id, auth.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected trashed promise to be hidden, got %d", resp.StatusCode)
+	}
+	_, body = doJSON(t, app, "GET", "/api/timeline", auth.Token, nil)
+	var timeline []models.Promise
+	json.Unmarshal(body, &timeline)
+	if len(timeline) != 0 {
+		t.Fatalf("Expected an empty timeline, got %s", body)
+	}
+
+	_, body = doJSON(t, app, "GET", "/api/trash/", auth.Token, nil)
+	var trash []models.Promise
+	json.Unmarshal(body, &trash)
+	if len(trash) != 1 || trash[0].ID != promise.ID || trash[0].DeletedAt == nil {
+		t.Fatalf("Unexpected trash: %s", body)
+	}
+	_, body = doJSON(t, app, "GET", "/api/trash/", other.Token, nil)
+	if string(body) != "[]" {
+		t.Fatalf("Expected another user's trash to be empty, got %s", body)
+	}
+
+	// Restore brings back the promise and its history
+	if resp, _ := doJSON(t, app, "POST", "/api/trash/"+id+"/restore", other.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 restoring another user's promise, got %d", resp.StatusCode)
+	}
+	resp, body = doJSON(t, app, "POST", "/api/trash/"+id+"/restore", auth.Token, nil)
+	if resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	resp, body = doJSON(t, app, "GET", "/api/promises/"+id, auth.Token, nil)
+	var restored models.Promise
+	json.Unmarshal(body, &restored)
+	if resp.StatusCode != 200 || len(restored.Events) == 0 || restored.DeletedAt != nil {
+		t.Fatalf("Expected restored promise with its events, got %d: %s", resp.StatusCode, body)
+	}
+
+	// Permanent deletion only works from the trash
+	if resp, _ := doJSON(t, app, "DELETE", "/api/trash/"+id, auth.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 for a promise outside the trash, got %d", resp.StatusCode)
+	}
+	doJSONIfMatch(t, app, "DELETE", "/api/promises/"+id, auth.Token, "*", nil)
+	if resp, _ := doJSON(t, app, "DELETE", "/api/trash/"+id, auth.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "POST", "/api/trash/"+id+"/restore", auth.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 restoring a deleted promise, got %d", resp.StatusCode)
+	}
+}
+
+func TestPurgeExpiredTrash(t *testing.T) {
+	st := setupTestDB(t)
+	userID, err := st.Users().Create("purgeuser", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &models.Promise{UserID: userID, Description: "Old", CurrentState: "active"}
+	if err := st.Promises().Create(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Promises().Trash(p.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+
+	// Retention of zero disables purging
+	t.Setenv("TRASH_RETENTION_DAYS", "0")
+	if err := api.PurgeExpiredTrash(st); err != nil {
+		t.Fatal(err)
+	}
+	if trash, _ := st.Promises().ListTrash(userID); len(trash) != 1 {
+		t.Fatalf("Expected the promise to stay in the trash, got %+v", trash)
+	}
+
+	// Freshly trashed promises are within the default retention
+	t.Setenv("TRASH_RETENTION_DAYS", "")
+	api.PurgeExpiredTrash(st)
+	if trash, _ := st.Promises().ListTrash(userID); len(trash) != 1 {
+		t.Fatalf("Expected the promise to stay in the trash, got %+v", trash)
+	}
+
+	if n, err := st.Promises().PurgeTrash(time.Now()); err != nil || n != 1 {
+		t.Fatalf("Expected one purged promise, got %d (%v)", n, err)
+	}
+}
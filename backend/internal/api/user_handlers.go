@@ -1,7 +1,7 @@
 package api
 
 import (
-	"database/sql"
+	"kept/internal/store"
 
 	"github.com/gofiber/fiber/v2"
 )
@@ -11,7 +11,7 @@ type UpdateEmailRequest struct {
 }
 
 // UpdateUserEmailHandler updates the user's email address
-func UpdateUserEmailHandler(db *sql.DB) fiber.Handler {
+func UpdateUserEmailHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 
@@ -29,16 +29,13 @@ func UpdateUserEmailHandler(db *sql.DB) fiber.Handler {
 			}
 		}
 
-		// Update email in database
-		var emailValue interface{}
-		if req.Email == nil || *req.Email == "" {
-			emailValue = nil
-		} else {
-			emailValue = *req.Email
+		// Update email in database (empty clears it)
+		var email string
+		if req.Email != nil {
+			email = *req.Email
 		}
 
-		_, err := db.Exec("UPDATE users SET email = ? WHERE id = ?", emailValue, userID)
-		if err != nil {
+		if err := st.Users().UpdateEmail(userID, email); err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Failed to update email")
 		}
 
@@ -50,31 +47,23 @@ func UpdateUserEmailHandler(db *sql.DB) fiber.Handler {
 }
 
 // GetUserProfileHandler returns the current user's profile information
-func GetUserProfileHandler(db *sql.DB) fiber.Handler {
+func GetUserProfileHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 
-		var username string
-		var email sql.NullString
-		var createdAt string
-
-		err := db.QueryRow(
-			"SELECT username, email, created_at FROM users WHERE id = ?",
-			userID,
-		).Scan(&username, &email, &createdAt)
-
+		user, err := st.Users().GetByID(userID)
 		if err != nil {
 			return fiber.NewError(fiber.StatusInternalServerError, "Failed to get user profile")
 		}
 
 		profile := fiber.Map{
 			"id":         userID,
-			"username":   username,
-			"created_at": createdAt,
+			"username":   user.Username,
+			"created_at": user.CreatedAt,
 		}
 
-		if email.Valid {
-			profile["email"] = email.String
+		if user.Email != "" {
+			profile["email"] = user.Email
 		} else {
 			profile["email"] = nil
 		}
@@ -1,10 +1,11 @@
 package api
 
 import (
-	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"kept/internal/store"
 	"log"
 	"os"
 	"time"
@@ -15,12 +16,20 @@ import (
 
 // PushPayload represents the notification payload sent to clients
 type PushPayload struct {
-	Title string                 `json:"title"`
-	Body  string                 `json:"body"`
-	Icon  string                 `json:"icon,omitempty"`
-	Badge string                 `json:"badge,omitempty"`
-	Tag   string                 `json:"tag,omitempty"`
-	Data  map[string]interface{} `json:"data,omitempty"`
+	Title   string                 `json:"title"`
+	Body    string                 `json:"body"`
+	Icon    string                 `json:"icon,omitempty"`
+	Badge   string                 `json:"badge,omitempty"`
+	Tag     string                 `json:"tag,omitempty"`
+	Data    map[string]interface{} `json:"data,omitempty"`
+	Actions []PushAction           `json:"actions,omitempty"`
+}
+
+// PushAction is a button on a notification. The service worker posts the
+// action to the actions_url in the payload data when it is clicked.
+type PushAction struct {
+	Action string `json:"action"`
+	Title  string `json:"title"`
 }
 
 // GetVapidOptions returns configured VAPID options from environment
@@ -43,50 +52,43 @@ func IsWebPushConfigured() bool {
 }
 
 // SendPushToUser sends a push notification to all subscriptions for a user
-func SendPushToUser(db *sql.DB, userID int, payload PushPayload) error {
+func SendPushToUser(st store.Store, userID int, payload PushPayload) error {
 	if !IsWebPushConfigured() {
 		log.Println("Web push not configured - skipping notification")
 		return nil
 	}
 
 	// Get all push subscriptions for the user
-	rows, err := db.Query(
-		"SELECT endpoint, p256dh, auth FROM push_subscriptions WHERE user_id = ?",
-		userID,
-	)
+	subs, err := st.Subscriptions().ListByUser(userID)
 	if err != nil {
 		return fmt.Errorf("failed to fetch subscriptions: %w", err)
 	}
-	defer rows.Close()
 
 	payloadJSON, err := json.Marshal(payload)
 	if err != nil {
 		return fmt.Errorf("failed to marshal payload: %w", err)
 	}
 
-	log.Printf("Payload to send: %s", string(payloadJSON))
+	// The payload holds promise text and push action tokens, so only the
+	// title and tag are logged
+	log.Printf("Sending push %q (%s) to user %d", payload.Title, payload.Tag, userID)
 
 	options := GetVapidOptions()
 	successCount := 0
 	failCount := 0
 	subscriptionCount := 0
 
-	for rows.Next() {
+	for _, sub := range subs {
 		subscriptionCount++
-		var endpoint, p256dh, auth string
-		if err := rows.Scan(&endpoint, &p256dh, &auth); err != nil {
-			log.Printf("Error scanning subscription: %v", err)
-			failCount++
-			continue
-		}
+		endpoint := sub.Endpoint
 
 		log.Printf("Sending push to endpoint: %s", endpoint[:50]+"...")
 
 		subscription := &webpush.Subscription{
 			Endpoint: endpoint,
 			Keys: webpush.Keys{
-				P256dh: p256dh,
-				Auth:   auth,
+				P256dh: sub.P256dh,
+				Auth:   sub.Auth,
 			},
 		}
 
@@ -97,7 +99,7 @@ func SendPushToUser(db *sql.DB, userID int, payload PushPayload) error {
 
 			// If subscription is expired/invalid (410 Gone or 404), remove it
 			if resp != nil && (resp.StatusCode == 410 || resp.StatusCode == 404) {
-				_, _ = db.Exec("DELETE FROM push_subscriptions WHERE endpoint = ?", endpoint)
+				_ = st.Subscriptions().DeleteByEndpoint(endpoint)
 				log.Printf("Removed expired subscription: %s", endpoint)
 			}
 			continue
@@ -115,7 +117,7 @@ func SendPushToUser(db *sql.DB, userID int, payload PushPayload) error {
 			// If 403 Forbidden, the VAPID keys don't match - delete the subscription
 			// so the client will re-subscribe with current keys
 			if resp.StatusCode == 403 {
-				_, _ = db.Exec("DELETE FROM push_subscriptions WHERE endpoint = ?", endpoint)
+				_ = st.Subscriptions().DeleteByEndpoint(endpoint)
 				log.Printf("Deleted mismatched subscription (403 Forbidden): %s", endpoint)
 				failCount++
 				continue
@@ -153,7 +155,7 @@ func VapidPublicKeyHandler() fiber.Handler {
 }
 
 // SendTestPushHandler sends an actual push notification for testing
-func SendTestPushHandler(db *sql.DB) fiber.Handler {
+func SendTestPushHandler(st store.Store) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		userID := c.Locals("userID").(int)
 
@@ -162,13 +164,10 @@ func SendTestPushHandler(db *sql.DB) fiber.Handler {
 		}
 
 		// Get most recent promise for context
-		row := db.QueryRow("SELECT id, recipient, description FROM promises WHERE user_id = ? ORDER BY updated_at DESC LIMIT 1", userID)
-		var promiseID int
-		var recipient, description string
-		err := row.Scan(&promiseID, &recipient, &description)
+		promise, err := st.Promises().MostRecentByUser(userID)
 
 		var payload PushPayload
-		if err == sql.ErrNoRows {
+		if errors.Is(err, store.ErrNotFound) {
 			payload = PushPayload{
 				Title: "Kept — Test Notification",
 				Body:  "This is a test notification",
@@ -185,11 +184,11 @@ func SendTestPushHandler(db *sql.DB) fiber.Handler {
 				Icon:  "/Static/logos/Kept%20Mascot%20Colored.svg",
 				Badge: "/Static/logos/Kept%20Mascot%20Colored.svg",
 				Tag:   fmt.Sprintf("kept-test-%d", time.Now().Unix()),
-				Data:  map[string]interface{}{"promise_id": promiseID},
+				Data:  map[string]interface{}{"promise_id": promise.ID},
 			}
 		}
 
-		if err := SendPushToUser(db, userID, payload); err != nil {
+		if err := SendPushToUser(st, userID, payload); err != nil {
 			log.Printf("Test push failed for user %d: %v", userID, err)
 			return fiber.NewError(fiber.StatusInternalServerError, "Failed to send test notification: "+err.Error())
 		}
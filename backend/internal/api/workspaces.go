@@ -0,0 +1,426 @@
+package api
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"kept/internal/models"
+	"kept/internal/store"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const maxWorkspaceNameLength = 100
+
+// roleRank orders workspace roles by how much they allow.
+var roleRank = map[string]int{models.RoleViewer: 1, models.RoleEditor: 2, models.RoleOwner: 3}
+
+// hasRole reports whether role allows at least what need allows.
+func hasRole(role, need string) bool {
+	return roleRank[role] > 0 && roleRank[role] >= roleRank[need]
+}
+
+// getWorkspaceFor loads a workspace in which userID has at least the given
+// role, returning 404 if they aren't a member and 403 if their role is too
+// low.
+func getWorkspaceFor(st store.Store, workspaceID, userID int, role string) (*models.Workspace, error) {
+	workspace, err := st.Workspaces().Get(workspaceID, userID)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Workspace not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !hasRole(workspace.Role, role) {
+		return nil, fiber.NewError(fiber.StatusForbidden, "Not authorized")
+	}
+	return workspace, nil
+}
+
+func workspaceParam(c *fiber.Ctx, st store.Store, userID int, role string) (*models.Workspace, error) {
+	workspaceID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid workspace ID")
+	}
+	return getWorkspaceFor(st, workspaceID, userID, role)
+}
+
+// workspaceFilter reads the workspace query parameter into filter, checking
+// that the user is a member.
+func workspaceFilter(c *fiber.Ctx, st store.Store, userID int, filter *store.PromiseFilter) error {
+	workspaceID, err := strconv.Atoi(c.Query("workspace", "0"))
+	if err != nil {
+		return fiber.NewError(fiber.StatusBadRequest, "Invalid workspace filter")
+	}
+	if workspaceID != 0 {
+		if _, err := getWorkspaceFor(st, workspaceID, userID, models.RoleViewer); err != nil {
+			return err
+		}
+	}
+	filter.WorkspaceID = workspaceID
+	return nil
+}
+
+func workspaceName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxWorkspaceNameLength {
+		return "", fiber.NewError(fiber.StatusBadRequest, "Workspace names must be 1 to 100 characters")
+	}
+	return name, nil
+}
+
+// checkOwnersLeft returns 409 if the workspace would be left without an
+// owner once userID stops being one.
+func checkOwnersLeft(st store.Store, workspaceID, userID int) error {
+	members, err := st.Workspaces().ListMembers(workspaceID)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		if m.Role == models.RoleOwner && m.UserID != userID {
+			return nil
+		}
+	}
+	return fiber.NewError(fiber.StatusConflict, "A workspace needs an owner")
+}
+
+func ListWorkspacesHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		workspaces, err := st.Workspaces().ListByUser(userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(workspaces)
+	}
+}
+
+// CreateWorkspaceHandler creates a workspace with the user as its owner.
+func CreateWorkspaceHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.WorkspaceRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		name, err := workspaceName(req.Name)
+		if err != nil {
+			return err
+		}
+
+		workspace := &models.Workspace{Name: name}
+		err = st.InTx(func(tx store.Store) error {
+			return tx.Workspaces().Create(workspace, userID)
+		})
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusCreated).JSON(workspace)
+	}
+}
+
+// GetWorkspaceHandler returns a workspace with its members.
+func GetWorkspaceHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		workspace, err := workspaceParam(c, st, userID, models.RoleViewer)
+		if err != nil {
+			return err
+		}
+		if workspace.Members, err = st.Workspaces().ListMembers(workspace.ID); err != nil {
+			return err
+		}
+		return c.JSON(workspace)
+	}
+}
+
+func UpdateWorkspaceHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.WorkspaceRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		workspace, err := workspaceParam(c, st, userID, models.RoleOwner)
+		if err != nil {
+			return err
+		}
+		if workspace.Name, err = workspaceName(req.Name); err != nil {
+			return err
+		}
+		if err := st.Workspaces().Rename(workspace.ID, workspace.Name); err != nil {
+			return err
+		}
+		return c.JSON(workspace)
+	}
+}
+
+// DeleteWorkspaceHandler deletes a workspace together with its promises.
+func DeleteWorkspaceHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		workspace, err := workspaceParam(c, st, userID, models.RoleOwner)
+		if err != nil {
+			return err
+		}
+		err = st.InTx(func(tx store.Store) error {
+			return tx.Workspaces().Delete(workspace.ID)
+		})
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
+
+// UpdateMemberHandler changes the role of a member.
+func UpdateMemberHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		memberID, err := strconv.Atoi(c.Params("userId"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid user ID")
+		}
+
+		var req models.UpdateMemberRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		if roleRank[req.Role] == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Role must be owner, editor or viewer")
+		}
+		workspace, err := workspaceParam(c, st, userID, models.RoleOwner)
+		if err != nil {
+			return err
+		}
+
+		err = st.InTx(func(tx store.Store) error {
+			if _, err := tx.Workspaces().Get(workspace.ID, memberID); errors.Is(err, store.ErrNotFound) {
+				return fiber.NewError(fiber.StatusNotFound, "Member not found")
+			} else if err != nil {
+				return err
+			}
+			if req.Role != models.RoleOwner {
+				if err := checkOwnersLeft(tx, workspace.ID, memberID); err != nil {
+					return err
+				}
+			}
+			return tx.Workspaces().SetMember(workspace.ID, memberID, req.Role)
+		})
+		if err != nil {
+			return err
+		}
+		members, err := st.Workspaces().ListMembers(workspace.ID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(members)
+	}
+}
+
+// RemoveMemberHandler removes a member. Owners can remove anyone; other
+// members can only leave. Promises they created stay in the workspace.
+func RemoveMemberHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		memberID, err := strconv.Atoi(c.Params("userId"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid user ID")
+		}
+
+		need := models.RoleOwner
+		if memberID == userID {
+			need = models.RoleViewer
+		}
+		workspace, err := workspaceParam(c, st, userID, need)
+		if err != nil {
+			return err
+		}
+
+		err = st.InTx(func(tx store.Store) error {
+			if err := checkOwnersLeft(tx, workspace.ID, memberID); err != nil {
+				return err
+			}
+			return tx.Workspaces().RemoveMember(workspace.ID, memberID)
+		})
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "Member not found")
+		}
+		if err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
+
+// InviteMemberHandler invites a user, by username, to join a workspace.
+func InviteMemberHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var req models.InviteMemberRequest
+		if err := c.BodyParser(&req); err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid request body")
+		}
+		if req.Role == "" {
+			req.Role = models.RoleEditor
+		}
+		if roleRank[req.Role] == 0 {
+			return fiber.NewError(fiber.StatusBadRequest, "Role must be owner, editor or viewer")
+		}
+		workspace, err := workspaceParam(c, st, userID, models.RoleOwner)
+		if err != nil {
+			return err
+		}
+		invitee, err := st.Users().GetByUsername(strings.TrimSpace(req.Username))
+		if errors.Is(err, store.ErrNotFound) {
+			return fiber.NewError(fiber.StatusNotFound, "User not found")
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := st.Workspaces().Get(workspace.ID, invitee.ID); err == nil {
+			return fiber.NewError(fiber.StatusConflict, "Already a member")
+		} else if !errors.Is(err, store.ErrNotFound) {
+			return err
+		}
+
+		invitation := &models.WorkspaceInvitation{
+			WorkspaceID:   workspace.ID,
+			WorkspaceName: workspace.Name,
+			UserID:        invitee.ID,
+			Username:      invitee.Username,
+			Role:          req.Role,
+			InvitedBy:     &userID,
+		}
+		err = st.Workspaces().Invite(invitation)
+		if errors.Is(err, store.ErrConflict) {
+			return fiber.NewError(fiber.StatusConflict, "Already invited")
+		}
+		if err != nil {
+			return err
+		}
+		return c.Status(fiber.StatusCreated).JSON(invitation)
+	}
+}
+
+// ListWorkspaceInvitationsHandler returns the pending invitations to a
+// workspace.
+func ListWorkspaceInvitationsHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		workspace, err := workspaceParam(c, st, userID, models.RoleOwner)
+		if err != nil {
+			return err
+		}
+		invitations, err := st.Workspaces().ListInvitations(workspace.ID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(invitations)
+	}
+}
+
+// CancelInvitationHandler withdraws a pending invitation.
+func CancelInvitationHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+		invitationID, err := strconv.Atoi(c.Params("invitationId"))
+		if err != nil {
+			return fiber.NewError(fiber.StatusBadRequest, "Invalid invitation ID")
+		}
+
+		workspace, err := workspaceParam(c, st, userID, models.RoleOwner)
+		if err != nil {
+			return err
+		}
+		invitation, err := st.Workspaces().GetInvitation(invitationID)
+		if errors.Is(err, store.ErrNotFound) || (err == nil && invitation.WorkspaceID != workspace.ID) {
+			return fiber.NewError(fiber.StatusNotFound, "Invitation not found")
+		}
+		if err != nil {
+			return err
+		}
+		if err := st.Workspaces().DeleteInvitation(invitationID); err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
+
+// ListMyInvitationsHandler returns the invitations the user hasn't answered.
+func ListMyInvitationsHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		invitations, err := st.Workspaces().ListInvitationsForUser(userID)
+		if err != nil {
+			return err
+		}
+		return c.JSON(invitations)
+	}
+}
+
+// getMyInvitation loads an invitation addressed to userID.
+func getMyInvitation(c *fiber.Ctx, st store.Store, userID int) (*models.WorkspaceInvitation, error) {
+	invitationID, err := strconv.Atoi(c.Params("id"))
+	if err != nil {
+		return nil, fiber.NewError(fiber.StatusBadRequest, "Invalid invitation ID")
+	}
+	invitation, err := st.Workspaces().GetInvitation(invitationID)
+	if errors.Is(err, store.ErrNotFound) || (err == nil && invitation.UserID != userID) {
+		return nil, fiber.NewError(fiber.StatusNotFound, "Invitation not found")
+	}
+	return invitation, err
+}
+
+// AcceptInvitationHandler makes the user a member with the invited role and
+// returns the workspace.
+func AcceptInvitationHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		var workspace *models.Workspace
+		err := st.InTx(func(tx store.Store) error {
+			invitation, err := getMyInvitation(c, tx, userID)
+			if err != nil {
+				return err
+			}
+			if err := tx.Workspaces().SetMember(invitation.WorkspaceID, userID, invitation.Role); err != nil {
+				return err
+			}
+			if err := tx.Workspaces().DeleteInvitation(invitation.ID); err != nil {
+				return err
+			}
+			workspace, err = tx.Workspaces().Get(invitation.WorkspaceID, userID)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return c.JSON(workspace)
+	}
+}
+
+func DeclineInvitationHandler(st store.Store) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Locals("userID").(int)
+
+		invitation, err := getMyInvitation(c, st, userID)
+		if err != nil {
+			return err
+		}
+		if err := st.Workspaces().DeleteInvitation(invitation.ID); err != nil {
+			return err
+		}
+		return c.JSON(fiber.Map{"success": true})
+	}
+}
@@ -0,0 +1,168 @@
+package api_test
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+
+	"kept/internal/models"
+)
+
+func TestWorkspaces(t *testing.T) {
+	st := setupTestDB(t)
+	app := setupTestApp(st)
+	alice := registerUser(t, app, "alicews")
+	bob := registerUser(t, app, "bobws")
+	carol := registerUser(t, app, "carolws")
+	dave := registerUser(t, app, "davews")
+
+	resp, body := doJSON(t, app, "POST", "/api/workspaces/", alice.Token, models.WorkspaceRequest{Name: " Household "})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var ws models.Workspace
+	json.Unmarshal(body, &ws)
+	if ws.Name != "Household" || ws.Role != models.RoleOwner {
+		t.Fatalf("Unexpected workspace: %s", body)
+	}
+	wsPath := "/api/workspaces/" + strconv.Itoa(ws.ID)
+
+	// Members join by accepting an invitation
+	for _, invite := range []struct {
+		username, role string
+		status         int
+	}{
+		{"bobws", models.RoleEditor, 201},
+		{"carolws", models.RoleViewer, 201},
+		{"carolws", models.RoleEditor, 409},
+		{"nobody", models.RoleViewer, 404},
+		{"davews", "admin", 400},
+	} {
+		resp, body := doJSON(t, app, "POST", wsPath+"/invitations", alice.Token, models.InviteMemberRequest{Username: invite.username, Role: invite.role})
+		if resp.StatusCode != invite.status {
+			t.Fatalf("Inviting %s as %s: expected status %d, got %d: %s", invite.username, invite.role, invite.status, resp.StatusCode, body)
+		}
+	}
+	if resp, _ := doJSON(t, app, "POST", wsPath+"/invitations", bob.Token, models.InviteMemberRequest{Username: "davews"}); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 inviting before joining, got %d", resp.StatusCode)
+	}
+	for _, member := range []models.AuthResponse{bob, carol} {
+		var invitations []models.WorkspaceInvitation
+		_, body := doJSON(t, app, "GET", "/api/invitations/", member.Token, nil)
+		json.Unmarshal(body, &invitations)
+		if len(invitations) != 1 || invitations[0].WorkspaceName != "Household" {
+			t.Fatalf("Unexpected invitations: %s", body)
+		}
+		if resp, _ := doJSON(t, app, "POST", "/api/invitations/"+strconv.Itoa(invitations[0].ID)+"/accept", dave.Token, nil); resp.StatusCode != 404 {
+			t.Fatalf("Expected status 404 accepting someone else's invitation, got %d", resp.StatusCode)
+		}
+		if resp, body := doJSON(t, app, "POST", "/api/invitations/"+strconv.Itoa(invitations[0].ID)+"/accept", member.Token, nil); resp.StatusCode != 200 {
+			t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+		}
+	}
+	_, body = doJSON(t, app, "GET", wsPath, carol.Token, nil)
+	json.Unmarshal(body, &ws)
+	if ws.Role != models.RoleViewer || len(ws.Members) != 3 {
+		t.Fatalf("Unexpected workspace: %s", body)
+	}
+
+	// Promises in the workspace are shared with its members
+	resp, body = doJSON(t, app, "POST", "/api/promises/", alice.Token, models.CreatePromiseRequest{Recipient: "Kids", Description: "Zoo trip", WorkspaceID: &ws.ID})
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	var promise models.Promise
+	json.Unmarshal(body, &promise)
+	promisePath := "/api/promises/" + strconv.Itoa(promise.ID)
+	if resp, _ := doJSON(t, app, "POST", "/api/promises/", carol.Token, models.CreatePromiseRequest{Recipient: "Kids", Description: "x", WorkspaceID: &ws.ID}); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 creating as a viewer, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "POST", "/api/promises/", dave.Token, models.CreatePromiseRequest{Recipient: "Kids", Description: "x", WorkspaceID: &ws.ID}); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 creating as a non-member, got %d", resp.StatusCode)
+	}
+
+	var promises []models.Promise
+	_, body = doJSON(t, app, "GET", "/api/promises/?workspace="+strconv.Itoa(ws.ID), carol.Token, nil)
+	json.Unmarshal(body, &promises)
+	if len(promises) != 1 || promises[0].ID != promise.ID {
+		t.Fatalf("Expected the workspace promise, got %s", body)
+	}
+	_, body = doJSON(t, app, "GET", "/api/promises/", alice.Token, nil)
+	json.Unmarshal(body, &promises)
+	if len(promises) != 0 {
+		t.Fatalf("Expected workspace promises out of the personal list, got %s", body)
+	}
+	if resp, _ := doJSON(t, app, "GET", "/api/promises/?workspace="+strconv.Itoa(ws.ID), dave.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 listing as a non-member, got %d", resp.StatusCode)
+	}
+
+	if resp, _ := doJSON(t, app, "GET", promisePath, carol.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected viewers to see the promise, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "GET", promisePath, dave.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected status 404 for a non-member, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSONIfMatch(t, app, "PATCH", promisePath, carol.Token, "*", map[string]any{"description": "Aquarium"}); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 editing as a viewer, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSONIfMatch(t, app, "DELETE", promisePath, carol.Token, "*", nil); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 trashing as a viewer, got %d", resp.StatusCode)
+	}
+	if resp, body := doJSONIfMatch(t, app, "PUT", promisePath+"/state", bob.Token, "*", models.UpdatePromiseStateRequest{State: "kept"}); resp.StatusCode != 200 {
+		t.Fatalf("Expected editors to change the state, got %d: %s", resp.StatusCode, body)
+	}
+
+	// Links one member shares can be revoked by the others who can share
+	resp, body = doJSON(t, app, "POST", promisePath+"/shares", bob.Token, models.CreateShareLinkRequest{})
+	var link models.ShareLink
+	json.Unmarshal(body, &link)
+	if resp.StatusCode != 201 {
+		t.Fatalf("Expected editors to share, got %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := doJSON(t, app, "DELETE", promisePath+"/shares/"+strconv.Itoa(link.ID), carol.Token, nil); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 revoking as a viewer, got %d", resp.StatusCode)
+	}
+	if resp, body := doJSON(t, app, "DELETE", promisePath+"/shares/"+strconv.Itoa(link.ID), alice.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected the owner to revoke an editor's link, got %d: %s", resp.StatusCode, body)
+	}
+	_, body = doJSON(t, app, "GET", "/api/timeline?workspace="+strconv.Itoa(ws.ID), carol.Token, nil)
+	json.Unmarshal(body, &promises)
+	if len(promises) != 1 || len(promises[0].Events) != 2 || promises[0].Events[0].State != "kept" {
+		t.Fatalf("Unexpected workspace timeline: %s", body)
+	}
+
+	// Roles are managed by owners, and a workspace always keeps one
+	if resp, _ := doJSON(t, app, "PATCH", wsPath+"/members/"+strconv.Itoa(carol.User.ID), bob.Token, models.UpdateMemberRequest{Role: models.RoleEditor}); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 changing roles as an editor, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "DELETE", wsPath+"/members/"+strconv.Itoa(alice.User.ID), alice.Token, nil); resp.StatusCode != 409 {
+		t.Fatalf("Expected status 409 for the last owner leaving, got %d", resp.StatusCode)
+	}
+	if resp, body := doJSON(t, app, "PATCH", wsPath+"/members/"+strconv.Itoa(bob.User.ID), alice.Token, models.UpdateMemberRequest{Role: models.RoleOwner}); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	remindAt := time.Now().Add(time.Hour)
+	if resp, body := doJSON(t, app, "POST", "/api/reminders/promise/"+strconv.Itoa(promise.ID), alice.Token, models.CreateReminderRequest{RemindAt: &remindAt}); resp.StatusCode != 201 {
+		t.Fatalf("Expected status 201, got %d: %s", resp.StatusCode, body)
+	}
+	if resp, _ := doJSON(t, app, "DELETE", wsPath+"/members/"+strconv.Itoa(alice.User.ID), alice.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected the owner to leave once there is another, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "GET", promisePath, alice.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected former members to lose access, got %d", resp.StatusCode)
+	}
+	if _, body := doJSON(t, app, "GET", "/api/reminders/", alice.Token, nil); string(body) != "[]" {
+		t.Fatalf("Expected former members not to see the promise through their reminders, got %s", body)
+	}
+
+	if resp, _ := doJSON(t, app, "DELETE", wsPath, carol.Token, nil); resp.StatusCode != 403 {
+		t.Fatalf("Expected status 403 deleting as a viewer, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "DELETE", wsPath, bob.Token, nil); resp.StatusCode != 200 {
+		t.Fatalf("Expected status 200, got %d", resp.StatusCode)
+	}
+	if resp, _ := doJSON(t, app, "GET", promisePath, bob.Token, nil); resp.StatusCode != 404 {
+		t.Fatalf("Expected the workspace's promises to be deleted, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// PushActionClaims let the buttons of a push notification act on a promise
+// for the user the notification was sent to. The token ID (jti) is recorded
+// when it is used, so each notification can only act once.
+type PushActionClaims struct {
+	UserID    int `json:"user_id"`
+	PromiseID int `json:"promise_id"`
+	jwt.RegisteredClaims
+}
+
+// pushActionSecret signs push action tokens, separately from session tokens
+// so neither can stand in for the other.
+func pushActionSecret() []byte {
+	return append(append([]byte{}, jwtSecret...), "-push-action"...)
+}
+
+// GeneratePushActionToken signs a token for acting on a promise from a push
+// notification that expires after ttl.
+func GeneratePushActionToken(userID, promiseID int, ttl time.Duration) (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	claims := PushActionClaims{
+		UserID:    userID,
+		PromiseID: promiseID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        hex.EncodeToString(id),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(ttl)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(pushActionSecret())
+}
+
+// ValidatePushActionToken checks the signature and expiry of a push action
+// token.
+func ValidatePushActionToken(tokenString string) (*PushActionClaims, error) {
+	token, err := jwt.ParseWithClaims(tokenString, &PushActionClaims{}, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+		return pushActionSecret(), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if claims, ok := token.Claims.(*PushActionClaims); ok && token.Valid && claims.UserID != 0 && claims.PromiseID != 0 && claims.ID != "" && claims.ExpiresAt != nil {
+		return claims, nil
+	}
+	return nil, errors.New("invalid push action token")
+}
@@ -0,0 +1,343 @@
+// Package backup takes consistent snapshots of a running SQLite database and
+// restores them.
+//
+// Snapshots are made with SQLite's online backup API, page by page, while the
+// server keeps serving requests. Both ends of the copy are opened with the
+// same DB_ENCRYPTION_KEY, so snapshots of a SQLCipher database are encrypted
+// with the same key and can only be restored with it.
+package backup
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"kept/internal/database"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrUnsupported is returned for databases that aren't SQLite. PostgreSQL
+// deployments should use pg_dump instead.
+var ErrUnsupported = errors.New("backups are only supported for SQLite databases")
+
+// ErrNotFound is returned when a named snapshot doesn't exist.
+var ErrNotFound = errors.New("snapshot not found")
+
+const nameLayout = "20060102T150405.000Z"
+
+var nameRe = regexp.MustCompile(`^kept-(\d{8}T\d{6}\.\d{3}Z)\.db$`)
+
+// Snapshot is a backup file in the snapshot directory.
+type Snapshot struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Config controls where snapshots go and how long they are kept.
+type Config struct {
+	// Dir is the directory snapshots are written to.
+	Dir string
+	// Interval between scheduled snapshots. Zero disables the schedule.
+	Interval time.Duration
+	// Keep is the number of snapshots to retain. Zero keeps any number.
+	Keep int
+	// MaxAge removes snapshots older than this. Zero keeps them forever.
+	MaxAge time.Duration
+}
+
+// ConfigFromEnv reads BACKUP_DIR, BACKUP_INTERVAL, BACKUP_KEEP and
+// BACKUP_MAX_AGE_DAYS. dbPath is used to put snapshots next to the database
+// by default.
+func ConfigFromEnv(dbPath string) (Config, error) {
+	cfg := Config{
+		Dir:      filepath.Join(filepath.Dir(dbPath), "backups"),
+		Interval: 24 * time.Hour,
+		Keep:     7,
+		MaxAge:   30 * 24 * time.Hour,
+	}
+	if dir := os.Getenv("BACKUP_DIR"); dir != "" {
+		cfg.Dir = dir
+	}
+	if v := os.Getenv("BACKUP_INTERVAL"); v != "" {
+		interval, err := time.ParseDuration(v)
+		if err != nil || interval < 0 {
+			return cfg, fmt.Errorf("invalid BACKUP_INTERVAL %q", v)
+		}
+		cfg.Interval = interval
+	}
+	if v := os.Getenv("BACKUP_KEEP"); v != "" {
+		keep, err := strconv.Atoi(v)
+		if err != nil || keep < 0 {
+			return cfg, fmt.Errorf("invalid BACKUP_KEEP %q", v)
+		}
+		cfg.Keep = keep
+	}
+	if v := os.Getenv("BACKUP_MAX_AGE_DAYS"); v != "" {
+		days, err := strconv.Atoi(v)
+		if err != nil || days < 0 {
+			return cfg, fmt.Errorf("invalid BACKUP_MAX_AGE_DAYS %q", v)
+		}
+		cfg.MaxAge = time.Duration(days) * 24 * time.Hour
+	}
+	return cfg, nil
+}
+
+// Manager creates, lists, prunes and restores snapshots of one database.
+type Manager struct {
+	db  *database.DB
+	cfg Config
+	// mu serializes snapshot and restore operations.
+	mu sync.Mutex
+}
+
+// NewManager returns a Manager for db. It returns ErrUnsupported unless db
+// is SQLite.
+func NewManager(db *database.DB, cfg Config) (*Manager, error) {
+	if db.Dialect != database.SQLite {
+		return nil, ErrUnsupported
+	}
+	return &Manager{db: db, cfg: cfg}, nil
+}
+
+// Config returns the manager's configuration.
+func (m *Manager) Config() Config {
+	return m.cfg
+}
+
+// Create takes a snapshot and then applies the retention policy.
+func (m *Manager) Create() (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snap, err := m.create()
+	if err != nil {
+		return nil, err
+	}
+	if err := m.prune(); err != nil {
+		log.Printf("Backup retention failed: %v", err)
+	}
+	return snap, nil
+}
+
+func (m *Manager) create() (*Snapshot, error) {
+	if err := os.MkdirAll(m.cfg.Dir, 0700); err != nil {
+		return nil, err
+	}
+
+	// Names have millisecond resolution; don't overwrite a snapshot taken
+	// in the same millisecond.
+	now := time.Now().UTC().Truncate(time.Millisecond)
+	name := "kept-" + now.Format(nameLayout) + ".db"
+	path := filepath.Join(m.cfg.Dir, name)
+	for fileExists(path) {
+		now = now.Add(time.Millisecond)
+		name = "kept-" + now.Format(nameLayout) + ".db"
+		path = filepath.Join(m.cfg.Dir, name)
+	}
+	partial := path + ".partial"
+	os.Remove(partial)
+
+	dest := database.OpenSQLiteFile(partial)
+	err := copyDatabase(dest, m.db.DB)
+	dest.Close()
+	if err != nil {
+		os.Remove(partial)
+		return nil, fmt.Errorf("snapshot failed: %w", err)
+	}
+	if err := os.Rename(partial, path); err != nil {
+		os.Remove(partial)
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{Name: name, Size: info.Size(), CreatedAt: now}, nil
+}
+
+// List returns the snapshots, newest first.
+func (m *Manager) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(m.cfg.Dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return []Snapshot{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []Snapshot{}
+	for _, entry := range entries {
+		match := nameRe.FindStringSubmatch(entry.Name())
+		if match == nil || entry.IsDir() {
+			continue
+		}
+		createdAt, err := time.Parse(nameLayout, match[1])
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, err
+		}
+		snapshots = append(snapshots, Snapshot{Name: entry.Name(), Size: info.Size(), CreatedAt: createdAt})
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// Prune removes snapshots beyond the configured count or age. The newest
+// snapshot is always kept.
+func (m *Manager) Prune() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.prune()
+}
+
+func (m *Manager) prune() error {
+	snapshots, err := m.List()
+	if err != nil {
+		return err
+	}
+	cutoff := time.Now().Add(-m.cfg.MaxAge)
+	for i, snap := range snapshots {
+		if i == 0 {
+			continue
+		}
+		tooMany := m.cfg.Keep > 0 && i >= m.cfg.Keep
+		tooOld := m.cfg.MaxAge > 0 && snap.CreatedAt.Before(cutoff)
+		if tooMany || tooOld {
+			if err := os.Remove(filepath.Join(m.cfg.Dir, snap.Name)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Restore replaces the contents of the live database with a snapshot. The
+// current state is snapshotted first so the restore can be undone, and
+// migrations are re-applied in case the snapshot predates the running
+// build. It returns the safety snapshot.
+func (m *Manager) Restore(name string) (*Snapshot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !nameRe.MatchString(name) {
+		return nil, ErrNotFound
+	}
+	path := filepath.Join(m.cfg.Dir, name)
+	if !fileExists(path) {
+		return nil, ErrNotFound
+	}
+
+	src := database.OpenSQLiteFile("file:" + path + "?mode=ro")
+	defer src.Close()
+	if err := checkIntegrity(src); err != nil {
+		return nil, fmt.Errorf("snapshot %s is unusable: %w", name, err)
+	}
+
+	safety, err := m.create()
+	if err != nil {
+		return nil, fmt.Errorf("could not snapshot current database before restoring: %w", err)
+	}
+
+	if err := copyDatabase(m.db.DB, src); err != nil {
+		return nil, fmt.Errorf("restore failed: %w", err)
+	}
+	if err := database.Migrate(m.db); err != nil {
+		return nil, fmt.Errorf("restored snapshot could not be migrated: %w", err)
+	}
+	return safety, nil
+}
+
+// Run takes a snapshot every Interval until ctx is done.
+func (m *Manager) Run(ctx context.Context) {
+	if m.cfg.Interval <= 0 {
+		return
+	}
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if snap, err := m.Create(); err != nil {
+				log.Printf("Scheduled backup failed: %v", err)
+			} else {
+				log.Printf("Created backup %s (%d bytes)", snap.Name, snap.Size)
+			}
+		}
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func checkIntegrity(db *sql.DB) error {
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New(result)
+	}
+	return nil
+}
+
+// copyDatabase copies src over dest with the online backup API. Pages are
+// copied in batches so writers on the live database aren't blocked for the
+// whole copy; the backup restarts itself if src changes in between.
+func copyDatabase(dest, src *sql.DB) error {
+	ctx := context.Background()
+	destConn, err := dest.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer destConn.Close()
+	srcConn, err := src.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer srcConn.Close()
+
+	return destConn.Raw(func(destRaw any) error {
+		return srcConn.Raw(func(srcRaw any) error {
+			d, ok := destRaw.(*sqlite3.SQLiteConn)
+			s, ok2 := srcRaw.(*sqlite3.SQLiteConn)
+			if !ok || !ok2 {
+				return ErrUnsupported
+			}
+
+			bk, err := d.Backup("main", s, "main")
+			if err != nil {
+				return err
+			}
+			for {
+				done, err := bk.Step(256)
+				if err != nil {
+					bk.Close()
+					return err
+				}
+				if done {
+					return bk.Finish()
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	})
+}
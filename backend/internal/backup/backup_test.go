@@ -0,0 +1,94 @@
+package backup_test
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"kept/internal/backup"
+	"kept/internal/database"
+)
+
+func openManager(t *testing.T, cfg backup.Config) (*database.DB, *backup.Manager) {
+	dir := t.TempDir()
+	db, err := database.Initialize(filepath.Join(dir, "kept.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cfg.Dir = filepath.Join(dir, "backups")
+	m, err := backup.NewManager(db, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, m
+}
+
+func countUsers(t *testing.T, db *database.DB) int {
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM users").Scan(&n); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	db, m := openManager(t, backup.Config{})
+
+	if _, err := db.Exec("INSERT INTO users (username, password_hash) VALUES ('alice', 'x')"); err != nil {
+		t.Fatal(err)
+	}
+	snap, err := m.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("INSERT INTO users (username, password_hash) VALUES ('bob', 'x')"); err != nil {
+		t.Fatal(err)
+	}
+	if n := countUsers(t, db); n != 2 {
+		t.Fatalf("Expected 2 users before restore, got %d", n)
+	}
+
+	previous, err := m.Restore(snap.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := countUsers(t, db); n != 1 {
+		t.Fatalf("Expected 1 user after restore, got %d", n)
+	}
+
+	// The state before the restore was kept and can be restored in turn
+	if _, err := m.Restore(previous.Name); err != nil {
+		t.Fatal(err)
+	}
+	if n := countUsers(t, db); n != 2 {
+		t.Fatalf("Expected 2 users after undoing the restore, got %d", n)
+	}
+
+	if _, err := m.Restore("../kept.db"); !errors.Is(err, backup.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for a bad name, got %v", err)
+	}
+}
+
+func TestRetentionKeepsNewestSnapshots(t *testing.T) {
+	_, m := openManager(t, backup.Config{Keep: 2})
+
+	var names []string
+	for i := 0; i < 3; i++ {
+		snap, err := m.Create()
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, snap.Name)
+	}
+
+	snapshots, err := m.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Name != names[2] || snapshots[1].Name != names[1] {
+		t.Fatalf("Expected the two newest snapshots, got %+v", snapshots)
+	}
+}
@@ -1,149 +1,203 @@
 package database
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/mattn/go-sqlite3"
 )
 
-func Initialize(dbPath string) (*sql.DB, error) {
-	// Create data directory if it doesn't exist
-	dir := filepath.Dir(dbPath)
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return nil, err
+// Dialect identifies the SQL database behind a DB.
+type Dialect string
+
+const (
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// DB is an open database handle together with its dialect.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+}
+
+// DialectFor picks the dialect for a DSN. postgres:// and postgresql:// URLs
+// select PostgreSQL; anything else is treated as a SQLite file path.
+func DialectFor(dsn string) Dialect {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return Postgres
 	}
+	return SQLite
+}
 
-	// Detect whether DB file already exists. If it does, require an
-	// encryption key to be provided via `DB_ENCRYPTION_KEY` so the
-	// application doesn't open an existing (possibly encrypted) DB
-	// without a key.
-	if _, err := os.Stat(dbPath); err == nil {
-		if os.Getenv("DB_ENCRYPTION_KEY") == "" {
-			return nil, fmt.Errorf("existing database detected at %s: DB_ENCRYPTION_KEY must be set to open it", dbPath)
+// Rebind rewrites ? placeholders into the dialect's style. Queries are written
+// with ? and must not contain a literal ? inside string constants.
+func (d Dialect) Rebind(query string) string {
+	if d != Postgres || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
 		}
-	} else if !os.IsNotExist(err) {
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// IsUniqueViolation reports whether err was caused by a UNIQUE or PRIMARY KEY
+// constraint.
+func (d Dialect) IsUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique ||
+			sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23505"
+	}
+	return false
+}
+
+// Initialize opens the database and applies any pending migrations.
+func Initialize(dsn string) (*DB, error) {
+	db, err := Open(dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := Migrate(db); err != nil {
+		db.Close()
 		return nil, err
 	}
 
-	db, err := sql.Open("sqlite3", dbPath)
+	return db, nil
+}
+
+// Open opens the database without touching its schema.
+func Open(dsn string) (*DB, error) {
+	if DialectFor(dsn) == Postgres {
+		return openPostgres(dsn)
+	}
+	return openSQLite(dsn)
+}
+
+func openPostgres(dsn string) (*DB, error) {
+	db, err := sql.Open("pgx", dsn)
 	if err != nil {
 		return nil, err
 	}
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	return &DB{DB: db, Dialect: Postgres}, nil
+}
 
-	// If an encryption key is provided via environment, apply it
-	// immediately after opening. This enables use with SQLCipher
-	// (requires the image/build to be linked against SQLCipher).
-	if key := os.Getenv("DB_ENCRYPTION_KEY"); key != "" {
-		// Escape single quotes in the key for the PRAGMA statement
-		esc := strings.ReplaceAll(key, "'", "''")
-		if _, err := db.Exec(fmt.Sprintf("PRAGMA key = '%s';", esc)); err != nil {
-			db.Close()
-			return nil, fmt.Errorf("failed to set database encryption key: %w", err)
-		}
-		// Optional: set compatibility mode for newer SQLCipher versions
-		_, _ = db.Exec("PRAGMA cipher_compatibility = 4;")
-		// Quick accessibility check: ensure we can read the schema. If the key is wrong
-		// or the file is not a valid SQLite database, this will return an error.
-		var count int
-		row := db.QueryRow("SELECT count(*) FROM sqlite_master;")
-		if err := row.Scan(&count); err != nil {
-			db.Close()
-			return nil, fmt.Errorf("database inaccessible with provided encryption key: %w", err)
-		}
+func openSQLite(dbPath string) (*DB, error) {
+	// Create data directory if it doesn't exist
+	dir := filepath.Dir(dbPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
 	}
 
-	// Enable foreign keys
-	if _, err := db.Exec("PRAGMA foreign_keys = ON"); err != nil {
+	// Check the file against the configured key up front so a missing or
+	// unnecessary key gives a clear error instead of "file is not a database".
+	key := os.Getenv("DB_ENCRYPTION_KEY")
+	kind, err := DetectSQLiteFile(dbPath)
+	if err != nil {
 		return nil, err
 	}
+	switch {
+	case kind == FileEncrypted && key == "":
+		return nil, fmt.Errorf("database at %s is encrypted: set DB_ENCRYPTION_KEY to open it", dbPath)
+	case kind == FileEncrypted && !SQLCipherAvailable():
+		return nil, fmt.Errorf("database at %s is encrypted, but this build of kept was not linked against SQLCipher", dbPath)
+	case kind == FilePlaintext && key != "" && SQLCipherAvailable():
+		return nil, fmt.Errorf("database at %s is not encrypted but DB_ENCRYPTION_KEY is set: run `kept db encrypt` to encrypt it, or unset the key", dbPath)
+	case key != "" && !SQLCipherAvailable():
+		log.Printf("WARNING: DB_ENCRYPTION_KEY is set but this build doesn't include SQLCipher; %s is stored unencrypted", dbPath)
+	}
 
-	// Create tables
-	if err := createTables(db); err != nil {
+	db := OpenSQLiteFile(dbPath)
+
+	// Quick accessibility check: ensure we can read the schema. If the key is wrong
+	// or the file is not a valid SQLite database, this will return an error.
+	var count int
+	row := db.QueryRow("SELECT count(*) FROM sqlite_master;")
+	if err := row.Scan(&count); err != nil {
+		db.Close()
+		if kind == FileEncrypted {
+			return nil, fmt.Errorf("database inaccessible with provided encryption key: %w", err)
+		}
 		return nil, err
 	}
 
-	return db, nil
+	return &DB{DB: db, Dialect: SQLite}, nil
+}
+
+// OpenSQLiteFile opens a SQLite file, creating it if needed, without any
+// checks or migrations. Every pooled connection is set up with the
+// DB_ENCRYPTION_KEY (for SQLCipher builds) and foreign key enforcement.
+func OpenSQLiteFile(path string) *sql.DB {
+	return openSQLiteWithKey(path, os.Getenv("DB_ENCRYPTION_KEY"))
+}
+
+func openSQLiteWithKey(path, key string) *sql.DB {
+	return sql.OpenDB(sqliteConnector{dsn: path, driver: &sqlite3.SQLiteDriver{
+		ConnectHook: sqliteConnectHook(key),
+	}})
+}
+
+// sqliteConnectHook prepares a new connection. Pragmas such as key and
+// foreign_keys are per connection, so they can't be set once after opening
+// the pool.
+func sqliteConnectHook(key string) func(*sqlite3.SQLiteConn) error {
+	return func(conn *sqlite3.SQLiteConn) error {
+		// If an encryption key is provided via environment, apply it
+		// immediately after opening. This enables use with SQLCipher
+		// (requires the image/build to be linked against SQLCipher).
+		if key != "" {
+			// Escape single quotes in the key for the PRAGMA statement
+			esc := strings.ReplaceAll(key, "'", "''")
+			if _, err := conn.Exec(fmt.Sprintf("PRAGMA key = '%s';", esc), nil); err != nil {
+				return fmt.Errorf("failed to set database encryption key: %w", err)
+			}
+			// Optional: set compatibility mode for newer SQLCipher versions
+			_, _ = conn.Exec("PRAGMA cipher_compatibility = 4;", nil)
+		}
+
+		// Enable foreign keys
+		_, err := conn.Exec("PRAGMA foreign_keys = ON", nil)
+		return err
+	}
+}
+
+// sqliteConnector opens connections through a driver carrying a ConnectHook.
+type sqliteConnector struct {
+	dsn    string
+	driver *sqlite3.SQLiteDriver
+}
+
+func (c sqliteConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
 }
 
-func createTables(db *sql.DB) error {
-	schema := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		username TEXT UNIQUE NOT NULL,
-		password_hash TEXT NOT NULL,
-		email TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-	);
-
-	CREATE TABLE IF NOT EXISTS promises (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		recipient TEXT NOT NULL,
-		description TEXT NOT NULL,
-		due_date DATETIME,
-		reminder_frequency TEXT,
-		last_reminded_at DATETIME,
-		current_state TEXT NOT NULL DEFAULT 'active',
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS promise_events (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		promise_id INTEGER NOT NULL,
-		state TEXT NOT NULL,
-		reflection_note TEXT,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (promise_id) REFERENCES promises(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS reminders (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		promise_id INTEGER NOT NULL,
-		user_id INTEGER NOT NULL,
-		remind_at DATETIME NOT NULL,
-		offset_minutes INTEGER NOT NULL,
-		is_sent BOOLEAN DEFAULT FALSE,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (promise_id) REFERENCES promises(id) ON DELETE CASCADE,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-	);
-
-	CREATE TABLE IF NOT EXISTS push_subscriptions (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		endpoint TEXT NOT NULL,
-		p256dh TEXT NOT NULL,
-		auth TEXT NOT NULL,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		UNIQUE(user_id, endpoint),
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-	);
-
-	-- Server-side refresh token store for rotating refresh tokens
-	CREATE TABLE IF NOT EXISTS refresh_tokens (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		user_id INTEGER NOT NULL,
-		token_hash TEXT NOT NULL UNIQUE,
-		expires_at DATETIME NOT NULL,
-		ttl_days INTEGER NOT NULL DEFAULT 7,
-		revoked BOOLEAN DEFAULT 0,
-		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
-		FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
-	);
-
-	CREATE INDEX IF NOT EXISTS idx_promises_user_id ON promises(user_id);
-	CREATE INDEX IF NOT EXISTS idx_promise_events_promise_id ON promise_events(promise_id);
-	CREATE INDEX IF NOT EXISTS idx_reminders_user_id ON reminders(user_id);
-	CREATE INDEX IF NOT EXISTS idx_reminders_remind_at ON reminders(remind_at);
-	CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
-	`
-
-	_, err := db.Exec(schema)
-	return err
+func (c sqliteConnector) Driver() driver.Driver {
+	return c.driver
 }
@@ -0,0 +1,189 @@
+package database
+
+import (
+	"bytes"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// FileKind describes what is on disk at a SQLite database path.
+type FileKind int
+
+const (
+	// FileMissing means there is no file, or an empty one, so a new
+	// database will be created.
+	FileMissing FileKind = iota
+	// FilePlaintext is an unencrypted SQLite database.
+	FilePlaintext
+	// FileEncrypted is a file without the SQLite header. SQLCipher
+	// databases start with a random salt instead, so anything else that
+	// isn't SQLite looks the same.
+	FileEncrypted
+)
+
+func (k FileKind) String() string {
+	switch k {
+	case FilePlaintext:
+		return "plaintext"
+	case FileEncrypted:
+		return "encrypted"
+	default:
+		return "missing"
+	}
+}
+
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// DetectSQLiteFile reports whether the database at path is missing,
+// plaintext or encrypted by looking at its header. path may be a file: URI.
+func DetectSQLiteFile(path string) (FileKind, error) {
+	path = strings.TrimPrefix(path, "file:")
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" || path == ":memory:" {
+		return FileMissing, nil
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return FileMissing, nil
+	}
+	if err != nil {
+		return FileMissing, err
+	}
+	defer f.Close()
+
+	header := make([]byte, len(sqliteHeader))
+	n, err := io.ReadFull(f, header)
+	if n == 0 && (err == io.EOF || err == nil) {
+		return FileMissing, nil
+	}
+	if bytes.Equal(header[:n], sqliteHeader) {
+		return FilePlaintext, nil
+	}
+	return FileEncrypted, nil
+}
+
+var (
+	sqlcipherOnce      sync.Once
+	sqlcipherAvailable bool
+)
+
+// SQLCipherAvailable reports whether the linked SQLite library is SQLCipher.
+// Plain SQLite silently ignores PRAGMA key, so this is the only way to know
+// whether DB_ENCRYPTION_KEY has any effect.
+func SQLCipherAvailable() bool {
+	sqlcipherOnce.Do(func() {
+		db := openSQLiteWithKey(":memory:", "")
+		defer db.Close()
+		var version string
+		err := db.QueryRow("PRAGMA cipher_version").Scan(&version)
+		sqlcipherAvailable = err == nil && version != ""
+	})
+	return sqlcipherAvailable
+}
+
+func quoteKey(key string) string {
+	return "'" + strings.ReplaceAll(key, "'", "''") + "'"
+}
+
+// EncryptFile encrypts the plaintext database at path with key. The data is
+// exported into a new encrypted file with sqlcipher_export, verified, and
+// then moved over the original. The server must not be running.
+func EncryptFile(path, key string) error {
+	if key == "" {
+		return errors.New("an encryption key is required")
+	}
+	if !SQLCipherAvailable() {
+		return errors.New("this build of kept was not linked against SQLCipher")
+	}
+	kind, err := DetectSQLiteFile(path)
+	if err != nil {
+		return err
+	}
+	if kind != FilePlaintext {
+		return fmt.Errorf("database at %s is %s, expected a plaintext database", path, kind)
+	}
+
+	tmp := path + ".encrypting"
+	os.Remove(tmp)
+	defer os.Remove(tmp)
+
+	src := openSQLiteWithKey(path, "")
+	err = exportEncrypted(src, tmp, key)
+	src.Close()
+	if err != nil {
+		return fmt.Errorf("exporting encrypted copy: %w", err)
+	}
+
+	if err := verifyKey(tmp, key); err != nil {
+		return fmt.Errorf("encrypted copy could not be verified: %w", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+func exportEncrypted(src *sql.DB, dest, key string) error {
+	// ATTACH and the export must run on the same connection
+	src.SetMaxOpenConns(1)
+	if _, err := src.Exec("ATTACH DATABASE " + quoteKey(dest) + " AS encrypted KEY " + quoteKey(key)); err != nil {
+		return err
+	}
+	var ignored any
+	if err := src.QueryRow("SELECT sqlcipher_export('encrypted')").Scan(&ignored); err != nil {
+		return err
+	}
+	_, err := src.Exec("DETACH DATABASE encrypted")
+	return err
+}
+
+// RekeyFile changes the key of the encrypted database at path from oldKey to
+// newKey with PRAGMA rekey. The server must not be running, and snapshots
+// taken before the rekey still need the old key.
+func RekeyFile(path, oldKey, newKey string) error {
+	if oldKey == "" || newKey == "" {
+		return errors.New("both the current and the new encryption key are required")
+	}
+	if !SQLCipherAvailable() {
+		return errors.New("this build of kept was not linked against SQLCipher")
+	}
+	kind, err := DetectSQLiteFile(path)
+	if err != nil {
+		return err
+	}
+	if kind != FileEncrypted {
+		return fmt.Errorf("database at %s is %s, expected an encrypted database", path, kind)
+	}
+	if err := verifyKey(path, oldKey); err != nil {
+		return fmt.Errorf("current key does not open the database: %w", err)
+	}
+
+	db := openSQLiteWithKey(path, oldKey)
+	db.SetMaxOpenConns(1)
+	_, err = db.Exec("PRAGMA rekey = " + quoteKey(newKey))
+	db.Close()
+	if err != nil {
+		return err
+	}
+	return verifyKey(path, newKey)
+}
+
+// verifyKey checks that key opens the database at path and that it passes an
+// integrity check.
+func verifyKey(path, key string) error {
+	db := openSQLiteWithKey(path, key)
+	defer db.Close()
+	var result string
+	if err := db.QueryRow("PRAGMA integrity_check").Scan(&result); err != nil {
+		return err
+	}
+	if result != "ok" {
+		return errors.New(result)
+	}
+	return nil
+}
@@ -0,0 +1,112 @@
+package database_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"kept/internal/database"
+)
+
+func TestDetectSQLiteFile(t *testing.T) {
+	dir := t.TempDir()
+
+	kind, err := database.DetectSQLiteFile(filepath.Join(dir, "missing.db"))
+	if err != nil || kind != database.FileMissing {
+		t.Fatalf("Expected missing, got %v (%v)", kind, err)
+	}
+
+	empty := filepath.Join(dir, "empty.db")
+	os.WriteFile(empty, nil, 0600)
+	if kind, _ := database.DetectSQLiteFile(empty); kind != database.FileMissing {
+		t.Fatalf("Expected empty file to count as missing, got %v", kind)
+	}
+
+	plain := filepath.Join(dir, "plain.db")
+	db, err := database.Initialize(plain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	if kind, _ := database.DetectSQLiteFile(plain); kind != database.FilePlaintext {
+		t.Fatalf("Expected plaintext, got %v", kind)
+	}
+	if kind, _ := database.DetectSQLiteFile("file:" + plain + "?mode=ro"); kind != database.FilePlaintext {
+		t.Fatalf("Expected plaintext for file: URI, got %v", kind)
+	}
+
+	// SQLCipher files start with a random salt instead of the SQLite header
+	encrypted := filepath.Join(dir, "encrypted.db")
+	os.WriteFile(encrypted, []byte("\x8f\x12random salt and pages"), 0600)
+	if kind, _ := database.DetectSQLiteFile(encrypted); kind != database.FileEncrypted {
+		t.Fatalf("Expected encrypted, got %v", kind)
+	}
+}
+
+func TestOpenExistingDatabaseWithoutKey(t *testing.T) {
+	t.Setenv("DB_ENCRYPTION_KEY", "")
+	path := filepath.Join(t.TempDir(), "kept.db")
+
+	db, err := database.Initialize(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Reopening a plaintext database doesn't need a key
+	db, err = database.Initialize(path)
+	if err != nil {
+		t.Fatalf("Expected plaintext database to reopen without a key: %v", err)
+	}
+	db.Close()
+
+	// An encrypted file without a key is refused with a clear error
+	encrypted := filepath.Join(t.TempDir(), "kept.db")
+	os.WriteFile(encrypted, []byte("\x8f\x12random salt and pages"), 0600)
+	if _, err := database.Open(encrypted); err == nil {
+		t.Fatal("Expected encrypted database to be refused without a key")
+	}
+}
+
+func TestEncryptAndRekey(t *testing.T) {
+	if !database.SQLCipherAvailable() {
+		t.Skip("not built against SQLCipher")
+	}
+	path := filepath.Join(t.TempDir(), "kept.db")
+	t.Setenv("DB_ENCRYPTION_KEY", "")
+	db, err := database.Initialize(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("INSERT INTO users (username, password_hash) VALUES ('alice', 'x')")
+	db.Close()
+
+	if err := database.EncryptFile(path, "first key"); err != nil {
+		t.Fatal(err)
+	}
+	if kind, _ := database.DetectSQLiteFile(path); kind != database.FileEncrypted {
+		t.Fatalf("Expected encrypted file, got %v", kind)
+	}
+
+	if err := database.RekeyFile(path, "wrong key", "second key"); err == nil {
+		t.Fatal("Expected rekey with the wrong key to fail")
+	}
+	if err := database.RekeyFile(path, "first key", "second key"); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("DB_ENCRYPTION_KEY", "first key")
+	if _, err := database.Open(path); err == nil {
+		t.Fatal("Expected the old key to be rejected")
+	}
+	t.Setenv("DB_ENCRYPTION_KEY", "second key")
+	db, err = database.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	var username string
+	if err := db.QueryRow("SELECT username FROM users").Scan(&username); err != nil || username != "alice" {
+		t.Fatalf("Expected data to survive, got %q (%v)", username, err)
+	}
+}
@@ -0,0 +1,22 @@
+package database
+
+import "sync"
+
+var (
+	fts5Once      sync.Once
+	fts5Available bool
+)
+
+// FTS5Available reports whether the linked SQLite library includes the FTS5
+// full-text search extension. go-sqlite3 only compiles it in with the
+// sqlite_fts5 build tag.
+func FTS5Available() bool {
+	fts5Once.Do(func() {
+		db := openSQLiteWithKey(":memory:", "")
+		defer db.Close()
+		var used int
+		err := db.QueryRow("SELECT sqlite_compileoption_used('ENABLE_FTS5')").Scan(&used)
+		fts5Available = err == nil && used == 1
+	})
+	return fts5Available
+}
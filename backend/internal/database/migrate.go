@@ -0,0 +1,331 @@
+package database
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"embed"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+//go:embed migrations
+var migrationFiles embed.FS
+
+var migrationFileRe = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// Migration is a single versioned schema change. Up and Down hold the SQL
+// embedded from migrations/<dialect>/NNNN_name.{up,down}.sql. Both dialects
+// use the same version numbers.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+// MigrationStatus describes a known migration and whether it has been applied.
+type MigrationStatus struct {
+	Migration
+	Applied          bool
+	AppliedAt        time.Time
+	ChecksumMismatch bool
+}
+
+// LoadMigrations returns the embedded migrations for a dialect ordered by
+// version.
+func LoadMigrations(dialect Dialect) ([]Migration, error) {
+	dir := path.Join("migrations", string(dialect))
+	entries, err := fs.ReadDir(migrationFiles, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		m := migrationFileRe.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("unexpected migration file name %q", entry.Name())
+		}
+		version, _ := strconv.Atoi(m[1])
+		content, err := migrationFiles.ReadFile(path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		mig := byVersion[version]
+		if mig == nil {
+			mig = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = mig
+		}
+		if mig.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, mig.Name, m[2])
+		}
+		if m[3] == "up" {
+			mig.Up = string(content)
+			sum := sha256.Sum256(content)
+			mig.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			mig.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Checksum == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no up file", mig.Version, mig.Name)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+func ensureMigrationsTable(db *DB) error {
+	appliedAt := "DATETIME DEFAULT CURRENT_TIMESTAMP"
+	if db.Dialect == Postgres {
+		appliedAt = "TIMESTAMPTZ NOT NULL DEFAULT now()"
+	}
+	_, err := db.Exec(`
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		checksum TEXT NOT NULL,
+		applied_at ` + appliedAt + `
+	)`)
+	return err
+}
+
+func tableExists(db *DB, table string) (bool, error) {
+	query := "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?"
+	if db.Dialect == Postgres {
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?"
+	}
+	var count int
+	err := db.QueryRow(db.Dialect.Rebind(query), table).Scan(&count)
+	return count > 0, err
+}
+
+// columnExists checks if a column exists on a given table (SQLite PRAGMA table_info)
+func columnExists(db *DB, table string, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	var cid int
+	var name string
+	var ctype string
+	var notnull int
+	var dflt sql.NullString
+	var pk int
+
+	for rows.Next() {
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// adoptLegacySchema brings SQLite databases created before versioned
+// migrations up to the baseline schema. Older releases added some columns with
+// ad-hoc migrations, which the baseline's CREATE TABLE IF NOT EXISTS can't do.
+func adoptLegacySchema(db *DB) error {
+	if db.Dialect != SQLite {
+		return nil
+	}
+	tracked, err := tableExists(db, "schema_migrations")
+	if err != nil || tracked {
+		return err
+	}
+	legacy, err := tableExists(db, "promises")
+	if err != nil || !legacy {
+		return err
+	}
+
+	columns := []struct{ table, column, ddl string }{
+		{"promises", "reminder_frequency", "ALTER TABLE promises ADD COLUMN reminder_frequency TEXT"},
+		{"promises", "last_reminded_at", "ALTER TABLE promises ADD COLUMN last_reminded_at DATETIME"},
+		{"users", "email", "ALTER TABLE users ADD COLUMN email TEXT"},
+	}
+	for _, col := range columns {
+		exists, err := columnExists(db, col.table, col.column)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			if _, err := db.Exec(col.ddl); err != nil {
+				return fmt.Errorf("adding %s.%s: %w", col.table, col.column, err)
+			}
+		}
+	}
+	return nil
+}
+
+// MigrationStatuses reports every known migration and whether it has been
+// applied. It does not modify the database beyond creating the tracking table.
+func MigrationStatuses(db *DB) ([]MigrationStatus, error) {
+	migrations, err := LoadMigrations(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT version, checksum, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	type appliedRow struct {
+		checksum  string
+		appliedAt time.Time
+	}
+	applied := map[int]appliedRow{}
+	for rows.Next() {
+		var version int
+		var r appliedRow
+		if err := rows.Scan(&version, &r.checksum, &r.appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = r
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		s := MigrationStatus{Migration: m}
+		if r, ok := applied[m.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = r.appliedAt
+			s.ChecksumMismatch = r.checksum != m.Checksum
+			delete(applied, m.Version)
+		}
+		statuses = append(statuses, s)
+	}
+	if len(applied) > 0 {
+		return nil, fmt.Errorf("database has %d migration(s) applied that this build doesn't know about", len(applied))
+	}
+	return statuses, nil
+}
+
+// PendingMigrations returns the migrations that have not been applied yet.
+func PendingMigrations(db *DB) ([]Migration, error) {
+	statuses, err := MigrationStatuses(db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []Migration
+	for _, s := range statuses {
+		if !s.Applied {
+			pending = append(pending, s.Migration)
+		}
+	}
+	return pending, nil
+}
+
+func verifyChecksums(statuses []MigrationStatus) error {
+	for _, s := range statuses {
+		if s.ChecksumMismatch {
+			return fmt.Errorf("migration %d (%s) was modified after it was applied", s.Version, s.Name)
+		}
+	}
+	return nil
+}
+
+// Migrate applies every pending migration in order, each in its own
+// transaction. It stops at the first failure.
+func Migrate(db *DB) error {
+	if err := adoptLegacySchema(db); err != nil {
+		return fmt.Errorf("adopting existing schema: %w", err)
+	}
+
+	statuses, err := MigrationStatuses(db)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksums(statuses); err != nil {
+		return err
+	}
+
+	for _, s := range statuses {
+		if s.Applied {
+			continue
+		}
+		if err := applyMigration(db, s.Migration, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateDown reverts the most recently applied migrations, newest first.
+func MigrateDown(db *DB, steps int) error {
+	statuses, err := MigrationStatuses(db)
+	if err != nil {
+		return err
+	}
+	if err := verifyChecksums(statuses); err != nil {
+		return err
+	}
+
+	for i := len(statuses) - 1; i >= 0 && steps > 0; i-- {
+		if !statuses[i].Applied {
+			continue
+		}
+		if err := applyMigration(db, statuses[i].Migration, false); err != nil {
+			return err
+		}
+		steps--
+	}
+	return nil
+}
+
+func applyMigration(db *DB, m Migration, up bool) error {
+	direction, script := "up", m.Up
+	if !up {
+		direction, script = "down", m.Down
+		if script == "" {
+			return fmt.Errorf("migration %d (%s) can't be reverted: no down file", m.Version, m.Name)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %d (%s) %s: %w", m.Version, m.Name, direction, err)
+	}
+
+	if up {
+		_, err = tx.Exec(
+			db.Dialect.Rebind("INSERT INTO schema_migrations (version, name, checksum) VALUES (?, ?, ?)"),
+			m.Version, m.Name, m.Checksum,
+		)
+	} else {
+		_, err = tx.Exec(db.Dialect.Rebind("DELETE FROM schema_migrations WHERE version = ?"), m.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,152 @@
+package database_test
+
+import (
+	"path/filepath"
+	"testing"
+
+	"kept/internal/database"
+)
+
+func TestMigrateAdoptsLegacyDatabase(t *testing.T) {
+	db, err := database.Open(filepath.Join(t.TempDir(), "kept.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Schema as created by releases before reminder frequencies and emails
+	_, err = db.Exec(`
+	CREATE TABLE users (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		username TEXT UNIQUE NOT NULL,
+		password_hash TEXT NOT NULL,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE promises (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		user_id INTEGER NOT NULL,
+		recipient TEXT NOT NULL,
+		description TEXT NOT NULL,
+		due_date DATETIME,
+		current_state TEXT NOT NULL DEFAULT 'active',
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+		updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE TABLE promise_events (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		promise_id INTEGER NOT NULL,
+		state TEXT NOT NULL,
+		reflection_note TEXT,
+		created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+	);
+	INSERT INTO users (username, password_hash) VALUES ('migrator', 'x');
+	INSERT INTO promises (user_id, recipient, description, current_state) VALUES (1, 'Alice', 'desc', 'postponed');
+	INSERT INTO promises (user_id, recipient, description) VALUES (1, ' alice', 'another');
+	INSERT INTO promise_events (promise_id, state, reflection_note) VALUES (1, 'postponed', 'postponed before migration');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	// Verify promise state has been updated
+	var state string
+	if err := db.QueryRow("SELECT current_state FROM promises WHERE id = 1").Scan(&state); err != nil {
+		t.Fatal(err)
+	}
+	if state != "kept" {
+		t.Fatalf("Expected promise state 'kept', got '%s'", state)
+	}
+
+	// Verify events were normalized to 'kept'
+	var evState string
+	if err := db.QueryRow("SELECT state FROM promise_events WHERE promise_id = 1 LIMIT 1").Scan(&evState); err != nil {
+		t.Fatal(err)
+	}
+	if evState != "kept" {
+		t.Fatalf("Expected event state 'kept', got '%s'", evState)
+	}
+
+	// Recipients were grouped into contacts, ignoring case and spaces
+	var contacts, linked int
+	if err := db.QueryRow("SELECT COUNT(*) FROM contacts").Scan(&contacts); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow("SELECT COUNT(DISTINCT contact_id) FROM promises WHERE contact_id IS NOT NULL").Scan(&linked); err != nil {
+		t.Fatal(err)
+	}
+	if contacts != 1 || linked != 1 {
+		t.Fatalf("Expected both promises linked to one contact, got %d contacts and %d linked", contacts, linked)
+	}
+
+	// Columns added by the old ad-hoc migrations are present
+	if _, err := db.Exec("UPDATE promises SET reminder_frequency = 'daily', last_reminded_at = CURRENT_TIMESTAMP"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("UPDATE users SET email = 'a@example.com'"); err != nil {
+		t.Fatal(err)
+	}
+
+	pending, err := database.PendingMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("Expected no pending migrations, got %d", len(pending))
+	}
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "kept.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	migrations, err := database.LoadMigrations(db.Dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := database.MigrateDown(db, len(migrations)); err != nil {
+		t.Fatal(err)
+	}
+	var tables int
+	db.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'promises'").Scan(&tables)
+	if tables != 0 {
+		t.Fatal("Expected promises table to be dropped")
+	}
+
+	pending, err := database.PendingMigrations(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(migrations) {
+		t.Fatalf("Expected %d pending migrations, got %d", len(migrations), len(pending))
+	}
+
+	if err := database.Migrate(db); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO users (username, password_hash) VALUES ('again', 'x')"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMigrateRejectsModifiedMigration(t *testing.T) {
+	db, err := database.Initialize(filepath.Join(t.TempDir(), "kept.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if _, err := db.Exec("UPDATE schema_migrations SET checksum = 'tampered' WHERE version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := database.Migrate(db); err == nil {
+		t.Fatal("Expected checksum mismatch to fail migration")
+	}
+}
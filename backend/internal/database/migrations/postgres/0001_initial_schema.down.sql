@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS caldav_objects;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS push_subscriptions;
+DROP TABLE IF EXISTS reminders;
+DROP TABLE IF EXISTS promise_events;
+DROP TABLE IF EXISTS promises;
+DROP TABLE IF EXISTS users;
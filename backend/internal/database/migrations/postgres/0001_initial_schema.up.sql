@@ -0,0 +1,75 @@
+CREATE TABLE users (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	username TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	email TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE promises (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	recipient TEXT NOT NULL,
+	description TEXT NOT NULL,
+	due_date TIMESTAMPTZ,
+	reminder_frequency TEXT,
+	last_reminded_at TIMESTAMPTZ,
+	current_state TEXT NOT NULL DEFAULT 'active',
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE promise_events (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	promise_id BIGINT NOT NULL REFERENCES promises(id) ON DELETE CASCADE,
+	state TEXT NOT NULL,
+	reflection_note TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE reminders (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	promise_id BIGINT NOT NULL REFERENCES promises(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	remind_at TIMESTAMPTZ NOT NULL,
+	offset_minutes INTEGER NOT NULL,
+	is_sent BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE push_subscriptions (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	endpoint TEXT NOT NULL,
+	p256dh TEXT NOT NULL,
+	auth TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE(user_id, endpoint)
+);
+
+-- Server-side refresh token store for rotating refresh tokens
+CREATE TABLE refresh_tokens (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	expires_at TIMESTAMPTZ NOT NULL,
+	ttl_days INTEGER NOT NULL DEFAULT 7,
+	revoked BOOLEAN NOT NULL DEFAULT FALSE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- Resource names and UIDs chosen by CalDAV clients for promises they created
+CREATE TABLE caldav_objects (
+	promise_id BIGINT PRIMARY KEY REFERENCES promises(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	uid TEXT NOT NULL,
+	name TEXT NOT NULL,
+	UNIQUE(user_id, uid),
+	UNIQUE(user_id, name)
+);
+
+CREATE INDEX idx_promises_user_id ON promises(user_id);
+CREATE INDEX idx_promise_events_promise_id ON promise_events(promise_id);
+CREATE INDEX idx_reminders_user_id ON reminders(user_id);
+CREATE INDEX idx_reminders_remind_at ON reminders(remind_at);
+CREATE INDEX idx_refresh_tokens_user_id ON refresh_tokens(user_id);
@@ -0,0 +1,2 @@
+-- Converted rows can't be told apart from promises that were really kept,
+-- so there is nothing to undo.
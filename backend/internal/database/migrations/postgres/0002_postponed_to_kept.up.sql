@@ -0,0 +1,3 @@
+-- "postponed" used to be a stored state. It is now recorded as "kept".
+UPDATE promises SET current_state = 'kept', updated_at = CURRENT_TIMESTAMP WHERE current_state = 'postponed';
+UPDATE promise_events SET state = 'kept' WHERE state = 'postponed';
@@ -0,0 +1,11 @@
+-- Per-user data keys for application-level field encryption, wrapped by a
+-- master key from FIELD_ENCRYPTION_KEYS. The newest key of a user is current.
+CREATE TABLE data_keys (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	master_version INTEGER NOT NULL,
+	wrapped_key TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_data_keys_user_id ON data_keys(user_id);
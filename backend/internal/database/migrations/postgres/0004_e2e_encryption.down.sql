@@ -0,0 +1,4 @@
+ALTER TABLE promise_events DROP COLUMN envelope;
+ALTER TABLE promises DROP COLUMN envelope;
+ALTER TABLE promises DROP COLUMN encrypted;
+ALTER TABLE users DROP COLUMN e2e_key_check;
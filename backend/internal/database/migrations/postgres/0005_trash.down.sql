@@ -0,0 +1,3 @@
+DELETE FROM promises WHERE deleted_at IS NOT NULL;
+DROP INDEX IF EXISTS idx_promises_deleted_at;
+ALTER TABLE promises DROP COLUMN deleted_at;
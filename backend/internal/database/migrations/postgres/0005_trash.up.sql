@@ -0,0 +1,5 @@
+-- Deleting a promise moves it to the trash. Trashed promises keep their
+-- events and reminders until they are purged.
+ALTER TABLE promises ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE INDEX idx_promises_deleted_at ON promises(deleted_at);
@@ -0,0 +1,14 @@
+-- Edits to a promise's content, due date or reminder frequency, one row per
+-- changed field. actor says what made the edit and actor_user_id who.
+CREATE TABLE promise_revisions (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	promise_id BIGINT NOT NULL REFERENCES promises(id) ON DELETE CASCADE,
+	field TEXT NOT NULL,
+	old_value TEXT,
+	new_value TEXT,
+	actor TEXT NOT NULL,
+	actor_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_promise_revisions_promise_id ON promise_revisions(promise_id);
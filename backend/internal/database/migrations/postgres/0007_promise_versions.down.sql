@@ -0,0 +1 @@
+ALTER TABLE promises DROP COLUMN version;
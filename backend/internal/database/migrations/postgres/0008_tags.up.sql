@@ -0,0 +1,17 @@
+-- User-defined labels for promises. A promise can have any number of tags.
+CREATE TABLE tags (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	color TEXT NOT NULL,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE (user_id, name)
+);
+
+CREATE TABLE promise_tags (
+	promise_id BIGINT NOT NULL REFERENCES promises(id) ON DELETE CASCADE,
+	tag_id BIGINT NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+	PRIMARY KEY (promise_id, tag_id)
+);
+
+CREATE INDEX idx_promise_tags_tag_id ON promise_tags(tag_id);
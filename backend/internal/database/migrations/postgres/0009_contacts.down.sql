@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_promises_contact_id;
+ALTER TABLE promises DROP COLUMN contact_id;
+DROP TABLE IF EXISTS contacts;
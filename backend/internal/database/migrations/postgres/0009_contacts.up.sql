@@ -0,0 +1,33 @@
+-- People promises are made to. Aliases are other spellings of the name, as a
+-- JSON array. Existing recipients are grouped into one contact per user and
+-- case-insensitive name; recipients encrypted at rest can't be compared in
+-- SQL and are linked by the server at startup instead.
+CREATE TABLE contacts (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	aliases TEXT,
+	email TEXT,
+	phone TEXT,
+	notes TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_contacts_user_id ON contacts(user_id);
+
+ALTER TABLE promises ADD COLUMN contact_id BIGINT REFERENCES contacts(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_promises_contact_id ON promises(contact_id);
+
+INSERT INTO contacts (user_id, name, created_at, updated_at)
+SELECT user_id, MIN(TRIM(recipient)), MIN(created_at), MIN(created_at)
+FROM promises
+WHERE encrypted = FALSE AND TRIM(recipient) <> '' AND recipient NOT LIKE 'enc:%'
+GROUP BY user_id, LOWER(TRIM(recipient));
+
+UPDATE promises SET contact_id = (
+	SELECT MIN(c.id) FROM contacts c
+	WHERE c.user_id = promises.user_id AND LOWER(c.name) = LOWER(TRIM(promises.recipient))
+)
+WHERE encrypted = FALSE AND TRIM(recipient) <> '' AND recipient NOT LIKE 'enc:%';
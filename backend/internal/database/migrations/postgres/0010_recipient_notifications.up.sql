@@ -0,0 +1,4 @@
+-- Recipients can opt in to emails about a promise made to them, with a link
+-- to confirm or dispute that it was kept.
+ALTER TABLE promises ADD COLUMN recipient_email TEXT;
+ALTER TABLE promises ADD COLUMN notify_recipient BOOLEAN NOT NULL DEFAULT FALSE;
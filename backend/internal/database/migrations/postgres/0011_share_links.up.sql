@@ -0,0 +1,14 @@
+-- Read-only public links to a promise. Only a hash of the token is stored.
+-- redact lists the fields hidden from viewers, as a JSON array.
+CREATE TABLE share_links (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	promise_id BIGINT NOT NULL REFERENCES promises(id) ON DELETE CASCADE,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	token_hash TEXT NOT NULL UNIQUE,
+	redact TEXT,
+	expires_at TIMESTAMPTZ,
+	revoked_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_share_links_promise_id ON share_links(promise_id);
@@ -0,0 +1,3 @@
+ALTER TABLE promise_events DROP COLUMN actor_user_id;
+ALTER TABLE promises DROP COLUMN partner_visible;
+DROP TABLE IF EXISTS partnerships;
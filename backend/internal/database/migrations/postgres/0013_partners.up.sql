@@ -0,0 +1,19 @@
+-- Accountability partners. user_id asks partner_id to hold them
+-- accountable; the partnership is pending until accepted_at is set.
+-- Partners see the promises marked partner_visible and can nudge them.
+CREATE TABLE partnerships (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	partner_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	accepted_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	UNIQUE (user_id, partner_id)
+);
+
+CREATE INDEX idx_partnerships_partner_id ON partnerships(partner_id);
+
+ALTER TABLE promises ADD COLUMN partner_visible BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Who caused an event, for events someone other than the owner causes, such
+-- as nudges.
+ALTER TABLE promise_events ADD COLUMN actor_user_id BIGINT REFERENCES users(id) ON DELETE SET NULL;
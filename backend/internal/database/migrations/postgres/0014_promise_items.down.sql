@@ -0,0 +1,2 @@
+ALTER TABLE promises DROP COLUMN keep_when_done;
+DROP TABLE IF EXISTS promise_items;
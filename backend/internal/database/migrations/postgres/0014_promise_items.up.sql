@@ -0,0 +1,17 @@
+-- Checklist items inside a promise, ordered by position. An item is done
+-- once done_at is set. With keep_when_done, ticking off the last item keeps
+-- the promise.
+CREATE TABLE promise_items (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	promise_id BIGINT NOT NULL REFERENCES promises(id) ON DELETE CASCADE,
+	position INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	due_date TIMESTAMPTZ,
+	done_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_promise_items_promise_id ON promise_items(promise_id, position);
+
+ALTER TABLE promises ADD COLUMN keep_when_done BOOLEAN NOT NULL DEFAULT FALSE;
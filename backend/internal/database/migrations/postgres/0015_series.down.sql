@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_promises_series_id;
+ALTER TABLE promises DROP COLUMN series_id;
+DROP TABLE IF EXISTS promise_series;
@@ -0,0 +1,22 @@
+-- Recurring promises. Each series has one current instance; once it is
+-- resolved, trashed or due, the workers create the next one from it, due on
+-- the next occurrence after starts_at (every interval_count days, weeks, months
+-- or years), until it passes ends_at or is stopped.
+CREATE TABLE promise_series (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	workspace_id BIGINT REFERENCES workspaces(id) ON DELETE CASCADE,
+	frequency TEXT NOT NULL,
+	interval_count INTEGER NOT NULL DEFAULT 1,
+	starts_at TIMESTAMPTZ NOT NULL,
+	ends_at TIMESTAMPTZ,
+	current_promise_id BIGINT REFERENCES promises(id) ON DELETE SET NULL,
+	stopped_at TIMESTAMPTZ,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_promise_series_user_id ON promise_series(user_id);
+
+ALTER TABLE promises ADD COLUMN series_id BIGINT REFERENCES promise_series(id) ON DELETE SET NULL;
+
+CREATE INDEX idx_promises_series_id ON promises(series_id);
@@ -0,0 +1,18 @@
+-- Reusable starting points for promises. The description may contain
+-- {{placeholders}} filled in when a promise is created from the template.
+-- due_offset_minutes is counted from then, and reminder_offsets is a JSON
+-- array of minutes before the due date.
+CREATE TABLE promise_templates (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	recipient TEXT NOT NULL,
+	description TEXT NOT NULL,
+	due_offset_minutes INTEGER,
+	reminder_frequency TEXT,
+	reminder_offsets TEXT,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+	updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_promise_templates_user_id ON promise_templates(user_id);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_promise_events_search;
+ALTER TABLE promise_events DROP COLUMN search;
+DROP INDEX IF EXISTS idx_promises_search;
+ALTER TABLE promises DROP COLUMN search;
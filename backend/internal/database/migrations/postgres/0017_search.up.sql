@@ -0,0 +1,15 @@
+-- Full-text search over promises and reflection notes. Recipients weigh
+-- more than descriptions. The 'simple' configuration doesn't stem, so
+-- search behaves the same for every language and like SQLite's FTS5.
+ALTER TABLE promises ADD COLUMN search tsvector GENERATED ALWAYS AS (
+	setweight(to_tsvector('simple', COALESCE(recipient, '')), 'A') ||
+	setweight(to_tsvector('simple', COALESCE(description, '')), 'B')
+) STORED;
+
+CREATE INDEX idx_promises_search ON promises USING GIN (search);
+
+ALTER TABLE promise_events ADD COLUMN search tsvector GENERATED ALWAYS AS (
+	to_tsvector('simple', COALESCE(reflection_note, ''))
+) STORED;
+
+CREATE INDEX idx_promise_events_search ON promise_events USING GIN (search);
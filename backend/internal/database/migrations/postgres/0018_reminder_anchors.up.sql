@@ -0,0 +1,4 @@
+-- What a reminder's time is based on: 'due' (offset_minutes before the due
+-- date), 'created' or 'state_change' (offset_minutes after the promise was
+-- made or its state last changed), or 'absolute' (remind_at as given).
+ALTER TABLE reminders ADD COLUMN anchor TEXT NOT NULL DEFAULT 'due';
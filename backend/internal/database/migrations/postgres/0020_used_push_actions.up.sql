@@ -0,0 +1,8 @@
+-- IDs of push action tokens that were used, so each works only once. Rows
+-- are purged once the token has expired.
+CREATE TABLE used_push_actions (
+	jti TEXT PRIMARY KEY,
+	expires_at TIMESTAMPTZ NOT NULL
+);
+
+CREATE INDEX idx_used_push_actions_expires_at ON used_push_actions(expires_at);
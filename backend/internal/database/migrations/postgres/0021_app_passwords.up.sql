@@ -0,0 +1,11 @@
+-- Passwords for CalDAV clients, so task apps don't need the account
+-- password. Only a hash of each is stored.
+CREATE TABLE app_passwords (
+	id BIGINT GENERATED BY DEFAULT AS IDENTITY PRIMARY KEY,
+	user_id BIGINT NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+	name TEXT NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX idx_app_passwords_user_id ON app_passwords(user_id);
@@ -0,0 +1,85 @@
+-- Baseline schema. Tables use IF NOT EXISTS so databases created before
+-- versioned migrations existed can adopt this version without changes.
+CREATE TABLE IF NOT EXISTS users (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	username TEXT UNIQUE NOT NULL,
+	password_hash TEXT NOT NULL,
+	email TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS promises (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	recipient TEXT NOT NULL,
+	description TEXT NOT NULL,
+	due_date DATETIME,
+	reminder_frequency TEXT,
+	last_reminded_at DATETIME,
+	current_state TEXT NOT NULL DEFAULT 'active',
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS promise_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	promise_id INTEGER NOT NULL,
+	state TEXT NOT NULL,
+	reflection_note TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (promise_id) REFERENCES promises(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS reminders (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	promise_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	remind_at DATETIME NOT NULL,
+	offset_minutes INTEGER NOT NULL,
+	is_sent BOOLEAN DEFAULT FALSE,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (promise_id) REFERENCES promises(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS push_subscriptions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	endpoint TEXT NOT NULL,
+	p256dh TEXT NOT NULL,
+	auth TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE(user_id, endpoint),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Server-side refresh token store for rotating refresh tokens
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	expires_at DATETIME NOT NULL,
+	ttl_days INTEGER NOT NULL DEFAULT 7,
+	revoked BOOLEAN DEFAULT 0,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Resource names and UIDs chosen by CalDAV clients for promises they created
+CREATE TABLE IF NOT EXISTS caldav_objects (
+	promise_id INTEGER PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	uid TEXT NOT NULL,
+	name TEXT NOT NULL,
+	UNIQUE(user_id, uid),
+	UNIQUE(user_id, name),
+	FOREIGN KEY (promise_id) REFERENCES promises(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_promises_user_id ON promises(user_id);
+CREATE INDEX IF NOT EXISTS idx_promise_events_promise_id ON promise_events(promise_id);
+CREATE INDEX IF NOT EXISTS idx_reminders_user_id ON reminders(user_id);
+CREATE INDEX IF NOT EXISTS idx_reminders_remind_at ON reminders(remind_at);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON refresh_tokens(user_id);
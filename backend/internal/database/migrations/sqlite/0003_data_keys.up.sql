@@ -0,0 +1,12 @@
+-- Per-user data keys for application-level field encryption, wrapped by a
+-- master key from FIELD_ENCRYPTION_KEYS. The newest key of a user is current.
+CREATE TABLE IF NOT EXISTS data_keys (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	master_version INTEGER NOT NULL,
+	wrapped_key TEXT NOT NULL,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_keys_user_id ON data_keys(user_id);
@@ -0,0 +1,7 @@
+-- Opt-in end-to-end encryption. The client encrypts promise content with a
+-- key derived from a passphrase; the server only stores the ciphertext
+-- envelopes and a key-check blob the client uses to verify the passphrase.
+ALTER TABLE users ADD COLUMN e2e_key_check TEXT;
+ALTER TABLE promises ADD COLUMN encrypted BOOLEAN NOT NULL DEFAULT 0;
+ALTER TABLE promises ADD COLUMN envelope TEXT;
+ALTER TABLE promise_events ADD COLUMN envelope TEXT;
@@ -0,0 +1,16 @@
+-- Edits to a promise's content, due date or reminder frequency, one row per
+-- changed field. actor says what made the edit and actor_user_id who.
+CREATE TABLE IF NOT EXISTS promise_revisions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	promise_id INTEGER NOT NULL,
+	field TEXT NOT NULL,
+	old_value TEXT,
+	new_value TEXT,
+	actor TEXT NOT NULL,
+	actor_user_id INTEGER,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (promise_id) REFERENCES promises(id) ON DELETE CASCADE,
+	FOREIGN KEY (actor_user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_promise_revisions_promise_id ON promise_revisions(promise_id);
@@ -0,0 +1,3 @@
+-- Every write to a promise bumps its version, which the API exposes as the
+-- ETag for optimistic concurrency control.
+ALTER TABLE promises ADD COLUMN version INTEGER NOT NULL DEFAULT 1;
@@ -0,0 +1,36 @@
+-- People promises are made to. Aliases are other spellings of the name, as a
+-- JSON array. Existing recipients are grouped into one contact per user and
+-- case-insensitive name; recipients encrypted at rest can't be compared in
+-- SQL and are linked by the server at startup instead.
+CREATE TABLE IF NOT EXISTS contacts (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	aliases TEXT,
+	email TEXT,
+	phone TEXT,
+	notes TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_contacts_user_id ON contacts(user_id);
+
+-- No REFERENCES clause: SQLite can't drop a column with a foreign key, so
+-- deleting a contact unlinks its promises explicitly.
+ALTER TABLE promises ADD COLUMN contact_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_promises_contact_id ON promises(contact_id);
+
+INSERT INTO contacts (user_id, name, created_at, updated_at)
+SELECT user_id, MIN(TRIM(recipient)), MIN(created_at), MIN(created_at)
+FROM promises
+WHERE encrypted = 0 AND TRIM(recipient) <> '' AND recipient NOT LIKE 'enc:%'
+GROUP BY user_id, LOWER(TRIM(recipient));
+
+UPDATE promises SET contact_id = (
+	SELECT MIN(c.id) FROM contacts c
+	WHERE c.user_id = promises.user_id AND LOWER(c.name) = LOWER(TRIM(promises.recipient))
+)
+WHERE encrypted = 0 AND TRIM(recipient) <> '' AND recipient NOT LIKE 'enc:%';
@@ -0,0 +1,2 @@
+ALTER TABLE promises DROP COLUMN notify_recipient;
+ALTER TABLE promises DROP COLUMN recipient_email;
@@ -0,0 +1,16 @@
+-- Read-only public links to a promise. Only a hash of the token is stored.
+-- redact lists the fields hidden from viewers, as a JSON array.
+CREATE TABLE IF NOT EXISTS share_links (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	promise_id INTEGER NOT NULL,
+	user_id INTEGER NOT NULL,
+	token_hash TEXT NOT NULL UNIQUE,
+	redact TEXT,
+	expires_at DATETIME,
+	revoked_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (promise_id) REFERENCES promises(id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_share_links_promise_id ON share_links(promise_id);
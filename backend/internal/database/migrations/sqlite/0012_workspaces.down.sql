@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_promises_workspace_id;
+ALTER TABLE promises DROP COLUMN workspace_id;
+DROP TABLE IF EXISTS workspace_invitations;
+DROP TABLE IF EXISTS workspace_members;
+DROP TABLE IF EXISTS workspaces;
@@ -0,0 +1,21 @@
+-- Accountability partners. user_id asks partner_id to hold them
+-- accountable; the partnership is pending until accepted_at is set.
+-- Partners see the promises marked partner_visible and can nudge them.
+CREATE TABLE IF NOT EXISTS partnerships (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	partner_id INTEGER NOT NULL,
+	accepted_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	UNIQUE (user_id, partner_id),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (partner_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_partnerships_partner_id ON partnerships(partner_id);
+
+ALTER TABLE promises ADD COLUMN partner_visible BOOLEAN NOT NULL DEFAULT 0;
+
+-- Who caused an event, for events someone other than the owner causes, such
+-- as nudges. No REFERENCES clause so the column can be dropped again.
+ALTER TABLE promise_events ADD COLUMN actor_user_id INTEGER;
@@ -0,0 +1,18 @@
+-- Checklist items inside a promise, ordered by position. An item is done
+-- once done_at is set. With keep_when_done, ticking off the last item keeps
+-- the promise.
+CREATE TABLE IF NOT EXISTS promise_items (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	promise_id INTEGER NOT NULL,
+	position INTEGER NOT NULL,
+	title TEXT NOT NULL,
+	due_date DATETIME,
+	done_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (promise_id) REFERENCES promises(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_promise_items_promise_id ON promise_items(promise_id, position);
+
+ALTER TABLE promises ADD COLUMN keep_when_done BOOLEAN NOT NULL DEFAULT 0;
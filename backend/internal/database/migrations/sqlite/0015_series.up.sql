@@ -0,0 +1,26 @@
+-- Recurring promises. Each series has one current instance; once it is
+-- resolved, trashed or due, the workers create the next one from it, due on
+-- the next occurrence after starts_at (every interval_count days, weeks, months
+-- or years), until it passes ends_at or is stopped.
+CREATE TABLE IF NOT EXISTS promise_series (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	workspace_id INTEGER,
+	frequency TEXT NOT NULL,
+	interval_count INTEGER NOT NULL DEFAULT 1,
+	starts_at DATETIME NOT NULL,
+	ends_at DATETIME,
+	current_promise_id INTEGER,
+	stopped_at DATETIME,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+	FOREIGN KEY (workspace_id) REFERENCES workspaces(id) ON DELETE CASCADE,
+	FOREIGN KEY (current_promise_id) REFERENCES promises(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_promise_series_user_id ON promise_series(user_id);
+
+-- No REFERENCES clause so the column can be dropped again.
+ALTER TABLE promises ADD COLUMN series_id INTEGER;
+
+CREATE INDEX IF NOT EXISTS idx_promises_series_id ON promises(series_id);
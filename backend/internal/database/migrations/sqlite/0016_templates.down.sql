@@ -0,0 +1 @@
+DROP TABLE IF EXISTS promise_templates;
@@ -0,0 +1,19 @@
+-- Reusable starting points for promises. The description may contain
+-- {{placeholders}} filled in when a promise is created from the template.
+-- due_offset_minutes is counted from then, and reminder_offsets is a JSON
+-- array of minutes before the due date.
+CREATE TABLE IF NOT EXISTS promise_templates (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	name TEXT NOT NULL,
+	recipient TEXT NOT NULL,
+	description TEXT NOT NULL,
+	due_offset_minutes INTEGER,
+	reminder_frequency TEXT,
+	reminder_offsets TEXT,
+	created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_promise_templates_user_id ON promise_templates(user_id);
@@ -0,0 +1,8 @@
+DROP TRIGGER IF EXISTS promises_fts_insert;
+DROP TRIGGER IF EXISTS promises_fts_update;
+DROP TRIGGER IF EXISTS promises_fts_delete;
+DROP TRIGGER IF EXISTS notes_fts_insert;
+DROP TRIGGER IF EXISTS notes_fts_update;
+DROP TRIGGER IF EXISTS notes_fts_delete;
+DROP TABLE IF EXISTS promises_fts;
+DROP TABLE IF EXISTS notes_fts;
@@ -0,0 +1,10 @@
+-- Full-text search needs SQLite's FTS5 extension, which not every build
+-- includes, so its tables and triggers are created by the server at startup
+-- instead (see sqlstore.EnsureSearchIndex). Without FTS5, search falls back
+-- to matching in the server.
+--
+-- The index is derived from promises and notes, so it lives outside the
+-- versioned schema: `kept-server migrate status` doesn't list it, and the
+-- server builds it at startup even when RUN_MIGRATIONS=false. The down
+-- migration still drops it, so reverting past this point leaves no FTS
+-- tables behind.
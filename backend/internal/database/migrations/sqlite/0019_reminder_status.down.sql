@@ -0,0 +1,3 @@
+ALTER TABLE reminders ADD COLUMN is_sent BOOLEAN DEFAULT FALSE;
+UPDATE reminders SET is_sent = TRUE WHERE status = 'sent';
+ALTER TABLE reminders DROP COLUMN status;
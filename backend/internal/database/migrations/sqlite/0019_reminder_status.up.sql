@@ -0,0 +1,8 @@
+-- Reminders are 'pending' until they go off, then 'sent', or 'failed' if the
+-- push reached nobody. Reminders about promises that are resolved or in the
+-- trash are 'cancelled', and pending again once the promise is reopened.
+ALTER TABLE reminders ADD COLUMN status TEXT NOT NULL DEFAULT 'pending';
+UPDATE reminders SET status = 'sent' WHERE is_sent = TRUE;
+UPDATE reminders SET status = 'cancelled'
+WHERE status = 'pending' AND promise_id IN (SELECT id FROM promises WHERE current_state <> 'active' OR deleted_at IS NOT NULL);
+ALTER TABLE reminders DROP COLUMN is_sent;
@@ -0,0 +1,230 @@
+// Package fieldcrypt encrypts individual database values with envelope
+// encryption. Each user has data keys that encrypt their values with
+// AES-256-GCM; the data keys themselves are stored wrapped (encrypted) by a
+// versioned master key that only lives in the environment.
+//
+// Rotating the master key means adding a new version and rewrapping the data
+// keys, which is cheap. Rotating a data key means creating a new one and
+// re-encrypting the rows that used the old one.
+package fieldcrypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Prefix marks an encrypted value. It is followed by the data key ID, a
+// colon and the base64 nonce and ciphertext.
+const Prefix = "enc:v1:"
+
+var (
+	// ErrUnknownMasterKey is returned when a data key was wrapped with a
+	// master key version that isn't configured.
+	ErrUnknownMasterKey = errors.New("master key version not configured")
+	// ErrMalformed is returned for values that carry the prefix but can't be
+	// parsed.
+	ErrMalformed = errors.New("malformed encrypted value")
+)
+
+// Keyring holds the master keys by version. The highest version is current
+// and used to wrap new data keys; older versions can still unwrap.
+type Keyring struct {
+	keys    map[int][]byte
+	current int
+}
+
+// ParseKeyring parses a comma-separated list of version:base64key pairs,
+// for example "2:q6Jz...,1:Xb9c...". Keys must be 32 bytes.
+func ParseKeyring(spec string) (*Keyring, error) {
+	k := &Keyring{keys: map[int][]byte{}}
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		v, encoded, ok := strings.Cut(part, ":")
+		version, err := strconv.Atoi(v)
+		if !ok || err != nil || version < 1 {
+			return nil, fmt.Errorf("invalid master key entry %q: expected version:base64key", part)
+		}
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("master key version %d must be 32 bytes of base64", version)
+		}
+		if _, dup := k.keys[version]; dup {
+			return nil, fmt.Errorf("master key version %d is listed twice", version)
+		}
+		k.keys[version] = key
+		k.current = max(k.current, version)
+	}
+	if len(k.keys) == 0 {
+		return nil, errors.New("no master keys given")
+	}
+	return k, nil
+}
+
+// KeyringFromEnv reads FIELD_ENCRYPTION_KEYS. It returns nil if the variable
+// is unset, which leaves field encryption off.
+func KeyringFromEnv() (*Keyring, error) {
+	spec := os.Getenv("FIELD_ENCRYPTION_KEYS")
+	if spec == "" {
+		return nil, nil
+	}
+	return ParseKeyring(spec)
+}
+
+// Current returns the version new data keys are wrapped with.
+func (k *Keyring) Current() int {
+	return k.current
+}
+
+// Versions returns the configured master key versions, oldest first.
+func (k *Keyring) Versions() []int {
+	versions := make([]int, 0, len(k.keys))
+	for v := range k.keys {
+		versions = append(versions, v)
+	}
+	sort.Ints(versions)
+	return versions
+}
+
+// Has reports whether a master key version is configured.
+func (k *Keyring) Has(version int) bool {
+	_, ok := k.keys[version]
+	return ok
+}
+
+// NewDataKey returns a random 256-bit data key.
+func NewDataKey() ([]byte, error) {
+	key := make([]byte, 32)
+	_, err := rand.Read(key)
+	return key, err
+}
+
+// Wrap encrypts a data key with the current master key. userID is bound to
+// the result so a wrapped key can't be moved to another user.
+func (k *Keyring) Wrap(userID int, dataKey []byte) (version int, wrapped string, err error) {
+	sealed, err := seal(k.keys[k.current], dataKey, wrapAAD(userID))
+	if err != nil {
+		return 0, "", err
+	}
+	return k.current, base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Unwrap decrypts a data key wrapped with the given master key version.
+func (k *Keyring) Unwrap(userID, version int, wrapped string) ([]byte, error) {
+	master, ok := k.keys[version]
+	if !ok {
+		return nil, fmt.Errorf("%w: %d", ErrUnknownMasterKey, version)
+	}
+	sealed, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, ErrMalformed
+	}
+	return open(master, sealed, wrapAAD(userID))
+}
+
+func wrapAAD(userID int) []byte {
+	return []byte("kept data key:" + strconv.Itoa(userID))
+}
+
+// Encrypt seals plaintext with a data key. field names what the value is
+// (for example "promises.description") and, with userID, is authenticated so
+// ciphertext can't be copied between users or columns. Empty strings are
+// left as they are.
+func Encrypt(dataKey []byte, keyID, userID int, field, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	sealed, err := seal(dataKey, []byte(plaintext), valueAAD(userID, field))
+	if err != nil {
+		return "", err
+	}
+	return Prefix + strconv.Itoa(keyID) + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Decrypt opens a value produced by Encrypt with the data key it names.
+func Decrypt(dataKey []byte, userID int, field, value string) (string, error) {
+	_, payload, err := parse(value)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := open(dataKey, payload, valueAAD(userID, field))
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// IsEncrypted reports whether value was produced by Encrypt. Values written
+// before encryption was enabled are plaintext.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, Prefix)
+}
+
+// KeyID returns the data key ID an encrypted value was sealed with.
+func KeyID(value string) (int, error) {
+	id, _, err := parse(value)
+	return id, err
+}
+
+func parse(value string) (int, []byte, error) {
+	rest, ok := strings.CutPrefix(value, Prefix)
+	if !ok {
+		return 0, nil, ErrMalformed
+	}
+	idPart, encoded, ok := strings.Cut(rest, ":")
+	id, err := strconv.Atoi(idPart)
+	if !ok || err != nil {
+		return 0, nil, ErrMalformed
+	}
+	payload, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, nil, ErrMalformed
+	}
+	return id, payload, nil
+}
+
+func valueAAD(userID int, field string) []byte {
+	return []byte(field + ":" + strconv.Itoa(userID))
+}
+
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func open(key, sealed, aad []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrMalformed
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
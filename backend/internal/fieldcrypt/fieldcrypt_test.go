@@ -0,0 +1,69 @@
+package fieldcrypt_test
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+
+	"kept/internal/fieldcrypt"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := fieldcrypt.NewDataKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := fieldcrypt.Encrypt(key, 7, 1, "promises.recipient", "Mom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fieldcrypt.IsEncrypted(sealed) || strings.Contains(sealed, "Mom") {
+		t.Fatalf("Unexpected ciphertext %q", sealed)
+	}
+	if id, err := fieldcrypt.KeyID(sealed); err != nil || id != 7 {
+		t.Fatalf("Expected key ID 7, got %d (%v)", id, err)
+	}
+
+	plaintext, err := fieldcrypt.Decrypt(key, 1, "promises.recipient", sealed)
+	if err != nil || plaintext != "Mom" {
+		t.Fatalf("Expected %q, got %q (%v)", "Mom", plaintext, err)
+	}
+
+	// Ciphertext is bound to the user and column it was written for
+	if _, err := fieldcrypt.Decrypt(key, 2, "promises.recipient", sealed); err == nil {
+		t.Fatal("Expected decryption for another user to fail")
+	}
+	if _, err := fieldcrypt.Decrypt(key, 1, "promises.description", sealed); err == nil {
+		t.Fatal("Expected decryption as another column to fail")
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString(make([]byte, 32))
+	k, err := fieldcrypt.ParseKeyring("1:" + key + ", 3:" + key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k.Current() != 3 || !k.Has(1) || k.Has(2) {
+		t.Fatalf("Unexpected keyring versions %v, current %d", k.Versions(), k.Current())
+	}
+
+	dataKey, _ := fieldcrypt.NewDataKey()
+	version, wrapped, err := k.Wrap(5, dataKey)
+	if err != nil || version != 3 {
+		t.Fatalf("Expected key wrapped with version 3, got %d (%v)", version, err)
+	}
+	unwrapped, err := k.Unwrap(5, version, wrapped)
+	if err != nil || string(unwrapped) != string(dataKey) {
+		t.Fatalf("Unwrap returned a different key (%v)", err)
+	}
+	if _, err := k.Unwrap(5, 2, wrapped); err == nil {
+		t.Fatal("Expected unknown master key version to fail")
+	}
+
+	for _, bad := range []string{"", "x:" + key, "1:short", "1:" + key + ",1:" + key} {
+		if _, err := fieldcrypt.ParseKeyring(bad); err == nil {
+			t.Fatalf("Expected %q to be rejected", bad)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type User struct {
 	ID           int       `json:"id"`
@@ -10,36 +13,171 @@ type User struct {
 	CreatedAt    time.Time `json:"created_at"`
 }
 
+// Promise is a commitment to someone. End-to-end encrypted promises have
+// Encrypted set, an empty Recipient and Description, and their content in
+// Envelope. Version goes up with every write and is served as the ETag.
+// With NotifyRecipient set, RecipientEmail gets a link to confirm or dispute
+// the outcome. Promises in a workspace have WorkspaceID set; UserID is then
+// the member who created them. PartnerVisible shows a personal promise to
+// the owner's accountability partners. Progress is the percentage of
+// checklist items done, nil without items; with KeepWhenDone, finishing the
+// last item keeps the promise. Instances of a recurring promise have
+// SeriesID set.
 type Promise struct {
 	ID                int        `json:"id"`
 	UserID            int        `json:"user_id"`
 	Recipient         string     `json:"recipient"`
+	RecipientEmail    string     `json:"recipient_email,omitempty"`
+	NotifyRecipient   bool       `json:"notify_recipient"`
+	RecipientLink     string     `json:"recipient_link,omitempty"`
 	Description       string     `json:"description"`
 	DueDate           *time.Time `json:"due_date,omitempty"`
 	CurrentState      string     `json:"current_state"`
 	ReminderFrequency string     `json:"reminder_frequency,omitempty"`
 	LastRemindedAt    *time.Time `json:"last_reminded_at,omitempty"`
+	Encrypted         bool       `json:"encrypted"`
+	Envelope          *Envelope  `json:"envelope,omitempty"`
+	ContactID         *int       `json:"contact_id,omitempty"`
+	WorkspaceID       *int       `json:"workspace_id,omitempty"`
+	PartnerVisible    bool       `json:"partner_visible"`
+	KeepWhenDone      bool       `json:"keep_when_done"`
+	Progress          *int       `json:"progress,omitempty"`
+	SeriesID          *int       `json:"series_id,omitempty"`
+	Version           int        `json:"version"`
 	CreatedAt         time.Time  `json:"created_at"`
 	UpdatedAt         time.Time  `json:"updated_at"`
+	DeletedAt         *time.Time `json:"deleted_at,omitempty"`
+	Tags              []Tag      `json:"tags"`
 	Events            []Event    `json:"events,omitempty"`
 }
 
+// Tag labels promises. Counts holds how many of the user's promises carry
+// the tag in each state.
+type Tag struct {
+	ID        int            `json:"id"`
+	UserID    int            `json:"user_id"`
+	Name      string         `json:"name"`
+	Color     string         `json:"color"`
+	Counts    map[string]int `json:"counts"`
+	CreatedAt time.Time      `json:"created_at"`
+}
+
+// Contact is someone promises are made to. Aliases are other names the
+// contact goes by; recipients matching the name or an alias are linked to
+// the contact.
+type Contact struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	Aliases   []string  `json:"aliases"`
+	Email     string    `json:"email,omitempty"`
+	Phone     string    `json:"phone,omitempty"`
+	Notes     string    `json:"notes,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Template is a reusable starting point for promises. Description may
+// contain {{placeholders}} that are filled in when a promise is created
+// from the template, which Placeholders lists. DueOffsetMinutes sets the
+// due date relative to that moment, and ReminderOffsets are minutes before
+// the due date to remind at.
+type Template struct {
+	ID                int       `json:"id"`
+	UserID            int       `json:"user_id"`
+	Name              string    `json:"name"`
+	Recipient         string    `json:"recipient"`
+	Description       string    `json:"description"`
+	DueOffsetMinutes  *int      `json:"due_offset_minutes,omitempty"`
+	ReminderFrequency string    `json:"reminder_frequency,omitempty"`
+	ReminderOffsets   []int     `json:"reminder_offsets"`
+	Placeholders      []string  `json:"placeholders"`
+	CreatedAt         time.Time `json:"created_at"`
+	UpdatedAt         time.Time `json:"updated_at"`
+}
+
+// ContactStats summarizes the promises made to a contact. KeepRate is the
+// share of kept promises among kept and broken ones, and is nil while none
+// have been resolved.
+type ContactStats struct {
+	Counts   map[string]int `json:"counts"`
+	Total    int            `json:"total"`
+	KeepRate *float64       `json:"keep_rate"`
+}
+
+// Event records a change of a promise's state, or something that happened
+// to it, such as a nudge. ActorUserID is set when someone other than the
+// owner caused it.
 type Event struct {
 	ID             int       `json:"id"`
 	PromiseID      int       `json:"promise_id"`
 	State          string    `json:"state"`
 	ReflectionNote string    `json:"reflection_note,omitempty"`
+	Envelope       *Envelope `json:"envelope,omitempty"`
+	ActorUserID    *int      `json:"actor_user_id,omitempty"`
 	CreatedAt      time.Time `json:"created_at"`
 }
 
+// Envelope is content encrypted by the client, such as the recipient and
+// description of an encrypted promise or the reflection note of its events.
+// The server stores it as is and can't read it.
+type Envelope struct {
+	Alg        string `json:"alg"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// E2EKeyCheck lets a client check an end-to-end encryption passphrase: it
+// holds the key derivation parameters and an envelope of a known value
+// encrypted with the derived key.
+type E2EKeyCheck struct {
+	KDF        string   `json:"kdf"`
+	Iterations int      `json:"iterations"`
+	Salt       string   `json:"salt"`
+	Check      Envelope `json:"check"`
+}
+
+// Reminder anchors: what a reminder's time is based on. Due reminders go
+// off OffsetMinutes before the due date, created and state_change ones
+// OffsetMinutes after the promise was made or its state last changed, and
+// absolute ones at RemindAt.
+const (
+	AnchorDue         = "due"
+	AnchorCreated     = "created"
+	AnchorStateChange = "state_change"
+	AnchorAbsolute    = "absolute"
+)
+
+// Reminder statuses. Pending reminders go off at RemindAt and are then sent,
+// or failed if the push reached nobody. Reminders about resolved or trashed
+// promises are cancelled until the promise is reopened.
+const (
+	ReminderPending   = "pending"
+	ReminderSent      = "sent"
+	ReminderCancelled = "cancelled"
+	ReminderFailed    = "failed"
+)
+
 type Reminder struct {
-	ID            int       `json:"id"`
-	PromiseID     int       `json:"promise_id"`
-	UserID        int       `json:"user_id"`
-	RemindAt      time.Time `json:"remind_at"`
-	OffsetMinutes int       `json:"offset_minutes"`
-	IsSent        bool      `json:"is_sent"`
-	CreatedAt     time.Time `json:"created_at"`
+	ID            int              `json:"id"`
+	PromiseID     int              `json:"promise_id"`
+	UserID        int              `json:"user_id"`
+	RemindAt      time.Time        `json:"remind_at"`
+	Anchor        string           `json:"anchor"`
+	OffsetMinutes int              `json:"offset_minutes"`
+	Status        string           `json:"status"`
+	CreatedAt     time.Time        `json:"created_at"`
+	Promise       *ReminderPromise `json:"promise,omitempty"`
+}
+
+// ReminderPromise summarizes the promise a reminder is about.
+type ReminderPromise struct {
+	Recipient    string     `json:"recipient"`
+	Description  string     `json:"description"`
+	DueDate      *time.Time `json:"due_date,omitempty"`
+	CurrentState string     `json:"current_state"`
+	Encrypted    bool       `json:"encrypted"`
+	Envelope     *Envelope  `json:"envelope,omitempty"`
 }
 
 type PushSubscription struct {
@@ -51,25 +189,380 @@ type PushSubscription struct {
 }
 
 type CreatePromiseRequest struct {
-	Recipient         string     `json:"recipient"`
-	Description       string     `json:"description"`
-	DueDate           *time.Time `json:"due_date,omitempty"`
-	ReminderFrequency string     `json:"reminder_frequency,omitempty"`
+	Recipient         string      `json:"recipient"`
+	RecipientEmail    string      `json:"recipient_email,omitempty"`
+	NotifyRecipient   bool        `json:"notify_recipient,omitempty"`
+	Description       string      `json:"description"`
+	DueDate           *time.Time  `json:"due_date,omitempty"`
+	ReminderFrequency string      `json:"reminder_frequency,omitempty"`
+	Encrypted         bool        `json:"encrypted,omitempty"`
+	Envelope          *Envelope   `json:"envelope,omitempty"`
+	TagIDs            []int       `json:"tag_ids,omitempty"`
+	ContactID         *int        `json:"contact_id,omitempty"`
+	WorkspaceID       *int        `json:"workspace_id,omitempty"`
+	PartnerVisible    bool        `json:"partner_visible,omitempty"`
+	KeepWhenDone      bool        `json:"keep_when_done,omitempty"`
+	Recurrence        *Recurrence `json:"recurrence,omitempty"`
+	// Text is parsed for the fields left out, with relative dates in
+	// TimeZone.
+	Text     string `json:"text,omitempty"`
+	TimeZone string `json:"timezone,omitempty"`
+}
+
+// ParsePromiseRequest asks for a preview of what Text would create.
+// TimeZone is an IANA name such as "Europe/Berlin" and defaults to UTC.
+type ParsePromiseRequest struct {
+	Text     string `json:"text"`
+	TimeZone string `json:"timezone,omitempty"`
 }
 
 type UpdatePromiseStateRequest struct {
 	State          string     `json:"state"`
 	ReflectionNote string     `json:"reflection_note,omitempty"`
+	NoteEnvelope   *Envelope  `json:"note_envelope,omitempty"`
 	NewDueDate     *time.Time `json:"new_due_date,omitempty"`
 }
 
+// UpdatePromiseRequest edits a promise with PATCH semantics: fields left out
+// are unchanged, and null clears the due date or reminder frequency.
+// Encrypted promises are edited by sending a new Envelope instead of
+// Recipient and Description. TagIDs replaces the promise's tags, and
+// ContactID links the promise to another contact or, with null, unlinks it.
 type UpdatePromiseRequest struct {
-	ReminderFrequency *int       `json:"reminder_frequency,omitempty"`
-	DueDate           *time.Time `json:"due_date,omitempty"`
+	Recipient         Optional[string]    `json:"recipient,omitzero"`
+	RecipientEmail    Optional[string]    `json:"recipient_email,omitzero"`
+	NotifyRecipient   Optional[bool]      `json:"notify_recipient,omitzero"`
+	Description       Optional[string]    `json:"description,omitzero"`
+	DueDate           Optional[time.Time] `json:"due_date,omitzero"`
+	ReminderFrequency Optional[string]    `json:"reminder_frequency,omitzero"`
+	Envelope          Optional[Envelope]  `json:"envelope,omitzero"`
+	TagIDs            Optional[[]int]     `json:"tag_ids,omitzero"`
+	ContactID         Optional[int]       `json:"contact_id,omitzero"`
+	PartnerVisible    Optional[bool]      `json:"partner_visible,omitzero"`
+	KeepWhenDone      Optional[bool]      `json:"keep_when_done,omitzero"`
+	// RearmReminders makes sent due date reminders go off again if a new
+	// due date puts them back in the future.
+	RearmReminders bool `json:"rearm_reminders,omitempty"`
+}
+
+// Optional is a request field that tells a missing field apart from an
+// explicit null. Set is true if the field was present; Value is nil for null.
+type Optional[T any] struct {
+	Set   bool
+	Value *T
+}
+
+// Some returns an Optional holding v.
+func Some[T any](v T) Optional[T] {
+	return Optional[T]{Set: true, Value: &v}
+}
+
+func (o *Optional[T]) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	o.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	return json.Unmarshal(data, &o.Value)
+}
+
+func (o Optional[T]) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.Value)
+}
+
+// PromiseItem is a step on a promise's checklist. Items are ordered by
+// Position, starting at 0, and are done once DoneAt is set.
+type PromiseItem struct {
+	ID        int        `json:"id"`
+	PromiseID int        `json:"promise_id"`
+	Position  int        `json:"position"`
+	Title     string     `json:"title"`
+	DueDate   *time.Time `json:"due_date,omitempty"`
+	Done      bool       `json:"done"`
+	DoneAt    *time.Time `json:"done_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// ItemRequest creates a checklist item or, with PATCH semantics, edits one.
+// Position moves the item, shifting the items in between.
+type ItemRequest struct {
+	Title    Optional[string]    `json:"title,omitzero"`
+	DueDate  Optional[time.Time] `json:"due_date,omitzero"`
+	Done     Optional[bool]      `json:"done,omitzero"`
+	Position Optional[int]       `json:"position,omitzero"`
+}
+
+// Series frequencies.
+const (
+	FrequencyDaily   = "daily"
+	FrequencyWeekly  = "weekly"
+	FrequencyMonthly = "monthly"
+	FrequencyYearly  = "yearly"
+)
+
+// Recurrence makes a new promise the first instance of a series: the next
+// instance falls due Interval days, weeks, months or years later, until
+// EndsAt if set.
+type Recurrence struct {
+	Frequency string     `json:"frequency"`
+	Interval  int        `json:"interval,omitempty"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+}
+
+// Series is a recurring promise. Its instances are promises with SeriesID
+// set, due on the occurrences counted from StartsAt. CurrentPromiseID is the
+// instance the next one will be created from. Stats and Instances are only
+// filled in for a single series.
+type Series struct {
+	ID               int          `json:"id"`
+	UserID           int          `json:"user_id"`
+	WorkspaceID      *int         `json:"workspace_id,omitempty"`
+	Frequency        string       `json:"frequency"`
+	Interval         int          `json:"interval"`
+	StartsAt         time.Time    `json:"starts_at"`
+	EndsAt           *time.Time   `json:"ends_at,omitempty"`
+	CurrentPromiseID *int         `json:"current_promise_id,omitempty"`
+	StoppedAt        *time.Time   `json:"stopped_at,omitempty"`
+	CreatedAt        time.Time    `json:"created_at"`
+	Stats            *SeriesStats `json:"stats,omitempty"`
+	Instances        []Promise    `json:"instances,omitempty"`
+}
+
+// SeriesStats summarizes a series' resolved instances. Streak counts the
+// most recent instances kept in a row and LongestStreak the longest such run.
+// HitRate is the share of kept instances among kept and broken ones, and is
+// nil while none have been resolved.
+type SeriesStats struct {
+	Counts        map[string]int `json:"counts"`
+	Total         int            `json:"total"`
+	Streak        int            `json:"streak"`
+	LongestStreak int            `json:"longest_streak"`
+	HitRate       *float64       `json:"hit_rate"`
+}
+
+// SeriesRequest changes a series' rule with PATCH semantics. Null EndsAt
+// lets the series run forever.
+type SeriesRequest struct {
+	Frequency Optional[string]    `json:"frequency,omitzero"`
+	Interval  Optional[int]       `json:"interval,omitzero"`
+	EndsAt    Optional[time.Time] `json:"ends_at,omitzero"`
+}
+
+// Revision records one field changed by an edit to a promise. OldValue and
+// NewValue are nil when the field was unset; dates are RFC 3339 in UTC and
+// envelopes are JSON. Actor says what made the edit ("user" or "caldav"),
+// and ActorUserID who, if anyone.
+type Revision struct {
+	ID          int       `json:"id"`
+	PromiseID   int       `json:"promise_id"`
+	Field       string    `json:"field"`
+	OldValue    *string   `json:"old_value"`
+	NewValue    *string   `json:"new_value"`
+	Actor       string    `json:"actor"`
+	ActorUserID *int      `json:"actor_user_id,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Search hit kinds
+const (
+	SearchHitPromise = "promise"
+	SearchHitNote    = "note"
+)
+
+// SearchHit is a promise, or the reflection note of one of its events, that
+// matched a search. Snippet is HTML-escaped with the matched words in <mark>
+// tags. Higher scores are better matches. Date is when the promise was made
+// or the note written.
+type SearchHit struct {
+	Kind      string    `json:"kind"`
+	PromiseID int       `json:"promise_id"`
+	EventID   *int      `json:"event_id,omitempty"`
+	Snippet   string    `json:"snippet"`
+	Score     float64   `json:"score"`
+	Date      time.Time `json:"date"`
+	Promise   *Promise  `json:"promise,omitempty"`
+}
+
+// TagRequest creates a tag or, with PATCH semantics, edits one.
+type TagRequest struct {
+	Name  Optional[string] `json:"name,omitzero"`
+	Color Optional[string] `json:"color,omitzero"`
+}
+
+// ContactRequest creates a contact or, with PATCH semantics, edits one.
+type ContactRequest struct {
+	Name    Optional[string]   `json:"name,omitzero"`
+	Aliases Optional[[]string] `json:"aliases,omitzero"`
+	Email   Optional[string]   `json:"email,omitzero"`
+	Phone   Optional[string]   `json:"phone,omitzero"`
+	Notes   Optional[string]   `json:"notes,omitzero"`
+}
+
+// TemplateRequest creates a template or, with PATCH semantics, edits one.
+// Null clears the due offset or reminder frequency.
+type TemplateRequest struct {
+	Name              Optional[string] `json:"name,omitzero"`
+	Recipient         Optional[string] `json:"recipient,omitzero"`
+	Description       Optional[string] `json:"description,omitzero"`
+	DueOffsetMinutes  Optional[int]    `json:"due_offset_minutes,omitzero"`
+	ReminderFrequency Optional[string] `json:"reminder_frequency,omitzero"`
+	ReminderOffsets   Optional[[]int]  `json:"reminder_offsets,omitzero"`
+}
+
+// UseTemplateRequest creates a promise from a template. Values fill in the
+// description's placeholders; Recipient and DueDate override the
+// template's.
+type UseTemplateRequest struct {
+	Values      map[string]string `json:"values,omitempty"`
+	Recipient   string            `json:"recipient,omitempty"`
+	DueDate     *time.Time        `json:"due_date,omitempty"`
+	TagIDs      []int             `json:"tag_ids,omitempty"`
+	ContactID   *int              `json:"contact_id,omitempty"`
+	WorkspaceID *int              `json:"workspace_id,omitempty"`
+}
+
+// MergeContactRequest names the contact to merge into another.
+type MergeContactRequest struct {
+	ContactID int `json:"contact_id"`
+}
+
+// PushActionRequest carries the signed token behind the buttons of a push
+// notification. It is sent in the body so it stays out of access logs.
+type PushActionRequest struct {
+	Token string `json:"token"`
+}
+
+// RecipientResponse is how the recipient of a promise answers its outcome:
+// "confirmed" or "disputed", with an optional note.
+type RecipientResponse struct {
+	Response string `json:"response" form:"response"`
+	Note     string `json:"note,omitempty" form:"note"`
+}
+
+// ShareLink is a read-only public link to a promise. Token is only known
+// when the link is created; URL is the link to hand out. Redact lists the
+// fields hidden from viewers.
+type ShareLink struct {
+	ID        int        `json:"id"`
+	PromiseID int        `json:"promise_id"`
+	UserID    int        `json:"user_id"`
+	Token     string     `json:"token,omitempty"`
+	URL       string     `json:"url,omitempty"`
+	Redact    []string   `json:"redact"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+type CreateShareLinkRequest struct {
+	Redact    []string   `json:"redact,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// AppPassword lets a CalDAV client sign in without the account password.
+// Password is only returned when it is created.
+type AppPassword struct {
+	ID        int       `json:"id"`
+	UserID    int       `json:"user_id"`
+	Name      string    `json:"name"`
+	Password  string    `json:"password,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type CreateAppPasswordRequest struct {
+	Name string `json:"name"`
+}
+
+// Workspace member roles. Viewers can see the workspace's promises, editors
+// can also change them, and owners can also manage the workspace and its
+// members.
+const (
+	RoleOwner  = "owner"
+	RoleEditor = "editor"
+	RoleViewer = "viewer"
+)
+
+// Workspace is a promise list shared by its members. Role is the role of the
+// user it was loaded for.
+type Workspace struct {
+	ID        int               `json:"id"`
+	Name      string            `json:"name"`
+	CreatedBy *int              `json:"created_by,omitempty"`
+	Role      string            `json:"role,omitempty"`
+	Members   []WorkspaceMember `json:"members,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+type WorkspaceMember struct {
+	UserID    int       `json:"user_id"`
+	Username  string    `json:"username"`
+	Role      string    `json:"role"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// WorkspaceInvitation asks a user to join a workspace with a role. It is
+// gone once accepted or declined.
+type WorkspaceInvitation struct {
+	ID            int       `json:"id"`
+	WorkspaceID   int       `json:"workspace_id"`
+	WorkspaceName string    `json:"workspace_name"`
+	UserID        int       `json:"user_id"`
+	Username      string    `json:"username"`
+	Role          string    `json:"role"`
+	InvitedBy     *int      `json:"invited_by,omitempty"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+type WorkspaceRequest struct {
+	Name string `json:"name"`
+}
+
+type InviteMemberRequest struct {
+	Username string `json:"username"`
+	Role     string `json:"role"`
+}
+
+type UpdateMemberRequest struct {
+	Role string `json:"role"`
+}
+
+// Partnership makes PartnerID an accountability partner of UserID once
+// accepted.
+type Partnership struct {
+	ID              int        `json:"id"`
+	UserID          int        `json:"user_id"`
+	Username        string     `json:"username"`
+	PartnerID       int        `json:"partner_id"`
+	PartnerUsername string     `json:"partner_username"`
+	AcceptedAt      *time.Time `json:"accepted_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+type PartnerRequest struct {
+	Username string `json:"username"`
+}
+
+type NudgeRequest struct {
+	Message string `json:"message,omitempty"`
+}
+
+// ReminderRequest describes a reminder. Anchor defaults to "due", or to
+// "absolute" when RemindAt is set; RemindAt is only used by absolute
+// reminders.
+type ReminderRequest struct {
+	Anchor        string     `json:"anchor,omitempty"`
+	OffsetMinutes int        `json:"offset_minutes"`
+	RemindAt      *time.Time `json:"remind_at,omitempty"`
 }
 
+// CreateReminderRequest creates one reminder like ReminderRequest, or
+// several with Reminders.
 type CreateReminderRequest struct {
-	OffsetMinutes int `json:"offset_minutes"`
+	Anchor        string            `json:"anchor,omitempty"`
+	OffsetMinutes int               `json:"offset_minutes"`
+	RemindAt      *time.Time        `json:"remind_at,omitempty"`
+	Reminders     []ReminderRequest `json:"reminders,omitempty"`
 }
 
 type RegisterRequest struct {
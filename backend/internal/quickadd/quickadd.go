@@ -0,0 +1,776 @@
+// Package quickadd parses a one-line description of a promise, such as
+// "Promise Sam I'll return the drill by Friday 6pm, remind me daily", into
+// a recipient, description, due date and reminder frequency.
+//
+// Parsing is rule based and deterministic: the same text and reference time
+// always give the same result. Parts of the text that could be read more
+// than one way are reported as ambiguous spans and lower the confidence.
+package quickadd
+
+import (
+	"math"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	// Client time zones have to resolve in images without zoneinfo
+	_ "time/tzdata"
+)
+
+// Span is a part of the parsed text, as byte offsets into it.
+type Span struct {
+	Start  int    `json:"start"`
+	End    int    `json:"end"`
+	Text   string `json:"text"`
+	Reason string `json:"reason"`
+}
+
+// Result is what Parse made of a text. Confidence runs from 0 to 1.
+type Result struct {
+	Recipient         string     `json:"recipient"`
+	Description       string     `json:"description"`
+	DueDate           *time.Time `json:"due_date,omitempty"`
+	ReminderFrequency string     `json:"reminder_frequency,omitempty"`
+	Confidence        float64    `json:"confidence"`
+	Ambiguous         []Span     `json:"ambiguous"`
+}
+
+// Confidence penalties.
+const (
+	noRecipientPenalty   = 0.4
+	noDescriptionPenalty = 0.5
+	ambiguityPenalty     = 0.15
+)
+
+// Dates without a time of day are due at the end of that day.
+const endOfDayHour, endOfDayMinute = 23, 59
+
+// Location resolves an IANA time zone name, with "" meaning UTC.
+func Location(name string) (*time.Location, error) {
+	if name == "" {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(name)
+}
+
+// Parse parses text relative to now. Relative dates such as "tomorrow" are
+// resolved in now's location, and the due date is returned in it.
+func Parse(text string, now time.Time) Result {
+	p := &parser{text: text, now: now, used: make([]bool, len(text))}
+	p.res.Ambiguous = []Span{}
+
+	p.parseReminder()
+	strong := p.parseRecipient()
+	p.parseDue()
+	if !strong {
+		p.guessRecipient()
+	}
+	p.res.Description = p.description()
+
+	confidence := 1.0
+	if p.res.Recipient == "" {
+		confidence -= noRecipientPenalty
+	}
+	if p.res.Description == "" {
+		confidence -= noDescriptionPenalty
+	}
+	confidence -= ambiguityPenalty * float64(len(p.res.Ambiguous))
+	p.res.Confidence = math.Round(max(confidence, 0)*100) / 100
+
+	sort.SliceStable(p.res.Ambiguous, func(i, j int) bool { return p.res.Ambiguous[i].Start < p.res.Ambiguous[j].Start })
+	return p.res
+}
+
+type parser struct {
+	text string
+	now  time.Time
+	// used marks the bytes of text that were parsed into a field.
+	used []bool
+	res  Result
+}
+
+// matches returns the submatch indexes of re that don't overlap text that
+// was parsed already.
+func (p *parser) matches(re *regexp.Regexp) [][]int {
+	var free [][]int
+	for _, m := range re.FindAllStringSubmatchIndex(p.text, -1) {
+		if p.free(m[0], m[1]) {
+			free = append(free, m)
+		}
+	}
+	return free
+}
+
+func (p *parser) free(start, end int) bool {
+	for i := start; i < end; i++ {
+		if p.used[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (p *parser) consume(start, end int) {
+	for i := start; i < end; i++ {
+		p.used[i] = true
+	}
+}
+
+func (p *parser) ambiguous(start, end int, reason string) {
+	p.res.Ambiguous = append(p.res.Ambiguous, Span{Start: start, End: end, Text: p.text[start:end], Reason: reason})
+}
+
+// group returns submatch i of m, or "" if it didn't participate.
+func (p *parser) group(m []int, i int) string {
+	if m[2*i] < 0 {
+		return ""
+	}
+	return p.text[m[2*i]:m[2*i+1]]
+}
+
+var (
+	reReminder      = regexp.MustCompile(`(?i)\b(?:and\s+)?remind\s+me\s+(?:(daily|weekly|monthly)|every\s+(day|week|month))\b`)
+	reOtherReminder = regexp.MustCompile(`(?i)\b(?:and\s+)?remind\s+me\b[^,.;!]*`)
+	frequencies     = map[string]string{"daily": "daily", "day": "daily", "weekly": "weekly", "week": "weekly", "monthly": "monthly", "month": "monthly"}
+)
+
+// parseReminder picks up "remind me daily" and the like.
+func (p *parser) parseReminder() {
+	if ms := p.matches(reReminder); len(ms) > 0 {
+		m := ms[0]
+		p.res.ReminderFrequency = frequencies[strings.ToLower(p.group(m, 1)+p.group(m, 2))]
+		p.consume(m[0], m[1])
+		return
+	}
+	if ms := p.matches(reOtherReminder); len(ms) > 0 {
+		m := ms[0]
+		p.ambiguous(m[0], m[1], "Only daily, weekly and monthly reminders are supported")
+		p.consume(m[0], m[1])
+	}
+}
+
+var (
+	// Promise Sam I'll ..., I promised Mom to ..., Tell Priya that ...
+	reRecipientLead = regexp.MustCompile(`^\s*(?i:(?:i\s+)?promised?|tell)\s+((?:[^\s,:]+\s+){0,3}?[^\s,:]+)\s*,?\s+(?i:(?:that\s+)?(?:i'll|i’ll|i\s+will)|to|that)\s+`)
+	// Sam: ..., To Sam: ...
+	reRecipientColon = regexp.MustCompile(`^\s*(?i:for\s+|to\s+)?(\p{Lu}[^\s:,]*(?:\s+\p{Lu}[^\s:,]*)?)\s*:\s+`)
+	// ... to Sam, ... for Sam
+	reRecipientName = regexp.MustCompile(`\b(?i:to|for|with)\s+(\p{Lu}[\p{L}'’-]*(?:\s+\p{Lu}[\p{L}'’-]*)?)`)
+)
+
+// parseRecipient picks up a recipient named at the start of the text. It
+// returns whether it found one.
+func (p *parser) parseRecipient() bool {
+	if m := reRecipientLead.FindStringSubmatchIndex(p.text); m != nil && p.free(m[0], m[1]) {
+		name := p.group(m, 1)
+		first := strings.ToLower(strings.Fields(name)[0])
+		if first != "to" && first != "that" && first != "i" {
+			p.res.Recipient = strings.TrimSpace(name)
+			p.consume(m[0], m[1])
+			return true
+		}
+	}
+	if m := reRecipientColon.FindStringSubmatchIndex(p.text); m != nil && p.free(m[0], m[1]) {
+		p.res.Recipient = p.group(m, 1)
+		p.consume(m[0], m[1])
+		return true
+	}
+	return false
+}
+
+// guessRecipient takes a capitalized name after "to", "for" or "with" as
+// the recipient, flagging it as a guess.
+func (p *parser) guessRecipient() {
+	for _, m := range reRecipientName.FindAllStringSubmatchIndex(p.text, -1) {
+		// The name ends before any word that is part of a date
+		group := p.group(m, 1)
+		name, end := []string{}, 0
+		for _, w := range strings.Fields(group) {
+			if calendarWords[strings.ToLower(w)] || w == "I" {
+				break
+			}
+			name = append(name, w)
+			end += strings.Index(group[end:], w) + len(w)
+		}
+		end += m[2]
+		if len(name) == 0 || !p.free(m[0], end) {
+			continue
+		}
+		p.res.Recipient = strings.Join(name, " ")
+		p.ambiguous(m[2], end, "Recipient guessed from a name")
+		p.consume(m[0], end)
+		return
+	}
+}
+
+// description is what is left of the text once the parsed parts are taken
+// out, with dangling connectives and punctuation trimmed.
+func (p *parser) description() string {
+	var b strings.Builder
+	for i := 0; i < len(p.text); i++ {
+		if p.used[i] {
+			if i == 0 || !p.used[i-1] {
+				b.WriteByte(' ')
+			}
+			continue
+		}
+		b.WriteByte(p.text[i])
+	}
+	d := strings.Join(strings.Fields(b.String()), " ")
+	d = reSpaceBeforePunct.ReplaceAllString(d, "$1")
+	d = reRepeatedPunct.ReplaceAllString(d, "$1")
+	for {
+		trimmed := reLeadingFiller.ReplaceAllString(reTrailingFiller.ReplaceAllString(d, ""), "")
+		if trimmed == d {
+			break
+		}
+		d = trimmed
+	}
+	r, size := utf8.DecodeRuneInString(d)
+	if size == 0 {
+		return ""
+	}
+	return string(unicode.ToUpper(r)) + d[size:]
+}
+
+var (
+	reSpaceBeforePunct = regexp.MustCompile(`\s+([,.;:!?])`)
+	reRepeatedPunct    = regexp.MustCompile(`([,;])(?:\s*[,;])+`)
+	reLeadingFiller    = regexp.MustCompile(`^(?:[\s,;:.!-]+|(?i:and|to|that|i'll|i’ll|i\s+will|promise)\b\s*)`)
+	reTrailingFiller   = regexp.MustCompile(`(?:[\s,;:.!-]+|\s(?i:by|on|before|until|till|due|at|and|in))$`)
+)
+
+// calendarWords are capitalized words that aren't names.
+var calendarWords = map[string]bool{}
+
+func init() {
+	for name := range weekdays {
+		calendarWords[name] = true
+	}
+	for name := range months {
+		calendarWords[name] = true
+	}
+	for _, w := range []string{"today", "tonight", "tomorrow", "next", "this", "the", "end"} {
+		calendarWords[w] = true
+	}
+}
+
+// parseDue finds the due date. If the text mentions several dates, the one
+// after "by", "before", "until" or "due" wins, or else the last one; the
+// others stay in the description and are flagged. So are dates and times
+// that don't exist, such as "Feb 30" or "25:00".
+func (p *parser) parseDue() {
+	dates, badDates := p.dateMatches()
+	times, badTimes := p.timeMatches()
+	for _, d := range badDates {
+		p.ambiguous(d.start, d.end, d.reason)
+	}
+	for _, t := range badTimes {
+		p.ambiguous(t.start, t.end, t.reason)
+	}
+
+	var date *dateMatch
+	for i := range dates {
+		if date == nil || dates[i].deadline || !date.deadline {
+			date = &dates[i]
+		}
+	}
+	for i := range dates {
+		if &dates[i] != date {
+			p.ambiguous(dates[i].start, dates[i].end, "Another date; left in the description")
+		}
+	}
+
+	// The time closest to the date belongs to it
+	var clock *timeMatch
+	for i := range times {
+		if clock == nil || date == nil || distance(&times[i], date) < distance(clock, date) {
+			clock = &times[i]
+		}
+	}
+	for i := range times {
+		if &times[i] != clock {
+			p.ambiguous(times[i].start, times[i].end, "Another time; left in the description")
+		}
+	}
+	if date != nil && date.exact && clock != nil {
+		p.ambiguous(clock.start, clock.end, "Another time; left in the description")
+		clock = nil
+	}
+
+	var due time.Time
+	switch {
+	case date != nil && date.exact:
+		due = date.at
+	case date != nil:
+		hour, minute := date.hour, date.minute
+		if clock != nil {
+			hour, minute = clock.hour, clock.minute
+		}
+		y, m, d := date.at.Date()
+		due = time.Date(y, m, d, hour, minute, 0, 0, p.now.Location())
+	case clock != nil:
+		y, m, d := p.now.Date()
+		due = time.Date(y, m, d, clock.hour, clock.minute, 0, 0, p.now.Location())
+		if due.Before(p.now) {
+			due = due.AddDate(0, 0, 1)
+		}
+	default:
+		return
+	}
+	p.res.DueDate = &due
+
+	if date != nil {
+		p.consume(date.start-date.prefix, date.end)
+		if date.reason != "" {
+			p.ambiguous(date.start, date.end, date.reason)
+		}
+		if due.Before(p.now) {
+			p.ambiguous(date.start, date.end, "Date is in the past")
+		}
+	}
+	if clock != nil {
+		p.consume(clock.start-clock.prefix, clock.end)
+		if clock.reason != "" {
+			p.ambiguous(clock.start, clock.end, clock.reason)
+		}
+	}
+}
+
+// distance is how many bytes apart a time and a date are.
+func distance(t *timeMatch, d *dateMatch) int {
+	if t.end <= d.start {
+		return d.start - t.end
+	}
+	return max(t.start-d.end, 0)
+}
+
+// dateMatch is a date found in the text. at holds the day, or the instant
+// if exact; hour and minute are the time of day to use without one given.
+type dateMatch struct {
+	start, end   int
+	prefix       int
+	deadline     bool
+	at           time.Time
+	exact        bool
+	hour, minute int
+	reason       string
+}
+
+// timeMatch is a time of day found in the text.
+type timeMatch struct {
+	start, end   int
+	prefix       int
+	hour, minute int
+	reason       string
+}
+
+// datePrefix is an optional preposition in front of a date, in the first
+// submatch of every date pattern.
+const datePrefix = `(?i)\b(?:(by|before|until|till|due|on)\s+)?`
+
+const weekdayNames = `monday|tuesday|wednesday|thursday|friday|saturday|sunday|mon|tues|tue|wed|thurs|thur|thu|fri|sat|sun`
+
+const monthNames = `january|february|march|april|may|june|july|august|september|october|november|december|jan|feb|mar|apr|jun|jul|aug|sept|sep|oct|nov|dec`
+
+var weekdays = map[string]time.Weekday{
+	"monday": time.Monday, "tuesday": time.Tuesday, "wednesday": time.Wednesday, "thursday": time.Thursday,
+	"friday": time.Friday, "saturday": time.Saturday, "sunday": time.Sunday,
+	"mon": time.Monday, "tue": time.Tuesday, "tues": time.Tuesday, "wed": time.Wednesday, "thu": time.Thursday,
+	"thur": time.Thursday, "thurs": time.Thursday, "fri": time.Friday, "sat": time.Saturday, "sun": time.Sunday,
+}
+
+var months = map[string]time.Month{
+	"january": time.January, "february": time.February, "march": time.March, "april": time.April,
+	"may": time.May, "june": time.June, "july": time.July, "august": time.August, "september": time.September,
+	"october": time.October, "november": time.November, "december": time.December,
+	"jan": time.January, "feb": time.February, "mar": time.March, "apr": time.April, "jun": time.June,
+	"jul": time.July, "aug": time.August, "sep": time.September, "sept": time.September, "oct": time.October,
+	"nov": time.November, "dec": time.December,
+}
+
+var numberWords = map[string]int{
+	"a": 1, "an": 1, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5, "six": 6,
+	"seven": 7, "eight": 8, "nine": 9, "ten": 10, "twelve": 12,
+}
+
+var (
+	reRelativeDay = regexp.MustCompile(datePrefix + `(today|tonight|tomorrow|tmrw|tmr)\b`)
+	reWeekday     = regexp.MustCompile(datePrefix + `(?:(next|this|coming)\s+)?(` + weekdayNames + `)\b`)
+	reIn          = regexp.MustCompile(datePrefix + `(?:with)?in\s+(\d+|an?|one|two|three|four|five|six|seven|eight|nine|ten|twelve)\s+(minute|min|hour|hr|day|week|month)s?\b`)
+	reNext        = regexp.MustCompile(datePrefix + `next\s+(week|month)\b`)
+	reEndOf       = regexp.MustCompile(datePrefix + `(?:the\s+)?end\s+of\s+(?:the\s+)?(day|week|month)\b`)
+	reISO         = regexp.MustCompile(datePrefix + `(\d{4})-(\d{2})-(\d{2})\b`)
+	reMonthDay    = regexp.MustCompile(datePrefix + `(` + monthNames + `)\.?\s+(\d{1,2})(?:st|nd|rd|th)?\b(?:,?\s+(\d{4})\b)?`)
+	reDayMonth    = regexp.MustCompile(datePrefix + `(?:the\s+)?(\d{1,2})(?:st|nd|rd|th)?\s+(?:of\s+)?(` + monthNames + `)\b\.?(?:,?\s+(\d{4})\b)?`)
+	reSlash       = regexp.MustCompile(datePrefix + `(\d{1,2})/(\d{1,2})(?:/(\d{4}|\d{2}))?\b`)
+	reOrdinal     = regexp.MustCompile(datePrefix + `the\s+(\d{1,2})(?:st|nd|rd|th)\b`)
+)
+
+// dateMatches returns the dates in the text, dropping ones overlapping a
+// longer one, and the ones that look like dates but don't exist.
+func (p *parser) dateMatches() (dates, bad []dateMatch) {
+	var found, invalid []dateMatch
+	match := func(m []int, reason string) dateMatch {
+		d := dateMatch{start: m[0], end: m[1], hour: endOfDayHour, minute: endOfDayMinute, reason: reason}
+		if m[2] >= 0 {
+			// Report the date without its preposition
+			d.start = m[3]
+			for unicode.IsSpace(rune(p.text[d.start])) {
+				d.start++
+			}
+			d.prefix = d.start - m[0]
+			switch strings.ToLower(p.group(m, 1)) {
+			case "by", "before", "until", "till", "due":
+				d.deadline = true
+			}
+		}
+		return d
+	}
+	add := func(m []int, at time.Time, reason string) {
+		d := match(m, reason)
+		d.at = at
+		found = append(found, d)
+	}
+	reject := func(m []int) {
+		invalid = append(invalid, match(m, "Not a valid date; no due date taken from it"))
+	}
+	today := p.day(p.now)
+
+	for _, m := range p.matches(reRelativeDay) {
+		switch strings.ToLower(p.group(m, 2)) {
+		case "today":
+			add(m, today, "")
+		case "tonight":
+			add(m, today, "")
+			found[len(found)-1].hour, found[len(found)-1].minute = 20, 0
+		default:
+			add(m, today.AddDate(0, 0, 1), "")
+		}
+	}
+	for _, m := range p.matches(reWeekday) {
+		name := strings.ToLower(p.group(m, 3))
+		modifier := strings.ToLower(p.group(m, 2))
+		// Abbreviations like "sat" and "sun" are only dates after a
+		// preposition or "next"
+		if !strings.HasSuffix(name, "day") && m[2] < 0 && modifier == "" {
+			continue
+		}
+		at, reason := p.weekday(weekdays[name], modifier)
+		add(m, at, reason)
+	}
+	for _, m := range p.matches(reIn) {
+		n, ok := numberWords[strings.ToLower(p.group(m, 2))]
+		if !ok {
+			n, _ = strconv.Atoi(p.group(m, 2))
+		}
+		switch unit := strings.ToLower(p.group(m, 3)); unit {
+		case "minute", "min", "hour", "hr":
+			step := time.Minute
+			if unit == "hour" || unit == "hr" {
+				step = time.Hour
+			}
+			add(m, p.now.Add(time.Duration(n)*step), "")
+			found[len(found)-1].exact = true
+		case "day":
+			add(m, today.AddDate(0, 0, n), "")
+		case "week":
+			add(m, today.AddDate(0, 0, 7*n), "")
+		case "month":
+			add(m, addMonths(today, n), "")
+		}
+	}
+	for _, m := range p.matches(reNext) {
+		if strings.ToLower(p.group(m, 2)) == "week" {
+			add(m, today.AddDate(0, 0, 7), "Taken as a week from today")
+		} else {
+			add(m, addMonths(today, 1), "Taken as a month from today")
+		}
+	}
+	for _, m := range p.matches(reEndOf) {
+		switch strings.ToLower(p.group(m, 2)) {
+		case "day":
+			add(m, today, "")
+		case "week":
+			add(m, today.AddDate(0, 0, (7-int(today.Weekday()))%7), "")
+		case "month":
+			add(m, time.Date(today.Year(), today.Month()+1, 0, 0, 0, 0, 0, today.Location()), "")
+		}
+	}
+	for _, m := range p.matches(reISO) {
+		y, _ := strconv.Atoi(p.group(m, 2))
+		mo, _ := strconv.Atoi(p.group(m, 3))
+		d, _ := strconv.Atoi(p.group(m, 4))
+		if at, ok := p.date(y, time.Month(mo), d); ok {
+			add(m, at, "")
+		} else {
+			reject(m)
+		}
+	}
+	for _, m := range p.matches(reMonthDay) {
+		d, _ := strconv.Atoi(p.group(m, 3))
+		if at, ok := p.dayOfYear(months[strings.ToLower(p.group(m, 2))], d, p.group(m, 4)); ok {
+			add(m, at, "")
+		} else {
+			reject(m)
+		}
+	}
+	for _, m := range p.matches(reDayMonth) {
+		d, _ := strconv.Atoi(p.group(m, 2))
+		if at, ok := p.dayOfYear(months[strings.ToLower(p.group(m, 3))], d, p.group(m, 4)); ok {
+			add(m, at, "")
+		} else {
+			reject(m)
+		}
+	}
+	for _, m := range p.matches(reSlash) {
+		a, _ := strconv.Atoi(p.group(m, 2))
+		b, _ := strconv.Atoi(p.group(m, 3))
+		month, day, reason := a, b, ""
+		switch {
+		case a > 12 && b <= 12:
+			month, day = b, a
+		case a <= 12 && b <= 12 && a != b:
+			reason = "Read as month/day; could be day/month"
+		}
+		if at, ok := p.dayOfYear(time.Month(month), day, p.group(m, 4)); ok {
+			add(m, at, reason)
+		} else if m[2] >= 0 {
+			// Without a preposition it is likely a ratio or score
+			reject(m)
+		}
+	}
+	for _, m := range p.matches(reOrdinal) {
+		d, _ := strconv.Atoi(p.group(m, 2))
+		at, ok := p.date(today.Year(), today.Month(), d)
+		if ok && at.Before(today) {
+			at, ok = p.date(today.Year(), today.Month()+1, d)
+		}
+		if ok {
+			add(m, at, "")
+		} else {
+			reject(m)
+		}
+	}
+
+	// Longer matches win over ones they overlap, and valid ones over
+	// invalid ones
+	dates = longestDates(found, nil)
+	return dates, longestDates(invalid, dates)
+}
+
+// longestDates returns the dates in found that don't overlap a longer one
+// or any in taken, in text order.
+func longestDates(found, taken []dateMatch) []dateMatch {
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].end-found[i].start+found[i].prefix > found[j].end-found[j].start+found[j].prefix
+	})
+	kept := append([]dateMatch(nil), taken...)
+	for _, d := range found {
+		overlaps := false
+		for _, k := range kept {
+			if d.start-d.prefix < k.end && k.start-k.prefix < d.end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, d)
+		}
+	}
+	kept = kept[len(taken):]
+	sort.Slice(kept, func(i, j int) bool { return kept[i].start < kept[j].start })
+	return kept
+}
+
+// weekday resolves a weekday name. On its own or with "this" it is the
+// next such day, counting today; "next" means the one in the following
+// week.
+func (p *parser) weekday(wd time.Weekday, modifier string) (time.Time, string) {
+	today := p.day(p.now)
+	ahead := (int(wd) - int(today.Weekday()) + 7) % 7
+	switch modifier {
+	case "next":
+		// Days until next Monday, plus the day within that week
+		nextWeek := 8 - isoWeekday(today.Weekday()) + isoWeekday(wd) - 1
+		coming := ahead
+		if coming == 0 {
+			coming = 7
+		}
+		reason := ""
+		if coming != nextWeek {
+			reason = "Read as " + wd.String() + " next week; could be this coming " + wd.String()
+		}
+		return today.AddDate(0, 0, nextWeek), reason
+	case "":
+		if ahead == 0 {
+			return today, "Read as today; could be " + wd.String() + " next week"
+		}
+	}
+	return today.AddDate(0, 0, ahead), ""
+}
+
+func isoWeekday(wd time.Weekday) int {
+	if wd == time.Sunday {
+		return 7
+	}
+	return int(wd)
+}
+
+// dayOfYear returns a day of a month, in year if given or else the next
+// time it comes around.
+func (p *parser) dayOfYear(month time.Month, day int, year string) (time.Time, bool) {
+	today := p.day(p.now)
+	if year != "" {
+		y, _ := strconv.Atoi(year)
+		if y < 100 {
+			y += 2000
+		}
+		return p.date(y, month, day)
+	}
+	at, ok := p.date(today.Year(), month, day)
+	if ok && at.Before(today) {
+		at, ok = p.date(today.Year()+1, month, day)
+	}
+	return at, ok
+}
+
+// date returns the start of a day in now's location, and false if there is
+// no such day.
+func (p *parser) date(year int, month time.Month, day int) (time.Time, bool) {
+	at := time.Date(year, month, day, 0, 0, 0, 0, p.now.Location())
+	return at, at.Day() == day && month >= time.January && month <= time.December
+}
+
+// day returns the start of t's day.
+func (p *parser) day(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// addMonths adds n months to t, clamping the day to the end of the month.
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, 0, 0, 0, 0, t.Location())
+	last := first.AddDate(0, 1, -1).Day()
+	return first.AddDate(0, 0, min(t.Day(), last)-1)
+}
+
+var (
+	reClock   = regexp.MustCompile(`(?i)\b(at\s+|@\s*)?(\d{1,2})(?::([0-5]\d))?\s*(a\.m\.|p\.m\.|am\b|pm\b)`)
+	reColon   = regexp.MustCompile(`(?i)\b(at\s+|@\s*)?([01]?\d|2[0-3]):([0-5]\d)\b`)
+	reBare    = regexp.MustCompile(`(?i)\b(at\s+|@\s*)(\d{1,2})\b(?:\s+o'?clock\b)?`)
+	reAtColon = regexp.MustCompile(`(?i)\b(at\s+|@\s*)(\d{1,2}):(\d{2})\b`)
+	reDayPart = regexp.MustCompile(`(?i)\b(at\s+|in\s+the\s+|this\s+)?(morning|afternoon|evening|noon|midday|midnight)\b`)
+)
+
+var dayParts = map[string][2]int{
+	"morning": {9, 0}, "afternoon": {15, 0}, "evening": {18, 0},
+	"noon": {12, 0}, "midday": {12, 0}, "midnight": {endOfDayHour, endOfDayMinute},
+}
+
+// timeMatches returns the times of day in the text, dropping ones
+// overlapping a longer one, and the ones that look like times but don't
+// exist.
+func (p *parser) timeMatches() (times, bad []timeMatch) {
+	var found, invalid []timeMatch
+	match := func(m []int, hour, minute int, reason string) timeMatch {
+		t := timeMatch{start: m[0], end: m[1], hour: hour, minute: minute, reason: reason}
+		if m[2] >= 0 {
+			t.start = m[3]
+			t.prefix = t.start - m[0]
+		}
+		return t
+	}
+	add := func(m []int, hour, minute int, reason string) {
+		found = append(found, match(m, hour, minute, reason))
+	}
+	reject := func(m []int) {
+		invalid = append(invalid, match(m, 0, 0, "Not a valid time; no due time taken from it"))
+	}
+
+	for _, m := range p.matches(reClock) {
+		hour, _ := strconv.Atoi(p.group(m, 2))
+		minute, _ := strconv.Atoi(p.group(m, 3))
+		if hour < 1 || hour > 12 {
+			reject(m)
+			continue
+		}
+		hour %= 12
+		if strings.HasPrefix(strings.ToLower(p.group(m, 4)), "p") {
+			hour += 12
+		}
+		add(m, hour, minute, "")
+	}
+	for _, m := range p.matches(reColon) {
+		hour, _ := strconv.Atoi(p.group(m, 2))
+		minute, _ := strconv.Atoi(p.group(m, 3))
+		reason := ""
+		if !strings.HasPrefix(p.group(m, 2), "0") && hour >= 1 && hour <= 11 {
+			hour, reason = guessHalfDay(hour)
+		}
+		add(m, hour, minute, reason)
+	}
+	for _, m := range p.matches(reBare) {
+		hour, _ := strconv.Atoi(p.group(m, 2))
+		reason := ""
+		switch {
+		case hour > 23:
+			continue
+		case hour >= 1 && hour <= 11:
+			hour, reason = guessHalfDay(hour)
+		}
+		add(m, hour, 0, reason)
+	}
+	for _, m := range p.matches(reDayPart) {
+		hm := dayParts[strings.ToLower(p.group(m, 2))]
+		add(m, hm[0], hm[1], "")
+	}
+	// reColon only matches real times, so look again for "at hh:mm"
+	for _, m := range p.matches(reAtColon) {
+		hour, _ := strconv.Atoi(p.group(m, 2))
+		minute, _ := strconv.Atoi(p.group(m, 3))
+		if hour > 23 || minute > 59 {
+			reject(m)
+		}
+	}
+
+	times = longestTimes(found, nil)
+	return times, longestTimes(invalid, times)
+}
+
+// longestTimes returns the times in found that don't overlap a longer one
+// or any in taken, in text order.
+func longestTimes(found, taken []timeMatch) []timeMatch {
+	sort.SliceStable(found, func(i, j int) bool {
+		return found[i].end-found[i].start+found[i].prefix > found[j].end-found[j].start+found[j].prefix
+	})
+	kept := append([]timeMatch(nil), taken...)
+	for _, t := range found {
+		overlaps := false
+		for _, k := range kept {
+			if t.start-t.prefix < k.end && k.start-k.prefix < t.end {
+				overlaps = true
+				break
+			}
+		}
+		if !overlaps {
+			kept = append(kept, t)
+		}
+	}
+	kept = kept[len(taken):]
+	sort.Slice(kept, func(i, j int) bool { return kept[i].start < kept[j].start })
+	return kept
+}
+
+// guessHalfDay reads an hour without am or pm the way it is usually meant:
+// 1 to 7 in the afternoon or evening, 8 to 11 in the morning.
+func guessHalfDay(hour int) (int, string) {
+	if hour <= 7 {
+		return hour + 12, "Read as " + strconv.Itoa(hour) + "pm; could be " + strconv.Itoa(hour) + "am"
+	}
+	return hour, "Read as " + strconv.Itoa(hour) + "am; could be " + strconv.Itoa(hour) + "pm"
+}
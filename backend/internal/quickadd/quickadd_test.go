@@ -0,0 +1,250 @@
+package quickadd
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	ny, err := Location("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A Wednesday morning
+	now := time.Date(2025, 3, 12, 10, 0, 0, 0, ny)
+	at := func(month time.Month, day, hour, minute int) *time.Time {
+		t := time.Date(2025, month, day, hour, minute, 0, 0, ny)
+		return &t
+	}
+
+	tests := []struct {
+		text        string
+		recipient   string
+		description string
+		due         *time.Time
+		frequency   string
+		confidence  float64
+		ambiguous   []string
+	}{
+		{
+			text:        "Promise Sam I'll return the drill by Friday 6pm, remind me daily",
+			recipient:   "Sam",
+			description: "Return the drill",
+			due:         at(time.March, 14, 18, 0),
+			frequency:   "daily",
+			confidence:  1,
+		},
+		{
+			text:        "I promised Mom to call her tomorrow evening",
+			recipient:   "Mom",
+			description: "Call her",
+			due:         at(time.March, 13, 18, 0),
+			confidence:  1,
+		},
+		{
+			text:        "Tell Priya that I'll send the slides in 2 hours",
+			recipient:   "Priya",
+			description: "Send the slides",
+			due:         at(time.March, 12, 12, 0),
+			confidence:  1,
+		},
+		{
+			text:        "Return the book to Alex next Tuesday at 3pm",
+			recipient:   "Alex",
+			description: "Return the book",
+			due:         at(time.March, 18, 15, 0),
+			confidence:  0.85,
+			ambiguous:   []string{"Alex"},
+		},
+		{
+			text:        "Promise Lee I'll review the draft by 2025-04-01 09:30, remind me every week",
+			recipient:   "Lee",
+			description: "Review the draft",
+			due:         at(time.April, 1, 9, 30),
+			frequency:   "weekly",
+			confidence:  1,
+		},
+		{
+			text:        "Promise Jo to bring snacks on the 20th",
+			recipient:   "Jo",
+			description: "Bring snacks",
+			due:         at(time.March, 20, 23, 59),
+			confidence:  1,
+		},
+		{
+			text:        "Promise Eve I’ll send the photos tonight",
+			recipient:   "Eve",
+			description: "Send the photos",
+			due:         at(time.March, 12, 20, 0),
+			confidence:  1,
+		},
+		{
+			text:        "Promise Zoë I'll bake a cake on Saturday morning",
+			recipient:   "Zoë",
+			description: "Bake a cake",
+			due:         at(time.March, 15, 9, 0),
+			confidence:  1,
+		},
+		{
+			text:        "Promise Ravi to return the ladder in a week",
+			recipient:   "Ravi",
+			description: "Return the ladder",
+			due:         at(time.March, 19, 23, 59),
+			confidence:  1,
+		},
+		{
+			text:        "Sam: fix the fence before end of month",
+			recipient:   "Sam",
+			description: "Fix the fence",
+			due:         at(time.March, 31, 23, 59),
+			confidence:  1,
+		},
+		{
+			// Dates that have passed this year mean next year
+			text:        "Promise Ana I'll call on March 3",
+			recipient:   "Ana",
+			description: "Call",
+			due:         ptr(time.Date(2026, 3, 3, 23, 59, 0, 0, ny)),
+			confidence:  1,
+		},
+		{
+			text:        "Promise Max I'll fix the bike by Wednesday",
+			recipient:   "Max",
+			description: "Fix the bike",
+			due:         at(time.March, 12, 23, 59),
+			confidence:  0.85,
+			ambiguous:   []string{"Wednesday"},
+		},
+		{
+			text:        "Promise Kim I'll water the plants next Friday",
+			recipient:   "Kim",
+			description: "Water the plants",
+			due:         at(time.March, 21, 23, 59),
+			confidence:  0.85,
+			ambiguous:   []string{"next Friday"},
+		},
+		{
+			// The deadline wins over other dates, which stay in the
+			// description
+			text:        "Promise Bo I'll call about the March 20 meeting by Friday",
+			recipient:   "Bo",
+			description: "Call about the March 20 meeting",
+			due:         at(time.March, 14, 23, 59),
+			confidence:  0.85,
+			ambiguous:   []string{"March 20"},
+		},
+		{
+			text:        "Send Dana the invoice on 4/5",
+			description: "Send Dana the invoice",
+			due:         at(time.April, 5, 23, 59),
+			confidence:  0.45,
+			ambiguous:   []string{"4/5"},
+		},
+		{
+			text:        "Send Dana the invoice on 25/4",
+			description: "Send Dana the invoice",
+			due:         at(time.April, 25, 23, 59),
+			confidence:  0.6,
+		},
+		{
+			text:        "Call the plumber at 6",
+			description: "Call the plumber",
+			due:         at(time.March, 12, 18, 0),
+			confidence:  0.45,
+			ambiguous:   []string{"6"},
+		},
+		{
+			// Times already past today mean tomorrow
+			text:        "Call the bank at 9am",
+			description: "Call the bank",
+			due:         at(time.March, 13, 9, 0),
+			confidence:  0.6,
+		},
+		{
+			text:        "Promise Sam I'll stretch, remind me hourly",
+			recipient:   "Sam",
+			description: "Stretch",
+			confidence:  0.85,
+			ambiguous:   []string{"remind me hourly"},
+		},
+		{
+			// 9 o'clock this morning has passed
+			text:        "Promise Sam I'll sort it out today at 9:00",
+			recipient:   "Sam",
+			description: "Sort it out",
+			due:         at(time.March, 12, 9, 0),
+			confidence:  0.7,
+			ambiguous:   []string{"today", "9:00"},
+		},
+		{
+			// Dates and times that don't exist are flagged, not dropped
+			text:        "pay rent by Feb 30",
+			description: "Pay rent by Feb 30",
+			confidence:  0.45,
+			ambiguous:   []string{"Feb 30"},
+		},
+		{
+			text:        "buy milk at 25:00",
+			description: "Buy milk at 25:00",
+			confidence:  0.45,
+			ambiguous:   []string{"25:00"},
+		},
+		{
+			text:        "Renew the lease on 2025-02-29",
+			description: "Renew the lease on 2025-02-29",
+			confidence:  0.45,
+			ambiguous:   []string{"2025-02-29"},
+		},
+		{
+			text:        "Split the bill 50/50",
+			description: "Split the bill 50/50",
+			confidence:  0.6,
+		},
+		{
+			text:        "Buy milk",
+			description: "Buy milk",
+			confidence:  0.6,
+		},
+		{
+			text:       "",
+			confidence: 0.1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			got := Parse(tt.text, now)
+			if got.Recipient != tt.recipient || got.Description != tt.description || got.ReminderFrequency != tt.frequency {
+				t.Errorf("Got recipient %q, description %q, frequency %q", got.Recipient, got.Description, got.ReminderFrequency)
+			}
+			if (got.DueDate == nil) != (tt.due == nil) || (got.DueDate != nil && !got.DueDate.Equal(*tt.due)) {
+				t.Errorf("Got due date %v, want %v", got.DueDate, tt.due)
+			}
+			if got.Confidence != tt.confidence {
+				t.Errorf("Got confidence %v, want %v", got.Confidence, tt.confidence)
+			}
+			if len(got.Ambiguous) != len(tt.ambiguous) {
+				t.Fatalf("Got ambiguous spans %+v, want %q", got.Ambiguous, tt.ambiguous)
+			}
+			for i, span := range got.Ambiguous {
+				if span.Text != tt.ambiguous[i] || tt.text[span.Start:span.End] != span.Text || span.Reason == "" {
+					t.Errorf("Got ambiguous span %+v, want %q", span, tt.ambiguous[i])
+				}
+			}
+		})
+	}
+}
+
+func TestParseIsDeterministic(t *testing.T) {
+	now := time.Date(2025, 3, 12, 10, 0, 0, 0, time.UTC)
+	text := "Promise Bo I'll call about the March 20 meeting at 5 by next Friday 6pm, remind me weekly"
+	first := Parse(text, now)
+	for range 20 {
+		got := Parse(text, now)
+		if got.Description != first.Description || !got.DueDate.Equal(*first.DueDate) || len(got.Ambiguous) != len(first.Ambiguous) {
+			t.Fatalf("Got %+v, then %+v", first, got)
+		}
+	}
+}
+
+func ptr(t time.Time) *time.Time { return &t }
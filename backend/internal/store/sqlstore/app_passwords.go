@@ -0,0 +1,62 @@
+package sqlstore
+
+import (
+	"kept/internal/models"
+)
+
+type appPasswordRepo struct{ s *Store }
+
+const appPasswordColumns = "id, user_id, name, created_at"
+
+func scanAppPassword(row interface{ Scan(...any) error }) (*models.AppPassword, error) {
+	var p models.AppPassword
+	var createdAt nullTime
+	if err := row.Scan(&p.ID, &p.UserID, &p.Name, &createdAt); err != nil {
+		return nil, notFound(err)
+	}
+	p.CreatedAt = createdAt.Time
+	return &p, nil
+}
+
+func (r appPasswordRepo) Create(p *models.AppPassword, tokenHash string) error {
+	created := now()
+	id, err := r.s.insert(
+		"INSERT INTO app_passwords (user_id, name, token_hash, created_at) VALUES (?, ?, ?, ?)",
+		p.UserID, p.Name, tokenHash, created,
+	)
+	if err != nil {
+		return err
+	}
+	p.ID = id
+	p.CreatedAt = created
+	return nil
+}
+
+func (r appPasswordRepo) GetByTokenHash(tokenHash string) (*models.AppPassword, error) {
+	return scanAppPassword(r.s.queryRow("SELECT "+appPasswordColumns+" FROM app_passwords WHERE token_hash = ?", tokenHash))
+}
+
+func (r appPasswordRepo) ListByUser(userID int) ([]models.AppPassword, error) {
+	rows, err := r.s.query(
+		"SELECT "+appPasswordColumns+" FROM app_passwords WHERE user_id = ? ORDER BY created_at DESC, id DESC",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	passwords := []models.AppPassword{}
+	for rows.Next() {
+		p, err := scanAppPassword(rows)
+		if err != nil {
+			return nil, err
+		}
+		passwords = append(passwords, *p)
+	}
+	return passwords, rows.Err()
+}
+
+func (r appPasswordRepo) Delete(id, userID int) error {
+	return r.s.execOne("DELETE FROM app_passwords WHERE id = ? AND user_id = ?", id, userID)
+}
@@ -0,0 +1,49 @@
+package sqlstore
+
+import "kept/internal/store"
+
+type caldavObjectRepo struct{ s *Store }
+
+func (r caldavObjectRepo) Create(o store.CalDAVObject) error {
+	_, err := r.s.exec(
+		"INSERT INTO caldav_objects (promise_id, user_id, uid, name) VALUES (?, ?, ?, ?)",
+		o.PromiseID, o.UserID, o.UID, o.Name,
+	)
+	if err != nil && r.s.db.Dialect.IsUniqueViolation(err) {
+		return store.ErrConflict
+	}
+	return err
+}
+
+func (r caldavObjectRepo) GetByName(userID int, name string) (*store.CalDAVObject, error) {
+	var o store.CalDAVObject
+	err := r.s.queryRow(
+		"SELECT promise_id, user_id, uid, name FROM caldav_objects WHERE user_id = ? AND name = ?",
+		userID, name,
+	).Scan(&o.PromiseID, &o.UserID, &o.UID, &o.Name)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	return &o, nil
+}
+
+func (r caldavObjectRepo) ListByUser(userID int) ([]store.CalDAVObject, error) {
+	rows, err := r.s.query(
+		"SELECT promise_id, user_id, uid, name FROM caldav_objects WHERE user_id = ? ORDER BY promise_id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var objects []store.CalDAVObject
+	for rows.Next() {
+		var o store.CalDAVObject
+		if err := rows.Scan(&o.PromiseID, &o.UserID, &o.UID, &o.Name); err != nil {
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+	return objects, rows.Err()
+}
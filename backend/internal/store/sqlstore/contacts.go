@@ -0,0 +1,209 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"kept/internal/models"
+	"kept/internal/store"
+)
+
+type contactRepo struct{ s *Store }
+
+const contactColumns = "id, user_id, name, aliases, email, phone, notes, created_at, updated_at"
+
+// scan reads a contact and decrypts its fields.
+func (r contactRepo) scan(row interface{ Scan(...any) error }) (*models.Contact, error) {
+	var c models.Contact
+	var aliases, email, phone, notes sql.NullString
+	var createdAt, updatedAt nullTime
+	err := row.Scan(&c.ID, &c.UserID, &c.Name, &aliases, &email, &phone, &notes, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	fields := []struct {
+		field string
+		value *string
+		from  string
+	}{
+		{fieldContactName, &c.Name, c.Name},
+		{fieldContactEmail, &c.Email, email.String},
+		{fieldContactPhone, &c.Phone, phone.String},
+		{fieldContactNotes, &c.Notes, notes.String},
+	}
+	for _, f := range fields {
+		if *f.value, err = r.s.decrypt(c.UserID, f.field, f.from); err != nil {
+			return nil, err
+		}
+	}
+	aliasJSON, err := r.s.decrypt(c.UserID, fieldContactAliases, aliases.String)
+	if err != nil {
+		return nil, err
+	}
+	c.Aliases = []string{}
+	if aliasJSON != "" {
+		if err := json.Unmarshal([]byte(aliasJSON), &c.Aliases); err != nil {
+			return nil, err
+		}
+	}
+	c.CreatedAt = createdAt.Time
+	c.UpdatedAt = updatedAt.Time
+	return &c, nil
+}
+
+// sealed returns the name, aliases, email, phone and notes as they are
+// stored.
+func (r contactRepo) sealed(c *models.Contact) ([]any, error) {
+	aliases := ""
+	if len(c.Aliases) > 0 {
+		b, err := json.Marshal(c.Aliases)
+		if err != nil {
+			return nil, err
+		}
+		aliases = string(b)
+	}
+	values := []struct {
+		field, value string
+	}{
+		{fieldContactName, c.Name},
+		{fieldContactAliases, aliases},
+		{fieldContactEmail, c.Email},
+		{fieldContactPhone, c.Phone},
+		{fieldContactNotes, c.Notes},
+	}
+	sealed := make([]any, len(values))
+	for i, v := range values {
+		value, err := r.s.encrypt(c.UserID, v.field, v.value)
+		if err != nil {
+			return nil, err
+		}
+		if i == 0 {
+			sealed[i] = value
+		} else {
+			sealed[i] = nullString(value)
+		}
+	}
+	return sealed, nil
+}
+
+func (r contactRepo) Create(c *models.Contact) error {
+	sealed, err := r.sealed(c)
+	if err != nil {
+		return err
+	}
+	created := now()
+	id, err := r.s.insert(
+		`INSERT INTO contacts (user_id, name, aliases, email, phone, notes, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		append(append([]any{c.UserID}, sealed...), created, created)...,
+	)
+	if err != nil {
+		return err
+	}
+	c.ID = id
+	if c.Aliases == nil {
+		c.Aliases = []string{}
+	}
+	c.CreatedAt = created
+	c.UpdatedAt = created
+	return nil
+}
+
+func (r contactRepo) Get(id, userID int) (*models.Contact, error) {
+	return r.scan(r.s.queryRow("SELECT "+contactColumns+" FROM contacts WHERE id = ? AND user_id = ?", id, userID))
+}
+
+func (r contactRepo) ListByUser(userID int) ([]models.Contact, error) {
+	rows, err := r.s.query("SELECT "+contactColumns+" FROM contacts WHERE user_id = ? ORDER BY id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	contacts := []models.Contact{}
+	for rows.Next() {
+		c, err := r.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, *c)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Names may be encrypted, so they are sorted after decrypting
+	sort.SliceStable(contacts, func(i, j int) bool {
+		return strings.ToLower(contacts[i].Name) < strings.ToLower(contacts[j].Name)
+	})
+	return contacts, nil
+}
+
+func (r contactRepo) Update(c *models.Contact) error {
+	sealed, err := r.sealed(c)
+	if err != nil {
+		return err
+	}
+	updated := now()
+	err = r.s.execOne(
+		`UPDATE contacts SET name = ?, aliases = ?, email = ?, phone = ?, notes = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?`,
+		append(sealed, updated, c.ID, c.UserID)...,
+	)
+	if err != nil {
+		return err
+	}
+	c.UpdatedAt = updated
+	return nil
+}
+
+func (r contactRepo) Delete(id, userID int) error {
+	_, err := r.s.exec(
+		"UPDATE promises SET contact_id = NULL, version = version + 1, updated_at = ? WHERE contact_id = ? AND user_id = ?",
+		now(), id, userID,
+	)
+	if err != nil {
+		return err
+	}
+	return r.s.execOne("DELETE FROM contacts WHERE id = ? AND user_id = ?", id, userID)
+}
+
+func (r contactRepo) MovePromises(from, into int) error {
+	_, err := r.s.exec(
+		"UPDATE promises SET contact_id = ?, version = version + 1, updated_at = ? WHERE contact_id = ?",
+		into, now(), from,
+	)
+	return err
+}
+
+func (r contactRepo) ListUnlinked() ([]store.UnlinkedPromise, error) {
+	rows, err := r.s.query(
+		"SELECT id, user_id, recipient FROM promises WHERE contact_id IS NULL AND encrypted = ? AND recipient <> '' ORDER BY id",
+		false,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var unlinked []store.UnlinkedPromise
+	for rows.Next() {
+		var p store.UnlinkedPromise
+		if err := rows.Scan(&p.ID, &p.UserID, &p.Recipient); err != nil {
+			return nil, err
+		}
+		if p.Recipient, err = r.s.decrypt(p.UserID, fieldRecipient, p.Recipient); err != nil {
+			return nil, err
+		}
+		unlinked = append(unlinked, p)
+	}
+	return unlinked, rows.Err()
+}
+
+func (r contactRepo) Link(promiseID, contactID int) error {
+	return r.s.execOne(
+		"UPDATE promises SET contact_id = ?, version = version + 1 WHERE id = ? AND contact_id IS NULL",
+		contactID, promiseID,
+	)
+}
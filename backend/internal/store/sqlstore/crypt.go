@@ -0,0 +1,217 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"kept/internal/fieldcrypt"
+)
+
+// Encrypted columns, named as they are bound into the ciphertext.
+const (
+	fieldRecipient      = "promises.recipient"
+	fieldDescription    = "promises.description"
+	fieldRecipientEmail = "promises.recipient_email"
+	fieldReflectionNote = "promise_events.reflection_note"
+	fieldRevisionValue  = "promise_revisions.value"
+	fieldItemTitle      = "promise_items.title"
+	fieldContactName    = "contacts.name"
+	fieldContactAliases = "contacts.aliases"
+	fieldContactEmail   = "contacts.email"
+	fieldContactPhone   = "contacts.phone"
+	fieldContactNotes   = "contacts.notes"
+
+	fieldTemplateRecipient   = "promise_templates.recipient"
+	fieldTemplateDescription = "promise_templates.description"
+)
+
+var errNoKeyring = errors.New("database has encrypted fields but FIELD_ENCRYPTION_KEYS is not set")
+
+// fieldCipher encrypts promise content with per-user data keys. Unwrapped
+// data keys are cached by ID; keys only ever get added, so entries don't go
+// stale.
+type fieldCipher struct {
+	keyring *fieldcrypt.Keyring
+	mu      sync.Mutex
+	keys    map[int][]byte
+}
+
+func (c *fieldCipher) cached(id int) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	key, ok := c.keys[id]
+	return key, ok
+}
+
+func (c *fieldCipher) remember(id int, key []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.keys[id] = key
+}
+
+// Option configures a Store.
+type Option func(*Store)
+
+// WithKeyring turns on field encryption. New promise content is encrypted
+// with the user's current data key; existing plaintext rows stay readable
+// until ReencryptFields rewrites them.
+func WithKeyring(k *fieldcrypt.Keyring) Option {
+	return func(s *Store) {
+		if k != nil {
+			s.crypt = &fieldCipher{keyring: k, keys: map[int][]byte{}}
+		}
+	}
+}
+
+// FieldsEncrypted reports whether promise content is encrypted at rest, in
+// which case it can't be searched or sorted in SQL.
+func (s *Store) FieldsEncrypted() bool {
+	return s.crypt != nil
+}
+
+// encrypt seals a value for userID, creating the user's first data key if
+// needed. Values pass through unchanged when encryption is off.
+func (s *Store) encrypt(userID int, field, value string) (string, error) {
+	if s.crypt == nil || value == "" {
+		return value, nil
+	}
+	id, key, err := s.currentDataKey(userID)
+	if err != nil {
+		return "", err
+	}
+	return fieldcrypt.Encrypt(key, id, userID, field, value)
+}
+
+// decrypt opens a value written by encrypt. Plaintext from before encryption
+// was turned on is returned as is.
+func (s *Store) decrypt(userID int, field, value string) (string, error) {
+	if !fieldcrypt.IsEncrypted(value) {
+		return value, nil
+	}
+	if s.crypt == nil {
+		return "", errNoKeyring
+	}
+	id, err := fieldcrypt.KeyID(value)
+	if err != nil {
+		return "", err
+	}
+	key, err := s.dataKey(userID, id)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := fieldcrypt.Decrypt(key, userID, field, value)
+	if err != nil {
+		return "", fmt.Errorf("decrypting %s for user %d: %w", field, userID, err)
+	}
+	return plaintext, nil
+}
+
+// currentDataKey returns the user's newest data key, creating one if the user
+// has none yet.
+func (s *Store) currentDataKey(userID int) (int, []byte, error) {
+	var id, version int
+	var wrapped string
+	err := s.queryRow(
+		"SELECT id, master_version, wrapped_key FROM data_keys WHERE user_id = ? ORDER BY id DESC LIMIT 1",
+		userID,
+	).Scan(&id, &version, &wrapped)
+	if errors.Is(err, sql.ErrNoRows) {
+		return s.createDataKey(userID)
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+	if key, ok := s.crypt.cached(id); ok {
+		return id, key, nil
+	}
+	key, err := s.crypt.keyring.Unwrap(userID, version, wrapped)
+	if err != nil {
+		return 0, nil, err
+	}
+	if !s.tx {
+		s.crypt.remember(id, key)
+	}
+	return id, key, nil
+}
+
+func (s *Store) createDataKey(userID int) (int, []byte, error) {
+	key, err := fieldcrypt.NewDataKey()
+	if err != nil {
+		return 0, nil, err
+	}
+	version, wrapped, err := s.crypt.keyring.Wrap(userID, key)
+	if err != nil {
+		return 0, nil, err
+	}
+	id, err := s.insert(
+		"INSERT INTO data_keys (user_id, master_version, wrapped_key, created_at) VALUES (?, ?, ?, ?)",
+		userID, version, wrapped, now(),
+	)
+	if err != nil {
+		return 0, nil, err
+	}
+	// A key created inside a transaction may be rolled back and its ID
+	// reused, so keys are only cached outside transactions.
+	if !s.tx {
+		s.crypt.remember(id, key)
+	}
+	return id, key, nil
+}
+
+func (s *Store) dataKey(userID, id int) ([]byte, error) {
+	if key, ok := s.crypt.cached(id); ok {
+		return key, nil
+	}
+	var version int
+	var wrapped string
+	err := s.queryRow(
+		"SELECT master_version, wrapped_key FROM data_keys WHERE id = ? AND user_id = ?",
+		id, userID,
+	).Scan(&version, &wrapped)
+	if err != nil {
+		return nil, fmt.Errorf("loading data key %d: %w", id, notFound(err))
+	}
+	key, err := s.crypt.keyring.Unwrap(userID, version, wrapped)
+	if err != nil {
+		return nil, err
+	}
+	if !s.tx {
+		s.crypt.remember(id, key)
+	}
+	return key, nil
+}
+
+// CheckKeyring verifies that every master key version data keys are wrapped
+// with is configured, so encrypted rows can be read. It also fails if data
+// keys exist but encryption is off.
+func (s *Store) CheckKeyring() error {
+	rows, err := s.query("SELECT DISTINCT master_version FROM data_keys ORDER BY master_version")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var missing []string
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return err
+		}
+		if s.crypt == nil {
+			return errNoKeyring
+		}
+		if !s.crypt.keyring.Has(version) {
+			missing = append(missing, fmt.Sprint(version))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("FIELD_ENCRYPTION_KEYS is missing master key version(s) %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"kept/internal/models"
+)
+
+type eventRepo struct{ s *Store }
+
+func (r eventRepo) Create(e *models.Event) error {
+	note := e.ReflectionNote
+	if note != "" && r.s.FieldsEncrypted() {
+		var userID int
+		if err := r.s.queryRow("SELECT user_id FROM promises WHERE id = ?", e.PromiseID).Scan(&userID); err != nil {
+			return notFound(err)
+		}
+		var err error
+		if note, err = r.s.encrypt(userID, fieldReflectionNote, note); err != nil {
+			return err
+		}
+	}
+
+	envelope, err := jsonValue(e.Envelope)
+	if err != nil {
+		return err
+	}
+	created := now()
+	id, err := r.s.insert(
+		"INSERT INTO promise_events (promise_id, state, reflection_note, envelope, actor_user_id, created_at) VALUES (?, ?, ?, ?, ?, ?)",
+		e.PromiseID, e.State, nullString(note), envelope, e.ActorUserID, created,
+	)
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	e.CreatedAt = created
+	return nil
+}
+
+func (r eventRepo) list(query string, args ...any) ([]models.Event, error) {
+	rows, err := r.s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []models.Event{}
+	for rows.Next() {
+		var e models.Event
+		var userID int
+		var note, envelope sql.NullString
+		var actorUserID sql.NullInt64
+		var createdAt nullTime
+		if err := rows.Scan(&e.ID, &e.PromiseID, &userID, &e.State, &note, &envelope, &actorUserID, &createdAt); err != nil {
+			return nil, err
+		}
+		if actorUserID.Valid {
+			id := int(actorUserID.Int64)
+			e.ActorUserID = &id
+		}
+		if e.Envelope, err = parseJSON[models.Envelope](envelope); err != nil {
+			return nil, err
+		}
+		if e.ReflectionNote, err = r.s.decrypt(userID, fieldReflectionNote, note.String); err != nil {
+			return nil, err
+		}
+		e.CreatedAt = createdAt.Time
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func (r eventRepo) ListByPromise(promiseID int) ([]models.Event, error) {
+	return r.list(
+		`SELECT pe.id, pe.promise_id, p.user_id, pe.state, pe.reflection_note, pe.envelope, pe.actor_user_id, pe.created_at
+		FROM promise_events pe JOIN promises p ON p.id = pe.promise_id
+		WHERE pe.promise_id = ? ORDER BY pe.created_at ASC, pe.id ASC`,
+		promiseID,
+	)
+}
+
+func (r eventRepo) ListByUser(userID int) ([]models.Event, error) {
+	return r.list(
+		`SELECT pe.id, pe.promise_id, p.user_id, pe.state, pe.reflection_note, pe.envelope, pe.actor_user_id, pe.created_at
+		FROM promise_events pe JOIN promises p ON p.id = pe.promise_id
+		WHERE p.user_id = ? AND p.deleted_at IS NULL ORDER BY pe.created_at DESC, pe.id DESC`,
+		userID,
+	)
+}
+
+func (r eventRepo) ListByWorkspace(workspaceID int) ([]models.Event, error) {
+	return r.list(
+		`SELECT pe.id, pe.promise_id, p.user_id, pe.state, pe.reflection_note, pe.envelope, pe.actor_user_id, pe.created_at
+		FROM promise_events pe JOIN promises p ON p.id = pe.promise_id
+		WHERE p.workspace_id = ? AND p.deleted_at IS NULL ORDER BY pe.created_at DESC, pe.id DESC`,
+		workspaceID,
+	)
+}
@@ -0,0 +1,161 @@
+package sqlstore
+
+import (
+	"kept/internal/models"
+)
+
+type itemRepo struct{ s *Store }
+
+const itemQuery = `SELECT i.id, i.promise_id, p.user_id, i.position, i.title, i.due_date, i.done_at, i.created_at, i.updated_at
+	FROM promise_items i JOIN promises p ON p.id = i.promise_id`
+
+func (r itemRepo) scan(row interface{ Scan(...any) error }) (*models.PromiseItem, error) {
+	var it models.PromiseItem
+	var userID int
+	var dueDate, doneAt, createdAt, updatedAt nullTime
+	if err := row.Scan(&it.ID, &it.PromiseID, &userID, &it.Position, &it.Title, &dueDate, &doneAt, &createdAt, &updatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	var err error
+	if it.Title, err = r.s.decrypt(userID, fieldItemTitle, it.Title); err != nil {
+		return nil, err
+	}
+	it.DueDate = dueDate.ptr()
+	it.DoneAt = doneAt.ptr()
+	it.Done = it.DoneAt != nil
+	it.CreatedAt = createdAt.Time
+	it.UpdatedAt = updatedAt.Time
+	return &it, nil
+}
+
+// sealTitle encrypts an item title for the owner of its promise.
+func (r itemRepo) sealTitle(promiseID int, title string) (string, error) {
+	if !r.s.FieldsEncrypted() {
+		return title, nil
+	}
+	var userID int
+	if err := r.s.queryRow("SELECT user_id FROM promises WHERE id = ?", promiseID).Scan(&userID); err != nil {
+		return "", notFound(err)
+	}
+	return r.s.encrypt(userID, fieldItemTitle, title)
+}
+
+func (r itemRepo) Create(it *models.PromiseItem) error {
+	title, err := r.sealTitle(it.PromiseID, it.Title)
+	if err != nil {
+		return err
+	}
+	var position int
+	if err := r.s.queryRow("SELECT COUNT(*) FROM promise_items WHERE promise_id = ?", it.PromiseID).Scan(&position); err != nil {
+		return err
+	}
+	if it.Done && it.DoneAt == nil {
+		done := now()
+		it.DoneAt = &done
+	}
+	created := now()
+	id, err := r.s.insert(
+		`INSERT INTO promise_items (promise_id, position, title, due_date, done_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		it.PromiseID, position, title, utcPtr(it.DueDate), utcPtr(it.DoneAt), created, created,
+	)
+	if err != nil {
+		return err
+	}
+	it.ID = id
+	it.Position = position
+	it.DueDate = utcPtr(it.DueDate)
+	it.CreatedAt = created
+	it.UpdatedAt = created
+	return nil
+}
+
+func (r itemRepo) Get(id int) (*models.PromiseItem, error) {
+	return r.scan(r.s.queryRow(itemQuery+" WHERE i.id = ?", id))
+}
+
+func (r itemRepo) ListByPromise(promiseID int) ([]models.PromiseItem, error) {
+	rows, err := r.s.query(itemQuery+" WHERE i.promise_id = ? ORDER BY i.position, i.id", promiseID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := []models.PromiseItem{}
+	for rows.Next() {
+		it, err := r.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, *it)
+	}
+	return items, rows.Err()
+}
+
+func (r itemRepo) Update(it *models.PromiseItem) error {
+	title, err := r.sealTitle(it.PromiseID, it.Title)
+	if err != nil {
+		return err
+	}
+	switch {
+	case !it.Done:
+		it.DoneAt = nil
+	case it.DoneAt == nil:
+		done := now()
+		it.DoneAt = &done
+	}
+	updated := now()
+	err = r.s.execOne(
+		"UPDATE promise_items SET title = ?, due_date = ?, done_at = ?, updated_at = ? WHERE id = ?",
+		title, utcPtr(it.DueDate), utcPtr(it.DoneAt), updated, it.ID,
+	)
+	if err != nil {
+		return err
+	}
+	it.DueDate = utcPtr(it.DueDate)
+	it.UpdatedAt = updated
+	return nil
+}
+
+func (r itemRepo) Move(id, position int) error {
+	var promiseID, from, count int
+	err := r.s.queryRow(
+		`SELECT i.promise_id, i.position, (SELECT COUNT(*) FROM promise_items o WHERE o.promise_id = i.promise_id)
+		FROM promise_items i WHERE i.id = ?`,
+		id,
+	).Scan(&promiseID, &from, &count)
+	if err != nil {
+		return notFound(err)
+	}
+	position = max(0, min(position, count-1))
+	switch {
+	case position < from:
+		_, err = r.s.exec(
+			"UPDATE promise_items SET position = position + 1 WHERE promise_id = ? AND position >= ? AND position < ?",
+			promiseID, position, from,
+		)
+	case position > from:
+		_, err = r.s.exec(
+			"UPDATE promise_items SET position = position - 1 WHERE promise_id = ? AND position > ? AND position <= ?",
+			promiseID, from, position,
+		)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return r.s.execOne("UPDATE promise_items SET position = ?, updated_at = ? WHERE id = ?", position, now(), id)
+}
+
+func (r itemRepo) Delete(id int) error {
+	var promiseID, position int
+	if err := r.s.queryRow("SELECT promise_id, position FROM promise_items WHERE id = ?", id).Scan(&promiseID, &position); err != nil {
+		return notFound(err)
+	}
+	if err := r.s.execOne("DELETE FROM promise_items WHERE id = ?", id); err != nil {
+		return err
+	}
+	_, err := r.s.exec("UPDATE promise_items SET position = position - 1 WHERE promise_id = ? AND position > ?", promiseID, position)
+	return err
+}
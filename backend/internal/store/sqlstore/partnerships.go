@@ -0,0 +1,75 @@
+package sqlstore
+
+import (
+	"kept/internal/models"
+)
+
+type partnershipRepo struct{ s *Store }
+
+const partnershipQuery = `SELECT p.id, p.user_id, u.username, p.partner_id, partner.username, p.accepted_at, p.created_at
+	FROM partnerships p
+	JOIN users u ON u.id = p.user_id
+	JOIN users partner ON partner.id = p.partner_id`
+
+func scanPartnership(row interface{ Scan(...any) error }) (*models.Partnership, error) {
+	var p models.Partnership
+	var acceptedAt, createdAt nullTime
+	if err := row.Scan(&p.ID, &p.UserID, &p.Username, &p.PartnerID, &p.PartnerUsername, &acceptedAt, &createdAt); err != nil {
+		return nil, notFound(err)
+	}
+	p.AcceptedAt = acceptedAt.ptr()
+	p.CreatedAt = createdAt.Time
+	return &p, nil
+}
+
+func (r partnershipRepo) Create(p *models.Partnership) error {
+	created := now()
+	id, err := r.s.insert(
+		"INSERT INTO partnerships (user_id, partner_id, created_at) VALUES (?, ?, ?)",
+		p.UserID, p.PartnerID, created,
+	)
+	if err != nil {
+		return err
+	}
+	p.ID = id
+	p.AcceptedAt = nil
+	p.CreatedAt = created
+	return nil
+}
+
+func (r partnershipRepo) Get(id int) (*models.Partnership, error) {
+	return scanPartnership(r.s.queryRow(partnershipQuery+" WHERE p.id = ?", id))
+}
+
+func (r partnershipRepo) Find(userID, partnerID int) (*models.Partnership, error) {
+	return scanPartnership(r.s.queryRow(partnershipQuery+" WHERE p.user_id = ? AND p.partner_id = ?", userID, partnerID))
+}
+
+func (r partnershipRepo) ListByUser(userID int) ([]models.Partnership, error) {
+	rows, err := r.s.query(
+		partnershipQuery+" WHERE p.user_id = ? OR p.partner_id = ? ORDER BY p.created_at, p.id",
+		userID, userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partnerships := []models.Partnership{}
+	for rows.Next() {
+		p, err := scanPartnership(rows)
+		if err != nil {
+			return nil, err
+		}
+		partnerships = append(partnerships, *p)
+	}
+	return partnerships, rows.Err()
+}
+
+func (r partnershipRepo) Accept(id int) error {
+	return r.s.execOne("UPDATE partnerships SET accepted_at = ? WHERE id = ? AND accepted_at IS NULL", now(), id)
+}
+
+func (r partnershipRepo) Delete(id int) error {
+	return r.s.execOne("DELETE FROM partnerships WHERE id = ?", id)
+}
@@ -0,0 +1,324 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"kept/internal/models"
+	"kept/internal/store"
+)
+
+type promiseRepo struct{ s *Store }
+
+const promiseColumns = "id, user_id, recipient, recipient_email, notify_recipient, description, due_date, current_state, reminder_frequency, last_reminded_at, encrypted, envelope, contact_id, workspace_id, partner_visible, keep_when_done, series_id, version, created_at, updated_at, deleted_at, " +
+	"(SELECT COUNT(*) FROM promise_items i WHERE i.promise_id = promises.id), " +
+	"(SELECT COUNT(i.done_at) FROM promise_items i WHERE i.promise_id = promises.id)"
+
+func scanPromise(row interface{ Scan(...any) error }) (*models.Promise, error) {
+	var p models.Promise
+	var recipientEmail, frequency, envelope sql.NullString
+	var dueDate, lastRemindedAt, createdAt, updatedAt, deletedAt nullTime
+	var encrypted, notify, partnerVisible, keepWhenDone flexBool
+	var contactID, workspaceID, seriesID sql.NullInt64
+	var items, itemsDone int
+	err := row.Scan(
+		&p.ID, &p.UserID, &p.Recipient, &recipientEmail, &notify, &p.Description, &dueDate, &p.CurrentState,
+		&frequency, &lastRemindedAt, &encrypted, &envelope, &contactID, &workspaceID, &partnerVisible, &keepWhenDone,
+		&seriesID, &p.Version, &createdAt, &updatedAt, &deletedAt, &items, &itemsDone,
+	)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	p.Encrypted = bool(encrypted)
+	p.RecipientEmail = recipientEmail.String
+	p.NotifyRecipient = bool(notify)
+	p.PartnerVisible = bool(partnerVisible)
+	p.KeepWhenDone = bool(keepWhenDone)
+	if items > 0 {
+		progress := itemsDone * 100 / items
+		p.Progress = &progress
+	}
+	if p.Envelope, err = parseJSON[models.Envelope](envelope); err != nil {
+		return nil, err
+	}
+	if contactID.Valid {
+		id := int(contactID.Int64)
+		p.ContactID = &id
+	}
+	if workspaceID.Valid {
+		id := int(workspaceID.Int64)
+		p.WorkspaceID = &id
+	}
+	if seriesID.Valid {
+		id := int(seriesID.Int64)
+		p.SeriesID = &id
+	}
+	p.DueDate = dueDate.ptr()
+	p.ReminderFrequency = frequency.String
+	p.LastRemindedAt = lastRemindedAt.ptr()
+	p.CreatedAt = createdAt.Time
+	p.UpdatedAt = updatedAt.Time
+	p.DeletedAt = deletedAt.ptr()
+	return &p, nil
+}
+
+// scan reads a promise and decrypts its content.
+func (r promiseRepo) scan(row interface{ Scan(...any) error }) (*models.Promise, error) {
+	p, err := scanPromise(row)
+	if err != nil {
+		return nil, err
+	}
+	if p.Recipient, err = r.s.decrypt(p.UserID, fieldRecipient, p.Recipient); err != nil {
+		return nil, err
+	}
+	if p.Description, err = r.s.decrypt(p.UserID, fieldDescription, p.Description); err != nil {
+		return nil, err
+	}
+	if p.RecipientEmail, err = r.s.decrypt(p.UserID, fieldRecipientEmail, p.RecipientEmail); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// sealed returns the recipient, description and recipient email as they are
+// stored.
+func (r promiseRepo) sealed(p *models.Promise) (recipient, description, email string, err error) {
+	if recipient, err = r.s.encrypt(p.UserID, fieldRecipient, p.Recipient); err != nil {
+		return "", "", "", err
+	}
+	if description, err = r.s.encrypt(p.UserID, fieldDescription, p.Description); err != nil {
+		return "", "", "", err
+	}
+	email, err = r.s.encrypt(p.UserID, fieldRecipientEmail, p.RecipientEmail)
+	return recipient, description, email, err
+}
+
+func (r promiseRepo) list(query string, args ...any) ([]models.Promise, error) {
+	rows, err := r.s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	promises := []models.Promise{}
+	for rows.Next() {
+		p, err := r.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		promises = append(promises, *p)
+	}
+	return promises, rows.Err()
+}
+
+func (r promiseRepo) Create(p *models.Promise) error {
+	recipient, description, email, err := r.sealed(p)
+	if err != nil {
+		return err
+	}
+	envelope, err := jsonValue(p.Envelope)
+	if err != nil {
+		return err
+	}
+	created := now()
+	id, err := r.s.insert(
+		`INSERT INTO promises (user_id, recipient, recipient_email, notify_recipient, description, due_date, current_state,
+		reminder_frequency, encrypted, envelope, contact_id, workspace_id, partner_visible, keep_when_done, series_id, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, 'active', ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.UserID, recipient, nullString(email), p.NotifyRecipient, description, utcPtr(p.DueDate), nullString(p.ReminderFrequency), p.Encrypted, envelope, p.ContactID, p.WorkspaceID, p.PartnerVisible, p.KeepWhenDone, p.SeriesID, created, created,
+	)
+	if err != nil {
+		return err
+	}
+	p.ID = id
+	p.CurrentState = "active"
+	p.Version = 1
+	p.DueDate = utcPtr(p.DueDate)
+	p.CreatedAt = created
+	p.UpdatedAt = created
+	return nil
+}
+
+func (r promiseRepo) Get(id int) (*models.Promise, error) {
+	return r.scan(r.s.queryRow("SELECT "+promiseColumns+" FROM promises WHERE id = ? AND deleted_at IS NULL", id))
+}
+
+func (r promiseRepo) ListByUser(userID int, filter store.PromiseFilter) ([]models.Promise, error) {
+	query := "SELECT " + promiseColumns + " FROM promises WHERE user_id = ? AND workspace_id IS NULL AND deleted_at IS NULL"
+	args := []any{userID}
+	if filter.WorkspaceID != 0 {
+		query = "SELECT " + promiseColumns + " FROM promises WHERE workspace_id = ? AND deleted_at IS NULL"
+		args = []any{filter.WorkspaceID}
+	}
+	if filter.SeriesID != 0 {
+		query = "SELECT " + promiseColumns + " FROM promises WHERE series_id = ? AND deleted_at IS NULL"
+		args = []any{filter.SeriesID}
+	}
+	if filter.State != "" {
+		query += " AND current_state = ?"
+		args = append(args, filter.State)
+	}
+	if filter.ContactID != 0 {
+		query += " AND contact_id = ?"
+		args = append(args, filter.ContactID)
+	}
+	if filter.PartnerVisible {
+		query += " AND partner_visible = TRUE"
+	}
+	if len(filter.TagIDs) > 0 {
+		sub := "SELECT promise_id FROM promise_tags WHERE tag_id IN (" + placeholders(len(filter.TagIDs)) + ")"
+		for _, id := range filter.TagIDs {
+			args = append(args, id)
+		}
+		if filter.AllTags {
+			sub += " GROUP BY promise_id HAVING COUNT(*) = ?"
+			args = append(args, len(filter.TagIDs))
+		}
+		query += " AND id IN (" + sub + ")"
+	}
+	// Encrypted content can't be matched in SQL, so the text filter is
+	// applied after decrypting instead.
+	text := strings.ToLower(strings.TrimSpace(filter.Text))
+	if text != "" && !r.s.FieldsEncrypted() {
+		pattern := "%" + likeEscaper.Replace(text) + "%"
+		query += ` AND (LOWER(recipient) LIKE ? ESCAPE '\' OR LOWER(description) LIKE ? ESCAPE '\')`
+		args = append(args, pattern, pattern)
+	}
+	query += " ORDER BY (current_state = 'kept') DESC, created_at DESC, id DESC"
+	promises, err := r.list(query, args...)
+	if err != nil || text == "" || !r.s.FieldsEncrypted() {
+		return promises, err
+	}
+
+	matched := []models.Promise{}
+	for _, p := range promises {
+		if strings.Contains(strings.ToLower(p.Recipient), text) || strings.Contains(strings.ToLower(p.Description), text) {
+			matched = append(matched, p)
+		}
+	}
+	return matched, nil
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (r promiseRepo) MostRecentByUser(userID int) (*models.Promise, error) {
+	return r.scan(r.s.queryRow(
+		"SELECT "+promiseColumns+" FROM promises WHERE user_id = ? AND deleted_at IS NULL ORDER BY updated_at DESC, id DESC LIMIT 1",
+		userID,
+	))
+}
+
+func (r promiseRepo) Update(p *models.Promise) error {
+	recipient, description, email, err := r.sealed(p)
+	if err != nil {
+		return err
+	}
+	envelope, err := jsonValue(p.Envelope)
+	if err != nil {
+		return err
+	}
+	updated := now()
+	err = r.stale(p.ID, r.s.execOne(
+		`UPDATE promises SET recipient = ?, recipient_email = ?, notify_recipient = ?, description = ?, envelope = ?,
+		contact_id = ?, partner_visible = ?, keep_when_done = ?, due_date = ?, reminder_frequency = ?, version = version + 1, updated_at = ?
+		WHERE id = ? AND version = ? AND deleted_at IS NULL`,
+		recipient, nullString(email), p.NotifyRecipient, description, envelope, p.ContactID, p.PartnerVisible, p.KeepWhenDone, utcPtr(p.DueDate), nullString(p.ReminderFrequency), updated, p.ID, p.Version,
+	))
+	if err != nil {
+		return err
+	}
+	p.Version++
+	p.UpdatedAt = updated
+	return nil
+}
+
+// stale turns the ErrNotFound of a versioned write into ErrStale if the
+// promise still exists, so it was written in between.
+func (r promiseRepo) stale(id int, err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		var exists int
+		if r.s.queryRow("SELECT 1 FROM promises WHERE id = ? AND deleted_at IS NULL", id).Scan(&exists) == nil {
+			return store.ErrStale
+		}
+	}
+	return err
+}
+
+func (r promiseRepo) Touch(id int) error {
+	return r.s.execOne("UPDATE promises SET version = version + 1, updated_at = ? WHERE id = ? AND deleted_at IS NULL", now(), id)
+}
+
+func (r promiseRepo) SetState(id, expectedVersion int, state string) error {
+	return r.stale(id, r.s.execOne(
+		"UPDATE promises SET current_state = ?, version = version + 1, updated_at = ? WHERE id = ? AND version = ? AND deleted_at IS NULL",
+		state, now(), id, expectedVersion,
+	))
+}
+
+func (r promiseRepo) Trash(id, userID int) error {
+	return r.s.execOne(
+		"UPDATE promises SET deleted_at = ? WHERE id = ? AND "+editableBy+" AND deleted_at IS NULL",
+		now(), id, userID, userID,
+	)
+}
+
+func (r promiseRepo) ListTrash(userID int) ([]models.Promise, error) {
+	return r.list(
+		"SELECT "+promiseColumns+" FROM promises WHERE "+editableBy+" AND deleted_at IS NOT NULL ORDER BY deleted_at DESC, id DESC",
+		userID, userID,
+	)
+}
+
+func (r promiseRepo) Restore(id, userID int) error {
+	return r.s.execOne(
+		"UPDATE promises SET deleted_at = NULL WHERE id = ? AND "+editableBy+" AND deleted_at IS NOT NULL",
+		id, userID, userID,
+	)
+}
+
+func (r promiseRepo) DeleteTrashed(id, userID int) error {
+	return r.s.execOne("DELETE FROM promises WHERE id = ? AND "+editableBy+" AND deleted_at IS NOT NULL", id, userID, userID)
+}
+
+func (r promiseRepo) PurgeTrash(before time.Time) (int, error) {
+	result, err := r.s.exec("DELETE FROM promises WHERE deleted_at IS NOT NULL AND deleted_at <= ?", utc(before))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+func (r promiseRepo) ListOverdueIDs(at time.Time) ([]int, error) {
+	rows, err := r.s.query(
+		"SELECT id FROM promises WHERE current_state = 'active' AND deleted_at IS NULL AND series_id IS NULL AND due_date IS NOT NULL AND due_date <= ?",
+		utc(at),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (r promiseRepo) ListWithRecurringReminders() ([]models.Promise, error) {
+	return r.list(
+		"SELECT " + promiseColumns + ` FROM promises
+		WHERE current_state = 'active' AND deleted_at IS NULL AND reminder_frequency IS NOT NULL AND reminder_frequency <> ''`,
+	)
+}
+
+func (r promiseRepo) MarkReminded(id int, at time.Time) error {
+	return r.s.execOne("UPDATE promises SET last_reminded_at = ? WHERE id = ?", utc(at), id)
+}
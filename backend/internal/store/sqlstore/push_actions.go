@@ -0,0 +1,26 @@
+package sqlstore
+
+import (
+	"time"
+
+	"kept/internal/store"
+)
+
+type pushActionRepo struct{ s *Store }
+
+func (r pushActionRepo) Use(jti string, expiresAt time.Time) error {
+	_, err := r.s.exec("INSERT INTO used_push_actions (jti, expires_at) VALUES (?, ?)", jti, utc(expiresAt))
+	if err != nil && r.s.db.Dialect.IsUniqueViolation(err) {
+		return store.ErrConflict
+	}
+	return err
+}
+
+func (r pushActionRepo) PurgeExpired(before time.Time) (int, error) {
+	result, err := r.s.exec("DELETE FROM used_push_actions WHERE expires_at <= ?", utc(before))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
@@ -0,0 +1,517 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"kept/internal/fieldcrypt"
+)
+
+// staleField matches a non-empty column that isn't encrypted with the
+// owning user's newest data key: plaintext, or sealed with a rotated key.
+// The owner's ID must be available as user_id in the surrounding query.
+func staleField(column string) string {
+	return "(" + column + " <> '' AND " + column + " NOT LIKE '" + fieldcrypt.Prefix + "' || " +
+		"CAST(COALESCE((SELECT MAX(k.id) FROM data_keys k WHERE k.user_id = owner.user_id), 0) AS TEXT) || ':%')"
+}
+
+// EncryptionStatus counts what ReencryptFields still has to do.
+type EncryptionStatus struct {
+	// KeysByMasterVersion counts data keys per master key version.
+	KeysByMasterVersion map[int]int
+	// StalePromises, StaleEvents, StaleRevisions, StaleItems,
+	// StaleContacts and StaleTemplates count rows with content that is
+	// plaintext or encrypted with an old data key.
+	StalePromises  int
+	StaleEvents    int
+	StaleRevisions int
+	StaleItems     int
+	StaleContacts  int
+	StaleTemplates int
+}
+
+// EncryptionStatus reports the progress of encryption and key rotation.
+func (s *Store) EncryptionStatus() (*EncryptionStatus, error) {
+	status := &EncryptionStatus{KeysByMasterVersion: map[int]int{}}
+	rows, err := s.query("SELECT master_version, COUNT(*) FROM data_keys GROUP BY master_version")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var version, count int
+		if err := rows.Scan(&version, &count); err != nil {
+			return nil, err
+		}
+		status.KeysByMasterVersion[version] = count
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	err = s.queryRow(
+		"SELECT COUNT(*) FROM promises owner WHERE " + stalePromise,
+	).Scan(&status.StalePromises)
+	if err != nil {
+		return nil, err
+	}
+	err = s.queryRow(
+		`SELECT COUNT(*) FROM promise_events pe JOIN promises owner ON owner.id = pe.promise_id
+		WHERE ` + staleField("pe.reflection_note"),
+	).Scan(&status.StaleEvents)
+	if err != nil {
+		return nil, err
+	}
+	err = s.queryRow(
+		`SELECT COUNT(*) FROM promise_revisions pr JOIN promises owner ON owner.id = pr.promise_id
+		WHERE ` + staleField("pr.old_value") + " OR " + staleField("pr.new_value"),
+	).Scan(&status.StaleRevisions)
+	if err != nil {
+		return nil, err
+	}
+	err = s.queryRow(
+		`SELECT COUNT(*) FROM promise_items i JOIN promises owner ON owner.id = i.promise_id
+		WHERE ` + staleField("i.title"),
+	).Scan(&status.StaleItems)
+	if err != nil {
+		return nil, err
+	}
+	err = s.queryRow("SELECT COUNT(*) FROM contacts owner WHERE " + staleContact).Scan(&status.StaleContacts)
+	if err != nil {
+		return nil, err
+	}
+	err = s.queryRow("SELECT COUNT(*) FROM promise_templates owner WHERE " + staleTemplate).Scan(&status.StaleTemplates)
+	return status, err
+}
+
+// stalePromise matches promises with any stale field.
+var stalePromise = staleField("owner.recipient") + " OR " + staleField("owner.description") + " OR " +
+	staleField("COALESCE(owner.recipient_email, '')")
+
+// staleContact matches contacts with any stale field.
+var staleContact = staleField("owner.name") + " OR " + staleField("COALESCE(owner.aliases, '')") + " OR " +
+	staleField("COALESCE(owner.email, '')") + " OR " + staleField("COALESCE(owner.phone, '')") + " OR " +
+	staleField("COALESCE(owner.notes, '')")
+
+// staleTemplate matches templates with any stale field.
+var staleTemplate = staleField("owner.recipient") + " OR " + staleField("owner.description")
+
+// RotateDataKeys gives every user who has a data key a new one. Existing
+// content stays readable with the old keys until ReencryptFields moves it
+// to the new ones. It returns the number of keys created.
+func (s *Store) RotateDataKeys() (int, error) {
+	if s.crypt == nil {
+		return 0, errNoKeyring
+	}
+	rows, err := s.query("SELECT DISTINCT user_id FROM data_keys")
+	if err != nil {
+		return 0, err
+	}
+	var users []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		users = append(users, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, userID := range users {
+		if _, _, err := s.createDataKey(userID); err != nil {
+			return 0, err
+		}
+	}
+	return len(users), nil
+}
+
+// ReencryptFields does one batch of background encryption work: it rewraps
+// data keys still wrapped by an old master key, then encrypts plaintext
+// content and moves content sealed with a rotated data key to the user's
+// newest key. Each step handles at most limit rows. It returns the number
+// of keys and rows rewritten; zero means everything is current.
+func (s *Store) ReencryptFields(limit int) (int, error) {
+	if s.crypt == nil {
+		return 0, nil
+	}
+	total := 0
+	for _, step := range []func(int) (int, error){s.rewrapDataKeys, s.reencryptPromises, s.reencryptEvents, s.reencryptRevisions, s.reencryptItems, s.reencryptContacts, s.reencryptTemplates} {
+		n, err := step(limit)
+		if err != nil {
+			return total, err
+		}
+		total += n
+	}
+	return total, nil
+}
+
+func (s *Store) rewrapDataKeys(limit int) (int, error) {
+	type dataKey struct {
+		id, userID, version int
+		wrapped             string
+	}
+	rows, err := s.query(
+		"SELECT id, user_id, master_version, wrapped_key FROM data_keys WHERE master_version <> ? ORDER BY id LIMIT ?",
+		s.crypt.keyring.Current(), limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var keys []dataKey
+	for rows.Next() {
+		var k dataKey
+		if err := rows.Scan(&k.id, &k.userID, &k.version, &k.wrapped); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		keys = append(keys, k)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, k := range keys {
+		key, err := s.crypt.keyring.Unwrap(k.userID, k.version, k.wrapped)
+		if err != nil {
+			return 0, err
+		}
+		version, wrapped, err := s.crypt.keyring.Wrap(k.userID, key)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := s.exec("UPDATE data_keys SET master_version = ?, wrapped_key = ? WHERE id = ?", version, wrapped, k.id); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
+
+func (s *Store) reencryptPromises(limit int) (int, error) {
+	type promise struct {
+		id, userID                    int
+		recipient, description, email string
+	}
+	rows, err := s.query(
+		"SELECT id, user_id, recipient, description, COALESCE(recipient_email, '') FROM promises owner WHERE "+
+			stalePromise+" ORDER BY id LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var stale []promise
+	for rows.Next() {
+		var p promise
+		if err := rows.Scan(&p.id, &p.userID, &p.recipient, &p.description, &p.email); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, p)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	done := 0
+	for _, p := range stale {
+		recipient, err := s.reseal(p.userID, fieldRecipient, p.recipient)
+		if err != nil {
+			return done, err
+		}
+		description, err := s.reseal(p.userID, fieldDescription, p.description)
+		if err != nil {
+			return done, err
+		}
+		email, err := s.reseal(p.userID, fieldRecipientEmail, p.email)
+		if err != nil {
+			return done, err
+		}
+		// Only rewrite rows that weren't edited in the meantime; the next
+		// batch picks up anything skipped.
+		result, err := s.exec(
+			`UPDATE promises SET recipient = ?, description = ?, recipient_email = ?
+			WHERE id = ? AND recipient = ? AND description = ? AND COALESCE(recipient_email, '') = ?`,
+			recipient, description, nullString(email), p.id, p.recipient, p.description, p.email,
+		)
+		if err != nil {
+			return done, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			done++
+		}
+	}
+	return done, nil
+}
+
+func (s *Store) reencryptEvents(limit int) (int, error) {
+	type event struct {
+		id, userID int
+		note       string
+	}
+	rows, err := s.query(
+		`SELECT pe.id, owner.user_id, pe.reflection_note
+		FROM promise_events pe JOIN promises owner ON owner.id = pe.promise_id
+		WHERE `+staleField("pe.reflection_note")+" ORDER BY pe.id LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var stale []event
+	for rows.Next() {
+		var e event
+		var note sql.NullString
+		if err := rows.Scan(&e.id, &e.userID, &note); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		e.note = note.String
+		stale = append(stale, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	done := 0
+	for _, e := range stale {
+		note, err := s.reseal(e.userID, fieldReflectionNote, e.note)
+		if err != nil {
+			return done, err
+		}
+		result, err := s.exec(
+			"UPDATE promise_events SET reflection_note = ? WHERE id = ? AND reflection_note = ?",
+			note, e.id, e.note,
+		)
+		if err != nil {
+			return done, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			done++
+		}
+	}
+	return done, nil
+}
+
+func (s *Store) reencryptRevisions(limit int) (int, error) {
+	type revision struct {
+		id, userID         int
+		oldValue, newValue sql.NullString
+	}
+	rows, err := s.query(
+		`SELECT pr.id, owner.user_id, pr.old_value, pr.new_value
+		FROM promise_revisions pr JOIN promises owner ON owner.id = pr.promise_id
+		WHERE `+staleField("pr.old_value")+" OR "+staleField("pr.new_value")+" ORDER BY pr.id LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var stale []revision
+	for rows.Next() {
+		var rev revision
+		if err := rows.Scan(&rev.id, &rev.userID, &rev.oldValue, &rev.newValue); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, rev)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	done := 0
+	for _, rev := range stale {
+		oldValue, newValue := rev.oldValue, rev.newValue
+		if oldValue.String, err = s.reseal(rev.userID, fieldRevisionValue, oldValue.String); err != nil {
+			return done, err
+		}
+		if newValue.String, err = s.reseal(rev.userID, fieldRevisionValue, newValue.String); err != nil {
+			return done, err
+		}
+		// Revisions are never edited, so there is nothing to race with.
+		if _, err := s.exec(
+			"UPDATE promise_revisions SET old_value = ?, new_value = ? WHERE id = ?",
+			oldValue, newValue, rev.id,
+		); err != nil {
+			return done, err
+		}
+		done++
+	}
+	return done, nil
+}
+
+func (s *Store) reencryptItems(limit int) (int, error) {
+	type item struct {
+		id, userID int
+		title      string
+	}
+	rows, err := s.query(
+		`SELECT i.id, owner.user_id, i.title
+		FROM promise_items i JOIN promises owner ON owner.id = i.promise_id
+		WHERE `+staleField("i.title")+" ORDER BY i.id LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var stale []item
+	for rows.Next() {
+		var it item
+		if err := rows.Scan(&it.id, &it.userID, &it.title); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, it)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	done := 0
+	for _, it := range stale {
+		title, err := s.reseal(it.userID, fieldItemTitle, it.title)
+		if err != nil {
+			return done, err
+		}
+		result, err := s.exec(
+			"UPDATE promise_items SET title = ? WHERE id = ? AND title = ?",
+			title, it.id, it.title,
+		)
+		if err != nil {
+			return done, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			done++
+		}
+	}
+	return done, nil
+}
+
+func (s *Store) reencryptContacts(limit int) (int, error) {
+	rows, err := s.query(
+		"SELECT id, user_id, name, COALESCE(aliases, ''), COALESCE(email, ''), COALESCE(phone, ''), COALESCE(notes, '') "+
+			"FROM contacts owner WHERE "+staleContact+" ORDER BY id LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	type contact struct {
+		id, userID int
+		values     [5]string
+	}
+	var stale []contact
+	for rows.Next() {
+		var c contact
+		if err := rows.Scan(&c.id, &c.userID, &c.values[0], &c.values[1], &c.values[2], &c.values[3], &c.values[4]); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, c)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	fields := [5]string{fieldContactName, fieldContactAliases, fieldContactEmail, fieldContactPhone, fieldContactNotes}
+	done := 0
+	for _, c := range stale {
+		args := make([]any, 0, 12)
+		for i, field := range fields {
+			value, err := s.reseal(c.userID, field, c.values[i])
+			if err != nil {
+				return done, err
+			}
+			if i == 0 {
+				args = append(args, value)
+			} else {
+				args = append(args, nullString(value))
+			}
+		}
+		args = append(args, c.id, c.values[0], c.values[1], c.values[2], c.values[3], c.values[4])
+		// Like promises, contacts edited in the meantime are left for the
+		// next batch.
+		result, err := s.exec(
+			`UPDATE contacts SET name = ?, aliases = ?, email = ?, phone = ?, notes = ?
+			WHERE id = ? AND name = ? AND COALESCE(aliases, '') = ? AND COALESCE(email, '') = ?
+			AND COALESCE(phone, '') = ? AND COALESCE(notes, '') = ?`,
+			args...,
+		)
+		if err != nil {
+			return done, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			done++
+		}
+	}
+	return done, nil
+}
+
+func (s *Store) reencryptTemplates(limit int) (int, error) {
+	type template struct {
+		id, userID             int
+		recipient, description string
+	}
+	rows, err := s.query(
+		"SELECT id, user_id, recipient, description FROM promise_templates owner WHERE "+staleTemplate+" ORDER BY id LIMIT ?",
+		limit,
+	)
+	if err != nil {
+		return 0, err
+	}
+	var stale []template
+	for rows.Next() {
+		var t template
+		if err := rows.Scan(&t.id, &t.userID, &t.recipient, &t.description); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		stale = append(stale, t)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	done := 0
+	for _, t := range stale {
+		recipient, err := s.reseal(t.userID, fieldTemplateRecipient, t.recipient)
+		if err != nil {
+			return done, err
+		}
+		description, err := s.reseal(t.userID, fieldTemplateDescription, t.description)
+		if err != nil {
+			return done, err
+		}
+		result, err := s.exec(
+			"UPDATE promise_templates SET recipient = ?, description = ? WHERE id = ? AND recipient = ? AND description = ?",
+			recipient, description, t.id, t.recipient, t.description,
+		)
+		if err != nil {
+			return done, err
+		}
+		if n, _ := result.RowsAffected(); n > 0 {
+			done++
+		}
+	}
+	return done, nil
+}
+
+// reseal decrypts a stored value, if it is encrypted, and encrypts it again
+// with the user's current data key.
+func (s *Store) reseal(userID int, field, stored string) (string, error) {
+	plaintext, err := s.decrypt(userID, field, stored)
+	if err != nil {
+		return "", err
+	}
+	return s.encrypt(userID, field, plaintext)
+}
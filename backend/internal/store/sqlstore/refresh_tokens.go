@@ -0,0 +1,39 @@
+package sqlstore
+
+import "kept/internal/store"
+
+type refreshTokenRepo struct{ s *Store }
+
+func (r refreshTokenRepo) Save(t store.RefreshToken) error {
+	_, err := r.s.exec(
+		`INSERT INTO refresh_tokens (user_id, token_hash, expires_at, ttl_days, revoked, created_at)
+		VALUES (?, ?, ?, ?, FALSE, ?)
+		ON CONFLICT (token_hash) DO UPDATE SET
+		expires_at = excluded.expires_at,
+		ttl_days = excluded.ttl_days,
+		revoked = FALSE`,
+		t.UserID, t.TokenHash, utc(t.ExpiresAt), t.TTLDays, now(),
+	)
+	return err
+}
+
+func (r refreshTokenRepo) Get(tokenHash string) (*store.RefreshToken, error) {
+	t := store.RefreshToken{TokenHash: tokenHash}
+	var expiresAt nullTime
+	var revoked flexBool
+	err := r.s.queryRow(
+		"SELECT user_id, expires_at, ttl_days, revoked FROM refresh_tokens WHERE token_hash = ?",
+		tokenHash,
+	).Scan(&t.UserID, &expiresAt, &t.TTLDays, &revoked)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	t.ExpiresAt = expiresAt.Time
+	t.Revoked = bool(revoked)
+	return &t, nil
+}
+
+func (r refreshTokenRepo) Revoke(tokenHash string) error {
+	_, err := r.s.exec("UPDATE refresh_tokens SET revoked = TRUE WHERE token_hash = ?", tokenHash)
+	return err
+}
@@ -0,0 +1,157 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"time"
+
+	"kept/internal/models"
+	"kept/internal/store"
+)
+
+type reminderRepo struct{ s *Store }
+
+const reminderColumns = "id, promise_id, user_id, remind_at, anchor, offset_minutes, status, created_at"
+
+func (r reminderRepo) Create(rem *models.Reminder) error {
+	if rem.Anchor == "" {
+		rem.Anchor = models.AnchorDue
+	}
+	if rem.Status == "" {
+		rem.Status = models.ReminderPending
+	}
+	created := now()
+	id, err := r.s.insert(
+		`INSERT INTO reminders (promise_id, user_id, remind_at, anchor, offset_minutes, status, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		rem.PromiseID, rem.UserID, utc(rem.RemindAt), rem.Anchor, rem.OffsetMinutes, rem.Status, created,
+	)
+	if err != nil {
+		return err
+	}
+	rem.ID = id
+	rem.RemindAt = utc(rem.RemindAt)
+	rem.CreatedAt = created
+	return nil
+}
+
+func (r reminderRepo) list(query string, args ...any) ([]models.Reminder, error) {
+	rows, err := r.s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reminders := []models.Reminder{}
+	for rows.Next() {
+		var rem models.Reminder
+		var remindAt, createdAt nullTime
+		if err := rows.Scan(&rem.ID, &rem.PromiseID, &rem.UserID, &remindAt, &rem.Anchor, &rem.OffsetMinutes, &rem.Status, &createdAt); err != nil {
+			return nil, err
+		}
+		rem.RemindAt = remindAt.Time
+		rem.CreatedAt = createdAt.Time
+		reminders = append(reminders, rem)
+	}
+	return reminders, rows.Err()
+}
+
+func (r reminderRepo) ListByUser(userID int) ([]models.Reminder, error) {
+	return r.list(
+		`SELECT r.id, r.promise_id, r.user_id, r.remind_at, r.anchor, r.offset_minutes, r.status, r.created_at
+		FROM reminders r JOIN promises p ON p.id = r.promise_id
+		WHERE r.user_id = ? AND p.deleted_at IS NULL ORDER BY r.remind_at ASC, r.id ASC`,
+		userID,
+	)
+}
+
+func (r reminderRepo) ListByPromise(promiseID int) ([]models.Reminder, error) {
+	return r.list("SELECT "+reminderColumns+" FROM reminders WHERE promise_id = ? ORDER BY remind_at ASC, id ASC", promiseID)
+}
+
+func (r reminderRepo) Get(id, userID int) (*models.Reminder, error) {
+	reminders, err := r.list("SELECT "+reminderColumns+" FROM reminders WHERE id = ? AND user_id = ?", id, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(reminders) == 0 {
+		return nil, store.ErrNotFound
+	}
+	return &reminders[0], nil
+}
+
+func (r reminderRepo) Update(rem *models.Reminder) error {
+	if rem.Status == "" {
+		rem.Status = models.ReminderPending
+	}
+	if err := r.s.execOne(
+		"UPDATE reminders SET remind_at = ?, anchor = ?, offset_minutes = ?, status = ? WHERE id = ? AND user_id = ?",
+		utc(rem.RemindAt), rem.Anchor, rem.OffsetMinutes, rem.Status, rem.ID, rem.UserID,
+	); err != nil {
+		return err
+	}
+	rem.RemindAt = utc(rem.RemindAt)
+	return nil
+}
+
+func (r reminderRepo) Delete(id, userID int) error {
+	return r.s.execOne("DELETE FROM reminders WHERE id = ? AND user_id = ?", id, userID)
+}
+
+func (r reminderRepo) ListDue(at time.Time) ([]store.DueReminder, error) {
+	rows, err := r.s.query(
+		`SELECT r.id, r.promise_id, r.user_id, r.remind_at, r.anchor, r.offset_minutes, p.user_id, p.recipient, p.description, p.encrypted, p.workspace_id
+		FROM reminders r
+		JOIN promises p ON r.promise_id = p.id
+		WHERE r.status = ? AND r.remind_at <= ? AND p.current_state = 'active' AND p.deleted_at IS NULL
+		ORDER BY r.remind_at ASC, r.id ASC`,
+		models.ReminderPending, utc(at),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []store.DueReminder
+	for rows.Next() {
+		var d store.DueReminder
+		var remindAt nullTime
+		var encrypted flexBool
+		var ownerID int
+		var workspaceID sql.NullInt64
+		if err := rows.Scan(&d.ID, &d.PromiseID, &d.UserID, &remindAt, &d.Anchor, &d.OffsetMinutes, &ownerID, &d.Recipient, &d.Description, &encrypted, &workspaceID); err != nil {
+			return nil, err
+		}
+		d.RemindAt = remindAt.Time
+		d.Encrypted = bool(encrypted)
+		if workspaceID.Valid {
+			id := int(workspaceID.Int64)
+			d.WorkspaceID = &id
+		}
+		// Promise content is encrypted for its creator, who in a workspace
+		// may not be the one who set the reminder
+		if d.Recipient, err = r.s.decrypt(ownerID, fieldRecipient, d.Recipient); err != nil {
+			return nil, err
+		}
+		if d.Description, err = r.s.decrypt(ownerID, fieldDescription, d.Description); err != nil {
+			return nil, err
+		}
+		due = append(due, d)
+	}
+	return due, rows.Err()
+}
+
+func (r reminderRepo) SetStatus(id int, status string) error {
+	return r.s.execOne("UPDATE reminders SET status = ? WHERE id = ?", status, id)
+}
+
+func (r reminderRepo) CancelPending(promiseID int) error {
+	_, err := r.s.exec("UPDATE reminders SET status = ? WHERE promise_id = ? AND status = ?",
+		models.ReminderCancelled, promiseID, models.ReminderPending)
+	return err
+}
+
+func (r reminderRepo) RestoreCancelled(promiseID int, after time.Time) error {
+	_, err := r.s.exec("UPDATE reminders SET status = ? WHERE promise_id = ? AND status = ? AND remind_at > ?",
+		models.ReminderPending, promiseID, models.ReminderCancelled, utc(after))
+	return err
+}
@@ -0,0 +1,94 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"kept/internal/models"
+)
+
+type revisionRepo struct{ s *Store }
+
+func (r revisionRepo) Create(rev *models.Revision) error {
+	oldValue, newValue := rev.OldValue, rev.NewValue
+	if r.s.FieldsEncrypted() {
+		var userID int
+		if err := r.s.queryRow("SELECT user_id FROM promises WHERE id = ?", rev.PromiseID).Scan(&userID); err != nil {
+			return notFound(err)
+		}
+		var err error
+		if oldValue, err = r.sealValue(userID, oldValue); err != nil {
+			return err
+		}
+		if newValue, err = r.sealValue(userID, newValue); err != nil {
+			return err
+		}
+	}
+
+	created := now()
+	id, err := r.s.insert(
+		`INSERT INTO promise_revisions (promise_id, field, old_value, new_value, actor, actor_user_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		rev.PromiseID, rev.Field, oldValue, newValue, rev.Actor, rev.ActorUserID, created,
+	)
+	if err != nil {
+		return err
+	}
+	rev.ID = id
+	rev.CreatedAt = created
+	return nil
+}
+
+func (r revisionRepo) sealValue(userID int, value *string) (*string, error) {
+	if value == nil {
+		return nil, nil
+	}
+	sealed, err := r.s.encrypt(userID, fieldRevisionValue, *value)
+	return &sealed, err
+}
+
+func (r revisionRepo) ListByPromise(promiseID int) ([]models.Revision, error) {
+	rows, err := r.s.query(
+		`SELECT pr.id, pr.promise_id, p.user_id, pr.field, pr.old_value, pr.new_value, pr.actor, pr.actor_user_id, pr.created_at
+		FROM promise_revisions pr JOIN promises p ON p.id = pr.promise_id
+		WHERE pr.promise_id = ? ORDER BY pr.created_at ASC, pr.id ASC`,
+		promiseID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []models.Revision{}
+	for rows.Next() {
+		var rev models.Revision
+		var userID int
+		var oldValue, newValue sql.NullString
+		var actorUserID sql.NullInt64
+		var createdAt nullTime
+		err := rows.Scan(&rev.ID, &rev.PromiseID, &userID, &rev.Field, &oldValue, &newValue, &rev.Actor, &actorUserID, &createdAt)
+		if err != nil {
+			return nil, err
+		}
+		if rev.OldValue, err = r.openValue(userID, oldValue); err != nil {
+			return nil, err
+		}
+		if rev.NewValue, err = r.openValue(userID, newValue); err != nil {
+			return nil, err
+		}
+		if actorUserID.Valid {
+			id := int(actorUserID.Int64)
+			rev.ActorUserID = &id
+		}
+		rev.CreatedAt = createdAt.Time
+		revisions = append(revisions, rev)
+	}
+	return revisions, rows.Err()
+}
+
+func (r revisionRepo) openValue(userID int, value sql.NullString) (*string, error) {
+	if !value.Valid {
+		return nil, nil
+	}
+	plaintext, err := r.s.decrypt(userID, fieldRevisionValue, value.String)
+	return &plaintext, err
+}
@@ -0,0 +1,122 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timeLayouts are the text formats timestamps can come back in. SQLite only
+// parses values in columns declared DATETIME, and older rows were written by
+// different code paths.
+var timeLayouts = []string{
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05Z07:00",
+	time.RFC3339Nano,
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+}
+
+// nullTime scans a nullable timestamp stored either natively or as text.
+type nullTime struct {
+	Time  time.Time
+	Valid bool
+}
+
+func (n *nullTime) Scan(v any) error {
+	n.Time, n.Valid = time.Time{}, false
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case time.Time:
+		n.Time, n.Valid = t, true
+		return nil
+	case []byte:
+		return n.parse(string(t))
+	case string:
+		return n.parse(t)
+	}
+	return fmt.Errorf("cannot scan %T into a timestamp", v)
+}
+
+func (n *nullTime) parse(s string) error {
+	if s == "" {
+		return nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			n.Time, n.Valid = t, true
+			return nil
+		}
+	}
+	return fmt.Errorf("unrecognized timestamp %q", s)
+}
+
+func (n nullTime) ptr() *time.Time {
+	if !n.Valid {
+		return nil
+	}
+	t := n.Time
+	return &t
+}
+
+// flexBool scans booleans that SQLite may return as integers or text.
+type flexBool bool
+
+func (b *flexBool) Scan(v any) error {
+	switch t := v.(type) {
+	case nil:
+		*b = false
+	case bool:
+		*b = flexBool(t)
+	case int64:
+		*b = t != 0
+	case []byte:
+		return b.Scan(string(t))
+	case string:
+		s := strings.TrimSpace(strings.ToLower(t))
+		switch s {
+		case "", "false":
+			*b = false
+		case "true":
+			*b = true
+		default:
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return fmt.Errorf("cannot scan %q into a boolean", t)
+			}
+			*b = n != 0
+		}
+	default:
+		return fmt.Errorf("cannot scan %T into a boolean", v)
+	}
+	return nil
+}
+
+// jsonValue encodes v for a nullable JSON text column. nil pointers are
+// stored as NULL.
+func jsonValue[T any](v *T) (sql.NullString, error) {
+	if v == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// parseJSON decodes a nullable JSON text column, returning nil for NULL.
+func parseJSON[T any](s sql.NullString) (*T, error) {
+	if !s.Valid || s.String == "" {
+		return nil, nil
+	}
+	v := new(T)
+	if err := json.Unmarshal([]byte(s.String), v); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
@@ -0,0 +1,489 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"html"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"kept/internal/database"
+	"kept/internal/models"
+	"kept/internal/store"
+)
+
+type searchRepo struct{ s *Store }
+
+// Snippets come back with matched words between these markers, which are
+// turned into <mark> tags once the rest of the snippet is escaped.
+const (
+	markStart = "\x02"
+	markEnd   = "\x03"
+)
+
+var markReplacer = strings.NewReplacer(markStart, "<mark>", markEnd, "</mark>")
+
+// snippetWords is roughly how many words a snippet shows.
+const snippetWords = 12
+
+// SQLite's search index: FTS5 tables holding a copy of the searchable text,
+// keyed by promise and event ID, and triggers keeping them up to date. They
+// aren't part of the migrations because FTS5 is only compiled in with the
+// sqlite_fts5 build tag. End-to-end encrypted promises aren't indexed.
+var (
+	searchIndexSQL = []string{
+		`CREATE VIRTUAL TABLE promises_fts USING fts5(recipient, description)`,
+		`CREATE VIRTUAL TABLE notes_fts USING fts5(reflection_note)`,
+		`CREATE TRIGGER promises_fts_insert AFTER INSERT ON promises WHEN NOT NEW.encrypted BEGIN
+			INSERT INTO promises_fts (rowid, recipient, description) VALUES (NEW.id, NEW.recipient, NEW.description);
+		END`,
+		`CREATE TRIGGER promises_fts_update AFTER UPDATE OF recipient, description, encrypted ON promises BEGIN
+			DELETE FROM promises_fts WHERE rowid = OLD.id;
+			INSERT INTO promises_fts (rowid, recipient, description) SELECT NEW.id, NEW.recipient, NEW.description WHERE NOT NEW.encrypted;
+		END`,
+		`CREATE TRIGGER promises_fts_delete AFTER DELETE ON promises BEGIN
+			DELETE FROM promises_fts WHERE rowid = OLD.id;
+		END`,
+		`CREATE TRIGGER notes_fts_insert AFTER INSERT ON promise_events WHEN NEW.reflection_note <> '' BEGIN
+			INSERT INTO notes_fts (rowid, reflection_note)
+			SELECT NEW.id, NEW.reflection_note FROM promises WHERE id = NEW.promise_id AND NOT encrypted;
+		END`,
+		`CREATE TRIGGER notes_fts_update AFTER UPDATE OF reflection_note ON promise_events BEGIN
+			DELETE FROM notes_fts WHERE rowid = OLD.id;
+			INSERT INTO notes_fts (rowid, reflection_note)
+			SELECT NEW.id, NEW.reflection_note FROM promises WHERE id = NEW.promise_id AND NOT encrypted AND NEW.reflection_note <> '';
+		END`,
+		`CREATE TRIGGER notes_fts_delete AFTER DELETE ON promise_events BEGIN
+			DELETE FROM notes_fts WHERE rowid = OLD.id;
+		END`,
+		`INSERT INTO promises_fts (rowid, recipient, description) SELECT id, recipient, description FROM promises WHERE NOT encrypted`,
+		`INSERT INTO notes_fts (rowid, reflection_note)
+		SELECT e.id, e.reflection_note FROM promise_events e JOIN promises p ON p.id = e.promise_id
+		WHERE e.reflection_note <> '' AND NOT p.encrypted`,
+	}
+	searchTriggers = []string{
+		"promises_fts_insert", "promises_fts_update", "promises_fts_delete",
+		"notes_fts_insert", "notes_fts_update", "notes_fts_delete",
+	}
+	searchTables = []string{"promises_fts", "notes_fts"}
+)
+
+// SearchIndexed reports whether searches use a full-text index. Otherwise
+// content is decrypted and matched in the server: always when fields are
+// encrypted, since an index could only hold ciphertext, and on SQLite when
+// the build lacks FTS5 or the index hasn't been built.
+func (s *Store) SearchIndexed() bool {
+	if s.FieldsEncrypted() {
+		return false
+	}
+	if s.db.Dialect == database.Postgres {
+		return true
+	}
+	if !database.FTS5Available() {
+		return false
+	}
+	names := append(append([]string{}, searchTables...), searchTriggers...)
+	args := make([]any, len(names))
+	for i, name := range names {
+		args[i] = name
+	}
+	var n int
+	err := s.queryRow("SELECT COUNT(*) FROM sqlite_master WHERE name IN ("+placeholders(len(names))+")", args...).Scan(&n)
+	return err == nil && n == len(names)
+}
+
+// EnsureSearchIndex builds SQLite's search index if it is missing or
+// incomplete. The server calls it at startup. When fields are encrypted the
+// index is dropped, and without FTS5 its triggers are dropped so writes
+// don't fail; it is rebuilt once neither is the case. PostgreSQL's index is
+// part of the schema.
+//
+// SQLite's index isn't a versioned migration, since whether it can exist
+// depends on the build and the keyring rather than the schema version. It
+// is run at startup whether or not RUN_MIGRATIONS is set, and doesn't show
+// up in `kept-server migrate status`.
+func (s *Store) EnsureSearchIndex() error {
+	if s.db.Dialect != database.SQLite {
+		return nil
+	}
+	if !database.FTS5Available() {
+		// The tables can't be dropped without FTS5.
+		return s.dropSearchIndex(false)
+	}
+	if s.FieldsEncrypted() {
+		return s.dropSearchIndex(true)
+	}
+	if s.SearchIndexed() {
+		return nil
+	}
+	_, err := s.RebuildSearchIndex()
+	return err
+}
+
+func (s *Store) dropSearchIndex(tables bool) error {
+	for _, name := range searchTriggers {
+		if _, err := s.exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+			return err
+		}
+	}
+	if !tables {
+		return nil
+	}
+	for _, name := range searchTables {
+		if _, err := s.exec("DROP TABLE IF EXISTS " + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RebuildSearchIndex rebuilds the search index from scratch and returns how
+// many promises and reflection notes it holds.
+func (s *Store) RebuildSearchIndex() (int, error) {
+	switch {
+	case s.db.Dialect == database.Postgres:
+		for _, index := range []string{"idx_promises_search", "idx_promise_events_search"} {
+			if _, err := s.exec("REINDEX INDEX " + index); err != nil {
+				return 0, err
+			}
+		}
+		var n int
+		err := s.queryRow(
+			`SELECT (SELECT COUNT(*) FROM promises WHERE NOT encrypted) +
+			(SELECT COUNT(*) FROM promise_events e JOIN promises p ON p.id = e.promise_id WHERE e.reflection_note <> '' AND NOT p.encrypted)`,
+		).Scan(&n)
+		return n, err
+	case !database.FTS5Available():
+		return 0, errors.New("this build of kept has no SQLite FTS5 support; build it with -tags sqlite_fts5")
+	case s.FieldsEncrypted():
+		return 0, errors.New("search doesn't use an index while field encryption is on")
+	}
+
+	var n int
+	err := s.InTx(func(tx store.Store) error {
+		t := tx.(*Store)
+		if err := t.dropSearchIndex(true); err != nil {
+			return err
+		}
+		for _, stmt := range searchIndexSQL {
+			if _, err := t.exec(stmt); err != nil {
+				return err
+			}
+		}
+		return t.queryRow("SELECT (SELECT COUNT(*) FROM promises_fts) + (SELECT COUNT(*) FROM notes_fts)").Scan(&n)
+	})
+	return n, err
+}
+
+// searchWords splits text into lowercase words the way the search index
+// does.
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (r searchRepo) Search(userID int, q store.SearchQuery) ([]models.SearchHit, error) {
+	words := searchWords(q.Text)
+	if len(words) == 0 {
+		return []models.SearchHit{}, nil
+	}
+
+	var hits []models.SearchHit
+	var err error
+	if r.s.SearchIndexed() {
+		hits, err = r.indexed(userID, q, words)
+	} else {
+		hits, err = r.scan(userID, q, words)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		if !hits[i].Date.Equal(hits[j].Date) {
+			return hits[i].Date.After(hits[j].Date)
+		}
+		return hits[i].PromiseID > hits[j].PromiseID
+	})
+	if q.Limit > 0 && len(hits) > q.Limit {
+		hits = hits[:q.Limit]
+	}
+	for i := range hits {
+		hits[i].Snippet = markReplacer.Replace(html.EscapeString(hits[i].Snippet))
+	}
+	return hits, nil
+}
+
+// scope returns the conditions every hit must meet. date is the column the
+// hit's date comes from.
+func (r searchRepo) scope(userID int, q store.SearchQuery, date string) (string, []any) {
+	where := " AND p.user_id = ? AND p.workspace_id IS NULL"
+	args := []any{userID}
+	if q.WorkspaceID != 0 {
+		where = " AND p.workspace_id = ?"
+		args = []any{q.WorkspaceID}
+	}
+	where += " AND p.deleted_at IS NULL AND p.encrypted = FALSE"
+	if q.State != "" {
+		where += " AND p.current_state = ?"
+		args = append(args, q.State)
+	}
+	if q.From != nil {
+		where += " AND " + date + " >= ?"
+		args = append(args, utc(*q.From))
+	}
+	if q.To != nil {
+		where += " AND " + date + " <= ?"
+		args = append(args, utc(*q.To))
+	}
+	return where, args
+}
+
+// indexed searches the full-text index. Each query returns promise ID,
+// event ID, score, snippet and date.
+func (r searchRepo) indexed(userID int, q store.SearchQuery, words []string) ([]models.SearchHit, error) {
+	var match, promiseQuery, noteQuery string
+	if r.s.db.Dialect == database.Postgres {
+		prefixes := make([]string, len(words))
+		for i, w := range words {
+			prefixes[i] = w + ":*"
+		}
+		match = strings.Join(prefixes, " & ")
+		headline := `'StartSel=' || chr(2) || ', StopSel=' || chr(3) || ', MaxWords=24, MinWords=8'`
+		promiseQuery = `SELECT p.id, CAST(NULL AS INTEGER), ts_rank(p.search, query),
+			ts_headline('simple', p.recipient || ': ' || p.description, query, ` + headline + `), p.created_at
+			FROM promises p CROSS JOIN to_tsquery('simple', ?) query WHERE p.search @@ query`
+		noteQuery = `SELECT p.id, e.id, ts_rank(e.search, query),
+			ts_headline('simple', e.reflection_note, query, ` + headline + `), e.created_at
+			FROM promise_events e JOIN promises p ON p.id = e.promise_id
+			CROSS JOIN to_tsquery('simple', ?) query WHERE e.search @@ query`
+	} else {
+		prefixes := make([]string, len(words))
+		for i, w := range words {
+			prefixes[i] = `"` + w + `"*`
+		}
+		match = strings.Join(prefixes, " ")
+		// bm25 is lower for better matches; recipients weigh double.
+		promiseQuery = `SELECT p.id, CAST(NULL AS INTEGER), -bm25(promises_fts, 2.0, 1.0),
+			snippet(promises_fts, -1, char(2), char(3), '…', ` + strconv.Itoa(snippetWords) + `), p.created_at
+			FROM promises_fts JOIN promises p ON p.id = promises_fts.rowid WHERE promises_fts MATCH ?`
+		noteQuery = `SELECT p.id, e.id, -bm25(notes_fts),
+			snippet(notes_fts, 0, char(2), char(3), '…', ` + strconv.Itoa(snippetWords) + `), e.created_at
+			FROM notes_fts JOIN promise_events e ON e.id = notes_fts.rowid
+			JOIN promises p ON p.id = e.promise_id WHERE notes_fts MATCH ?`
+	}
+
+	hits := []models.SearchHit{}
+	for _, part := range []struct{ kind, query, date string }{
+		{models.SearchHitPromise, promiseQuery, "p.created_at"},
+		{models.SearchHitNote, noteQuery, "e.created_at"},
+	} {
+		where, args := r.scope(userID, q, part.date)
+		query := part.query + where + " ORDER BY 3 DESC"
+		args = append([]any{match}, args...)
+		if q.Limit > 0 {
+			query += " LIMIT ?"
+			args = append(args, q.Limit)
+		}
+		found, err := r.list(part.kind, query, args...)
+		if err != nil {
+			return nil, err
+		}
+		hits = append(hits, found...)
+	}
+	return hits, nil
+}
+
+func (r searchRepo) list(kind, query string, args ...any) ([]models.SearchHit, error) {
+	rows, err := r.s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	hits := []models.SearchHit{}
+	for rows.Next() {
+		hit := models.SearchHit{Kind: kind}
+		var eventID sql.NullInt64
+		var snippet sql.NullString
+		var date nullTime
+		if err := rows.Scan(&hit.PromiseID, &eventID, &hit.Score, &snippet, &date); err != nil {
+			return nil, err
+		}
+		if eventID.Valid {
+			id := int(eventID.Int64)
+			hit.EventID = &id
+		}
+		hit.Snippet = snippet.String
+		hit.Date = date.Time
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
+
+// scan searches without an index by matching decrypted content. Scores
+// count the matched words, with recipients weighing double.
+func (r searchRepo) scan(userID int, q store.SearchQuery, words []string) ([]models.SearchHit, error) {
+	promises, err := r.s.Promises().ListByUser(userID, store.PromiseFilter{State: q.State, WorkspaceID: q.WorkspaceID})
+	if err != nil {
+		return nil, err
+	}
+	var events []models.Event
+	if q.WorkspaceID != 0 {
+		events, err = r.s.Events().ListByWorkspace(q.WorkspaceID)
+	} else {
+		events, err = r.s.Events().ListByUser(userID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	inRange := func(t time.Time) bool {
+		return (q.From == nil || !t.Before(*q.From)) && (q.To == nil || !t.After(*q.To))
+	}
+
+	hits := []models.SearchHit{}
+	searched := map[int]bool{}
+	for _, p := range promises {
+		if p.Encrypted {
+			continue
+		}
+		searched[p.ID] = true
+		if !inRange(p.CreatedAt) {
+			continue
+		}
+		recipient, description := matchText(p.Recipient, words), matchText(p.Description, words)
+		if !matchesAll(words, recipient, description) {
+			continue
+		}
+		snippet := description
+		if description.count == 0 {
+			snippet = recipient
+		}
+		hits = append(hits, models.SearchHit{
+			Kind:      models.SearchHitPromise,
+			PromiseID: p.ID,
+			Snippet:   snippet.snippet(),
+			Score:     float64(2*recipient.count + description.count),
+			Date:      p.CreatedAt,
+		})
+	}
+	for _, e := range events {
+		if !searched[e.PromiseID] || e.ReflectionNote == "" || !inRange(e.CreatedAt) {
+			continue
+		}
+		note := matchText(e.ReflectionNote, words)
+		if !matchesAll(words, note) {
+			continue
+		}
+		eventID := e.ID
+		hits = append(hits, models.SearchHit{
+			Kind:      models.SearchHitNote,
+			PromiseID: e.PromiseID,
+			EventID:   &eventID,
+			Snippet:   note.snippet(),
+			Score:     float64(note.count),
+			Date:      e.CreatedAt,
+		})
+	}
+	return hits, nil
+}
+
+// textMatch is a text split into words, noting which of them begin with a
+// search word.
+type textMatch struct {
+	text  string
+	spans [][2]int
+	hits  []bool
+	found map[string]bool
+	count int
+}
+
+func matchText(text string, words []string) textMatch {
+	m := textMatch{text: text, found: map[string]bool{}}
+	start := -1
+	for i, c := range text {
+		inWord := unicode.IsLetter(c) || unicode.IsDigit(c)
+		if inWord && start < 0 {
+			start = i
+		} else if !inWord && start >= 0 {
+			m.spans = append(m.spans, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		m.spans = append(m.spans, [2]int{start, len(text)})
+	}
+
+	m.hits = make([]bool, len(m.spans))
+	for i, span := range m.spans {
+		token := strings.ToLower(text[span[0]:span[1]])
+		for _, w := range words {
+			if strings.HasPrefix(token, w) {
+				m.hits[i] = true
+				m.found[w] = true
+			}
+		}
+		if m.hits[i] {
+			m.count++
+		}
+	}
+	return m
+}
+
+// matchesAll reports whether every search word is found in one of the
+// texts.
+func matchesAll(words []string, texts ...textMatch) bool {
+	for _, w := range words {
+		found := false
+		for _, t := range texts {
+			found = found || t.found[w]
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// snippet returns a few words around the first match with the matches
+// marked.
+func (m textMatch) snippet() string {
+	first := 0
+	for first < len(m.hits) && !m.hits[first] {
+		first++
+	}
+	if first == len(m.hits) {
+		return ""
+	}
+	from := max(0, first-snippetWords/4)
+	to := min(len(m.spans), from+snippetWords)
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := m.spans[from][0]
+	for i := from; i < to; i++ {
+		span := m.spans[i]
+		b.WriteString(m.text[pos:span[0]])
+		if m.hits[i] {
+			b.WriteString(markStart + m.text[span[0]:span[1]] + markEnd)
+		} else {
+			b.WriteString(m.text[span[0]:span[1]])
+		}
+		pos = span[1]
+	}
+	if to < len(m.spans) {
+		b.WriteString("…")
+	} else {
+		b.WriteString(m.text[pos:])
+	}
+	return b.String()
+}
@@ -0,0 +1,105 @@
+package sqlstore
+
+import (
+	"database/sql"
+	"time"
+
+	"kept/internal/models"
+)
+
+type seriesRepo struct{ s *Store }
+
+const seriesColumns = "id, user_id, workspace_id, frequency, interval_count, starts_at, ends_at, current_promise_id, stopped_at, created_at"
+
+func scanSeries(row interface{ Scan(...any) error }) (*models.Series, error) {
+	var s models.Series
+	var workspaceID, currentID sql.NullInt64
+	var startsAt, endsAt, stoppedAt, createdAt nullTime
+	err := row.Scan(&s.ID, &s.UserID, &workspaceID, &s.Frequency, &s.Interval, &startsAt, &endsAt, &currentID, &stoppedAt, &createdAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if workspaceID.Valid {
+		id := int(workspaceID.Int64)
+		s.WorkspaceID = &id
+	}
+	if currentID.Valid {
+		id := int(currentID.Int64)
+		s.CurrentPromiseID = &id
+	}
+	s.StartsAt = startsAt.Time
+	s.EndsAt = endsAt.ptr()
+	s.StoppedAt = stoppedAt.ptr()
+	s.CreatedAt = createdAt.Time
+	return &s, nil
+}
+
+func (r seriesRepo) Create(s *models.Series) error {
+	created := now()
+	id, err := r.s.insert(
+		`INSERT INTO promise_series (user_id, workspace_id, frequency, interval_count, starts_at, ends_at, current_promise_id, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		s.UserID, s.WorkspaceID, s.Frequency, s.Interval, utc(s.StartsAt), utcPtr(s.EndsAt), s.CurrentPromiseID, created,
+	)
+	if err != nil {
+		return err
+	}
+	s.ID = id
+	s.StartsAt = utc(s.StartsAt)
+	s.EndsAt = utcPtr(s.EndsAt)
+	s.CreatedAt = created
+	return nil
+}
+
+func (r seriesRepo) Get(id int) (*models.Series, error) {
+	return scanSeries(r.s.queryRow("SELECT "+seriesColumns+" FROM promise_series WHERE id = ?", id))
+}
+
+func (r seriesRepo) ListByUser(userID, workspaceID int) ([]models.Series, error) {
+	query := "SELECT " + seriesColumns + " FROM promise_series WHERE user_id = ? AND workspace_id IS NULL"
+	args := []any{userID}
+	if workspaceID != 0 {
+		query = "SELECT " + seriesColumns + " FROM promise_series WHERE workspace_id = ?"
+		args = []any{workspaceID}
+	}
+	rows, err := r.s.query(query+" ORDER BY created_at DESC, id DESC", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	series := []models.Series{}
+	for rows.Next() {
+		s, err := scanSeries(rows)
+		if err != nil {
+			return nil, err
+		}
+		series = append(series, *s)
+	}
+	return series, rows.Err()
+}
+
+func (r seriesRepo) Update(s *models.Series) error {
+	return r.s.execOne(
+		"UPDATE promise_series SET frequency = ?, interval_count = ?, starts_at = ?, ends_at = ? WHERE id = ?",
+		s.Frequency, s.Interval, utc(s.StartsAt), utcPtr(s.EndsAt), s.ID,
+	)
+}
+
+func (r seriesRepo) SetCurrent(id, promiseID int) error {
+	return r.s.execOne("UPDATE promise_series SET current_promise_id = ? WHERE id = ?", promiseID, id)
+}
+
+func (r seriesRepo) Stop(id int) error {
+	return r.s.execOne("UPDATE promise_series SET stopped_at = ? WHERE id = ? AND stopped_at IS NULL", now(), id)
+}
+
+func (r seriesRepo) ListDue(at time.Time) ([]models.Promise, error) {
+	return promiseRepo{r.s}.list(
+		"SELECT "+promiseColumns+` FROM promises
+		WHERE id IN (SELECT current_promise_id FROM promise_series WHERE stopped_at IS NULL)
+		AND (current_state <> 'active' OR deleted_at IS NOT NULL OR (due_date IS NOT NULL AND due_date <= ?))
+		ORDER BY id`,
+		utc(at),
+	)
+}
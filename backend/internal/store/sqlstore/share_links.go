@@ -0,0 +1,91 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"kept/internal/models"
+)
+
+type shareLinkRepo struct{ s *Store }
+
+const shareLinkColumns = "id, promise_id, user_id, redact, expires_at, revoked_at, created_at"
+
+func scanShareLink(row interface{ Scan(...any) error }) (*models.ShareLink, error) {
+	var l models.ShareLink
+	var redact sql.NullString
+	var expiresAt, revokedAt, createdAt nullTime
+	if err := row.Scan(&l.ID, &l.PromiseID, &l.UserID, &redact, &expiresAt, &revokedAt, &createdAt); err != nil {
+		return nil, notFound(err)
+	}
+	fields, err := parseJSON[[]string](redact)
+	if err != nil {
+		return nil, err
+	}
+	l.Redact = []string{}
+	if fields != nil {
+		l.Redact = *fields
+	}
+	l.ExpiresAt = expiresAt.ptr()
+	l.RevokedAt = revokedAt.ptr()
+	l.CreatedAt = createdAt.Time
+	return &l, nil
+}
+
+func (r shareLinkRepo) Create(l *models.ShareLink, tokenHash string) error {
+	var redact *[]string
+	if len(l.Redact) > 0 {
+		redact = &l.Redact
+	}
+	value, err := jsonValue(redact)
+	if err != nil {
+		return err
+	}
+	created := now()
+	id, err := r.s.insert(
+		`INSERT INTO share_links (promise_id, user_id, token_hash, redact, expires_at, created_at)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		l.PromiseID, l.UserID, tokenHash, value, utcPtr(l.ExpiresAt), created,
+	)
+	if err != nil {
+		return err
+	}
+	l.ID = id
+	if l.Redact == nil {
+		l.Redact = []string{}
+	}
+	l.ExpiresAt = utcPtr(l.ExpiresAt)
+	l.CreatedAt = created
+	return nil
+}
+
+func (r shareLinkRepo) GetByTokenHash(tokenHash string) (*models.ShareLink, error) {
+	return scanShareLink(r.s.queryRow("SELECT "+shareLinkColumns+" FROM share_links WHERE token_hash = ?", tokenHash))
+}
+
+func (r shareLinkRepo) ListByPromise(promiseID int) ([]models.ShareLink, error) {
+	rows, err := r.s.query(
+		"SELECT "+shareLinkColumns+" FROM share_links WHERE promise_id = ? ORDER BY created_at DESC, id DESC",
+		promiseID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []models.ShareLink{}
+	for rows.Next() {
+		l, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, *l)
+	}
+	return links, rows.Err()
+}
+
+func (r shareLinkRepo) Revoke(id, promiseID int) error {
+	return r.s.execOne(
+		"UPDATE share_links SET revoked_at = ? WHERE id = ? AND promise_id = ? AND revoked_at IS NULL",
+		now(), id, promiseID,
+	)
+}
@@ -0,0 +1,270 @@
+package sqlstore_test
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"testing"
+
+	"kept/internal/database"
+	"kept/internal/fieldcrypt"
+	"kept/internal/models"
+	"kept/internal/store"
+	"kept/internal/store/sqlstore"
+	"kept/internal/store/storetest"
+)
+
+func TestSQLite(t *testing.T) {
+	storetest.Run(t, func(t testing.TB) store.Store { return storetest.OpenSQLite(t) })
+}
+
+func TestPostgres(t *testing.T) {
+	storetest.OpenPostgres(t) // skips when no server is configured
+	storetest.Run(t, func(t testing.TB) store.Store { return storetest.OpenPostgres(t) })
+}
+
+func TestSQLiteEncrypted(t *testing.T) {
+	keyring := testKeyring(t, masterKey(1))
+	storetest.Run(t, func(t testing.TB) store.Store {
+		return storetest.OpenSQLite(t, sqlstore.WithKeyring(keyring))
+	})
+}
+
+// masterKey returns a FIELD_ENCRYPTION_KEYS entry with a random key.
+func masterKey(version int) string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return strconv.Itoa(version) + ":" + base64.StdEncoding.EncodeToString(key)
+}
+
+func testKeyring(t testing.TB, entries ...string) *fieldcrypt.Keyring {
+	t.Helper()
+	k, err := fieldcrypt.ParseKeyring(strings.Join(entries, ","))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return k
+}
+
+// rawContent reads a promise's stored columns without decrypting them.
+func rawContent(t *testing.T, st *sqlstore.Store, id int) (recipient, description string) {
+	t.Helper()
+	err := st.DB().QueryRow(st.DB().Dialect.Rebind("SELECT recipient, description FROM promises WHERE id = ?"), id).
+		Scan(&recipient, &description)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return recipient, description
+}
+
+func reencryptAll(t *testing.T, st *sqlstore.Store) {
+	t.Helper()
+	for {
+		n, err := st.ReencryptFields(1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n == 0 {
+			return
+		}
+	}
+}
+
+func TestFieldEncryption(t *testing.T) {
+	plain := storetest.Open(t)
+	userID, err := plain.Users().Create("alice", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A promise written before encryption was turned on
+	old := &models.Promise{UserID: userID, Recipient: "Mom", Description: "Call on Sunday"}
+	if err := plain.Promises().Create(old); err != nil {
+		t.Fatal(err)
+	}
+	if err := plain.Events().Create(&models.Event{PromiseID: old.ID, State: "kept", ReflectionNote: "She was happy"}); err != nil {
+		t.Fatal(err)
+	}
+	oldDescription, newDescription := "Call on Saturday", "Call on Sunday"
+	revision := &models.Revision{PromiseID: old.ID, Field: "description", OldValue: &oldDescription, NewValue: &newDescription, Actor: "user"}
+	if err := plain.Revisions().Create(revision); err != nil {
+		t.Fatal(err)
+	}
+	item := &models.PromiseItem{PromiseID: old.ID, Title: "Find her new number"}
+	if err := plain.Items().Create(item); err != nil {
+		t.Fatal(err)
+	}
+	contact := &models.Contact{UserID: userID, Name: "Mom", Aliases: []string{"Mother"}, Phone: "555-0100"}
+	if err := plain.Contacts().Create(contact); err != nil {
+		t.Fatal(err)
+	}
+	template := &models.Template{UserID: userID, Name: "Weekly call", Recipient: "Mom", Description: "Call about {{topic}}"}
+	if err := plain.Templates().Create(template); err != nil {
+		t.Fatal(err)
+	}
+
+	v1, v2 := masterKey(1), masterKey(2)
+	st := sqlstore.New(plain.DB(), sqlstore.WithKeyring(testKeyring(t, v1)))
+	p := &models.Promise{UserID: userID, Recipient: "Sam", Description: "Return the drill"}
+	if err := st.Promises().Create(p); err != nil {
+		t.Fatal(err)
+	}
+	if recipient, description := rawContent(t, st, p.ID); !fieldcrypt.IsEncrypted(recipient) || !fieldcrypt.IsEncrypted(description) {
+		t.Fatalf("Expected new content to be encrypted, got %q / %q", recipient, description)
+	}
+
+	// Plaintext rows stay readable and are encrypted by the background job
+	got, err := st.Promises().Get(old.ID)
+	if err != nil || got.Recipient != "Mom" {
+		t.Fatalf("Expected plaintext row to be readable, got %+v (%v)", got, err)
+	}
+	status, err := st.EncryptionStatus()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.StalePromises != 1 || status.StaleEvents != 1 || status.StaleRevisions != 1 || status.StaleItems != 1 || status.StaleContacts != 1 || status.StaleTemplates != 1 {
+		t.Fatalf("Expected one stale promise, event, revision, item, contact and template, got %+v", status)
+	}
+	reencryptAll(t, st)
+	if recipient, _ := rawContent(t, st, old.ID); !fieldcrypt.IsEncrypted(recipient) {
+		t.Fatalf("Expected old row to be encrypted, got %q", recipient)
+	}
+	events, err := st.Events().ListByPromise(old.ID)
+	if err != nil || len(events) != 1 || events[0].ReflectionNote != "She was happy" {
+		t.Fatalf("Unexpected events %+v (%v)", events, err)
+	}
+	var rawOld string
+	if err := st.DB().QueryRow("SELECT old_value FROM promise_revisions WHERE id = ?", revision.ID).Scan(&rawOld); err != nil || !fieldcrypt.IsEncrypted(rawOld) {
+		t.Fatalf("Expected revision to be encrypted, got %q (%v)", rawOld, err)
+	}
+	revisions, err := st.Revisions().ListByPromise(old.ID)
+	if err != nil || len(revisions) != 1 || *revisions[0].OldValue != oldDescription || *revisions[0].NewValue != newDescription {
+		t.Fatalf("Unexpected revisions %+v (%v)", revisions, err)
+	}
+
+	var rawTitle string
+	if err := st.DB().QueryRow("SELECT title FROM promise_items WHERE id = ?", item.ID).Scan(&rawTitle); err != nil || !fieldcrypt.IsEncrypted(rawTitle) {
+		t.Fatalf("Expected item to be encrypted, got %q (%v)", rawTitle, err)
+	}
+	if got, err := st.Items().Get(item.ID); err != nil || got.Title != "Find her new number" {
+		t.Fatalf("Unexpected item %+v (%v)", got, err)
+	}
+
+	var rawName string
+	if err := st.DB().QueryRow("SELECT name FROM contacts WHERE id = ?", contact.ID).Scan(&rawName); err != nil || !fieldcrypt.IsEncrypted(rawName) {
+		t.Fatalf("Expected contact to be encrypted, got %q (%v)", rawName, err)
+	}
+	gotContact, err := st.Contacts().Get(contact.ID, userID)
+	if err != nil || gotContact.Name != "Mom" || gotContact.Aliases[0] != "Mother" || gotContact.Phone != "555-0100" {
+		t.Fatalf("Unexpected contact %+v (%v)", gotContact, err)
+	}
+
+	var rawDescription string
+	if err := st.DB().QueryRow("SELECT description FROM promise_templates WHERE id = ?", template.ID).Scan(&rawDescription); err != nil || !fieldcrypt.IsEncrypted(rawDescription) {
+		t.Fatalf("Expected template to be encrypted, got %q (%v)", rawDescription, err)
+	}
+	if got, err := st.Templates().Get(template.ID, userID); err != nil || got.Recipient != "Mom" || got.Description != "Call about {{topic}}" {
+		t.Fatalf("Unexpected template %+v (%v)", got, err)
+	}
+
+	// Text filtering still works on encrypted content
+	list, err := st.Promises().ListByUser(userID, store.PromiseFilter{Text: "drill"})
+	if err != nil || len(list) != 1 || list[0].ID != p.ID {
+		t.Fatalf("Expected text filter to find the drill promise, got %+v (%v)", list, err)
+	}
+	hits, err := st.Search().Search(userID, store.SearchQuery{Text: "happ"})
+	if err != nil || len(hits) != 1 || hits[0].Kind != models.SearchHitNote || hits[0].Snippet != "She was <mark>happy</mark>" {
+		t.Fatalf("Expected search to find the decrypted note, got %+v (%v)", hits, err)
+	}
+
+	// Without the keyring encrypted rows can't be read, and startup says so
+	if _, err := plain.Promises().Get(p.ID); err == nil {
+		t.Fatal("Expected reading encrypted content without keys to fail")
+	}
+	if err := plain.CheckKeyring(); err == nil {
+		t.Fatal("Expected CheckKeyring to fail without keys")
+	}
+
+	// Rotating the master key rewraps data keys; then v1 can be dropped
+	st = sqlstore.New(plain.DB(), sqlstore.WithKeyring(testKeyring(t, v2, v1)))
+	if _, err := st.RotateDataKeys(); err != nil {
+		t.Fatal(err)
+	}
+	reencryptAll(t, st)
+	status, _ = st.EncryptionStatus()
+	if status.KeysByMasterVersion[1] != 0 || status.StalePromises != 0 || status.StaleEvents != 0 || status.StaleRevisions != 0 || status.StaleItems != 0 || status.StaleContacts != 0 || status.StaleTemplates != 0 {
+		t.Fatalf("Expected everything on master key 2 and the new data key, got %+v", status)
+	}
+
+	st = sqlstore.New(plain.DB(), sqlstore.WithKeyring(testKeyring(t, v2)))
+	if err := st.CheckKeyring(); err != nil {
+		t.Fatal(err)
+	}
+	got, err = st.Promises().Get(old.ID)
+	if err != nil || got.Description != "Call on Sunday" {
+		t.Fatalf("Expected content to survive rotation, got %+v (%v)", got, err)
+	}
+}
+
+func TestSearchIndex(t *testing.T) {
+	st := storetest.OpenSQLite(t)
+	if !database.FTS5Available() {
+		if _, err := st.RebuildSearchIndex(); err == nil {
+			t.Fatal("Expected rebuilding the search index without FTS5 to fail")
+		}
+		t.Skip("SQLite was built without FTS5; run with -tags sqlite_fts5")
+	}
+	userID, err := st.Users().Create("alice", "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	search := func(text string) int {
+		t.Helper()
+		hits, err := st.Search().Search(userID, store.SearchQuery{Text: text})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(hits)
+	}
+	if err := st.Promises().Create(&models.Promise{UserID: userID, Recipient: "Sam", Description: "Return the drill"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Promises written while a trigger was missing are indexed at startup
+	if _, err := st.DB().Exec("DROP TRIGGER promises_fts_insert"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Promises().Create(&models.Promise{UserID: userID, Recipient: "Sam", Description: "Paint the fence"}); err != nil {
+		t.Fatal(err)
+	}
+	if st.SearchIndexed() {
+		t.Fatal("Expected an incomplete index not to be used")
+	}
+	if err := st.EnsureSearchIndex(); err != nil {
+		t.Fatal(err)
+	}
+	if !st.SearchIndexed() || search("fence") != 1 {
+		t.Fatal("Expected the rebuilt index to find the fence")
+	}
+
+	if _, err := st.DB().Exec("DELETE FROM promises_fts"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st.RebuildSearchIndex(); err != nil || n != 2 {
+		t.Fatalf("Expected 2 rows indexed, got %d (%v)", n, err)
+	}
+	if search("drill") != 1 {
+		t.Fatal("Expected the rebuilt index to find the drill")
+	}
+
+	// With field encryption the index could only hold ciphertext
+	encrypted := sqlstore.New(st.DB(), sqlstore.WithKeyring(testKeyring(t, masterKey(1))))
+	if err := encrypted.EnsureSearchIndex(); err != nil {
+		t.Fatal(err)
+	}
+	var tables int
+	if err := st.DB().QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE name LIKE '%_fts%'").Scan(&tables); err != nil || tables != 0 {
+		t.Fatalf("Expected the index to be dropped, found %d objects (%v)", tables, err)
+	}
+}
@@ -0,0 +1,152 @@
+// Package sqlstore implements store.Store on top of database/sql. The same
+// queries serve SQLite and PostgreSQL; they are written with ? placeholders
+// and rebound for the dialect of the underlying database.
+package sqlstore
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"kept/internal/database"
+	"kept/internal/store"
+)
+
+// querier is satisfied by both *sql.DB and *sql.Tx.
+type querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	Query(query string, args ...any) (*sql.Rows, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// Store is a store.Store backed by a SQL database.
+type Store struct {
+	db    *database.DB
+	q     querier
+	tx    bool
+	crypt *fieldCipher
+}
+
+var _ store.Store = (*Store)(nil)
+
+// New returns a Store using db. The schema must already be migrated.
+func New(db *database.DB, opts ...Option) *Store {
+	s := &Store{db: db, q: db.DB}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// DB returns the underlying database handle.
+func (s *Store) DB() *database.DB { return s.db }
+
+func (s *Store) Users() store.UserRepository                 { return userRepo{s} }
+func (s *Store) Promises() store.PromiseRepository           { return promiseRepo{s} }
+func (s *Store) Events() store.EventRepository               { return eventRepo{s} }
+func (s *Store) Revisions() store.RevisionRepository         { return revisionRepo{s} }
+func (s *Store) Items() store.ItemRepository                 { return itemRepo{s} }
+func (s *Store) Series() store.SeriesRepository              { return seriesRepo{s} }
+func (s *Store) Tags() store.TagRepository                   { return tagRepo{s} }
+func (s *Store) Contacts() store.ContactRepository           { return contactRepo{s} }
+func (s *Store) Templates() store.TemplateRepository         { return templateRepo{s} }
+func (s *Store) Search() store.SearchRepository              { return searchRepo{s} }
+func (s *Store) ShareLinks() store.ShareLinkRepository       { return shareLinkRepo{s} }
+func (s *Store) Workspaces() store.WorkspaceRepository       { return workspaceRepo{s} }
+func (s *Store) Partnerships() store.PartnershipRepository   { return partnershipRepo{s} }
+func (s *Store) Reminders() store.ReminderRepository         { return reminderRepo{s} }
+func (s *Store) Subscriptions() store.SubscriptionRepository { return subscriptionRepo{s} }
+func (s *Store) RefreshTokens() store.RefreshTokenRepository { return refreshTokenRepo{s} }
+func (s *Store) PushActions() store.PushActionRepository     { return pushActionRepo{s} }
+func (s *Store) AppPasswords() store.AppPasswordRepository   { return appPasswordRepo{s} }
+func (s *Store) CalDAVObjects() store.CalDAVObjectRepository { return caldavObjectRepo{s} }
+
+// InTx runs fn inside a transaction. Calls nested inside a transaction reuse
+// it.
+func (s *Store) InTx(fn func(tx store.Store) error) error {
+	if s.tx {
+		return fn(s)
+	}
+
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(&Store{db: s.db, q: tx, tx: true, crypt: s.crypt}); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *Store) exec(query string, args ...any) (sql.Result, error) {
+	return s.q.Exec(s.db.Dialect.Rebind(query), args...)
+}
+
+func (s *Store) query(query string, args ...any) (*sql.Rows, error) {
+	return s.q.Query(s.db.Dialect.Rebind(query), args...)
+}
+
+func (s *Store) queryRow(query string, args ...any) *sql.Row {
+	return s.q.QueryRow(s.db.Dialect.Rebind(query), args...)
+}
+
+// insert runs an INSERT and returns the generated id. Both SQLite and
+// PostgreSQL support RETURNING.
+func (s *Store) insert(query string, args ...any) (int, error) {
+	var id int
+	err := s.queryRow(query+" RETURNING id", args...).Scan(&id)
+	if err != nil && s.db.Dialect.IsUniqueViolation(err) {
+		return 0, store.ErrConflict
+	}
+	return id, err
+}
+
+// execOne runs a statement that must affect a row, returning
+// store.ErrNotFound otherwise.
+func (s *Store) execOne(query string, args ...any) error {
+	result, err := s.exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return store.ErrNotFound
+	}
+	return nil
+}
+
+// notFound maps sql.ErrNoRows to store.ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return store.ErrNotFound
+	}
+	return err
+}
+
+// now returns the current time in UTC.
+func now() time.Time {
+	return time.Now().UTC()
+}
+
+// utc normalizes times before they are written so SQLite's text timestamps
+// compare correctly.
+func utc(t time.Time) time.Time {
+	return t.UTC()
+}
+
+func utcPtr(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	u := t.UTC()
+	return &u
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
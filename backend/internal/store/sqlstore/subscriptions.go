@@ -0,0 +1,48 @@
+package sqlstore
+
+import "kept/internal/models"
+
+type subscriptionRepo struct{ s *Store }
+
+func (r subscriptionRepo) Upsert(sub models.PushSubscription) error {
+	_, err := r.s.exec(
+		`INSERT INTO push_subscriptions (user_id, endpoint, p256dh, auth)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (user_id, endpoint) DO UPDATE SET
+		p256dh = excluded.p256dh,
+		auth = excluded.auth`,
+		sub.UserID, sub.Endpoint, sub.P256dh, sub.Auth,
+	)
+	return err
+}
+
+func (r subscriptionRepo) ListByUser(userID int) ([]models.PushSubscription, error) {
+	rows, err := r.s.query(
+		"SELECT id, user_id, endpoint, p256dh, auth FROM push_subscriptions WHERE user_id = ? ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subs := []models.PushSubscription{}
+	for rows.Next() {
+		var sub models.PushSubscription
+		if err := rows.Scan(&sub.ID, &sub.UserID, &sub.Endpoint, &sub.P256dh, &sub.Auth); err != nil {
+			return nil, err
+		}
+		subs = append(subs, sub)
+	}
+	return subs, rows.Err()
+}
+
+func (r subscriptionRepo) Delete(userID int, endpoint string) error {
+	_, err := r.s.exec("DELETE FROM push_subscriptions WHERE user_id = ? AND endpoint = ?", userID, endpoint)
+	return err
+}
+
+func (r subscriptionRepo) DeleteByEndpoint(endpoint string) error {
+	_, err := r.s.exec("DELETE FROM push_subscriptions WHERE endpoint = ?", endpoint)
+	return err
+}
@@ -0,0 +1,139 @@
+package sqlstore
+
+import (
+	"strings"
+
+	"kept/internal/models"
+	"kept/internal/store"
+)
+
+type tagRepo struct{ s *Store }
+
+// tagStates are the states tag counts are reported for. Postponed promises
+// are stored as kept.
+var tagStates = []string{"active", "kept", "broken"}
+
+func (r tagRepo) Create(t *models.Tag) error {
+	created := now()
+	id, err := r.s.insert(
+		"INSERT INTO tags (user_id, name, color, created_at) VALUES (?, ?, ?, ?)",
+		t.UserID, t.Name, t.Color, created,
+	)
+	if err != nil {
+		return err
+	}
+	t.ID = id
+	t.CreatedAt = created
+	t.Counts = map[string]int{}
+	for _, state := range tagStates {
+		t.Counts[state] = 0
+	}
+	return nil
+}
+
+func (r tagRepo) ListByUser(userID int) ([]models.Tag, error) {
+	rows, err := r.s.query("SELECT id, user_id, name, color, created_at FROM tags WHERE user_id = ? ORDER BY name, id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []models.Tag{}
+	index := map[int]int{}
+	for rows.Next() {
+		var t models.Tag
+		var createdAt nullTime
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Color, &createdAt); err != nil {
+			return nil, err
+		}
+		t.CreatedAt = createdAt.Time
+		t.Counts = map[string]int{}
+		for _, state := range tagStates {
+			t.Counts[state] = 0
+		}
+		index[t.ID] = len(tags)
+		tags = append(tags, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	counts, err := r.s.query(
+		`SELECT pt.tag_id, p.current_state, COUNT(*)
+		FROM promise_tags pt JOIN promises p ON p.id = pt.promise_id
+		WHERE p.user_id = ? AND p.deleted_at IS NULL
+		GROUP BY pt.tag_id, p.current_state`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer counts.Close()
+	for counts.Next() {
+		var tagID, count int
+		var state string
+		if err := counts.Scan(&tagID, &state, &count); err != nil {
+			return nil, err
+		}
+		if i, ok := index[tagID]; ok {
+			tags[i].Counts[state] = count
+		}
+	}
+	return tags, counts.Err()
+}
+
+func (r tagRepo) Update(t *models.Tag) error {
+	err := r.s.execOne("UPDATE tags SET name = ?, color = ? WHERE id = ? AND user_id = ?", t.Name, t.Color, t.ID, t.UserID)
+	if err != nil && r.s.db.Dialect.IsUniqueViolation(err) {
+		return store.ErrConflict
+	}
+	return err
+}
+
+func (r tagRepo) Delete(id, userID int) error {
+	return r.s.execOne("DELETE FROM tags WHERE id = ? AND user_id = ?", id, userID)
+}
+
+func (r tagRepo) ListAssignments(userID int) (map[int][]int, error) {
+	rows, err := r.s.query(
+		`SELECT pt.promise_id, pt.tag_id FROM promise_tags pt JOIN tags t ON t.id = pt.tag_id
+		WHERE t.user_id = ? ORDER BY pt.promise_id, t.name, t.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := map[int][]int{}
+	for rows.Next() {
+		var promiseID, tagID int
+		if err := rows.Scan(&promiseID, &tagID); err != nil {
+			return nil, err
+		}
+		assignments[promiseID] = append(assignments[promiseID], tagID)
+	}
+	return assignments, rows.Err()
+}
+
+func (r tagRepo) SetForPromise(promiseID, userID int, tagIDs []int) error {
+	_, err := r.s.exec(
+		"DELETE FROM promise_tags WHERE promise_id = ? AND tag_id IN (SELECT id FROM tags WHERE user_id = ?)",
+		promiseID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	for _, tagID := range tagIDs {
+		if _, err := r.s.exec("INSERT INTO promise_tags (promise_id, tag_id) VALUES (?, ?)", promiseID, tagID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// placeholders returns n comma-separated ? placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,133 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"kept/internal/models"
+)
+
+type templateRepo struct{ s *Store }
+
+const templateColumns = "id, user_id, name, recipient, description, due_offset_minutes, reminder_frequency, reminder_offsets, created_at, updated_at"
+
+// scan reads a template and decrypts its recipient and description.
+func (r templateRepo) scan(row interface{ Scan(...any) error }) (*models.Template, error) {
+	var t models.Template
+	var dueOffset sql.NullInt64
+	var frequency, offsets sql.NullString
+	var createdAt, updatedAt nullTime
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.Recipient, &t.Description, &dueOffset, &frequency, &offsets, &createdAt, &updatedAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if t.Recipient, err = r.s.decrypt(t.UserID, fieldTemplateRecipient, t.Recipient); err != nil {
+		return nil, err
+	}
+	if t.Description, err = r.s.decrypt(t.UserID, fieldTemplateDescription, t.Description); err != nil {
+		return nil, err
+	}
+	if dueOffset.Valid {
+		minutes := int(dueOffset.Int64)
+		t.DueOffsetMinutes = &minutes
+	}
+	t.ReminderFrequency = frequency.String
+	t.ReminderOffsets = []int{}
+	parsed, err := parseJSON[[]int](offsets)
+	if err != nil {
+		return nil, err
+	}
+	if parsed != nil {
+		t.ReminderOffsets = *parsed
+	}
+	t.CreatedAt = createdAt.Time
+	t.UpdatedAt = updatedAt.Time
+	return &t, nil
+}
+
+// sealed returns the recipient, description and reminder offsets as they
+// are stored.
+func (r templateRepo) sealed(t *models.Template) ([]any, error) {
+	recipient, err := r.s.encrypt(t.UserID, fieldTemplateRecipient, t.Recipient)
+	if err != nil {
+		return nil, err
+	}
+	description, err := r.s.encrypt(t.UserID, fieldTemplateDescription, t.Description)
+	if err != nil {
+		return nil, err
+	}
+	var offsets sql.NullString
+	if len(t.ReminderOffsets) > 0 {
+		if offsets, err = jsonValue(&t.ReminderOffsets); err != nil {
+			return nil, err
+		}
+	}
+	return []any{recipient, description, offsets}, nil
+}
+
+func (r templateRepo) Create(t *models.Template) error {
+	sealed, err := r.sealed(t)
+	if err != nil {
+		return err
+	}
+	created := now()
+	id, err := r.s.insert(
+		`INSERT INTO promise_templates (user_id, name, recipient, description, reminder_offsets, due_offset_minutes, reminder_frequency, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		append(append([]any{t.UserID, t.Name}, sealed...), t.DueOffsetMinutes, nullString(t.ReminderFrequency), created, created)...,
+	)
+	if err != nil {
+		return err
+	}
+	t.ID = id
+	if t.ReminderOffsets == nil {
+		t.ReminderOffsets = []int{}
+	}
+	t.CreatedAt = created
+	t.UpdatedAt = created
+	return nil
+}
+
+func (r templateRepo) Get(id, userID int) (*models.Template, error) {
+	return r.scan(r.s.queryRow("SELECT "+templateColumns+" FROM promise_templates WHERE id = ? AND user_id = ?", id, userID))
+}
+
+func (r templateRepo) ListByUser(userID int) ([]models.Template, error) {
+	rows, err := r.s.query("SELECT "+templateColumns+" FROM promise_templates WHERE user_id = ? ORDER BY LOWER(name), id", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	templates := []models.Template{}
+	for rows.Next() {
+		t, err := r.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		templates = append(templates, *t)
+	}
+	return templates, rows.Err()
+}
+
+func (r templateRepo) Update(t *models.Template) error {
+	sealed, err := r.sealed(t)
+	if err != nil {
+		return err
+	}
+	updated := now()
+	err = r.s.execOne(
+		`UPDATE promise_templates SET name = ?, recipient = ?, description = ?, reminder_offsets = ?,
+		due_offset_minutes = ?, reminder_frequency = ?, updated_at = ?
+		WHERE id = ? AND user_id = ?`,
+		append(append([]any{t.Name}, sealed...), t.DueOffsetMinutes, nullString(t.ReminderFrequency), updated, t.ID, t.UserID)...,
+	)
+	if err != nil {
+		return err
+	}
+	t.UpdatedAt = updated
+	return nil
+}
+
+func (r templateRepo) Delete(id, userID int) error {
+	return r.s.execOne("DELETE FROM promise_templates WHERE id = ? AND user_id = ?", id, userID)
+}
@@ -0,0 +1,66 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"kept/internal/models"
+	"kept/internal/store"
+)
+
+type userRepo struct{ s *Store }
+
+const userColumns = "id, username, email, password_hash, created_at"
+
+func scanUser(row interface{ Scan(...any) error }) (*models.User, error) {
+	var u models.User
+	var email sql.NullString
+	var createdAt nullTime
+	if err := row.Scan(&u.ID, &u.Username, &email, &u.PasswordHash, &createdAt); err != nil {
+		return nil, notFound(err)
+	}
+	u.Email = email.String
+	u.CreatedAt = createdAt.Time
+	return &u, nil
+}
+
+func (r userRepo) Create(username, passwordHash string) (int, error) {
+	return r.s.insert(
+		"INSERT INTO users (username, password_hash, created_at) VALUES (?, ?, ?)",
+		username, passwordHash, now(),
+	)
+}
+
+func (r userRepo) GetByID(id int) (*models.User, error) {
+	return scanUser(r.s.queryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id))
+}
+
+func (r userRepo) GetByUsername(username string) (*models.User, error) {
+	return scanUser(r.s.queryRow("SELECT "+userColumns+" FROM users WHERE username = ?", username))
+}
+
+func (r userRepo) UpdateEmail(id int, email string) error {
+	return r.s.execOne("UPDATE users SET email = ? WHERE id = ?", nullString(email), id)
+}
+
+func (r userRepo) GetE2EKeyCheck(id int) (*models.E2EKeyCheck, error) {
+	var blob sql.NullString
+	if err := r.s.queryRow("SELECT e2e_key_check FROM users WHERE id = ?", id).Scan(&blob); err != nil {
+		return nil, notFound(err)
+	}
+	check, err := parseJSON[models.E2EKeyCheck](blob)
+	if err != nil {
+		return nil, err
+	}
+	if check == nil {
+		return nil, store.ErrNotFound
+	}
+	return check, nil
+}
+
+func (r userRepo) SetE2EKeyCheck(id int, check *models.E2EKeyCheck) error {
+	blob, err := jsonValue(check)
+	if err != nil {
+		return err
+	}
+	return r.s.execOne("UPDATE users SET e2e_key_check = ? WHERE id = ?", blob, id)
+}
@@ -0,0 +1,197 @@
+package sqlstore
+
+import (
+	"database/sql"
+
+	"kept/internal/models"
+)
+
+type workspaceRepo struct{ s *Store }
+
+// editableBy matches promises the user bound to both placeholders can
+// change: their personal promises and those of workspaces where they are an
+// owner or editor.
+const editableBy = `((workspace_id IS NULL AND user_id = ?) OR workspace_id IN (
+	SELECT workspace_id FROM workspace_members WHERE user_id = ? AND role IN ('owner', 'editor')))`
+
+func scanWorkspace(row interface{ Scan(...any) error }) (*models.Workspace, error) {
+	var w models.Workspace
+	var createdBy sql.NullInt64
+	var createdAt nullTime
+	if err := row.Scan(&w.ID, &w.Name, &createdBy, &w.Role, &createdAt); err != nil {
+		return nil, notFound(err)
+	}
+	if createdBy.Valid {
+		id := int(createdBy.Int64)
+		w.CreatedBy = &id
+	}
+	w.CreatedAt = createdAt.Time
+	return &w, nil
+}
+
+func (r workspaceRepo) Create(w *models.Workspace, ownerID int) error {
+	created := now()
+	id, err := r.s.insert("INSERT INTO workspaces (name, created_by, created_at) VALUES (?, ?, ?)", w.Name, ownerID, created)
+	if err != nil {
+		return err
+	}
+	if err := r.SetMember(id, ownerID, models.RoleOwner); err != nil {
+		return err
+	}
+	w.ID = id
+	w.CreatedBy = &ownerID
+	w.Role = models.RoleOwner
+	w.CreatedAt = created
+	return nil
+}
+
+func (r workspaceRepo) Get(id, userID int) (*models.Workspace, error) {
+	return scanWorkspace(r.s.queryRow(
+		`SELECT w.id, w.name, w.created_by, m.role, w.created_at
+		FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE w.id = ? AND m.user_id = ?`,
+		id, userID,
+	))
+}
+
+func (r workspaceRepo) ListByUser(userID int) ([]models.Workspace, error) {
+	rows, err := r.s.query(
+		`SELECT w.id, w.name, w.created_by, m.role, w.created_at
+		FROM workspaces w JOIN workspace_members m ON m.workspace_id = w.id
+		WHERE m.user_id = ? ORDER BY LOWER(w.name), w.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	workspaces := []models.Workspace{}
+	for rows.Next() {
+		w, err := scanWorkspace(rows)
+		if err != nil {
+			return nil, err
+		}
+		workspaces = append(workspaces, *w)
+	}
+	return workspaces, rows.Err()
+}
+
+func (r workspaceRepo) Rename(id int, name string) error {
+	return r.s.execOne("UPDATE workspaces SET name = ? WHERE id = ?", name, id)
+}
+
+func (r workspaceRepo) Delete(id int) error {
+	if _, err := r.s.exec("DELETE FROM promises WHERE workspace_id = ?", id); err != nil {
+		return err
+	}
+	return r.s.execOne("DELETE FROM workspaces WHERE id = ?", id)
+}
+
+func (r workspaceRepo) ListMembers(workspaceID int) ([]models.WorkspaceMember, error) {
+	rows, err := r.s.query(
+		`SELECT m.user_id, u.username, m.role, m.created_at
+		FROM workspace_members m JOIN users u ON u.id = m.user_id
+		WHERE m.workspace_id = ? ORDER BY u.username`,
+		workspaceID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.WorkspaceMember{}
+	for rows.Next() {
+		var m models.WorkspaceMember
+		var createdAt nullTime
+		if err := rows.Scan(&m.UserID, &m.Username, &m.Role, &createdAt); err != nil {
+			return nil, err
+		}
+		m.CreatedAt = createdAt.Time
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (r workspaceRepo) SetMember(workspaceID, userID int, role string) error {
+	_, err := r.s.exec(
+		`INSERT INTO workspace_members (workspace_id, user_id, role, created_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT (workspace_id, user_id) DO UPDATE SET role = excluded.role`,
+		workspaceID, userID, role, now(),
+	)
+	return err
+}
+
+func (r workspaceRepo) RemoveMember(workspaceID, userID int) error {
+	return r.s.execOne("DELETE FROM workspace_members WHERE workspace_id = ? AND user_id = ?", workspaceID, userID)
+}
+
+const invitationQuery = `SELECT i.id, i.workspace_id, w.name, i.user_id, u.username, i.role, i.invited_by, i.created_at
+	FROM workspace_invitations i
+	JOIN workspaces w ON w.id = i.workspace_id
+	JOIN users u ON u.id = i.user_id`
+
+func scanInvitation(row interface{ Scan(...any) error }) (*models.WorkspaceInvitation, error) {
+	var inv models.WorkspaceInvitation
+	var invitedBy sql.NullInt64
+	var createdAt nullTime
+	err := row.Scan(&inv.ID, &inv.WorkspaceID, &inv.WorkspaceName, &inv.UserID, &inv.Username, &inv.Role, &invitedBy, &createdAt)
+	if err != nil {
+		return nil, notFound(err)
+	}
+	if invitedBy.Valid {
+		id := int(invitedBy.Int64)
+		inv.InvitedBy = &id
+	}
+	inv.CreatedAt = createdAt.Time
+	return &inv, nil
+}
+
+func (r workspaceRepo) listInvitations(query string, args ...any) ([]models.WorkspaceInvitation, error) {
+	rows, err := r.s.query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invitations := []models.WorkspaceInvitation{}
+	for rows.Next() {
+		inv, err := scanInvitation(rows)
+		if err != nil {
+			return nil, err
+		}
+		invitations = append(invitations, *inv)
+	}
+	return invitations, rows.Err()
+}
+
+func (r workspaceRepo) Invite(inv *models.WorkspaceInvitation) error {
+	created := now()
+	id, err := r.s.insert(
+		"INSERT INTO workspace_invitations (workspace_id, user_id, role, invited_by, created_at) VALUES (?, ?, ?, ?, ?)",
+		inv.WorkspaceID, inv.UserID, inv.Role, inv.InvitedBy, created,
+	)
+	if err != nil {
+		return err
+	}
+	inv.ID = id
+	inv.CreatedAt = created
+	return nil
+}
+
+func (r workspaceRepo) GetInvitation(id int) (*models.WorkspaceInvitation, error) {
+	return scanInvitation(r.s.queryRow(invitationQuery+" WHERE i.id = ?", id))
+}
+
+func (r workspaceRepo) ListInvitations(workspaceID int) ([]models.WorkspaceInvitation, error) {
+	return r.listInvitations(invitationQuery+" WHERE i.workspace_id = ? ORDER BY i.created_at, i.id", workspaceID)
+}
+
+func (r workspaceRepo) ListInvitationsForUser(userID int) ([]models.WorkspaceInvitation, error) {
+	return r.listInvitations(invitationQuery+" WHERE i.user_id = ? ORDER BY i.created_at, i.id", userID)
+}
+
+func (r workspaceRepo) DeleteInvitation(id int) error {
+	return r.s.execOne("DELETE FROM workspace_invitations WHERE id = ?", id)
+}
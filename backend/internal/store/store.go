@@ -0,0 +1,420 @@
+// Package store defines the persistence interfaces used by the HTTP handlers
+// and background workers. Implementations live in subpackages.
+package store
+
+import (
+	"errors"
+	"time"
+
+	"kept/internal/models"
+)
+
+var (
+	// ErrNotFound is returned when a requested row does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write violates a uniqueness constraint.
+	ErrConflict = errors.New("conflict")
+	// ErrStale is returned when a write is based on an outdated version of a
+	// row.
+	ErrStale = errors.New("stale version")
+)
+
+// Store groups the repositories. InTx runs fn against a Store whose
+// repositories share one transaction, committing if fn returns nil.
+type Store interface {
+	Users() UserRepository
+	Promises() PromiseRepository
+	Events() EventRepository
+	Revisions() RevisionRepository
+	Items() ItemRepository
+	Series() SeriesRepository
+	Tags() TagRepository
+	Contacts() ContactRepository
+	Templates() TemplateRepository
+	Search() SearchRepository
+	ShareLinks() ShareLinkRepository
+	Workspaces() WorkspaceRepository
+	Partnerships() PartnershipRepository
+	Reminders() ReminderRepository
+	Subscriptions() SubscriptionRepository
+	RefreshTokens() RefreshTokenRepository
+	PushActions() PushActionRepository
+	AppPasswords() AppPasswordRepository
+	CalDAVObjects() CalDAVObjectRepository
+
+	InTx(fn func(tx Store) error) error
+}
+
+type UserRepository interface {
+	// Create inserts a user and returns its ID. It returns ErrConflict if the
+	// username is taken.
+	Create(username, passwordHash string) (int, error)
+	GetByID(id int) (*models.User, error)
+	GetByUsername(username string) (*models.User, error)
+	// UpdateEmail sets or, with an empty string, clears the user's email.
+	UpdateEmail(id int, email string) error
+	// GetE2EKeyCheck returns the user's end-to-end encryption key check, or
+	// ErrNotFound if they haven't set up end-to-end encryption.
+	GetE2EKeyCheck(id int) (*models.E2EKeyCheck, error)
+	// SetE2EKeyCheck stores the key check, or clears it when check is nil.
+	SetE2EKeyCheck(id int, check *models.E2EKeyCheck) error
+}
+
+// PromiseFilter narrows ListByUser. Zero values match everything.
+type PromiseFilter struct {
+	State string
+	// Text matches recipient or description, ignoring case.
+	Text string
+	// TagIDs matches promises with any of the tags, or with all of them if
+	// AllTags is set.
+	TagIDs  []int
+	AllTags bool
+	// ContactID matches promises linked to the contact.
+	ContactID int
+	// WorkspaceID lists the workspace's promises instead of the user's
+	// personal ones.
+	WorkspaceID int
+	// PartnerVisible matches promises shown to accountability partners.
+	PartnerVisible bool
+	// SeriesID lists the instances of a recurring promise instead of the
+	// user's personal promises.
+	SeriesID int
+}
+
+type PromiseRepository interface {
+	// Create inserts an active promise and fills in its ID and timestamps.
+	Create(p *models.Promise) error
+	// Get returns a promise that isn't in the trash.
+	Get(id int) (*models.Promise, error)
+	// ListByUser returns the user's personal promises, or those of
+	// filter.WorkspaceID or filter.SeriesID, kept ones first, then newest
+	// first.
+	ListByUser(userID int, filter PromiseFilter) ([]models.Promise, error)
+	// MostRecentByUser returns the user's most recently updated promise.
+	MostRecentByUser(userID int) (*models.Promise, error)
+	// Update saves the recipient and their email settings, description,
+	// envelope, contact, partner visibility, checklist setting, due date and
+	// reminder frequency of an existing promise and bumps its version. It
+	// returns ErrStale if p.Version is no longer the stored version.
+	Update(p *models.Promise) error
+	// Touch bumps a promise's version after a change to something its
+	// representation includes, such as checklist progress.
+	Touch(id int) error
+	// SetState changes a promise's state and bumps its version. It returns
+	// ErrStale if expectedVersion is no longer the stored version.
+	SetState(id, expectedVersion int, state string) error
+	// Trash moves a promise userID can edit to the trash: one of their
+	// personal promises or one in a workspace where they are an owner or
+	// editor. Trashed promises are left out of every other query until they
+	// are restored. It returns ErrNotFound if there was nothing to trash.
+	Trash(id, userID int) error
+	// ListTrash returns the trashed promises userID can edit, most recently
+	// trashed first.
+	ListTrash(userID int) ([]models.Promise, error)
+	// Restore takes a promise userID can edit out of the trash.
+	Restore(id, userID int) error
+	// DeleteTrashed permanently deletes a trashed promise userID can edit,
+	// with its events and reminders.
+	DeleteTrashed(id, userID int) error
+	// PurgeTrash permanently deletes promises trashed at or before the given
+	// time and returns how many were deleted.
+	PurgeTrash(before time.Time) (int, error)
+	// ListOverdueIDs returns active promises whose due date is at or before
+	// now, except instances of a series.
+	ListOverdueIDs(now time.Time) ([]int, error)
+	// ListWithRecurringReminders returns active promises that have a
+	// reminder frequency set.
+	ListWithRecurringReminders() ([]models.Promise, error)
+	MarkReminded(id int, at time.Time) error
+}
+
+type EventRepository interface {
+	Create(e *models.Event) error
+	// ListByPromise returns a promise's events, oldest first.
+	ListByPromise(promiseID int) ([]models.Event, error)
+	// ListByUser returns the events of all of a user's promises that aren't
+	// in the trash, newest first.
+	ListByUser(userID int) ([]models.Event, error)
+	// ListByWorkspace returns the events of a workspace's promises that
+	// aren't in the trash, newest first.
+	ListByWorkspace(workspaceID int) ([]models.Event, error)
+}
+
+type RevisionRepository interface {
+	Create(r *models.Revision) error
+	// ListByPromise returns a promise's revisions, oldest first.
+	ListByPromise(promiseID int) ([]models.Revision, error)
+}
+
+type ItemRepository interface {
+	// Create appends an item to its promise's checklist.
+	Create(it *models.PromiseItem) error
+	Get(id int) (*models.PromiseItem, error)
+	// ListByPromise returns a promise's items by position.
+	ListByPromise(promiseID int) ([]models.PromiseItem, error)
+	// Update saves the title, due date and done state of an item.
+	Update(it *models.PromiseItem) error
+	// Move puts an item at position, clamped to the checklist, and shifts
+	// the items in between.
+	Move(id, position int) error
+	// Delete removes an item and closes the gap it leaves.
+	Delete(id int) error
+}
+
+type SeriesRepository interface {
+	Create(s *models.Series) error
+	Get(id int) (*models.Series, error)
+	// ListByUser returns the user's personal series, or those of a workspace
+	// if workspaceID isn't 0, newest first.
+	ListByUser(userID, workspaceID int) ([]models.Series, error)
+	// Update saves the frequency, interval, start and end of a series.
+	Update(s *models.Series) error
+	SetCurrent(id, promiseID int) error
+	// Stop ends a series; no more instances are created.
+	Stop(id int) error
+	// ListDue returns the current instances of running series that are
+	// resolved, trashed, or due at or before now.
+	ListDue(now time.Time) ([]models.Promise, error)
+}
+
+type TagRepository interface {
+	// Create inserts a tag. It returns ErrConflict if the user already has a
+	// tag with that name.
+	Create(t *models.Tag) error
+	// ListByUser returns the user's tags sorted by name, with counts of the
+	// promises outside the trash that carry them, by state.
+	ListByUser(userID int) ([]models.Tag, error)
+	// Update saves the name and color of a tag owned by t.UserID. It returns
+	// ErrNotFound if there is no such tag and ErrConflict if the name is taken.
+	Update(t *models.Tag) error
+	// Delete removes a tag owned by userID from all promises and deletes it.
+	Delete(id, userID int) error
+	// ListAssignments maps the IDs of the user's tagged promises to their
+	// tag IDs.
+	ListAssignments(userID int) (map[int][]int, error)
+	// SetForPromise replaces userID's tags on a promise. Tags other members
+	// of a workspace put on it stay.
+	SetForPromise(promiseID, userID int, tagIDs []int) error
+}
+
+type ContactRepository interface {
+	Create(c *models.Contact) error
+	// Get returns a contact owned by userID.
+	Get(id, userID int) (*models.Contact, error)
+	// ListByUser returns the user's contacts sorted by name.
+	ListByUser(userID int) ([]models.Contact, error)
+	// Update saves the name, aliases, email, phone and notes of a contact
+	// owned by c.UserID.
+	Update(c *models.Contact) error
+	// Delete unlinks a contact owned by userID from its promises and deletes
+	// it.
+	Delete(id, userID int) error
+	// MovePromises links the promises of contact from to contact into
+	// instead, bumping their versions.
+	MovePromises(from, into int) error
+	// ListUnlinked returns promises with a recipient that aren't linked to a
+	// contact and aren't end-to-end encrypted, including trashed ones.
+	ListUnlinked() ([]UnlinkedPromise, error)
+	// Link links a promise that isn't linked yet to a contact.
+	Link(promiseID, contactID int) error
+}
+
+type TemplateRepository interface {
+	Create(t *models.Template) error
+	// Get returns a template owned by userID.
+	Get(id, userID int) (*models.Template, error)
+	// ListByUser returns the user's templates sorted by name.
+	ListByUser(userID int) ([]models.Template, error)
+	// Update saves the fields of a template owned by t.UserID.
+	Update(t *models.Template) error
+	Delete(id, userID int) error
+}
+
+// SearchQuery describes a search. Zero values other than Text match
+// everything.
+type SearchQuery struct {
+	// Text is split into words, each of which must begin a word of the
+	// promise's recipient or description, or of a reflection note.
+	Text  string
+	State string
+	// From and To bound the date of a hit, inclusive.
+	From, To *time.Time
+	// WorkspaceID searches the workspace's promises instead of the user's
+	// personal ones.
+	WorkspaceID int
+	// Limit caps the number of hits; 0 means no limit.
+	Limit int
+}
+
+type SearchRepository interface {
+	// Search returns the user's personal promises, or those of
+	// q.WorkspaceID, and their reflection notes that match q, best match
+	// first. Trashed and end-to-end encrypted promises never match.
+	Search(userID int, q SearchQuery) ([]models.SearchHit, error)
+}
+
+// UnlinkedPromise is a promise whose recipient hasn't been matched to a
+// contact yet.
+type UnlinkedPromise struct {
+	ID        int
+	UserID    int
+	Recipient string
+}
+
+type AppPasswordRepository interface {
+	// Create stores an app password under the hash of the password.
+	Create(p *models.AppPassword, tokenHash string) error
+	GetByTokenHash(tokenHash string) (*models.AppPassword, error)
+	// ListByUser returns a user's app passwords, newest first.
+	ListByUser(userID int) ([]models.AppPassword, error)
+	// Delete removes an app password owned by userID. It returns ErrNotFound
+	// if there was nothing to delete.
+	Delete(id, userID int) error
+}
+
+type ShareLinkRepository interface {
+	// Create stores a link under the hash of its token.
+	Create(l *models.ShareLink, tokenHash string) error
+	GetByTokenHash(tokenHash string) (*models.ShareLink, error)
+	// ListByPromise returns a promise's links, newest first.
+	ListByPromise(promiseID int) ([]models.ShareLink, error)
+	// Revoke revokes a link to promiseID. It returns ErrNotFound if there is
+	// no such link that is still active.
+	Revoke(id, promiseID int) error
+}
+
+type WorkspaceRepository interface {
+	// Create inserts a workspace with ownerID as its owner.
+	Create(w *models.Workspace, ownerID int) error
+	// Get returns a workspace with the role userID has in it. It returns
+	// ErrNotFound if userID isn't a member.
+	Get(id, userID int) (*models.Workspace, error)
+	// ListByUser returns the workspaces userID is a member of, with their
+	// role, sorted by name.
+	ListByUser(userID int) ([]models.Workspace, error)
+	Rename(id int, name string) error
+	// Delete deletes a workspace with its promises, members and invitations.
+	Delete(id int) error
+	// ListMembers returns a workspace's members sorted by username.
+	ListMembers(workspaceID int) ([]models.WorkspaceMember, error)
+	// SetMember adds a member or changes the role of an existing one.
+	SetMember(workspaceID, userID int, role string) error
+	// RemoveMember returns ErrNotFound if userID isn't a member.
+	RemoveMember(workspaceID, userID int) error
+	// Invite stores an invitation. It returns ErrConflict if the user is
+	// already invited to the workspace.
+	Invite(inv *models.WorkspaceInvitation) error
+	GetInvitation(id int) (*models.WorkspaceInvitation, error)
+	// ListInvitations returns the pending invitations to a workspace, oldest
+	// first.
+	ListInvitations(workspaceID int) ([]models.WorkspaceInvitation, error)
+	// ListInvitationsForUser returns the invitations userID hasn't answered
+	// yet, oldest first.
+	ListInvitationsForUser(userID int) ([]models.WorkspaceInvitation, error)
+	DeleteInvitation(id int) error
+}
+
+type PartnershipRepository interface {
+	// Create stores a pending partnership. It returns ErrConflict if the
+	// user already asked that partner.
+	Create(p *models.Partnership) error
+	Get(id int) (*models.Partnership, error)
+	// Find returns the partnership in which partnerID holds userID
+	// accountable, pending or not.
+	Find(userID, partnerID int) (*models.Partnership, error)
+	// ListByUser returns the partnerships userID is on either side of,
+	// oldest first.
+	ListByUser(userID int) ([]models.Partnership, error)
+	Accept(id int) error
+	Delete(id int) error
+}
+
+// DueReminder is a pending reminder together with the promise it is about.
+// Recipient and Description are empty for end-to-end encrypted promises.
+type DueReminder struct {
+	models.Reminder
+	Recipient   string
+	Description string
+	Encrypted   bool
+	WorkspaceID *int
+}
+
+type ReminderRepository interface {
+	// Create inserts a reminder, anchored to the due date and pending unless
+	// r.Anchor and r.Status say otherwise.
+	Create(r *models.Reminder) error
+	// Get returns a reminder owned by userID.
+	Get(id, userID int) (*models.Reminder, error)
+	ListByUser(userID int) ([]models.Reminder, error)
+	// ListByPromise returns a promise's reminders for every user.
+	ListByPromise(promiseID int) ([]models.Reminder, error)
+	// Update saves the time, anchor, offset and status of a reminder owned
+	// by r.UserID. An empty status means pending.
+	Update(r *models.Reminder) error
+	// Delete removes a reminder owned by userID. It returns ErrNotFound if
+	// there was nothing to delete.
+	Delete(id, userID int) error
+	// ListDue returns pending reminders about active promises whose time is
+	// at or before now.
+	ListDue(now time.Time) ([]DueReminder, error)
+	// SetStatus records whether a reminder was sent or failed.
+	SetStatus(id int, status string) error
+	// CancelPending cancels a promise's pending reminders.
+	CancelPending(promiseID int) error
+	// RestoreCancelled makes a promise's cancelled reminders whose time is
+	// after the given one pending again.
+	RestoreCancelled(promiseID int, after time.Time) error
+}
+
+type SubscriptionRepository interface {
+	// Upsert stores a subscription, replacing the keys of an existing one
+	// with the same user and endpoint.
+	Upsert(sub models.PushSubscription) error
+	ListByUser(userID int) ([]models.PushSubscription, error)
+	Delete(userID int, endpoint string) error
+	// DeleteByEndpoint removes an endpoint for every user, for subscriptions
+	// the push service reports as gone.
+	DeleteByEndpoint(endpoint string) error
+}
+
+// RefreshToken is a stored refresh token. Only the token hash is kept.
+type RefreshToken struct {
+	UserID    int
+	TokenHash string
+	ExpiresAt time.Time
+	TTLDays   int
+	Revoked   bool
+}
+
+type RefreshTokenRepository interface {
+	// Save stores a token hash, or refreshes the expiry of an existing one
+	// and clears its revoked flag.
+	Save(t RefreshToken) error
+	Get(tokenHash string) (*RefreshToken, error)
+	Revoke(tokenHash string) error
+}
+
+type PushActionRepository interface {
+	// Use records that the push action token with the given ID was used. It
+	// returns ErrConflict if it already was.
+	Use(jti string, expiresAt time.Time) error
+	// PurgeExpired forgets tokens that expired at or before the given time
+	// and returns how many there were.
+	PurgeExpired(before time.Time) (int, error)
+}
+
+// CalDAVObject records the resource name and UID a CalDAV client chose for a
+// promise it created.
+type CalDAVObject struct {
+	PromiseID int
+	UserID    int
+	UID       string
+	Name      string
+}
+
+type CalDAVObjectRepository interface {
+	// Create returns ErrConflict if the name or UID is already used.
+	Create(o CalDAVObject) error
+	GetByName(userID int, name string) (*CalDAVObject, error)
+	ListByUser(userID int) ([]CalDAVObject, error)
+}
@@ -0,0 +1,1435 @@
+package storetest
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"kept/internal/models"
+	"kept/internal/store"
+)
+
+// Run exercises every repository of the stores returned by open. Each
+// subtest gets a fresh store.
+func Run(t *testing.T, open func(testing.TB) store.Store) {
+	t.Run("Users", func(t *testing.T) { testUsers(t, open(t)) })
+	t.Run("Promises", func(t *testing.T) { testPromises(t, open(t)) })
+	t.Run("Trash", func(t *testing.T) { testTrash(t, open(t)) })
+	t.Run("Events", func(t *testing.T) { testEvents(t, open(t)) })
+	t.Run("Revisions", func(t *testing.T) { testRevisions(t, open(t)) })
+	t.Run("Items", func(t *testing.T) { testItems(t, open(t)) })
+	t.Run("Series", func(t *testing.T) { testSeries(t, open(t)) })
+	t.Run("Tags", func(t *testing.T) { testTags(t, open(t)) })
+	t.Run("Contacts", func(t *testing.T) { testContacts(t, open(t)) })
+	t.Run("Templates", func(t *testing.T) { testTemplates(t, open(t)) })
+	t.Run("Search", func(t *testing.T) { testSearch(t, open(t)) })
+	t.Run("ShareLinks", func(t *testing.T) { testShareLinks(t, open(t)) })
+	t.Run("Workspaces", func(t *testing.T) { testWorkspaces(t, open(t)) })
+	t.Run("Partnerships", func(t *testing.T) { testPartnerships(t, open(t)) })
+	t.Run("Reminders", func(t *testing.T) { testReminders(t, open(t)) })
+	t.Run("Subscriptions", func(t *testing.T) { testSubscriptions(t, open(t)) })
+	t.Run("RefreshTokens", func(t *testing.T) { testRefreshTokens(t, open(t)) })
+	t.Run("PushActions", func(t *testing.T) { testPushActions(t, open(t)) })
+	t.Run("AppPasswords", func(t *testing.T) { testAppPasswords(t, open(t)) })
+	t.Run("CalDAVObjects", func(t *testing.T) { testCalDAVObjects(t, open(t)) })
+	t.Run("Transactions", func(t *testing.T) { testTransactions(t, open(t)) })
+}
+
+func mustUser(t *testing.T, st store.Store, username string) int {
+	t.Helper()
+	id, err := st.Users().Create(username, "hash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+func mustPromise(t *testing.T, st store.Store, userID int, description string, due *time.Time) *models.Promise {
+	t.Helper()
+	p := &models.Promise{UserID: userID, Recipient: "Alex", Description: description, DueDate: due}
+	if err := st.Promises().Create(p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func testUsers(t *testing.T, st store.Store) {
+	id := mustUser(t, st, "alice")
+	if _, err := st.Users().Create("alice", "other"); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("Expected ErrConflict for duplicate username, got %v", err)
+	}
+
+	if err := st.Users().UpdateEmail(id, "alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	u, err := st.Users().GetByUsername("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != id || u.Email != "alice@example.com" || u.PasswordHash != "hash" {
+		t.Fatalf("Unexpected user: %+v", u)
+	}
+
+	if err := st.Users().UpdateEmail(id, ""); err != nil {
+		t.Fatal(err)
+	}
+	u, err = st.Users().GetByID(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Email != "" {
+		t.Fatalf("Expected email to be cleared, got %q", u.Email)
+	}
+
+	if _, err := st.Users().GetByID(id + 100); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	if _, err := st.Users().GetE2EKeyCheck(id); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected no key check yet, got %v", err)
+	}
+	check := &models.E2EKeyCheck{KDF: "PBKDF2-SHA256", Iterations: 1000, Salt: "c2FsdA==",
+		Check: models.Envelope{Alg: "AES-GCM-256", Nonce: "bm9uY2U=", Ciphertext: "Y2hlY2s="}}
+	if err := st.Users().SetE2EKeyCheck(id, check); err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.Users().GetE2EKeyCheck(id)
+	if err != nil || *got != *check {
+		t.Fatalf("Expected key check %+v, got %+v (%v)", check, got, err)
+	}
+	if err := st.Users().SetE2EKeyCheck(id, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Users().GetE2EKeyCheck(id); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected key check to be cleared, got %v", err)
+	}
+}
+
+func testPromises(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	otherID := mustUser(t, st, "bob")
+
+	past := time.Now().Add(-time.Hour)
+	future := time.Now().Add(time.Hour)
+	overdue := mustPromise(t, st, userID, "Overdue", &past)
+	upcoming := mustPromise(t, st, userID, "Upcoming", &future)
+	mustPromise(t, st, otherID, "Someone else's", nil)
+
+	got, err := st.Promises().Get(overdue.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.CurrentState != "active" || got.DueDate == nil || got.DueDate.Sub(past).Abs() > time.Second {
+		t.Fatalf("Unexpected promise: %+v", got)
+	}
+
+	ids, err := st.Promises().ListOverdueIDs(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 1 || ids[0] != overdue.ID {
+		t.Fatalf("Expected only promise %d to be overdue, got %v", overdue.ID, ids)
+	}
+
+	if err := st.Promises().SetState(upcoming.ID, upcoming.Version, "kept"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := st.Promises().ListByUser(userID, store.PromiseFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != upcoming.ID {
+		t.Fatalf("Expected kept promise first, got %+v", list)
+	}
+	if list[0].Version != 2 {
+		t.Fatalf("Expected SetState to bump the version to 2, got %d", list[0].Version)
+	}
+	if err := st.Promises().SetState(upcoming.ID, upcoming.Version, "broken"); !errors.Is(err, store.ErrStale) {
+		t.Fatalf("Expected ErrStale changing the state of an old version, got %v", err)
+	}
+	list, err = st.Promises().ListByUser(userID, store.PromiseFilter{State: "active"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != overdue.ID {
+		t.Fatalf("Expected only the active promise, got %+v", list)
+	}
+	list, err = st.Promises().ListByUser(userID, store.PromiseFilter{Text: "COMING"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != upcoming.ID {
+		t.Fatalf("Expected text filter to match only %q, got %+v", "Upcoming", list)
+	}
+	list, err = st.Promises().ListByUser(userID, store.PromiseFilter{Text: "%"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("Expected %% to match literally, got %+v", list)
+	}
+
+	overdue.Description = "Edited"
+	overdue.DueDate = nil
+	overdue.ReminderFrequency = "daily"
+	if err := st.Promises().Update(overdue); err != nil {
+		t.Fatal(err)
+	}
+	if overdue.Version != 2 {
+		t.Fatalf("Expected Update to bump the version to 2, got %d", overdue.Version)
+	}
+	stale := *overdue
+	stale.Version = 1
+	if err := st.Promises().Update(&stale); !errors.Is(err, store.ErrStale) {
+		t.Fatalf("Expected ErrStale updating an old version, got %v", err)
+	}
+	missing := models.Promise{ID: overdue.ID + 100, Version: 1}
+	if err := st.Promises().Update(&missing); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound updating a missing promise, got %v", err)
+	}
+	recurring, err := st.Promises().ListWithRecurringReminders()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recurring) != 1 || recurring[0].Description != "Edited" || recurring[0].DueDate != nil {
+		t.Fatalf("Unexpected recurring promises: %+v", recurring)
+	}
+
+	remindedAt := time.Now().Add(-time.Minute)
+	if err := st.Promises().MarkReminded(overdue.ID, remindedAt); err != nil {
+		t.Fatal(err)
+	}
+	got, err = st.Promises().MostRecentByUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != overdue.ID || got.LastRemindedAt == nil {
+		t.Fatalf("Unexpected most recent promise: %+v", got)
+	}
+
+	if err := st.Promises().Trash(overdue.ID, otherID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound trashing another user's promise, got %v", err)
+	}
+	if err := st.Promises().Trash(overdue.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Promises().Get(overdue.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound after trashing, got %v", err)
+	}
+}
+
+func testTrash(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	otherID := mustUser(t, st, "bob")
+	past := time.Now().Add(-time.Hour)
+	p := mustPromise(t, st, userID, "Trashed", &past)
+	p.ReminderFrequency = "daily"
+	if err := st.Promises().Update(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Events().Create(&models.Event{PromiseID: p.ID, State: "active"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Reminders().Create(&models.Reminder{PromiseID: p.ID, UserID: userID, RemindAt: past}); err != nil {
+		t.Fatal(err)
+	}
+	kept := mustPromise(t, st, userID, "Kept around", nil)
+
+	if err := st.Promises().Restore(p.ID, userID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound restoring a promise that isn't trashed, got %v", err)
+	}
+	if err := st.Promises().DeleteTrashed(p.ID, userID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound deleting a promise that isn't trashed, got %v", err)
+	}
+	if err := st.Promises().Trash(p.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Promises().Trash(p.ID, userID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound trashing twice, got %v", err)
+	}
+
+	// Trashed promises drop out of every other query
+	if err := st.Promises().SetState(p.ID, p.Version, "kept"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound changing a trashed promise, got %v", err)
+	}
+	list, err := st.Promises().ListByUser(userID, store.PromiseFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != kept.ID {
+		t.Fatalf("Expected only the untrashed promise, got %+v", list)
+	}
+	if recent, err := st.Promises().MostRecentByUser(userID); err != nil || recent.ID != kept.ID {
+		t.Fatalf("Expected most recent to skip the trash, got %+v (%v)", recent, err)
+	}
+	if ids, err := st.Promises().ListOverdueIDs(time.Now()); err != nil || len(ids) != 0 {
+		t.Fatalf("Expected no overdue promises, got %v (%v)", ids, err)
+	}
+	if recurring, err := st.Promises().ListWithRecurringReminders(); err != nil || len(recurring) != 0 {
+		t.Fatalf("Expected no recurring promises, got %+v (%v)", recurring, err)
+	}
+	if events, err := st.Events().ListByUser(userID); err != nil || len(events) != 0 {
+		t.Fatalf("Expected no events, got %+v (%v)", events, err)
+	}
+	if reminders, err := st.Reminders().ListByUser(userID); err != nil || len(reminders) != 0 {
+		t.Fatalf("Expected no reminders, got %+v (%v)", reminders, err)
+	}
+	if due, err := st.Reminders().ListDue(time.Now()); err != nil || len(due) != 0 {
+		t.Fatalf("Expected no due reminders, got %+v (%v)", due, err)
+	}
+
+	trash, err := st.Promises().ListTrash(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trash) != 1 || trash[0].ID != p.ID || trash[0].DeletedAt == nil {
+		t.Fatalf("Unexpected trash: %+v", trash)
+	}
+
+	// Restoring brings the promise back with its history
+	if err := st.Promises().Restore(p.ID, otherID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound restoring another user's promise, got %v", err)
+	}
+	if err := st.Promises().Restore(p.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.Promises().Get(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.DeletedAt != nil {
+		t.Fatalf("Expected restored promise to have no deleted_at, got %v", got.DeletedAt)
+	}
+	if events, err := st.Events().ListByUser(userID); err != nil || len(events) != 1 {
+		t.Fatalf("Expected the event to come back, got %+v (%v)", events, err)
+	}
+
+	// Purging only removes promises trashed before the cutoff
+	if err := st.Promises().Trash(p.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := st.Promises().PurgeTrash(time.Now().Add(-time.Hour)); err != nil || n != 0 {
+		t.Fatalf("Expected nothing to purge, got %d (%v)", n, err)
+	}
+	if n, err := st.Promises().PurgeTrash(time.Now().Add(time.Second)); err != nil || n != 1 {
+		t.Fatalf("Expected one purged promise, got %d (%v)", n, err)
+	}
+	if trash, err := st.Promises().ListTrash(userID); err != nil || len(trash) != 0 {
+		t.Fatalf("Expected an empty trash, got %+v (%v)", trash, err)
+	}
+
+	if err := st.Promises().Trash(kept.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Promises().DeleteTrashed(kept.ID, otherID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound deleting another user's promise, got %v", err)
+	}
+	if err := st.Promises().DeleteTrashed(kept.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Promises().Restore(kept.ID, userID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound restoring a deleted promise, got %v", err)
+	}
+}
+
+func testEvents(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	p := mustPromise(t, st, userID, "Call", nil)
+
+	for _, e := range []models.Event{
+		{PromiseID: p.ID, State: "active"},
+		{PromiseID: p.ID, State: "kept", ReflectionNote: "Done"},
+	} {
+		if err := st.Events().Create(&e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	events, err := st.Events().ListByPromise(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].State != "active" || events[1].ReflectionNote != "Done" {
+		t.Fatalf("Unexpected events: %+v", events)
+	}
+
+	events, err = st.Events().ListByUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].State != "kept" {
+		t.Fatalf("Expected newest event first, got %+v", events)
+	}
+}
+
+func testRevisions(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	p := mustPromise(t, st, userID, "Call", nil)
+
+	oldValue, newValue := "Call", "Call back"
+	edit := models.Revision{PromiseID: p.ID, Field: "description", OldValue: &oldValue, NewValue: &newValue, Actor: "user", ActorUserID: &userID}
+	if err := st.Revisions().Create(&edit); err != nil {
+		t.Fatal(err)
+	}
+	cleared := models.Revision{PromiseID: p.ID, Field: "due_date", OldValue: &oldValue, Actor: "caldav"}
+	if err := st.Revisions().Create(&cleared); err != nil {
+		t.Fatal(err)
+	}
+	if edit.ID == 0 || edit.CreatedAt.IsZero() {
+		t.Fatalf("Expected ID and timestamp to be set, got %+v", edit)
+	}
+
+	list, err := st.Revisions().ListByPromise(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != edit.ID || *list[0].NewValue != newValue || *list[0].ActorUserID != userID {
+		t.Fatalf("Unexpected revisions: %+v", list)
+	}
+	if list[1].NewValue != nil || list[1].ActorUserID != nil || list[1].Actor != "caldav" {
+		t.Fatalf("Expected a cleared value without an actor user, got %+v", list[1])
+	}
+}
+
+func testItems(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	p := mustPromise(t, st, userID, "Help Alex move", nil)
+	if p.Progress != nil {
+		t.Fatalf("Expected no progress without items, got %d", *p.Progress)
+	}
+	if err := st.Promises().Touch(p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := st.Promises().Get(p.ID); err != nil || got.Version != p.Version+1 {
+		t.Fatalf("Expected Touch to bump the version, got %+v (%v)", got, err)
+	}
+
+	due := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	var items []*models.PromiseItem
+	for _, title := range []string{"Rent a van", "Pack boxes", "Carry", "Return the van"} {
+		it := &models.PromiseItem{PromiseID: p.ID, Title: title}
+		if title == "Rent a van" {
+			it.DueDate = &due
+		}
+		if err := st.Items().Create(it); err != nil {
+			t.Fatal(err)
+		}
+		items = append(items, it)
+	}
+	if items[3].Position != 3 {
+		t.Fatalf("Expected items to be appended, got position %d", items[3].Position)
+	}
+
+	items[0].Done = true
+	if err := st.Items().Update(items[0]); err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.Items().Get(items[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Done || got.DoneAt == nil || !got.DueDate.Equal(due) {
+		t.Fatalf("Unexpected item: %+v", got)
+	}
+	if p, err = st.Promises().Get(p.ID); err != nil || p.Progress == nil || *p.Progress != 25 {
+		t.Fatalf("Expected 25%% progress, got %+v, %v", p, err)
+	}
+
+	// Moving shifts the items in between, deleting closes the gap
+	if err := st.Items().Move(items[3].ID, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Items().Move(items[1].ID, 99); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Items().Delete(items[2].ID); err != nil {
+		t.Fatal(err)
+	}
+	list, err := st.Items().ListByPromise(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var titles []string
+	for i, it := range list {
+		if it.Position != i {
+			t.Fatalf("Expected dense positions, got %+v", list)
+		}
+		titles = append(titles, it.Title)
+	}
+	if len(titles) != 3 || titles[0] != "Return the van" || titles[1] != "Rent a van" || titles[2] != "Pack boxes" {
+		t.Fatalf("Unexpected order: %v", titles)
+	}
+	if err := st.Items().Delete(items[2].ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound deleting twice, got %v", err)
+	}
+}
+
+func testSeries(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	due := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	series := &models.Series{UserID: userID, Frequency: models.FrequencyWeekly, Interval: 1, StartsAt: due}
+	if err := st.Series().Create(series); err != nil {
+		t.Fatal(err)
+	}
+	p := &models.Promise{UserID: userID, Recipient: "Grandma", Description: "Call", DueDate: &due, SeriesID: &series.ID}
+	if err := st.Promises().Create(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Series().SetCurrent(series.ID, p.ID); err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.Series().Get(series.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.CurrentPromiseID != p.ID || !got.StartsAt.Equal(due) || got.Interval != 1 {
+		t.Fatalf("Unexpected series: %+v", got)
+	}
+	if instances, err := st.Promises().ListByUser(userID, store.PromiseFilter{SeriesID: series.ID}); err != nil || len(instances) != 1 || *instances[0].SeriesID != series.ID {
+		t.Fatalf("Expected the instance, got %v, %v", instances, err)
+	}
+
+	// The current instance is due once it is resolved or its date passes
+	if due, err := st.Series().ListDue(time.Now()); err != nil || len(due) != 0 {
+		t.Fatalf("Expected nothing due yet, got %v, %v", due, err)
+	}
+	if due, err := st.Series().ListDue(due.Add(time.Minute)); err != nil || len(due) != 1 || due[0].ID != p.ID {
+		t.Fatalf("Expected the overdue instance, got %v, %v", due, err)
+	}
+	// Overdue instances are the series' to handle, not auto-keep's
+	if ids, err := st.Promises().ListOverdueIDs(due.Add(time.Minute)); err != nil || len(ids) != 0 {
+		t.Fatalf("Expected series instances not to be listed as overdue, got %v, %v", ids, err)
+	}
+	if err := st.Promises().SetState(p.ID, p.Version, "kept"); err != nil {
+		t.Fatal(err)
+	}
+	if due, err := st.Series().ListDue(time.Now()); err != nil || len(due) != 1 {
+		t.Fatalf("Expected the kept instance, got %v, %v", due, err)
+	}
+
+	got.Frequency, got.Interval = models.FrequencyMonthly, 2
+	if err := st.Series().Update(got); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Series().Stop(series.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Series().Stop(series.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound stopping twice, got %v", err)
+	}
+	if due, err := st.Series().ListDue(time.Now()); err != nil || len(due) != 0 {
+		t.Fatalf("Expected stopped series to be left alone, got %v, %v", due, err)
+	}
+	list, err := st.Series().ListByUser(userID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Frequency != models.FrequencyMonthly || list[0].Interval != 2 || list[0].StoppedAt == nil {
+		t.Fatalf("Unexpected series: %+v", list)
+	}
+}
+
+func testTags(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	otherID := mustUser(t, st, "bob")
+	work := models.Tag{UserID: userID, Name: "work", Color: "#ff0000"}
+	home := models.Tag{UserID: userID, Name: "home", Color: "#00ff00"}
+	for _, tag := range []*models.Tag{&work, &home} {
+		if err := st.Tags().Create(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Tags().Create(&models.Tag{UserID: userID, Name: "work", Color: "#000000"}); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("Expected ErrConflict for a duplicate name, got %v", err)
+	}
+	if err := st.Tags().Create(&models.Tag{UserID: otherID, Name: "work", Color: "#000000"}); err != nil {
+		t.Fatalf("Expected other users to reuse the name, got %v", err)
+	}
+
+	both := mustPromise(t, st, userID, "Both", nil)
+	workOnly := mustPromise(t, st, userID, "Work only", nil)
+	mustPromise(t, st, userID, "Untagged", nil)
+	if err := st.Tags().SetForPromise(both.ID, userID, []int{work.ID, home.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Tags().SetForPromise(workOnly.ID, userID, []int{home.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Tags().SetForPromise(workOnly.ID, userID, []int{work.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Promises().SetState(workOnly.ID, workOnly.Version, "kept"); err != nil {
+		t.Fatal(err)
+	}
+
+	assignments, err := st.Tags().ListAssignments(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(assignments) != 2 || len(assignments[both.ID]) != 2 || assignments[both.ID][0] != home.ID || len(assignments[workOnly.ID]) != 1 {
+		t.Fatalf("Unexpected assignments: %v", assignments)
+	}
+
+	tags, err := st.Tags().ListByUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 2 || tags[0].Name != "home" || tags[1].Name != "work" {
+		t.Fatalf("Expected tags sorted by name, got %+v", tags)
+	}
+	if counts := tags[1].Counts; counts["active"] != 1 || counts["kept"] != 1 || counts["broken"] != 0 {
+		t.Fatalf("Unexpected work counts: %v", counts)
+	}
+
+	for _, tc := range []struct {
+		filter store.PromiseFilter
+		want   int
+	}{
+		{store.PromiseFilter{TagIDs: []int{work.ID}}, 2},
+		{store.PromiseFilter{TagIDs: []int{work.ID, home.ID}}, 2},
+		{store.PromiseFilter{TagIDs: []int{work.ID, home.ID}, AllTags: true}, 1},
+		{store.PromiseFilter{TagIDs: []int{home.ID}, State: "kept"}, 0},
+	} {
+		list, err := st.Promises().ListByUser(userID, tc.filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) != tc.want {
+			t.Errorf("Filter %+v: expected %d promises, got %d", tc.filter, tc.want, len(list))
+		}
+	}
+
+	work.Name = "office"
+	if err := st.Tags().Update(&work); err != nil {
+		t.Fatal(err)
+	}
+	work.Name = "home"
+	if err := st.Tags().Update(&work); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("Expected ErrConflict renaming to a taken name, got %v", err)
+	}
+	stolen := models.Tag{ID: home.ID, UserID: otherID, Name: "mine", Color: "#000000"}
+	if err := st.Tags().Update(&stolen); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound updating another user's tag, got %v", err)
+	}
+	if err := st.Tags().Delete(home.ID, otherID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound deleting another user's tag, got %v", err)
+	}
+	if err := st.Tags().Delete(home.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	assignments, _ = st.Tags().ListAssignments(userID)
+	if len(assignments[both.ID]) != 1 {
+		t.Fatalf("Expected deleted tag to be unassigned, got %v", assignments)
+	}
+}
+
+func testTemplates(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	otherID := mustUser(t, st, "bob")
+	week := 7 * 24 * 60
+	review := models.Template{UserID: userID, Name: "review", Recipient: "Team", Description: "Review {{pr}}", DueOffsetMinutes: &week, ReminderFrequency: "daily", ReminderOffsets: []int{60, 1440}}
+	call := models.Template{UserID: userID, Name: "Call", Recipient: "Mom", Description: "Call Mom"}
+	for _, template := range []*models.Template{&review, &call} {
+		if err := st.Templates().Create(template); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := st.Templates().Get(review.ID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Description != "Review {{pr}}" || *got.DueOffsetMinutes != week || got.ReminderFrequency != "daily" || len(got.ReminderOffsets) != 2 || got.ReminderOffsets[1] != 1440 {
+		t.Fatalf("Unexpected template: %+v", got)
+	}
+	if _, err := st.Templates().Get(review.ID, otherID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for another user's template, got %v", err)
+	}
+	templates, err := st.Templates().ListByUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(templates) != 2 || templates[0].Name != "Call" || templates[0].DueOffsetMinutes != nil || len(templates[0].ReminderOffsets) != 0 {
+		t.Fatalf("Expected templates sorted by name ignoring case, got %+v", templates)
+	}
+
+	review.DueOffsetMinutes = nil
+	review.ReminderOffsets = nil
+	if err := st.Templates().Update(&review); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Templates().Get(review.ID, userID); got.DueOffsetMinutes != nil || len(got.ReminderOffsets) != 0 {
+		t.Fatalf("Expected update to be saved, got %+v", got)
+	}
+	stolen := models.Template{ID: review.ID, UserID: otherID, Name: "mine"}
+	if err := st.Templates().Update(&stolen); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound updating another user's template, got %v", err)
+	}
+	if err := st.Templates().Delete(review.ID, otherID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound deleting another user's template, got %v", err)
+	}
+	if err := st.Templates().Delete(review.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Templates().Get(review.ID, userID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound after delete, got %v", err)
+	}
+}
+
+func testSearch(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	otherID := mustUser(t, st, "bob")
+	club := &models.Promise{UserID: userID, Recipient: "Garden club", Description: "Bring folding chairs"}
+	hose := &models.Promise{UserID: userID, Recipient: "Alex", Description: "Return the <garden> hose"}
+	secret := &models.Promise{UserID: userID, Recipient: "garden", Description: "garden", Encrypted: true, Envelope: &models.Envelope{Alg: "AES-GCM", Nonce: "n", Ciphertext: "c"}}
+	others := &models.Promise{UserID: otherID, Recipient: "Sam", Description: "Water the garden"}
+	for _, p := range []*models.Promise{club, hose, secret, others} {
+		if err := st.Promises().Create(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	note := &models.Event{PromiseID: hose.ID, State: "kept", ReflectionNote: "Alex gardens every weekend"}
+	if err := st.Events().Create(note); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Promises().SetState(hose.ID, hose.Version, "kept"); err != nil {
+		t.Fatal(err)
+	}
+
+	search := func(q store.SearchQuery) []models.SearchHit {
+		t.Helper()
+		hits, err := st.Search().Search(userID, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hits
+	}
+
+	// Words match as prefixes; recipients weigh more than descriptions
+	hits := search(store.SearchQuery{Text: "GARD"})
+	if len(hits) != 3 || hits[0].PromiseID != club.ID || hits[0].Kind != models.SearchHitPromise {
+		t.Fatalf("Expected the club, the hose and the note, club first, got %+v", hits)
+	}
+	var noteHit *models.SearchHit
+	for i, hit := range hits {
+		if hit.Kind == models.SearchHitNote {
+			noteHit = &hits[i]
+		}
+		if hit.PromiseID == hose.ID && hit.Kind == models.SearchHitPromise && !strings.Contains(hit.Snippet, "&lt;<mark>garden</mark>&gt;") {
+			t.Fatalf("Expected an escaped snippet with the match marked, got %q", hit.Snippet)
+		}
+	}
+	if noteHit == nil || noteHit.PromiseID != hose.ID || noteHit.EventID == nil || *noteHit.EventID != note.ID ||
+		!strings.Contains(noteHit.Snippet, "<mark>gardens</mark>") {
+		t.Fatalf("Expected the reflection note to match, got %+v", noteHit)
+	}
+
+	// Every word must match
+	if hits := search(store.SearchQuery{Text: "garden chairs"}); len(hits) != 1 || hits[0].PromiseID != club.ID {
+		t.Fatalf("Expected only the club to match both words, got %+v", hits)
+	}
+	if hits := search(store.SearchQuery{Text: "garden piano"}); len(hits) != 0 {
+		t.Fatalf("Expected no hits, got %+v", hits)
+	}
+	if hits := search(store.SearchQuery{Text: "  \"*"}); len(hits) != 0 {
+		t.Fatalf("Expected no hits without words, got %+v", hits)
+	}
+
+	// Filters
+	if hits := search(store.SearchQuery{Text: "garden", State: "kept"}); len(hits) != 2 || hits[0].PromiseID != hose.ID || hits[1].PromiseID != hose.ID {
+		t.Fatalf("Expected the kept promise and its note, got %+v", hits)
+	}
+	future := time.Now().Add(time.Hour)
+	if hits := search(store.SearchQuery{Text: "garden", From: &future}); len(hits) != 0 {
+		t.Fatalf("Expected no hits from the future, got %+v", hits)
+	}
+	past := time.Now().Add(-time.Hour)
+	if hits := search(store.SearchQuery{Text: "garden", From: &past, To: &future, Limit: 1}); len(hits) != 1 || hits[0].PromiseID != club.ID {
+		t.Fatalf("Expected the limit to keep the best hit, got %+v", hits)
+	}
+
+	// Edits are searchable, trashed promises aren't
+	club.Description = "Bring the barbecue"
+	if err := st.Promises().Update(club); err != nil {
+		t.Fatal(err)
+	}
+	if hits := search(store.SearchQuery{Text: "barbecue"}); len(hits) != 1 || hits[0].PromiseID != club.ID {
+		t.Fatalf("Expected the edited description to match, got %+v", hits)
+	}
+	if hits := search(store.SearchQuery{Text: "chairs"}); len(hits) != 0 {
+		t.Fatalf("Expected the old description not to match, got %+v", hits)
+	}
+	if err := st.Promises().Trash(hose.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if hits := search(store.SearchQuery{Text: "garden"}); len(hits) != 1 || hits[0].PromiseID != club.ID {
+		t.Fatalf("Expected trashed promises and their notes to be left out, got %+v", hits)
+	}
+
+	// Workspaces are searched separately
+	ws := &models.Workspace{Name: "Home"}
+	if err := st.Workspaces().Create(ws, userID); err != nil {
+		t.Fatal(err)
+	}
+	shed := &models.Promise{UserID: otherID, WorkspaceID: &ws.ID, Recipient: "Family", Description: "Paint the garden shed"}
+	if err := st.Promises().Create(shed); err != nil {
+		t.Fatal(err)
+	}
+	if hits := search(store.SearchQuery{Text: "shed"}); len(hits) != 0 {
+		t.Fatalf("Expected workspace promises to be left out of personal search, got %+v", hits)
+	}
+	if hits := search(store.SearchQuery{Text: "shed", WorkspaceID: ws.ID}); len(hits) != 1 || hits[0].PromiseID != shed.ID {
+		t.Fatalf("Expected the workspace promise, got %+v", hits)
+	}
+}
+
+func testContacts(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	otherID := mustUser(t, st, "bob")
+	sam := models.Contact{UserID: userID, Name: "sam", Aliases: []string{"Samuel"}, Email: "sam@example.com"}
+	alex := models.Contact{UserID: userID, Name: "Alex"}
+	for _, contact := range []*models.Contact{&sam, &alex} {
+		if err := st.Contacts().Create(contact); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err := st.Contacts().Get(sam.ID, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "sam" || len(got.Aliases) != 1 || got.Aliases[0] != "Samuel" || got.Email != "sam@example.com" || got.Phone != "" {
+		t.Fatalf("Unexpected contact: %+v", got)
+	}
+	if _, err := st.Contacts().Get(sam.ID, otherID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for another user's contact, got %v", err)
+	}
+	contacts, err := st.Contacts().ListByUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(contacts) != 2 || contacts[0].Name != "Alex" || len(contacts[0].Aliases) != 0 {
+		t.Fatalf("Expected contacts sorted by name ignoring case, got %+v", contacts)
+	}
+
+	sam.Phone = "555-0100"
+	sam.Aliases = nil
+	if err := st.Contacts().Update(&sam); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := st.Contacts().Get(sam.ID, userID); got.Phone != "555-0100" || len(got.Aliases) != 0 {
+		t.Fatalf("Expected update to be saved, got %+v", got)
+	}
+	stolen := models.Contact{ID: sam.ID, UserID: otherID, Name: "mine"}
+	if err := st.Contacts().Update(&stolen); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound updating another user's contact, got %v", err)
+	}
+
+	linked := &models.Promise{UserID: userID, Recipient: "Sam", Description: "Linked", ContactID: &sam.ID}
+	if err := st.Promises().Create(linked); err != nil {
+		t.Fatal(err)
+	}
+	unlinked := mustPromise(t, st, userID, "Unlinked", nil)
+	if p, _ := st.Promises().Get(linked.ID); p.ContactID == nil || *p.ContactID != sam.ID {
+		t.Fatalf("Expected promise linked to contact %d, got %v", sam.ID, p.ContactID)
+	}
+	list, err := st.Promises().ListByUser(userID, store.PromiseFilter{ContactID: sam.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != linked.ID {
+		t.Fatalf("Expected only the linked promise, got %+v", list)
+	}
+
+	pending, err := st.Contacts().ListUnlinked()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != unlinked.ID || pending[0].Recipient != "Alex" {
+		t.Fatalf("Expected the unlinked promise, got %+v", pending)
+	}
+	if err := st.Contacts().Link(unlinked.ID, alex.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Contacts().Link(unlinked.ID, sam.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound linking a linked promise, got %v", err)
+	}
+
+	if err := st.Contacts().MovePromises(alex.ID, sam.ID); err != nil {
+		t.Fatal(err)
+	}
+	moved, _ := st.Promises().Get(unlinked.ID)
+	if moved.ContactID == nil || *moved.ContactID != sam.ID || moved.Version != unlinked.Version+2 {
+		t.Fatalf("Expected promise moved to contact %d with a new version, got %+v", sam.ID, moved)
+	}
+
+	if err := st.Contacts().Delete(sam.ID, otherID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound deleting another user's contact, got %v", err)
+	}
+	if err := st.Contacts().Delete(sam.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if p, _ := st.Promises().Get(linked.ID); p.ContactID != nil {
+		t.Fatalf("Expected deleted contact to be unlinked, got %v", *p.ContactID)
+	}
+}
+
+func testShareLinks(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	otherID := mustUser(t, st, "bob")
+	p := mustPromise(t, st, userID, "Shared", nil)
+	other := mustPromise(t, st, otherID, "Other", nil)
+	expires := time.Now().Add(time.Hour)
+
+	open := &models.ShareLink{PromiseID: p.ID, UserID: userID}
+	redacted := &models.ShareLink{PromiseID: p.ID, UserID: userID, Redact: []string{"reflection_notes"}, ExpiresAt: &expires}
+	if err := st.ShareLinks().Create(open, "hash-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ShareLinks().Create(redacted, "hash-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ShareLinks().Create(&models.ShareLink{PromiseID: p.ID, UserID: userID}, "hash-1"); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("Expected ErrConflict for a reused token, got %v", err)
+	}
+
+	got, err := st.ShareLinks().GetByTokenHash("hash-2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID != redacted.ID || len(got.Redact) != 1 || got.Redact[0] != "reflection_notes" || got.ExpiresAt == nil || got.RevokedAt != nil {
+		t.Fatalf("Unexpected link: %+v", got)
+	}
+	if _, err := st.ShareLinks().GetByTokenHash("missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := st.ShareLinks().Revoke(open.ID, other.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound revoking through another promise, got %v", err)
+	}
+	if err := st.ShareLinks().Revoke(open.ID, p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.ShareLinks().Revoke(open.ID, p.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound revoking twice, got %v", err)
+	}
+	links, err := st.ShareLinks().ListByPromise(p.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(links) != 2 || links[0].ID != redacted.ID || links[1].RevokedAt == nil || len(links[1].Redact) != 0 {
+		t.Fatalf("Unexpected links: %+v", links)
+	}
+}
+
+func testWorkspaces(t *testing.T, st store.Store) {
+	aliceID := mustUser(t, st, "alice")
+	bobID := mustUser(t, st, "bob")
+	carolID := mustUser(t, st, "carol")
+
+	ws := &models.Workspace{Name: "Home"}
+	if err := st.Workspaces().Create(ws, aliceID); err != nil {
+		t.Fatal(err)
+	}
+	if ws.Role != models.RoleOwner {
+		t.Fatalf("Expected the creator to own the workspace, got %q", ws.Role)
+	}
+	if _, err := st.Workspaces().Get(ws.ID, bobID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for a non-member, got %v", err)
+	}
+
+	inv := &models.WorkspaceInvitation{WorkspaceID: ws.ID, UserID: bobID, Role: models.RoleEditor, InvitedBy: &aliceID}
+	if err := st.Workspaces().Invite(inv); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Workspaces().Invite(&models.WorkspaceInvitation{WorkspaceID: ws.ID, UserID: bobID, Role: models.RoleViewer}); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("Expected ErrConflict for a second invitation, got %v", err)
+	}
+	invitations, err := st.Workspaces().ListInvitationsForUser(bobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invitations) != 1 || invitations[0].WorkspaceName != "Home" || invitations[0].Username != "bob" || *invitations[0].InvitedBy != aliceID {
+		t.Fatalf("Unexpected invitations: %+v", invitations)
+	}
+	if err := st.Workspaces().SetMember(ws.ID, bobID, inv.Role); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Workspaces().DeleteInvitation(inv.ID); err != nil {
+		t.Fatal(err)
+	}
+	if invitations, err := st.Workspaces().ListInvitations(ws.ID); err != nil || len(invitations) != 0 {
+		t.Fatalf("Expected no pending invitations, got %v, %v", invitations, err)
+	}
+	if err := st.Workspaces().SetMember(ws.ID, carolID, models.RoleEditor); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Workspaces().SetMember(ws.ID, carolID, models.RoleViewer); err != nil {
+		t.Fatal(err)
+	}
+	list, err := st.Workspaces().ListByUser(carolID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].ID != ws.ID || list[0].Role != models.RoleViewer {
+		t.Fatalf("Unexpected workspaces: %+v", list)
+	}
+	members, err := st.Workspaces().ListMembers(ws.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 3 || members[0].Username != "alice" || members[1].Role != models.RoleEditor || members[2].Role != models.RoleViewer {
+		t.Fatalf("Unexpected members: %+v", members)
+	}
+
+	// Workspace promises are listed for the workspace, not their creator
+	personal := mustPromise(t, st, aliceID, "Personal", nil)
+	shared := &models.Promise{UserID: aliceID, WorkspaceID: &ws.ID, Recipient: "Kids", Description: "Zoo trip"}
+	if err := st.Promises().Create(shared); err != nil {
+		t.Fatal(err)
+	}
+	promises, err := st.Promises().ListByUser(aliceID, store.PromiseFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(promises) != 1 || promises[0].ID != personal.ID {
+		t.Fatalf("Expected only the personal promise, got %+v", promises)
+	}
+	promises, err = st.Promises().ListByUser(carolID, store.PromiseFilter{WorkspaceID: ws.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(promises) != 1 || promises[0].ID != shared.ID || *promises[0].WorkspaceID != ws.ID {
+		t.Fatalf("Expected the workspace promise, got %+v", promises)
+	}
+	if err := st.Events().Create(&models.Event{PromiseID: shared.ID, State: "active"}); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := st.Events().ListByWorkspace(ws.ID); err != nil || len(events) != 1 {
+		t.Fatalf("Expected the workspace promise's event, got %v, %v", events, err)
+	}
+
+	// Members keep their own tags on a shared promise
+	aliceTag := models.Tag{UserID: aliceID, Name: "family", Color: "#ff0000"}
+	bobTag := models.Tag{UserID: bobID, Name: "weekend", Color: "#00ff00"}
+	for _, tag := range []*models.Tag{&aliceTag, &bobTag} {
+		if err := st.Tags().Create(tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := st.Tags().SetForPromise(shared.ID, aliceID, []int{aliceTag.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Tags().SetForPromise(shared.ID, bobID, []int{bobTag.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if assignments, err := st.Tags().ListAssignments(aliceID); err != nil || len(assignments[shared.ID]) != 1 {
+		t.Fatalf("Expected alice's tag to stay, got %v, %v", assignments, err)
+	}
+
+	// Editors can trash and restore workspace promises, viewers can't
+	if err := st.Promises().Trash(shared.ID, carolID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound trashing as a viewer, got %v", err)
+	}
+	if err := st.Promises().Trash(shared.ID, bobID); err != nil {
+		t.Fatal(err)
+	}
+	if trash, err := st.Promises().ListTrash(bobID); err != nil || len(trash) != 1 || trash[0].ID != shared.ID {
+		t.Fatalf("Expected the workspace promise in bob's trash, got %v, %v", trash, err)
+	}
+	if err := st.Promises().Restore(shared.ID, bobID); err != nil {
+		t.Fatal(err)
+	}
+
+	reminder := &models.Reminder{PromiseID: shared.ID, UserID: bobID, RemindAt: time.Now().Add(-time.Minute)}
+	if err := st.Reminders().Create(reminder); err != nil {
+		t.Fatal(err)
+	}
+	due, err := st.Reminders().ListDue(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(due) != 1 || due[0].WorkspaceID == nil || *due[0].WorkspaceID != ws.ID || due[0].Description != "Zoo trip" {
+		t.Fatalf("Unexpected due reminders: %+v", due)
+	}
+
+	if err := st.Workspaces().RemoveMember(ws.ID, carolID); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Workspaces().RemoveMember(ws.ID, carolID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound removing twice, got %v", err)
+	}
+	if err := st.Workspaces().Rename(ws.ID, "House"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := st.Workspaces().Get(ws.ID, bobID); err != nil || got.Name != "House" || got.Role != models.RoleEditor {
+		t.Fatalf("Unexpected workspace: %+v, %v", got, err)
+	}
+
+	// Deleting the workspace deletes its promises
+	if err := st.Workspaces().Delete(ws.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := st.Promises().Get(shared.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected the workspace promise to be gone, got %v", err)
+	}
+	if _, err := st.Promises().Get(personal.ID); err != nil {
+		t.Fatalf("Expected the personal promise to stay, got %v", err)
+	}
+	if list, err := st.Workspaces().ListByUser(aliceID); err != nil || len(list) != 0 {
+		t.Fatalf("Expected no workspaces, got %v, %v", list, err)
+	}
+}
+
+func testPartnerships(t *testing.T, st store.Store) {
+	aliceID := mustUser(t, st, "alice")
+	bobID := mustUser(t, st, "bob")
+
+	p := &models.Partnership{UserID: aliceID, PartnerID: bobID}
+	if err := st.Partnerships().Create(p); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Partnerships().Create(&models.Partnership{UserID: aliceID, PartnerID: bobID}); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("Expected ErrConflict asking twice, got %v", err)
+	}
+	if _, err := st.Partnerships().Find(bobID, aliceID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected partnerships to be one-way, got %v", err)
+	}
+	if err := st.Partnerships().Accept(p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Partnerships().Accept(p.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound accepting twice, got %v", err)
+	}
+	got, err := st.Partnerships().Find(aliceID, bobID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.AcceptedAt == nil || got.Username != "alice" || got.PartnerUsername != "bob" {
+		t.Fatalf("Unexpected partnership: %+v", got)
+	}
+	if list, err := st.Partnerships().ListByUser(bobID); err != nil || len(list) != 1 || list[0].ID != p.ID {
+		t.Fatalf("Expected the partnership on bob's side, got %v, %v", list, err)
+	}
+
+	// Partners see only the promises shown to them
+	mustPromise(t, st, aliceID, "Private", nil)
+	shown := &models.Promise{UserID: aliceID, Recipient: "Gym", Description: "Train", PartnerVisible: true}
+	if err := st.Promises().Create(shown); err != nil {
+		t.Fatal(err)
+	}
+	promises, err := st.Promises().ListByUser(aliceID, store.PromiseFilter{PartnerVisible: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(promises) != 1 || promises[0].ID != shown.ID || !promises[0].PartnerVisible {
+		t.Fatalf("Expected the promise shown to partners, got %+v", promises)
+	}
+
+	nudge := models.Event{PromiseID: shown.ID, State: "nudged", ActorUserID: &bobID}
+	if err := st.Events().Create(&nudge); err != nil {
+		t.Fatal(err)
+	}
+	if events, err := st.Events().ListByPromise(shown.ID); err != nil || len(events) != 1 || events[0].ActorUserID == nil || *events[0].ActorUserID != bobID {
+		t.Fatalf("Expected the nudge with its actor, got %v, %v", events, err)
+	}
+
+	if err := st.Partnerships().Delete(p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := st.Partnerships().ListByUser(aliceID); err != nil || len(list) != 0 {
+		t.Fatalf("Expected no partnerships, got %v, %v", list, err)
+	}
+}
+
+func testReminders(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	otherID := mustUser(t, st, "bob")
+	p := mustPromise(t, st, userID, "Call", nil)
+
+	due := &models.Reminder{PromiseID: p.ID, UserID: userID, RemindAt: time.Now().Add(-time.Minute), OffsetMinutes: 60}
+	later := &models.Reminder{PromiseID: p.ID, UserID: userID, RemindAt: time.Now().Add(time.Hour), OffsetMinutes: 10}
+	for _, r := range []*models.Reminder{due, later} {
+		if err := st.Reminders().Create(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	pending, err := st.Reminders().ListDue(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != due.ID || pending[0].Description != "Call" {
+		t.Fatalf("Unexpected due reminders: %+v", pending)
+	}
+
+	if err := st.Reminders().SetStatus(due.ID, models.ReminderSent); err != nil {
+		t.Fatal(err)
+	}
+	pending, err = st.Reminders().ListDue(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 0 {
+		t.Fatalf("Expected no due reminders after sending, got %+v", pending)
+	}
+
+	list, err := st.Reminders().ListByUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].ID != due.ID || list[0].Status != models.ReminderSent || list[1].Status != models.ReminderPending || list[0].Anchor != models.AnchorDue {
+		t.Fatalf("Unexpected reminders: %+v", list)
+	}
+
+	// Updating a reminder re-arms it
+	due.Anchor, due.RemindAt = models.AnchorAbsolute, time.Now().Add(-time.Second).Truncate(time.Second)
+	if err := st.Reminders().Update(due); err != nil {
+		t.Fatal(err)
+	}
+	got, err := st.Reminders().Get(due.ID, userID)
+	if err != nil || got.Anchor != models.AnchorAbsolute || got.Status != models.ReminderPending || !got.RemindAt.Equal(due.RemindAt) {
+		t.Fatalf("Expected the update to be saved, got %+v (%v)", got, err)
+	}
+	if _, err := st.Reminders().Get(due.ID, otherID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound for another user's reminder, got %v", err)
+	}
+	stolen := *due
+	stolen.UserID = otherID
+	if err := st.Reminders().Update(&stolen); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound updating another user's reminder, got %v", err)
+	}
+	if pending, err := st.Reminders().ListDue(time.Now()); err != nil || len(pending) != 1 || pending[0].Anchor != models.AnchorAbsolute {
+		t.Fatalf("Expected the re-armed reminder to be due, got %+v (%v)", pending, err)
+	}
+
+	// Resolved promises have no due reminders, even before they are cancelled
+	if err := st.Promises().SetState(p.ID, p.Version, "kept"); err != nil {
+		t.Fatal(err)
+	}
+	if pending, err := st.Reminders().ListDue(time.Now()); err != nil || len(pending) != 0 {
+		t.Fatalf("Expected no due reminders for a kept promise, got %+v (%v)", pending, err)
+	}
+	if err := st.Reminders().CancelPending(p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := st.Reminders().ListByPromise(p.ID); err != nil || len(list) != 2 ||
+		list[0].Status != models.ReminderCancelled || list[1].Status != models.ReminderCancelled {
+		t.Fatalf("Expected both reminders to be cancelled, got %+v (%v)", list, err)
+	}
+	// Only reminders still ahead come back
+	if err := st.Reminders().RestoreCancelled(p.ID, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if list, err := st.Reminders().ListByPromise(p.ID); err != nil || len(list) != 2 ||
+		list[0].Status != models.ReminderCancelled || list[1].ID != later.ID || list[1].Status != models.ReminderPending {
+		t.Fatalf("Expected the later reminder to be restored, got %+v (%v)", list, err)
+	}
+
+	if err := st.Reminders().Delete(later.ID, otherID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound deleting another user's reminder, got %v", err)
+	}
+	if err := st.Reminders().Delete(later.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testSubscriptions(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	otherID := mustUser(t, st, "bob")
+
+	subs := []models.PushSubscription{
+		{UserID: userID, Endpoint: "https://push.example/1", P256dh: "a", Auth: "a"},
+		{UserID: userID, Endpoint: "https://push.example/1", P256dh: "b", Auth: "b"},
+		{UserID: otherID, Endpoint: "https://push.example/1", P256dh: "c", Auth: "c"},
+	}
+	for _, sub := range subs {
+		if err := st.Subscriptions().Upsert(sub); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list, err := st.Subscriptions().ListByUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].P256dh != "b" {
+		t.Fatalf("Expected the subscription to be replaced, got %+v", list)
+	}
+
+	if err := st.Subscriptions().Delete(userID, "https://push.example/1"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := st.Subscriptions().ListByUser(otherID); len(list) != 1 {
+		t.Fatalf("Expected other user's subscription to remain, got %+v", list)
+	}
+	if err := st.Subscriptions().DeleteByEndpoint("https://push.example/1"); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ := st.Subscriptions().ListByUser(otherID); len(list) != 0 {
+		t.Fatalf("Expected subscription to be removed, got %+v", list)
+	}
+}
+
+func testRefreshTokens(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	expires := time.Now().Add(24 * time.Hour)
+
+	if err := st.RefreshTokens().Save(store.RefreshToken{UserID: userID, TokenHash: "h1", ExpiresAt: expires, TTLDays: 1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.RefreshTokens().Revoke("h1"); err != nil {
+		t.Fatal(err)
+	}
+	tok, err := st.RefreshTokens().Get("h1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !tok.Revoked || tok.UserID != userID || tok.TTLDays != 1 {
+		t.Fatalf("Unexpected token: %+v", tok)
+	}
+
+	// Saving again refreshes the token and clears the revoked flag
+	if err := st.RefreshTokens().Save(store.RefreshToken{UserID: userID, TokenHash: "h1", ExpiresAt: expires, TTLDays: 30}); err != nil {
+		t.Fatal(err)
+	}
+	tok, err = st.RefreshTokens().Get("h1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.Revoked || tok.TTLDays != 30 || tok.ExpiresAt.Sub(expires).Abs() > time.Second {
+		t.Fatalf("Unexpected token after save: %+v", tok)
+	}
+
+	if _, err := st.RefreshTokens().Get("missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func testPushActions(t *testing.T, st store.Store) {
+	now := time.Now()
+	if err := st.PushActions().Use("a", now.Add(-time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.PushActions().Use("b", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.PushActions().Use("b", now.Add(time.Hour)); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("Expected ErrConflict, got %v", err)
+	}
+
+	if n, err := st.PushActions().PurgeExpired(now); err != nil || n != 1 {
+		t.Fatalf("Expected one expired action purged, got %d (%v)", n, err)
+	}
+	// Purged IDs are free again, but live ones stay used
+	if err := st.PushActions().Use("a", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.PushActions().Use("b", now.Add(time.Hour)); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("Expected ErrConflict, got %v", err)
+	}
+}
+
+func testAppPasswords(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	otherID := mustUser(t, st, "bob")
+
+	phone := &models.AppPassword{UserID: userID, Name: "Phone"}
+	if err := st.AppPasswords().Create(phone, "hash-1"); err != nil {
+		t.Fatal(err)
+	}
+	laptop := &models.AppPassword{UserID: userID, Name: "Laptop"}
+	if err := st.AppPasswords().Create(laptop, "hash-2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.AppPasswords().Create(&models.AppPassword{UserID: otherID, Name: "Phone"}, "hash-1"); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("Expected ErrConflict for a reused password, got %v", err)
+	}
+
+	got, err := st.AppPasswords().GetByTokenHash("hash-1")
+	if err != nil || got.ID != phone.ID || got.UserID != userID || got.Name != "Phone" {
+		t.Fatalf("Unexpected app password: %+v (%v)", got, err)
+	}
+	if _, err := st.AppPasswords().GetByTokenHash("missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := st.AppPasswords().Delete(phone.ID, otherID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("Expected ErrNotFound deleting another user's app password, got %v", err)
+	}
+	if err := st.AppPasswords().Delete(phone.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	passwords, err := st.AppPasswords().ListByUser(userID)
+	if err != nil || len(passwords) != 1 || passwords[0].ID != laptop.ID {
+		t.Fatalf("Expected only the laptop's app password, got %+v (%v)", passwords, err)
+	}
+}
+
+func testCalDAVObjects(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	first := mustPromise(t, st, userID, "First", nil)
+	second := mustPromise(t, st, userID, "Second", nil)
+
+	obj := store.CalDAVObject{PromiseID: first.ID, UserID: userID, UID: "abc", Name: "abc.ics"}
+	if err := st.CalDAVObjects().Create(obj); err != nil {
+		t.Fatal(err)
+	}
+	dup := store.CalDAVObject{PromiseID: second.ID, UserID: userID, UID: "abc", Name: "other.ics"}
+	if err := st.CalDAVObjects().Create(dup); !errors.Is(err, store.ErrConflict) {
+		t.Fatalf("Expected ErrConflict for duplicate UID, got %v", err)
+	}
+
+	got, err := st.CalDAVObjects().GetByName(userID, "abc.ics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got != obj {
+		t.Fatalf("Unexpected object: %+v", got)
+	}
+
+	// Objects go away with their promise
+	if err := st.Promises().Trash(first.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.Promises().DeleteTrashed(first.ID, userID); err != nil {
+		t.Fatal(err)
+	}
+	list, err := st.CalDAVObjects().ListByUser(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 0 {
+		t.Fatalf("Expected objects to be deleted with the promise, got %+v", list)
+	}
+}
+
+func testTransactions(t *testing.T, st store.Store) {
+	userID := mustUser(t, st, "alice")
+	rollback := errors.New("rollback")
+
+	err := st.InTx(func(tx store.Store) error {
+		mustPromise(t, tx, userID, "Discarded", nil)
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("Expected the callback's error, got %v", err)
+	}
+
+	err = st.InTx(func(tx store.Store) error {
+		p := mustPromise(t, tx, userID, "Committed", nil)
+		return tx.Events().Create(&models.Event{PromiseID: p.ID, State: "active"})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := st.Promises().ListByUser(userID, store.PromiseFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Description != "Committed" {
+		t.Fatalf("Expected only the committed promise, got %+v", list)
+	}
+}
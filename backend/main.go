@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"log"
 	"os"
 	"strings"
@@ -8,22 +10,57 @@ import (
 
 	"kept/internal/api"
 	"kept/internal/database"
+	"kept/internal/fieldcrypt"
+	"kept/internal/store/sqlstore"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/logger"
 )
 
+// databaseURL returns the database to use: a postgres:// URL selects
+// PostgreSQL, anything else is a SQLite file path.
+func databaseURL() string {
+	if url := os.Getenv("DATABASE_URL"); url != "" {
+		return url
+	}
+	return "./data/kept.db"
+}
+
+// newStore wraps db in a store, with field encryption when
+// FIELD_ENCRYPTION_KEYS is set. It fails if encrypted content exists that the
+// configured keys can't read.
+func newStore(db *database.DB) (*sqlstore.Store, error) {
+	keyring, err := fieldcrypt.KeyringFromEnv()
+	if err != nil {
+		return nil, fmt.Errorf("invalid FIELD_ENCRYPTION_KEYS: %w", err)
+	}
+	st := sqlstore.New(db, sqlstore.WithKeyring(keyring))
+	if err := st.CheckKeyring(); err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
 func main() {
-	// Initialize database
-	db, err := database.Initialize("./data/kept.db")
+	// Subcommands (e.g. `kept migrate status`) run instead of the server
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Open database
+	db, err := database.Open(databaseURL())
 	if err != nil {
 		log.Fatal("Failed to initialize database:", err)
 	}
 	defer db.Close()
 
-	// Run migrations only if explicitly enabled (opt-in for safety)
-	// Default to true if not set, to ensure schema is up to date
+	// Apply migrations at startup unless disabled. Default to true if not set,
+	// to ensure schema is up to date. Either way, refuse to serve on an
+	// outdated or broken schema.
 	runMigrations := os.Getenv("RUN_MIGRATIONS")
 	if runMigrations == "" {
 		runMigrations = "true"
@@ -31,17 +68,42 @@ func main() {
 
 	if runMigrations == "true" {
 		log.Println("Running database migrations...")
-		if err := api.MigratePostponedToKept(db); err != nil {
-			log.Printf("Migration error: %v", err)
+		if err := database.Migrate(db); err != nil {
+			log.Fatalf("Database migration failed: %v", err)
 		}
-		if err := api.MigrateAddReminderFrequency(db); err != nil {
-			log.Printf("Migration error (reminder freq): %v", err)
+	} else {
+		pending, err := database.PendingMigrations(db)
+		if err != nil {
+			log.Fatalf("Failed to check database migrations: %v", err)
 		}
-		if err := api.MigrateAddUserEmail(db); err != nil {
-			log.Printf("Migration error (user email): %v", err)
+		if len(pending) > 0 {
+			log.Fatalf("Database has %d pending migration(s); run `kept migrate up` or set RUN_MIGRATIONS=true", len(pending))
 		}
-	} else {
-		log.Println("Migrations skipped (set RUN_MIGRATIONS=true to enable)")
+		log.Println("Automatic migrations disabled; schema is up to date")
+	}
+
+	st, err := newStore(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if st.FieldsEncrypted() {
+		log.Println("Field encryption enabled for promise content")
+	}
+	if err := st.EnsureSearchIndex(); err != nil {
+		log.Printf("Preparing the search index failed: %v", err)
+	} else if !st.SearchIndexed() {
+		log.Println("Search runs without a full-text index; build with -tags sqlite_fts5 for a faster search")
+	}
+	// Link recipients the contacts migration couldn't read in SQL
+	if n, err := api.LinkRecipientsToContacts(st); err != nil {
+		log.Printf("Linking recipients to contacts failed: %v", err)
+	} else if n > 0 {
+		log.Printf("Linked %d promise(s) to contacts", n)
+	}
+
+	backups, err := newBackupManager(db)
+	if err != nil {
+		log.Fatalf("Invalid backup configuration: %v", err)
 	}
 
 	// Run background workers only if enabled (default: true for backward compatibility)
@@ -54,22 +116,38 @@ func main() {
 		log.Println("Starting background workers...")
 		// Run auto-keep once at startup and start background worker to repeatedly
 		// auto-keep overdue promises (active → kept when due_date has passed).
-		if err := api.AutoKeepOverduePromises(db); err != nil {
+		if err := api.AutoKeepOverduePromises(st); err != nil {
 			log.Printf("Auto-keep error at startup: %v", err)
 		}
+		if backups != nil && backups.Config().Interval > 0 {
+			log.Printf("Backing up the database every %s to %s", backups.Config().Interval, backups.Config().Dir)
+			go backups.Run(context.Background())
+		}
 		go func() {
 			ticker := time.NewTicker(1 * time.Minute)
 			defer ticker.Stop()
 			for range ticker.C {
-				if err := api.AutoKeepOverduePromises(db); err != nil {
+				if err := api.AutoKeepOverduePromises(st); err != nil {
 					log.Printf("Auto-keep worker error: %v", err)
 				}
-				if err := api.ProcessRecurringReminders(db); err != nil {
+				if err := api.SpawnSeriesInstances(st); err != nil {
+					log.Printf("Recurring promise worker error: %v", err)
+				}
+				if err := api.ProcessRecurringReminders(st); err != nil {
 					log.Printf("Recurring reminder worker error: %v", err)
 				}
-				if err := api.ProcessScheduledReminders(db); err != nil {
+				if err := api.ProcessScheduledReminders(st); err != nil {
 					log.Printf("Scheduled reminder worker error: %v", err)
 				}
+				if err := api.PurgeExpiredTrash(st); err != nil {
+					log.Printf("Trash purge worker error: %v", err)
+				}
+				if err := api.PurgeUsedPushActions(st); err != nil {
+					log.Printf("Push action purge worker error: %v", err)
+				}
+				if _, err := st.ReencryptFields(200); err != nil {
+					log.Printf("Field re-encryption worker error: %v", err)
+				}
 			}
 		}()
 	} else {
@@ -77,7 +155,21 @@ func main() {
 	}
 
 	// Create Fiber app
+	trustedProxies := []string{"127.0.0.1", "::1"}
+	if raw := strings.TrimSpace(os.Getenv("TRUSTED_PROXIES")); raw != "" {
+		trustedProxies = strings.Split(raw, ",")
+		for i, p := range trustedProxies {
+			trustedProxies[i] = strings.TrimSpace(p)
+		}
+	}
+
 	app := fiber.New(fiber.Config{
+		RequestMethods: api.RequestMethods,
+		// Client IPs come from nginx, but only when the request is from it
+		ProxyHeader:             "X-Real-IP",
+		EnableTrustedProxyCheck: true,
+		TrustedProxies:          trustedProxies,
+		EnableIPValidation:      true,
 		ErrorHandler: func(c *fiber.Ctx, err error) error {
 			code := fiber.StatusInternalServerError
 			if e, ok := err.(*fiber.Error); ok {
@@ -113,13 +205,14 @@ func main() {
 
 	app.Use(cors.New(cors.Config{
 		AllowOrigins:     allowedOrigins,
-		AllowMethods:     "GET,POST,PUT,DELETE,OPTIONS",
-		AllowHeaders:     "Origin, Content-Type, Accept, Authorization",
+		AllowMethods:     "GET,POST,PUT,PATCH,DELETE,OPTIONS",
+		AllowHeaders:     "Origin, Content-Type, Accept, Authorization, If-Match, If-None-Match",
+		ExposeHeaders:    "ETag",
 		AllowCredentials: true, // Required for cookies
 	}))
 
 	// Setup routes
-	api.SetupRoutes(app, db)
+	api.SetupRoutes(app, st, backups)
 
 	// Start server
 	port := os.Getenv("PORT")
@@ -66,6 +66,7 @@ self.addEventListener('push', (event) => {
       tag: data.tag || 'promise-reminder',
       requireInteraction: false,
       data: data.data || {},
+      actions: data.actions || [],
     };
 
     // Deduplicate notifications by tag: close any existing notifications with
@@ -92,9 +93,28 @@ self.addEventListener('push', (event) => {
   }
 });
 
+// Buttons post their action with the signed token in the notification
+// data, so they work without opening the app. If that fails, the app is
+// opened.
 self.addEventListener('notificationclick', (event) => {
   event.notification.close();
+  const { actions_url: actionsUrl, action_token: token } = event.notification.data || {};
+  if (!event.action || !actionsUrl || !token) {
+    event.waitUntil(clients.openWindow('/'));
+    return;
+  }
   event.waitUntil(
-    clients.openWindow('/')
+    fetch(`${actionsUrl}/${encodeURIComponent(event.action)}`, {
+      method: 'POST',
+      headers: { 'Content-Type': 'application/json' },
+      body: JSON.stringify({ token }),
+    })
+      .then((response) => {
+        if (!response.ok) {
+          console.error('Notification action failed', response.status);
+          return clients.openWindow('/');
+        }
+      })
+      .catch(() => clients.openWindow('/'))
   );
 });
@@ -0,0 +1,105 @@
+// End-to-end encryption for promises. The key is derived from a passphrase
+// in the browser and never sent to the server, which only stores envelopes
+// ({alg, nonce, ciphertext}) and a key check used to verify the passphrase.
+
+const ALG = 'AES-GCM-256';
+const KDF = 'PBKDF2-SHA256';
+const ITERATIONS = 600000;
+const CHECK_VALUE = 'kept-e2e-key-check';
+
+const encoder = new TextEncoder();
+const decoder = new TextDecoder();
+
+function toBase64(bytes) {
+  return btoa(String.fromCharCode(...new Uint8Array(bytes)));
+}
+
+function fromBase64(value) {
+  return Uint8Array.from(atob(value), (c) => c.charCodeAt(0));
+}
+
+export async function deriveKey(passphrase, salt, iterations = ITERATIONS) {
+  const material = await crypto.subtle.importKey(
+    'raw', encoder.encode(passphrase), 'PBKDF2', false, ['deriveKey'],
+  );
+  return crypto.subtle.deriveKey(
+    { name: 'PBKDF2', hash: 'SHA-256', salt: fromBase64(salt), iterations },
+    material,
+    { name: 'AES-GCM', length: 256 },
+    false,
+    ['encrypt', 'decrypt'],
+  );
+}
+
+export async function encryptJSON(key, value) {
+  const nonce = crypto.getRandomValues(new Uint8Array(12));
+  const ciphertext = await crypto.subtle.encrypt(
+    { name: 'AES-GCM', iv: nonce }, key, encoder.encode(JSON.stringify(value)),
+  );
+  return { alg: ALG, nonce: toBase64(nonce), ciphertext: toBase64(ciphertext) };
+}
+
+export async function decryptJSON(key, envelope) {
+  if (envelope.alg !== ALG) {
+    throw new Error(`Unsupported envelope algorithm ${envelope.alg}`);
+  }
+  const plaintext = await crypto.subtle.decrypt(
+    { name: 'AES-GCM', iv: fromBase64(envelope.nonce) }, key, fromBase64(envelope.ciphertext),
+  );
+  return JSON.parse(decoder.decode(plaintext));
+}
+
+// createKeyCheck derives a key for a new passphrase and returns it with the
+// key check to store on the server.
+export async function createKeyCheck(passphrase) {
+  const salt = toBase64(crypto.getRandomValues(new Uint8Array(16)));
+  const key = await deriveKey(passphrase, salt);
+  const check = await encryptJSON(key, CHECK_VALUE);
+  return { key, keyCheck: { kdf: KDF, iterations: ITERATIONS, salt, check } };
+}
+
+// unlock derives the key for a passphrase and verifies it against the stored
+// key check. It throws if the passphrase is wrong.
+export async function unlock(passphrase, keyCheck) {
+  if (keyCheck.kdf !== KDF) {
+    throw new Error(`Unsupported key derivation ${keyCheck.kdf}`);
+  }
+  const key = await deriveKey(passphrase, keyCheck.salt, keyCheck.iterations);
+  let value;
+  try {
+    value = await decryptJSON(key, keyCheck.check);
+  } catch {
+    throw new Error('Wrong passphrase');
+  }
+  if (value !== CHECK_VALUE) {
+    throw new Error('Wrong passphrase');
+  }
+  return key;
+}
+
+// encryptPromise turns a create request into its encrypted form.
+export async function encryptPromise(key, data) {
+  const { recipient, description, ...rest } = data;
+  return { ...rest, encrypted: true, envelope: await encryptJSON(key, { recipient, description }) };
+}
+
+// decryptPromise fills in the content of an encrypted promise and the notes
+// of its events. Other promises are returned unchanged.
+export async function decryptPromise(key, promise) {
+  if (!promise.encrypted || !promise.envelope) {
+    return promise;
+  }
+  const { recipient, description } = await decryptJSON(key, promise.envelope);
+  const events = promise.events && await Promise.all(promise.events.map(async (event) => (
+    event.envelope
+      ? { ...event, reflection_note: (await decryptJSON(key, event.envelope)).reflection_note }
+      : event
+  )));
+  return { ...promise, recipient, description, ...(events && { events }) };
+}
+
+// encryptNote returns the state update fields for a reflection note on an
+// encrypted promise.
+export async function encryptNote(key, reflectionNote) {
+  return { note_envelope: await encryptJSON(key, { reflection_note: reflectionNote }) };
+}
@@ -1,15 +1,105 @@
+import { decryptPromise, encryptNote, encryptPromise } from './e2e.js';
+
 const API_URL = '/api';
 
 export class PromiseService {
   constructor() {
     this.authService = null;
+    this.e2eKey = null;
+    // Last seen version of each promise, sent back as If-Match so edits made
+    // on another device aren't silently overwritten.
+    this.versions = new Map();
   }
 
   setAuthService(authService) {
     this.authService = authService;
   }
 
+  // setE2EKey sets the unlocked end-to-end key. With a key, promises created
+  // with `encrypted: true` are encrypted before they are sent, and encrypted
+  // promises are decrypted as they are loaded.
+  setE2EKey(key) {
+    this.e2eKey = key;
+  }
+
+  async decrypt(promise) {
+    return this.e2eKey ? decryptPromise(this.e2eKey, promise) : promise;
+  }
+
+  // receive records the version of a promise loaded from the server and
+  // decrypts it.
+  async receive(promise) {
+    this.versions.set(Number(promise.id), promise.version);
+    return this.decrypt(promise);
+  }
+
+  // headersFor returns the headers for a write to a promise, with its last
+  // seen version as If-Match. A promise not seen yet is loaded first, so the
+  // write never goes through without the precondition.
+  async headersFor(id) {
+    if (!this.versions.has(Number(id))) {
+      await this.getPromise(id);
+    }
+    return {
+      ...this.authService.getHeaders(),
+      'If-Match': `"${this.versions.get(Number(id))}"`,
+    };
+  }
+
+  // rememberETag records the version a mutation left the promise at.
+  rememberETag(id, response) {
+    const etag = response.headers?.get('ETag');
+    if (etag) {
+      this.versions.set(Number(id), Number(etag.replace(/"/g, '')));
+    }
+  }
+
+  // conflictError builds the error thrown when a promise was changed
+  // elsewhere. It carries the current promise so the caller can merge.
+  async conflictError(response) {
+    const error = new Error('This promise was changed on another device');
+    error.conflict = await this.receive(await response.json());
+    return error;
+  }
+
+  async getKeyCheck() {
+    const response = await fetch(`${API_URL}/user/e2e`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (response.status === 404) {
+      return null;
+    }
+    if (!response.ok) {
+      throw new Error('Failed to fetch encryption settings');
+    }
+
+    return response.json();
+  }
+
+  async setKeyCheck(keyCheck) {
+    const response = await fetch(`${API_URL}/user/e2e`, {
+      method: 'PUT',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify(keyCheck),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to save encryption settings');
+    }
+
+    return response.json();
+  }
+
   async createPromise(data) {
+    if (data.encrypted) {
+      if (!this.e2eKey) {
+        throw new Error('Unlock encryption before creating encrypted promises');
+      }
+      data = await encryptPromise(this.e2eKey, data);
+    }
+
     const response = await fetch(`${API_URL}/promises/`, {
       method: 'POST',
       headers: this.authService.getHeaders(),
@@ -21,13 +111,28 @@ export class PromiseService {
       throw new Error(error.error || 'Failed to create promise');
     }
 
-    return response.json();
+    return this.receive(await response.json());
   }
 
-  async getPromises(state = null) {
-    const url = state 
-      ? `${API_URL}/promises/?state=${state}`
-      : `${API_URL}/promises/`;
+  // tags filters by tag IDs; match is 'any' or 'all' of them. contact
+  // filters by contact ID.
+  async getPromises(state = null, { tags = [], match = 'any', contact = null, workspace = null } = {}) {
+    const params = new URLSearchParams();
+    if (state) {
+      params.set('state', state);
+    }
+    if (contact) {
+      params.set('contact', contact);
+    }
+    if (workspace) {
+      params.set('workspace', workspace);
+    }
+    if (tags.length > 0) {
+      params.set('tags', tags.join(','));
+      params.set('tag_match', match);
+    }
+    const query = params.toString();
+    const url = `${API_URL}/promises/${query ? `?${query}` : ''}`;
 
     const response = await fetch(url, {
       headers: this.authService.getHeaders(),
@@ -37,7 +142,8 @@ export class PromiseService {
       throw new Error('Failed to fetch promises');
     }
 
-    return response.json();
+    const promises = await response.json();
+    return Promise.all(promises.map((promise) => this.receive(promise)));
   }
 
   async getPromise(id) {
@@ -49,41 +155,112 @@ export class PromiseService {
       throw new Error('Failed to fetch promise');
     }
 
-    return response.json();
+    return this.receive(await response.json());
   }
 
+  // Pass `encrypted: true` for encrypted promises so the reflection note is
+  // encrypted before it is sent.
   async updatePromiseState(id, data) {
+    const { encrypted, ...update } = data;
+    if (encrypted && update.reflection_note) {
+      const { reflection_note: note, ...rest } = update;
+      data = { ...rest, ...(await encryptNote(this.e2eKey, note)) };
+    } else {
+      data = update;
+    }
+
     const response = await fetch(`${API_URL}/promises/${id}/state`, {
       method: 'PUT',
-      headers: this.authService.getHeaders(),
+      headers: await this.headersFor(id),
       body: JSON.stringify(data),
     });
 
+    if (response.status === 412) {
+      throw await this.conflictError(response);
+    }
     if (!response.ok) {
       const error = await response.json();
       throw new Error(error.error || 'Failed to update promise');
     }
 
+    this.rememberETag(id, response);
     return response.json();
   }
 
   async updatePromise(id, data) {
     const response = await fetch(`${API_URL}/promises/${id}`, {
-      method: 'PUT',
-      headers: this.authService.getHeaders(),
+      method: 'PATCH',
+      headers: await this.headersFor(id),
       body: JSON.stringify(data),
     });
 
+    if (response.status === 412) {
+      throw await this.conflictError(response);
+    }
     if (!response.ok) {
       const error = await response.json();
       throw new Error(error.error || 'Failed to update promise');
     }
 
+    return this.receive(await response.json());
+  }
+
+  async getRevisions(id) {
+    const response = await fetch(`${API_URL}/promises/${id}/revisions`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch promise history');
+    }
+
     return response.json();
   }
 
   async deletePromise(id) {
     const response = await fetch(`${API_URL}/promises/${id}`, {
+      method: 'DELETE',
+      headers: await this.headersFor(id),
+    });
+
+    if (response.status === 412) {
+      throw await this.conflictError(response);
+    }
+    if (!response.ok) {
+      throw new Error('Failed to delete promise');
+    }
+
+    return response.json();
+  }
+
+  async getTrash() {
+    const response = await fetch(`${API_URL}/trash`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch trash');
+    }
+
+    const trash = await response.json();
+    return Promise.all(trash.map((promise) => this.receive(promise)));
+  }
+
+  async restorePromise(id) {
+    const response = await fetch(`${API_URL}/trash/${id}/restore`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to restore promise');
+    }
+
+    return this.receive(await response.json());
+  }
+
+  async deletePromisePermanently(id) {
+    const response = await fetch(`${API_URL}/trash/${id}`, {
       method: 'DELETE',
       headers: this.authService.getHeaders(),
     });
@@ -95,8 +272,17 @@ export class PromiseService {
     return response.json();
   }
 
-  async getTimeline() {
-    const response = await fetch(`${API_URL}/timeline`, {
+  async getTimeline({ tags = [], match = 'any', workspace = null } = {}) {
+    const params = new URLSearchParams();
+    if (tags.length > 0) {
+      params.set('tags', tags.join(','));
+      params.set('tag_match', match);
+    }
+    if (workspace) {
+      params.set('workspace', workspace);
+    }
+    const query = params.toString();
+    const response = await fetch(`${API_URL}/timeline${query ? `?${query}` : ''}`, {
       headers: this.authService.getHeaders(),
     });
 
@@ -104,18 +290,722 @@ export class PromiseService {
       throw new Error('Failed to fetch timeline');
     }
 
-    return response.json();
+    const timeline = await response.json();
+    return Promise.all(timeline.map((promise) => this.receive(promise)));
+  }
+
+  // getReminders lists the user's reminders with their status (pending,
+  // sent, cancelled or failed) and a summary of their promise, decrypted
+  // like the promise itself.
+  async getReminders() {
+    const response = await fetch(`${API_URL}/reminders`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch reminders');
+    }
+
+    const reminders = await response.json();
+    return Promise.all(reminders.map(async (reminder) => ({
+      ...reminder,
+      promise: reminder.promise && await this.decrypt(reminder.promise),
+    })));
   }
 
-  async createReminder(promiseId, offsetMinutes) {
+  // createReminder takes minutes before the due date, a reminder such as
+  // { anchor: 'created', offset_minutes: 30 } or { remind_at }, or a list of
+  // them, in which case it resolves to a list.
+  async createReminder(promiseId, reminder) {
+    let body = reminder;
+    if (typeof reminder === 'number') body = { offset_minutes: reminder };
+    if (Array.isArray(reminder)) body = { reminders: reminder };
     const response = await fetch(`${API_URL}/reminders/promise/${promiseId}`, {
       method: 'POST',
       headers: this.authService.getHeaders(),
-      body: JSON.stringify({ offset_minutes: offsetMinutes }),
+      body: JSON.stringify(body),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to create reminder');
+    }
+
+    return response.json();
+  }
+
+  async updateReminder(id, reminder) {
+    const response = await fetch(`${API_URL}/reminders/${id}`, {
+      method: 'PATCH',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify(reminder),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to update reminder');
+    }
+
+    return response.json();
+  }
+
+  async getTags() {
+    const response = await fetch(`${API_URL}/tags`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch tags');
+    }
+
+    return response.json();
+  }
+
+  async createTag(data) {
+    const response = await fetch(`${API_URL}/tags`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify(data),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to create tag');
+    }
+
+    return response.json();
+  }
+
+  async updateTag(id, data) {
+    const response = await fetch(`${API_URL}/tags/${id}`, {
+      method: 'PATCH',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify(data),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to update tag');
+    }
+
+    return response.json();
+  }
+
+  async deleteTag(id) {
+    const response = await fetch(`${API_URL}/tags/${id}`, {
+      method: 'DELETE',
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to delete tag');
+    }
+
+    return response.json();
+  }
+
+  async getContacts() {
+    const response = await fetch(`${API_URL}/contacts`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch contacts');
+    }
+
+    return response.json();
+  }
+
+  async createContact(data) {
+    const response = await fetch(`${API_URL}/contacts`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify(data),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to create contact');
+    }
+
+    return response.json();
+  }
+
+  async updateContact(id, data) {
+    const response = await fetch(`${API_URL}/contacts/${id}`, {
+      method: 'PATCH',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify(data),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to update contact');
+    }
+
+    return response.json();
+  }
+
+  async deleteContact(id) {
+    const response = await fetch(`${API_URL}/contacts/${id}`, {
+      method: 'DELETE',
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to delete contact');
+    }
+
+    return response.json();
+  }
+
+  // mergeContact folds the contact otherId into id.
+  async mergeContact(id, otherId) {
+    const response = await fetch(`${API_URL}/contacts/${id}/merge`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify({ contact_id: otherId }),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to merge contacts');
+    }
+
+    return response.json();
+  }
+
+  async getContactHistory(id) {
+    const response = await fetch(`${API_URL}/contacts/${id}/history`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch contact history');
+    }
+
+    const history = await response.json();
+    history.promises = await Promise.all(history.promises.map((promise) => this.receive(promise)));
+    return history;
+  }
+
+  async getContactStats(id) {
+    const response = await fetch(`${API_URL}/contacts/${id}/stats`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch contact stats');
+    }
+
+    return response.json();
+  }
+
+  // createShareLink returns the new link, including its URL; the URL can't
+  // be retrieved again later. redact lists fields to hide from viewers.
+  async createShareLink(promiseId, { redact = [], expiresAt = null } = {}) {
+    const response = await fetch(`${API_URL}/promises/${promiseId}/shares`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify({ redact, expires_at: expiresAt }),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to create share link');
+    }
+
+    return response.json();
+  }
+
+  async getShareLinks(promiseId) {
+    const response = await fetch(`${API_URL}/promises/${promiseId}/shares`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch share links');
+    }
+
+    return response.json();
+  }
+
+  async revokeShareLink(promiseId, shareId) {
+    const response = await fetch(`${API_URL}/promises/${promiseId}/shares/${shareId}`, {
+      method: 'DELETE',
+      headers: this.authService.getHeaders(),
     });
 
     if (!response.ok) {
-      throw new Error('Failed to create reminder');
+      throw new Error('Failed to revoke share link');
+    }
+
+    return response.json();
+  }
+
+  async getWorkspaces() {
+    const response = await fetch(`${API_URL}/workspaces/`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch workspaces');
+    }
+
+    return response.json();
+  }
+
+  // getWorkspace returns a workspace with its members.
+  async getWorkspace(id) {
+    const response = await fetch(`${API_URL}/workspaces/${id}`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch workspace');
+    }
+
+    return response.json();
+  }
+
+  async createWorkspace(name) {
+    const response = await fetch(`${API_URL}/workspaces/`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify({ name }),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to create workspace');
+    }
+
+    return response.json();
+  }
+
+  async renameWorkspace(id, name) {
+    const response = await fetch(`${API_URL}/workspaces/${id}`, {
+      method: 'PATCH',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify({ name }),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to rename workspace');
+    }
+
+    return response.json();
+  }
+
+  // deleteWorkspace deletes the workspace and all of its promises.
+  async deleteWorkspace(id) {
+    const response = await fetch(`${API_URL}/workspaces/${id}`, {
+      method: 'DELETE',
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to delete workspace');
+    }
+
+    return response.json();
+  }
+
+  async inviteMember(workspaceId, username, role = 'editor') {
+    const response = await fetch(`${API_URL}/workspaces/${workspaceId}/invitations`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify({ username, role }),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to invite member');
+    }
+
+    return response.json();
+  }
+
+  async setMemberRole(workspaceId, userId, role) {
+    const response = await fetch(`${API_URL}/workspaces/${workspaceId}/members/${userId}`, {
+      method: 'PATCH',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify({ role }),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to change role');
+    }
+
+    return response.json();
+  }
+
+  // removeMember removes a member, or leaves the workspace when userId is
+  // the current user.
+  async removeMember(workspaceId, userId) {
+    const response = await fetch(`${API_URL}/workspaces/${workspaceId}/members/${userId}`, {
+      method: 'DELETE',
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to remove member');
+    }
+
+    return response.json();
+  }
+
+  async getInvitations() {
+    const response = await fetch(`${API_URL}/invitations/`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch invitations');
+    }
+
+    return response.json();
+  }
+
+  // answerInvitation accepts or declines an invitation. Accepting returns
+  // the workspace.
+  async answerInvitation(id, accept) {
+    const response = await fetch(`${API_URL}/invitations/${id}/${accept ? 'accept' : 'decline'}`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to answer invitation');
+    }
+
+    return response.json();
+  }
+
+  // getPartners returns the user's accountability partnerships, both the
+  // partners they asked and the users who asked them.
+  async getPartners() {
+    const response = await fetch(`${API_URL}/partners/`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch partners');
+    }
+
+    return response.json();
+  }
+
+  async invitePartner(username) {
+    const response = await fetch(`${API_URL}/partners/`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify({ username }),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to ask partner');
+    }
+
+    return response.json();
+  }
+
+  async acceptPartner(id) {
+    const response = await fetch(`${API_URL}/partners/${id}/accept`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to accept partner');
+    }
+
+    return response.json();
+  }
+
+  // removePartner ends a partnership, or declines or withdraws a pending one.
+  async removePartner(id) {
+    const response = await fetch(`${API_URL}/partners/${id}`, {
+      method: 'DELETE',
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to remove partner');
+    }
+
+    return response.json();
+  }
+
+  // getPartnerPromises returns the promises the user who asked for this
+  // partnership shows to their partners.
+  async getPartnerPromises(id) {
+    const response = await fetch(`${API_URL}/partners/${id}/promises`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch partner promises');
+    }
+
+    return response.json();
+  }
+
+  async nudgePromise(promiseId, message = '') {
+    const response = await fetch(`${API_URL}/promises/${promiseId}/nudge`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify({ message }),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to nudge');
+    }
+
+    return response.json();
+  }
+
+  // getItems returns a promise's checklist in order.
+  async getItems(promiseId) {
+    const response = await fetch(`${API_URL}/promises/${promiseId}/items`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch checklist');
+    }
+
+    return response.json();
+  }
+
+  // addItem, updateItem and deleteItem change the promise too, so they send
+  // its version and remember the one they leave it at.
+  async addItem(promiseId, item) {
+    const response = await fetch(`${API_URL}/promises/${promiseId}/items`, {
+      method: 'POST',
+      headers: await this.headersFor(promiseId),
+      body: JSON.stringify(item),
+    });
+
+    if (response.status === 412) {
+      throw await this.conflictError(response);
+    }
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to add item');
+    }
+
+    this.rememberETag(promiseId, response);
+    return response.json();
+  }
+
+  // updateItem edits, ticks off or moves an item. The response has
+  // promise_kept set when it was the last item of a keep_when_done promise.
+  async updateItem(promiseId, itemId, changes) {
+    const response = await fetch(`${API_URL}/promises/${promiseId}/items/${itemId}`, {
+      method: 'PATCH',
+      headers: await this.headersFor(promiseId),
+      body: JSON.stringify(changes),
+    });
+
+    if (response.status === 412) {
+      throw await this.conflictError(response);
+    }
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to update item');
+    }
+
+    this.rememberETag(promiseId, response);
+    return response.json();
+  }
+
+  async deleteItem(promiseId, itemId) {
+    const response = await fetch(`${API_URL}/promises/${promiseId}/items/${itemId}`, {
+      method: 'DELETE',
+      headers: await this.headersFor(promiseId),
+    });
+
+    if (response.status === 412) {
+      throw await this.conflictError(response);
+    }
+    if (!response.ok) {
+      throw new Error('Failed to delete item');
+    }
+
+    this.rememberETag(promiseId, response);
+    return response.json();
+  }
+
+  async getSeries({ workspace = null } = {}) {
+    const query = workspace ? `?workspace=${encodeURIComponent(workspace)}` : '';
+    const response = await fetch(`${API_URL}/series/${query}`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch series');
+    }
+
+    return response.json();
+  }
+
+  // getSeriesDetail returns a series with its instances, newest first, and
+  // its streak and hit-rate stats.
+  async getSeriesDetail(id) {
+    const response = await fetch(`${API_URL}/series/${id}`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch series');
+    }
+
+    const series = await response.json();
+    series.instances = await Promise.all((series.instances || []).map((promise) => this.receive(promise)));
+    return series;
+  }
+
+  async updateSeries(id, changes) {
+    const response = await fetch(`${API_URL}/series/${id}`, {
+      method: 'PATCH',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify(changes),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to update series');
+    }
+
+    return response.json();
+  }
+
+  async stopSeries(id) {
+    const response = await fetch(`${API_URL}/series/${id}/stop`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to stop series');
+    }
+
+    return response.json();
+  }
+
+  async getTemplates() {
+    const response = await fetch(`${API_URL}/templates/`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to fetch templates');
+    }
+
+    return response.json();
+  }
+
+  async createTemplate(template) {
+    const response = await fetch(`${API_URL}/templates/`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify(template),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to create template');
+    }
+
+    return response.json();
+  }
+
+  async updateTemplate(id, changes) {
+    const response = await fetch(`${API_URL}/templates/${id}`, {
+      method: 'PATCH',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify(changes),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to update template');
+    }
+
+    return response.json();
+  }
+
+  async deleteTemplate(id) {
+    const response = await fetch(`${API_URL}/templates/${id}`, {
+      method: 'DELETE',
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      throw new Error('Failed to delete template');
+    }
+
+    return response.json();
+  }
+
+  // createFromTemplate creates a promise from a template, filling its
+  // placeholders with values.
+  async createFromTemplate(id, values = {}, overrides = {}) {
+    const response = await fetch(`${API_URL}/templates/${id}/promises`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify({ ...overrides, values }),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to create promise from template');
+    }
+
+    return response.json();
+  }
+
+  // parsePromise previews what a quick-add text would create. Relative
+  // dates are read in the browser's time zone.
+  async parsePromise(text) {
+    const response = await fetch(`${API_URL}/promises/parse`, {
+      method: 'POST',
+      headers: this.authService.getHeaders(),
+      body: JSON.stringify({ text, timezone: Intl.DateTimeFormat().resolvedOptions().timeZone }),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to parse text');
+    }
+
+    return response.json();
+  }
+
+  // search looks for promises and reflection notes matching text. Hits come
+  // best first with their promise and a snippet that is safe to render as
+  // HTML, with the matches in <mark> tags.
+  async search(text, { state = '', from = '', to = '', workspace = null, limit = null } = {}) {
+    const params = new URLSearchParams({ q: text });
+    if (state) params.set('state', state);
+    if (from) params.set('from', from);
+    if (to) params.set('to', to);
+    if (workspace) params.set('workspace', workspace);
+    if (limit) params.set('limit', limit);
+    const response = await fetch(`${API_URL}/search?${params}`, {
+      headers: this.authService.getHeaders(),
+    });
+
+    if (!response.ok) {
+      const error = await response.json();
+      throw new Error(error.error || 'Failed to search');
     }
 
     return response.json();
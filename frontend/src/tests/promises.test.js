@@ -1,4 +1,4 @@
-import { describe, it, expect, beforeEach } from 'vitest';
+import { describe, it, expect, beforeEach, vi } from 'vitest';
 import { PromiseService } from '../services/promises.js';
 
 describe('PromiseService', () => {
@@ -29,3 +29,81 @@ describe('PromiseService', () => {
     expect(typeof promiseService.getTimeline).toBe('function');
   });
 });
+
+describe('PromiseService end-to-end encryption', () => {
+  it('encrypts new promises and decrypts them when loaded', async () => {
+    const { createKeyCheck } = await import('../services/e2e.js');
+    const { key } = await createKeyCheck('correct horse battery staple');
+
+    const promiseService = new PromiseService();
+    promiseService.setAuthService({ getHeaders: () => ({}) });
+    promiseService.setE2EKey(key);
+
+    let sent;
+    vi.stubGlobal('fetch', vi.fn(async (url, options) => {
+      sent = JSON.parse(options.body);
+      return { ok: true, json: async () => ({ id: 1, ...sent, recipient: '', description: '' }) };
+    }));
+
+    const created = await promiseService.createPromise({ recipient: 'Mom', description: 'Call', encrypted: true });
+    expect(sent.recipient).toBeUndefined();
+    expect(sent.envelope.ciphertext).toBeTruthy();
+    expect(created.recipient).toBe('Mom');
+    expect(created.description).toBe('Call');
+  });
+});
+
+describe('PromiseService concurrency', () => {
+  it('sends the last seen version and reports conflicts', async () => {
+    const promiseService = new PromiseService();
+    promiseService.setAuthService({ getHeaders: () => ({}) });
+
+    const requests = [];
+    const current = { id: 7, version: 3, recipient: 'Sam', description: 'Changed elsewhere' };
+    vi.stubGlobal('fetch', vi.fn(async (url, options = {}) => {
+      requests.push(options);
+      if (options.method === 'PATCH') {
+        return { ok: false, status: 412, json: async () => current };
+      }
+      return { ok: true, status: 200, json: async () => ({ id: 7, version: 2, recipient: 'Sam', description: 'Call' }) };
+    }));
+
+    await promiseService.getPromise(7);
+    await expect(promiseService.updatePromise('7', { description: 'Call today' })).rejects.toMatchObject({ conflict: current });
+    expect(requests[1].headers['If-Match']).toBe('"2"');
+    expect(promiseService.versions.get(7)).toBe(3);
+  });
+
+  it('loads the version of a promise it has not seen before writing', async () => {
+    const promiseService = new PromiseService();
+    promiseService.setAuthService({ getHeaders: () => ({}) });
+
+    const requests = [];
+    vi.stubGlobal('fetch', vi.fn(async (url, options = {}) => {
+      requests.push({ url, ...options });
+      return { ok: true, status: 200, headers: new Headers(), json: async () => ({ id: 9, version: 4, success: true }) };
+    }));
+
+    await promiseService.updatePromiseState(9, { state: 'kept' });
+    expect(requests[0].method).toBeUndefined();
+    expect(requests[0].url).toMatch(/\/promises\/9$/);
+    expect(requests[1].headers['If-Match']).toBe('"4"');
+  });
+
+  it('sends and updates the version on checklist changes', async () => {
+    const promiseService = new PromiseService();
+    promiseService.setAuthService({ getHeaders: () => ({}) });
+    promiseService.versions.set(9, 4);
+
+    const requests = [];
+    vi.stubGlobal('fetch', vi.fn(async (url, options = {}) => {
+      requests.push({ url, ...options });
+      return { ok: true, status: 201, headers: new Headers({ ETag: '"5"' }), json: async () => ({ id: 1 }) };
+    }));
+
+    await promiseService.addItem(9, { title: 'Rent a van' });
+    await promiseService.deleteItem(9, 1);
+    expect(requests[0].headers['If-Match']).toBe('"4"');
+    expect(requests[1].headers['If-Match']).toBe('"5"');
+  });
+});